@@ -5,10 +5,13 @@ import (
 	"os"
 
 	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
 
 	"github.com/spf13/cobra"
 )
 
+var addCompressedInput string
+
 var addCmd = &cobra.Command{
 	Use:   "add [alias] [path]",
 	Short: "Add a new ZBackup repository",
@@ -29,6 +32,19 @@ var addCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if addCompressedInput != "" {
+			if !services.ValidCompressedInputPolicy(addCompressedInput) {
+				fmt.Fprintf(os.Stderr, "Error: invalid compressed input policy '%s' (expected warn, reject or decompress)\n", addCompressedInput)
+				os.Exit(1)
+			}
+			settings := registry.GetSettings(alias)
+			settings.CompressedInput = addCompressedInput
+			if err := registry.SetSettings(alias, settings); err != nil {
+				fmt.Fprintf(os.Stderr, "Error adding repository: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		if err := registry.Save(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error saving registry: %v\n", err)
 			os.Exit(1)
@@ -40,4 +56,5 @@ var addCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(addCmd)
+	addCmd.Flags().StringVar(&addCompressedInput, "compressed-input", "", "policy for compressed input: warn, reject or decompress")
 }
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	annotateDescription string
+	annotateTags        []string
+	annotateUntags      []string
+	annotateNote        string
+	annotateSelect      string
+)
+
+var annotateCmd = &cobra.Command{
+	Use:   "annotate [alias] [backup]",
+	Short: "Edit backup metadata",
+	Long: `Changes the description, tags or notes of an existing backup. Every edit is recorded in the
+sidecar's history. Omit the backup name and use --select to annotate every backup matching a selector,
+for example --select 'suffix:monthly since:2024-01-01 until:2025-01-01'.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+		if (len(args) == 2) == (annotateSelect != "") {
+			fmt.Fprintln(os.Stderr, "Error: specify either a backup name or --select")
+			os.Exit(1)
+		}
+
+		annotation := services.Annotation{
+			RemoveTags: annotateUntags,
+			Note:       annotateNote,
+		}
+		if cmd.Flags().Changed("description") {
+			annotation.Description = &annotateDescription
+		}
+		if len(annotateTags) > 0 {
+			annotation.SetTags = make(map[string]string)
+			for _, tag := range annotateTags {
+				key, value, err := services.ParseTag(tag)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				annotation.SetTags[key] = value
+			}
+		}
+		if annotation.Empty() {
+			fmt.Fprintln(os.Stderr, "Error: nothing to change (use --description, --tag, --untag or --note)")
+			os.Exit(1)
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		inspector := services.NewRepositoryInspector()
+
+		var names []string
+		if len(args) == 2 {
+			names = []string{args[1]}
+		} else {
+			selector, err := selectors.Parse(annotateSelect)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			details, err := inspector.Inspect(alias, repoPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error inspecting repository: %v\n", err)
+				os.Exit(1)
+			}
+			for _, b := range selector.Filter(details.Backups) {
+				names = append(names, b.Filename)
+			}
+		}
+
+		results := inspector.Annotate(repoPath, names, annotation)
+
+		failed := 0
+		for _, r := range results {
+			if r.Error != "" {
+				failed++
+			}
+		}
+
+		render(format, output.Document{
+			Kind:       "annotation_report",
+			Data:       results,
+			RecordKind: "annotation_result",
+			Records:    results,
+			Columns: []output.Column{
+				output.Col("filename", func(r services.AnnotateResult) string { return r.Filename }),
+				output.Col("changes", func(r services.AnnotateResult) string { return csvInt(int64(r.Changes)) }),
+				output.Col("error", func(r services.AnnotateResult) string { return r.Error }),
+			},
+			Table: func(out io.Writer) {
+				w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+				fmt.Fprintln(w, "BACKUP NAME\tCHANGES\tDETAILS")
+				for _, r := range results {
+					fmt.Fprintf(w, "%s\t%d\t%s\n", r.Filename, r.Changes, r.Error)
+				}
+				w.Flush()
+				fmt.Fprintln(out, "")
+				fmt.Fprintf(out, "Annotated %d backup(s), %d failed.\n", len(results)-failed, failed)
+			},
+		})
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(annotateCmd)
+	annotateCmd.Flags().StringVarP(&annotateDescription, "description", "m", "", "Replace the description")
+	annotateCmd.Flags().StringArrayVar(&annotateTags, "tag", nil, "Set a tag (key=value, repeatable)")
+	annotateCmd.Flags().StringArrayVar(&annotateUntags, "untag", nil, "Remove a tag by key (repeatable)")
+	annotateCmd.Flags().StringVar(&annotateNote, "note", "", "Append a note")
+	annotateCmd.Flags().StringVarP(&annotateSelect, "select", "S", "", "Bulk mode: annotate every backup matching a selector")
+	addOutputFlags(annotateCmd)
+}
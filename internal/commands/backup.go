@@ -12,13 +12,20 @@ import (
 var (
 	backupSuffix      string
 	backupDescription string
+	backupDecompress  bool
+	backupTags        []string
+	backupEstimate    bool
 )
 
 var backupCmd = &cobra.Command{
 	Use:   "backup [alias]",
 	Short: "Create a new backup",
-	Long:  `Create a new backup for the repository associated with the given alias.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Create a new backup for the repository associated with the given alias.
+With --estimate-only, nothing is stored: the input is chunked and looked up like 'zbwrap estimate' does.
+
+The pre_backup, post_backup and on_failure hooks configured for the repository receive the backup as a
+JSON event on stdin. A failing pre_backup hook aborts the backup.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		repoAlias := args[0]
 
@@ -36,20 +43,60 @@ var backupCmd = &cobra.Command{
 
 		runner := services.NewBackupRunner(registry)
 
+		settings := registry.GetSettings(repoAlias)
+		opts := services.BackupOptions{
+			Alias:           repoAlias,
+			Suffix:          backupSuffix,
+			Description:     backupDescription,
+			CompressedInput: settings.CompressedInput,
+			Hooks:           settings.Hooks,
+		}
+		if backupDecompress {
+			opts.CompressedInput = services.CompressedInputDecompress
+		}
+		if len(backupTags) > 0 {
+			opts.Tags = make(map[string]string)
+			for _, tag := range backupTags {
+				key, value, err := services.ParseTag(tag)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				opts.Tags[key] = value
+			}
+		}
+
+		if backupEstimate {
+			report, err := runner.Estimate(repoPath, opts, os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Estimate failed: %v\n", err)
+				os.Exit(1)
+			}
+			printEstimate(os.Stdout, report)
+			return
+		}
+
 		fmt.Printf("Starting backup for alias: %s (%s)\n", repoAlias, repoPath)
 
 		// Stream from stdin to zbackup
-		if err := runner.Backup(repoPath, backupSuffix, backupDescription, os.Stdin); err != nil {
+		if err := runner.BackupWithOptions(repoPath, opts, os.Stdin); err != nil {
 			fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+			notify(registry, services.Notification{Event: services.NotifyEventBackup, Outcome: services.NotifyFailure, Alias: repoAlias,
+				Subject: fmt.Sprintf("%s backup of %s failed", backupSuffix, repoAlias), Message: err.Error()})
 			os.Exit(1)
 		}
 
 		fmt.Println("Backup completed successfully.")
+		notify(registry, services.Notification{Event: services.NotifyEventBackup, Outcome: services.NotifySuccess, Alias: repoAlias,
+			Subject: fmt.Sprintf("%s backup of %s completed", backupSuffix, repoAlias)})
 	},
 }
 
 func init() {
 	backupCmd.Flags().StringVarP(&backupSuffix, "suffix", "s", "manual", "suffix for the backup filename")
 	backupCmd.Flags().StringVarP(&backupDescription, "description", "m", "", "optional description for the backup")
+	backupCmd.Flags().BoolVar(&backupDecompress, "decompress", false, "decompress gzip, xz or zstd input before storing it")
+	backupCmd.Flags().BoolVar(&backupEstimate, "estimate-only", false, "report what the backup would add to the repository without storing anything")
+	backupCmd.Flags().StringArrayVarP(&backupTags, "tag", "t", nil, "tag the backup (key=value, repeatable)")
 	rootCmd.AddCommand(backupCmd)
 }
@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkReadDataSubset string
+	checkHistory        bool
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check [alias]",
+	Short: "Verify repository structure and data",
+	Long: `Reads the repository with the native format reader and confirms that every chunk referenced by
+every backup appears in the index, and that every indexed bundle exists and holds the chunks the index lists.
+
+With --read-data-subset, a random share of the bundles (e.g. 10%) is also decompressed and every chunk
+checked against its hash. Each run is recorded in the repository's check history, together with the
+runs of 'verify'; use --history to show it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		var percent float64
+		if checkReadDataSubset != "" {
+			var err error
+			percent, err = parsePercent(checkReadDataSubset)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --read-data-subset: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		if checkHistory {
+			history, err := services.CheckHistory(repoPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading check history: %v\n", err)
+				os.Exit(1)
+			}
+			render(format, output.Document{
+				Kind:       "check_history",
+				Data:       history,
+				RecordKind: "check",
+				Records:    history,
+				Columns: []output.Column{
+					output.Col("started_at", func(s services.CheckSummary) string { return csvTime(s.StartedAt) }),
+					output.Col("command", func(s services.CheckSummary) string { return s.Command }),
+					output.Col("ok", func(s services.CheckSummary) string { return strconv.FormatBool(s.OK) }),
+					output.Col("problems", func(s services.CheckSummary) string { return strconv.Itoa(s.ProblemCount) }),
+					output.Col("backups", func(s services.CheckSummary) string { return strconv.Itoa(s.Backups) }),
+					output.Col("bundles", func(s services.CheckSummary) string { return strconv.Itoa(s.Bundles) }),
+					output.Col("read_data_percent", func(s services.CheckSummary) string { return formatPercent(s.ReadDataPercent) }),
+					output.Col("bundles_read", func(s services.CheckSummary) string { return strconv.Itoa(s.BundlesRead) }),
+					output.Col("duration_seconds", func(s services.CheckSummary) string {
+						return strconv.FormatFloat(s.DurationSeconds, 'f', 3, 64)
+					}),
+				},
+				Table: func(w io.Writer) { printCheckHistory(w, history) },
+			})
+			return
+		}
+
+		inspector := services.NewRepositoryInspector()
+		report, err := inspector.Check(alias, repoPath, services.CheckOptions{
+			ReadDataPercent: percent,
+			PasswordFile:    registry.PasswordFile(),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking repository: %v\n", err)
+			notify(registry, services.Notification{Event: services.NotifyEventCheck, Outcome: services.NotifyFailure, Alias: alias,
+				Subject: fmt.Sprintf("check of %s could not run", alias), Message: err.Error()})
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "check_report",
+			Data:       report,
+			RecordKind: "check_problem",
+			Records:    report.Problems,
+			Columns: []output.Column{
+				output.Col("class", func(p services.CheckProblem) string { return p.Class }),
+				output.Col("path", func(p services.CheckProblem) string { return p.Path }),
+				output.Col("detail", func(p services.CheckProblem) string { return p.Detail }),
+			},
+			Table: func(w io.Writer) { printCheckReport(w, report) },
+		})
+		notify(registry, checkNotification(report))
+
+		if !report.OK {
+			os.Exit(1)
+		}
+	},
+}
+
+// parsePercent accepts "10%" or "10" and returns a share between 0 (exclusive) and 100
+func parsePercent(s string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	if value <= 0 || value > 100 {
+		return 0, fmt.Errorf("percentage %q must be above 0 and at most 100", s)
+	}
+	return value, nil
+}
+
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64) + "%"
+}
+
+func printCheckReport(out io.Writer, report *services.CheckReport) {
+	fmt.Fprintf(out, "Checked %d backup(s), %d bundle(s) and %d indexed chunk(s).\n",
+		report.Backups, report.Bundles, report.IndexedChunks)
+	if report.ReadDataPercent > 0 {
+		fmt.Fprintf(out, "Read %d bundle(s) (%s, %s) and verified %d chunk(s).\n",
+			report.BundlesRead, formatPercent(report.ReadDataPercent), humanize.Bytes(uint64(report.BytesRead)), report.ChunksVerified)
+	}
+	fmt.Fprintln(out, "")
+
+	if report.OK {
+		fmt.Fprintf(out, "Repository '%s' is intact.\n", report.Alias)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tPATH\tDETAILS")
+	for _, p := range report.Problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Class, p.Path, p.Detail)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+	fmt.Fprintf(out, "%d problem(s) found in repository '%s'.\n", len(report.Problems), report.Alias)
+}
+
+func printCheckHistory(out io.Writer, history []services.CheckSummary) {
+	if len(history) == 0 {
+		fmt.Fprintln(out, "No checks recorded.")
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tCOMMAND\tRESULT\tPROBLEMS\tBACKUPS\tBUNDLES\tDATA READ\tDURATION")
+	for k := len(history) - 1; k >= 0; k-- {
+		s := history[k]
+		result := "ok"
+		if !s.OK {
+			result = "failed"
+		}
+		dataRead := "-"
+		if s.ReadDataPercent > 0 {
+			dataRead = fmt.Sprintf("%s (%d bundles)", formatPercent(s.ReadDataPercent), s.BundlesRead)
+		} else if s.Command == services.HistoryVerify {
+			dataRead = humanize.Bytes(uint64(s.BytesRead))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%.1fs\n",
+			s.StartedAt.Format("2006-01-02 15:04:05"), s.Command, result, s.ProblemCount, s.Backups, s.Bundles, dataRead, s.DurationSeconds)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&checkReadDataSubset, "read-data-subset", "", "Also decompress and verify this share of bundles, e.g. 10%")
+	checkCmd.Flags().BoolVar(&checkHistory, "history", false, "Show past checks instead of running one")
+	addOutputFlags(checkCmd)
+}
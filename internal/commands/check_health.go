@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkHealthAll     bool
+	checkHealthTimeout time.Duration
+)
+
+var checkHealthCmd = &cobra.Command{
+	Use:   "check-health [alias|--all]",
+	Short: "Evaluate repository health for monitoring systems",
+	Long: `Evaluates the health expectations configured for a repository (settings.<alias>.health in the
+registry): the maximum age of the newest backup per suffix, the minimum number of backups, the maximum
+repository size, the minimum free space and the maximum age of the last successful 'check'. Every
+repository is also checked for reachability.
+
+The output follows the monitoring plugin conventions of Nagios and Icinga: a summary line with
+performance data, then one OK, WARNING, CRITICAL or UNKNOWN line per check. The exit code is 0, 1, 2
+or 3 accordingly, so the command also works as a systemd OnFailure trigger.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		if checkHealthAll == (len(args) == 1) {
+			healthUnknown("specify an alias or --all")
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			healthUnknown(fmt.Sprintf("error loading registry: %v", err))
+		}
+
+		repos := registry.List()
+		if !checkHealthAll {
+			path, ok := registry.Get(args[0])
+			if !ok {
+				healthUnknown("repository not found: " + args[0])
+			}
+			repos = map[string]string{args[0]: path}
+		}
+		expectations := make(map[string]*registries.HealthExpectations)
+		for alias := range repos {
+			expectations[alias] = registry.GetSettings(alias).Health
+		}
+
+		report := services.NewRepositoryInspector().CheckHealth(repos, expectations, services.StatusOptions{Timeout: checkHealthTimeout})
+
+		render(format, output.Document{
+			Kind:       "health_report",
+			Data:       report,
+			RecordKind: "health_check",
+			Records:    report.Checks,
+			Columns: []output.Column{
+				output.Col("alias", func(c services.HealthCheck) string { return c.Alias }),
+				output.Col("check", func(c services.HealthCheck) string { return c.Name }),
+				output.Col("state", func(c services.HealthCheck) string { return c.State }),
+				output.Col("message", func(c services.HealthCheck) string { return c.Message }),
+				output.Col("perfdata", func(c services.HealthCheck) string { return formatPerfData(c.Perf) }),
+			},
+			Table: func(w io.Writer) { printHealthReport(w, report, len(repos)) },
+		})
+		for _, note := range healthNotifications(report) {
+			notify(registry, note)
+		}
+		os.Exit(services.HealthExitCode(report.State))
+	},
+}
+
+// healthUnknown reports a problem that prevents the evaluation, as monitoring plugins do
+func healthUnknown(message string) {
+	fmt.Printf("%s - %s\n", services.HealthUnknown, message)
+	os.Exit(services.HealthExitCode(services.HealthUnknown))
+}
+
+func printHealthReport(out io.Writer, report *services.HealthReport, repos int) {
+	var perf []services.PerfData
+	for _, c := range report.Checks {
+		perf = append(perf, c.Perf...)
+	}
+	summary := fmt.Sprintf("%s - %d repository(ies): %d critical, %d warning, %d unknown, %d ok", report.State,
+		repos, report.Critical, report.Warning, report.Unknown, report.OK)
+	if len(perf) > 0 {
+		summary += " | " + formatPerfData(perf)
+	}
+	fmt.Fprintln(out, summary)
+	for _, c := range report.Checks {
+		fmt.Fprintf(out, "%s %s %s: %s\n", c.State, c.Alias, c.Name, c.Message)
+	}
+}
+
+func formatPerfData(perf []services.PerfData) string {
+	values := make([]string, len(perf))
+	for k, p := range perf {
+		values[k] = p.String()
+	}
+	return strings.Join(values, " ")
+}
+
+func init() {
+	rootCmd.AddCommand(checkHealthCmd)
+	checkHealthCmd.Flags().BoolVar(&checkHealthAll, "all", false, "Check every registered repository")
+	checkHealthCmd.Flags().DurationVar(&checkHealthTimeout, "timeout", services.DefaultStatusTimeout, "Report a repository as offline if it does not answer in time")
+	addOutputFlags(checkHealthCmd)
+}
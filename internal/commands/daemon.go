@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"zbwrap/internal/jobs"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonMaxConcurrent   int
+	daemonShutdownTimeout time.Duration
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run backup jobs on their schedules",
+	Long: `Runs the jobs that have a "schedule" (a cron expression such as "30 1 * * *" or "@daily") in the
+foreground, as a service manager expects. Each scheduled run is delayed by a random duration of up to
+the job's "jitter". With "catch_up", a job whose scheduled run was missed while the daemon was not
+running is run on start.
+
+A job waits while an earlier run of it or another job using one of its targets is running, and at most
+--max-concurrent jobs run at once. Runs are recorded in the job history ('zbwrap jobs show') and notify
+like 'zbwrap run' does.
+
+SIGHUP reloads the jobs from the registry. SIGINT or SIGTERM stops scheduling and gives running jobs
+--shutdown-timeout to finish; they are then cancelled, which stops their sources and removes their
+partial backups.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if daemonMaxConcurrent < 1 {
+			fmt.Fprintln(os.Stderr, "Error: --max-concurrent must be at least 1")
+			os.Exit(1)
+		}
+
+		stateDir := filepath.Dir(registries.NewLocalRegistry().ConfigPath())
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+		load := func() (*registries.LocalRegistry, error) {
+			registry := registries.NewLocalRegistry()
+			if err := registry.Load(); err != nil {
+				return nil, fmt.Errorf("error loading registry: %w", err)
+			}
+			return registry, nil
+		}
+
+		// Notification state is shared by the jobs running at once
+		var notifyMu sync.Mutex
+		daemon := jobs.NewDaemon(load, stateDir, jobs.DaemonOptions{
+			MaxConcurrent:   daemonMaxConcurrent,
+			ShutdownTimeout: daemonShutdownTimeout,
+			OnRun: func(registry *registries.LocalRegistry, job registries.Job, run *jobs.Run) {
+				if len(registry.Notifications) == 0 {
+					return
+				}
+				notifyMu.Lock()
+				defer notifyMu.Unlock()
+				notifier := services.NewNotifier(registry.Notifications, stateDir)
+				for _, note := range jobNotifications(job, run) {
+					for _, d := range notifier.Notify(note) {
+						if d.Error != "" {
+							logger.Printf("Warning: notification to %s failed: %s", d.Channel, d.Error)
+						}
+					}
+				}
+			},
+			Logf: logger.Printf,
+		})
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		reload := make(chan struct{})
+		go func() {
+			for range hup {
+				logger.Printf("Reloading jobs")
+				reload <- struct{}{}
+			}
+		}()
+
+		if err := daemon.Run(ctx, reload); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().IntVar(&daemonMaxConcurrent, "max-concurrent", 1, "Run at most this many jobs at once")
+	daemonCmd.Flags().DurationVar(&daemonShutdownTimeout, "shutdown-timeout", jobs.DefaultShutdownTimeout, "Let running jobs finish for this long on shutdown before cancelling them")
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var duRefresh bool
+
+var duCmd = &cobra.Command{
+	Use:   "du [alias]",
+	Short: "Show repository disk usage by component",
+	Long: `Breaks the disk usage of a repository down into bundles, index, backups, sidecars and tmp files,
+lists the largest bundles and reports the free space and inodes of the filesystem holding it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		inspector := services.NewRepositoryInspector()
+		report, err := inspector.DiskUsage(alias, repoPath, duRefresh)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error inspecting repository: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "disk_usage",
+			Data:       report,
+			RecordKind: "usage_component",
+			Records:    report.Usage.Components(),
+			Columns: []output.Column{
+				output.Col("component", func(c services.UsageComponent) string { return c.Component }),
+				output.Col("bytes", func(c services.UsageComponent) string { return csvInt(c.Bytes) }),
+				output.Col("files", func(c services.UsageComponent) string { return csvInt(int64(c.Files)) }),
+			},
+			Table: func(w io.Writer) { printUsageReport(w, report) },
+		})
+	},
+}
+
+func printUsageReport(out io.Writer, report *services.UsageReport) {
+	fmt.Fprintf(out, "REPOSITORY: %s [%s]\n", report.Alias, report.PhysicalPath)
+	fmt.Fprintf(out, "TOTAL DISK USAGE: %s\n", humanize.Bytes(uint64(report.TotalSizeBytes)))
+	fmt.Fprintln(out, "")
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tSIZE\tFILES\tSHARE")
+	for _, c := range report.Usage.Components() {
+		share := 0.0
+		if report.TotalSizeBytes > 0 {
+			share = float64(c.Bytes) * 100 / float64(report.TotalSizeBytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\n", c.Component, humanize.Bytes(uint64(c.Bytes)), c.Files, share)
+	}
+	w.Flush()
+
+	if len(report.Usage.LargestBundles) > 0 {
+		fmt.Fprintln(out, "")
+		w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "LARGEST BUNDLES\tSIZE")
+		for _, b := range report.Usage.LargestBundles {
+			fmt.Fprintf(w, "%s\t%s\n", b.ID, humanize.Bytes(uint64(b.Bytes)))
+		}
+		w.Flush()
+	}
+
+	if fs := report.Usage.Filesystem; fs != nil {
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, formatFilesystemUsage(fs))
+	}
+}
+
+// formatFilesystemUsage summarizes free space and inode usage on one line
+func formatFilesystemUsage(fs *services.FilesystemUsage) string {
+	line := fmt.Sprintf("FILESYSTEM: %s available of %s", humanize.Bytes(fs.AvailableBytes), humanize.Bytes(fs.TotalBytes))
+	if fs.TotalInodes > 0 {
+		used := fs.TotalInodes - fs.FreeInodes
+		line += fmt.Sprintf(", inodes %d of %d used (%.1f%%)", used, fs.TotalInodes, float64(used)*100/float64(fs.TotalInodes))
+	}
+	return line
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+	duCmd.Flags().BoolVar(&duRefresh, "refresh", false, "Ignore the repository catalog and rescan everything")
+	addOutputFlags(duCmd)
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	estimateDecompress bool
+)
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [alias]",
+	Short: "Estimate how much new input would add to a repository",
+	Long: `Reads a stream from stdin, splits it into chunks the way zbackup does and looks them up in the
+repository index, then reports the bytes a backup would add and the deduplication ratio. Nothing is
+written. Byte counts are before bundle compression. 'backup --estimate-only' does the same.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		opts := services.BackupOptions{CompressedInput: registry.GetSettings(alias).CompressedInput}
+		if estimateDecompress {
+			opts.CompressedInput = services.CompressedInputDecompress
+		}
+
+		report, err := services.NewBackupRunner(registry).Estimate(repoPath, opts, os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error estimating input: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "estimate",
+			Data:       report,
+			RecordKind: "estimate",
+			Records:    []*services.EstimateReport{report},
+			Columns: []output.Column{
+				output.Col("input_bytes", func(r *services.EstimateReport) string { return strconv.FormatUint(r.InputBytes, 10) }),
+				output.Col("chunks", func(r *services.EstimateReport) string { return strconv.Itoa(r.Chunks) }),
+				output.Col("new_chunks", func(r *services.EstimateReport) string { return strconv.Itoa(r.NewChunks) }),
+				output.Col("new_bytes", func(r *services.EstimateReport) string { return strconv.FormatUint(r.NewBytes, 10) }),
+				output.Col("duplicate_bytes", func(r *services.EstimateReport) string { return strconv.FormatUint(r.DuplicateBytes, 10) }),
+				output.Col("dedup_ratio", func(r *services.EstimateReport) string { return strconv.FormatFloat(r.DedupRatio, 'f', 2, 64) }),
+			},
+			Table: func(w io.Writer) { printEstimate(w, report) },
+		})
+	},
+}
+
+func printEstimate(out io.Writer, report *services.EstimateReport) {
+	if report.Compression != nil {
+		fmt.Fprintf(out, "Input:           %s (after %s decompression)\n", humanize.Bytes(report.InputBytes), report.Compression.Codec)
+	} else {
+		fmt.Fprintf(out, "Input:           %s\n", humanize.Bytes(report.InputBytes))
+	}
+	fmt.Fprintf(out, "Chunks:          %d\n", report.Chunks)
+	fmt.Fprintf(out, "Already stored:  %s (%.1f%%)\n", humanize.Bytes(report.DuplicateBytes), report.DuplicateShare*100)
+	fmt.Fprintf(out, "New:             %s in %d chunk(s), before compression\n", humanize.Bytes(report.NewBytes), report.NewChunks)
+	if report.NewBytes == 0 && report.InputBytes > 0 {
+		fmt.Fprintln(out, "Dedup ratio:     everything is already stored")
+	} else {
+		fmt.Fprintf(out, "Dedup ratio:     %s\n", formatRatio(report.DedupRatio))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(estimateCmd)
+	estimateCmd.Flags().BoolVar(&estimateDecompress, "decompress", false, "decompress gzip, xz or zstd input first, as backup --decompress does")
+	addOutputFlags(estimateCmd)
+}
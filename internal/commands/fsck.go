@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckRepair bool
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck [alias]",
+	Short: "Check repository consistency",
+	Long: `Reports orphan and corrupt sidecars, incomplete or empty backups, names that do not follow
+the naming schema, leftover zbackup tmp/ files and index entries whose bundles are missing.
+The index of encrypted repositories is read with the configured password file.
+Use --repair to apply a safe, logged fix to each problem; removed files are kept in .zbwrap-quarantine.
+zbackup's own files are never modified: problems in the index are reported with a hint
+to run 'zbackup gc'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		inspector := services.NewRepositoryInspector()
+		report, err := inspector.Fsck(alias, repoPath, services.FsckOptions{
+			Repair:       fsckRepair,
+			PasswordFile: registry.PasswordFile(),
+			Log:          os.Stderr,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking repository: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "fsck_report",
+			Data:       report,
+			RecordKind: "fsck_problem",
+			Records:    report.Problems,
+			Columns: []output.Column{
+				output.Col("class", func(p services.FsckProblem) string { return p.Class }),
+				output.Col("path", func(p services.FsckProblem) string { return p.Path }),
+				output.Col("detail", func(p services.FsckProblem) string { return p.Detail }),
+				output.Col("repair", func(p services.FsckProblem) string { return p.Repair }),
+			},
+			Table: func(w io.Writer) { printFsckReport(w, report) },
+		})
+
+		if len(report.Problems) > 0 && !fsckRepair {
+			os.Exit(1)
+		}
+	},
+}
+
+func printFsckReport(out io.Writer, report *services.FsckReport) {
+	if len(report.Problems) == 0 {
+		fmt.Fprintf(out, "Repository '%s' is consistent.\n", report.Alias)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	if report.Repaired {
+		fmt.Fprintln(w, "PROBLEM\tPATH\tDETAILS\tREPAIR")
+	} else {
+		fmt.Fprintln(w, "PROBLEM\tPATH\tDETAILS")
+	}
+	for _, p := range report.Problems {
+		if report.Repaired {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Class, p.Path, p.Detail, p.Repair)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Class, p.Path, p.Detail)
+		}
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+	fmt.Fprintf(out, "%d problem(s) found in repository '%s'.\n", len(report.Problems), report.Alias)
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "Apply safe repairs to the problems found")
+	addOutputFlags(fsckCmd)
+}
@@ -1,27 +1,52 @@
 package commands
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strconv"
 	"text/tabwriter"
+	"time"
 
+	"zbwrap/internal/output"
 	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
 	"zbwrap/internal/services"
 
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
-var infoJson bool
+var (
+	infoSelect  string
+	infoSince   string
+	infoUntil   string
+	infoSuffix  string
+	infoMime    string
+	infoStatus  string
+	infoGrep    string
+	infoSort    string
+	infoReverse bool
+	infoLimit   int
+	infoOffset  int
+	infoCursor  string
+	infoRefresh bool
+	infoUnique  bool
+)
 
 var infoCmd = &cobra.Command{
 	Use:   "info [alias]",
 	Short: "Show repository details",
-	Long:  `Displays detailed information about a specific repository, including disk usage and backup history.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Displays detailed information about a specific repository, including disk usage and backup history.
+Results are served from the repository catalog (.zbwrap/catalog.json) for anything unchanged since the last scan;
+use --refresh to rescan everything. With --unique, chunk references are read from the repository index and
+backup files to show, for each backup, the bytes no other backup uses (what deleting it would free after
+zbackup gc) and the bytes it shares with the previous and next backup of the same suffix.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		alias := args[0]
+		format := selectedFormat()
 
 		registry := registries.NewLocalRegistry()
 		if err := registry.Load(); err != nil {
@@ -35,44 +60,193 @@ var infoCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		opts, err := infoOptions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.PasswordFile = registry.PasswordFile()
+
 		inspector := services.NewRepositoryInspector()
-		details, err := inspector.Inspect(alias, path)
+		details, err := inspector.InspectWithOptions(alias, path, opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error inspecting repository: %v\n", err)
 			os.Exit(1)
 		}
 
-		if infoJson {
-			output, err := json.MarshalIndent(details, "", "  ")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Println(string(output))
-		} else {
-			printHumanReadable(details)
+		columns := backupColumns
+		if infoUnique {
+			columns = append(append([]output.Column{}, backupColumns...), uniqueColumns...)
 		}
+
+		render(format, output.Document{
+			Kind:       "repository",
+			Data:       details,
+			RecordKind: "backup",
+			Records:    details.Backups,
+			Columns:    columns,
+			Table:      func(w io.Writer) { printHumanReadable(w, details) },
+		})
 	},
 }
 
-func printHumanReadable(details *services.RepoDetails) {
-	fmt.Printf("REPOSITORY: %s [%s]\n", details.Alias, details.PhysicalPath)
-	fmt.Printf("TOTAL DISK USAGE: %s\n", humanize.Bytes(uint64(details.TotalSizeBytes)))
-	fmt.Println("-------------------------------------------------------------------------------------------------")
+// backupColumns are the CSV columns of a backup listing
+var backupColumns = []output.Column{
+	output.Col("filename", func(b services.BackupItem) string { return b.Filename }),
+	output.Col("suffix", func(b services.BackupItem) string { return b.Suffix }),
+	output.Col("date", func(b services.BackupItem) string { return csvTime(b.Date) }),
+	output.Col("size_bytes", func(b services.BackupItem) string { return csvInt(b.SizeBytes) }),
+	output.Col("logical_size_bytes", func(b services.BackupItem) string { return csvInt(b.LogicalSizeBytes) }),
+	output.Col("mime_type", func(b services.BackupItem) string { return b.MimeType }),
+	output.Col("status", func(b services.BackupItem) string { return b.Status }),
+	output.Col("description", func(b services.BackupItem) string { return b.Description }),
+	output.Col("tags", func(b services.BackupItem) string { return csvTags(b.Tags) }),
+}
+
+// uniqueColumns are added to backupColumns by --unique
+var uniqueColumns = []output.Column{
+	output.Col("chunk_bytes", func(b services.BackupItem) string {
+		return uniqueCell(b, func(u *services.ChunkUsage) uint64 { return u.ChunkBytes })
+	}),
+	output.Col("unique_bytes", func(b services.BackupItem) string {
+		return uniqueCell(b, func(u *services.ChunkUsage) uint64 { return u.UniqueBytes })
+	}),
+	output.Col("shared_previous_bytes", func(b services.BackupItem) string {
+		return uniqueCell(b, func(u *services.ChunkUsage) uint64 { return u.SharedPreviousBytes })
+	}),
+	output.Col("shared_next_bytes", func(b services.BackupItem) string {
+		return uniqueCell(b, func(u *services.ChunkUsage) uint64 { return u.SharedNextBytes })
+	}),
+}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "BACKUP NAME\tDATE\tMIME TYPE\tDESCRIPTION")
+// uniqueCell formats a chunk usage figure, leaving it empty when the backup could not be read
+func uniqueCell(b services.BackupItem, value func(*services.ChunkUsage) uint64) string {
+	if b.Unique == nil || b.Unique.Error != "" {
+		return ""
+	}
+	return strconv.FormatUint(value(b.Unique), 10)
+}
+
+// infoOptions builds the inspection query from the command line flags
+func infoOptions() (services.InspectOptions, error) {
+	opts := services.InspectOptions{
+		Suffix:   infoSuffix,
+		MimeType: infoMime,
+		Status:   infoStatus,
+		SortBy:   infoSort,
+		Reverse:  infoReverse,
+		Limit:    infoLimit,
+		Offset:   infoOffset,
+		Cursor:   infoCursor,
+		Refresh:  infoRefresh,
+		Unique:   infoUnique,
+	}
+	if !services.ValidSortBy(infoSort) {
+		return opts, fmt.Errorf("invalid --sort '%s' (expected date, size or name)", infoSort)
+	}
+	if infoLimit < 0 || infoOffset < 0 {
+		return opts, fmt.Errorf("--limit and --offset must not be negative")
+	}
+
+	now := time.Now()
+	var err error
+	if infoSince != "" {
+		if opts.Since, err = selectors.ParseWhen(infoSince, now); err != nil {
+			return opts, fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if infoUntil != "" {
+		if opts.Until, err = selectors.ParseWhen(infoUntil, now); err != nil {
+			return opts, fmt.Errorf("invalid --until: %w", err)
+		}
+	}
+	if infoGrep != "" {
+		if opts.Grep, err = regexp.Compile(infoGrep); err != nil {
+			return opts, fmt.Errorf("invalid --grep: %w", err)
+		}
+	}
+	if infoSelect != "" {
+		selector, err := selectors.Parse(infoSelect)
+		if err != nil {
+			return opts, err
+		}
+		opts.Match = selector.Match
+	}
+	return opts, nil
+}
+
+func printHumanReadable(out io.Writer, details *services.RepoDetails) {
+	fmt.Fprintf(out, "REPOSITORY: %s [%s]\n", details.Alias, details.PhysicalPath)
+	fmt.Fprintf(out, "TOTAL DISK USAGE: %s\n", humanize.Bytes(uint64(details.TotalSizeBytes)))
+	if u := details.Usage; u != nil {
+		fmt.Fprintf(out, "  bundles %s, index %s, backups %s, sidecars %s, tmp %s, other %s\n",
+			humanize.Bytes(uint64(u.Bundles.Bytes)), humanize.Bytes(uint64(u.Index.Bytes)), humanize.Bytes(uint64(u.Backups.Bytes)),
+			humanize.Bytes(uint64(u.Sidecars.Bytes)), humanize.Bytes(uint64(u.Tmp.Bytes)), humanize.Bytes(uint64(u.Other.Bytes)))
+		if u.Filesystem != nil {
+			fmt.Fprintln(out, formatFilesystemUsage(u.Filesystem))
+		}
+	}
+	fmt.Fprintln(out, "-------------------------------------------------------------------------------------------------")
+
+	showUnique := false
+	for _, b := range details.Backups {
+		showUnique = showUnique || b.Unique != nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	if showUnique {
+		fmt.Fprintln(w, "BACKUP NAME\tDATE\tSIZE\tUNIQUE\tSHARED PREV\tSHARED NEXT\tDESCRIPTION")
+	} else {
+		fmt.Fprintln(w, "BACKUP NAME\tDATE\tSIZE\tMIME TYPE\tDESCRIPTION")
+	}
 	fmt.Fprintln(w, "-------------------------------------------------------------------------------------------------")
 
 	for _, b := range details.Backups {
 		dateStr := b.Date.Format("Jan 02, 15:04")
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Filename, dateStr, b.MimeType, b.Description)
+		if showUnique {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.Filename, dateStr, humanize.Bytes(uint64(b.SizeBytes)), formatChunkUsage(b.Unique), b.Description)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.Filename, dateStr, humanize.Bytes(uint64(b.SizeBytes)), b.MimeType, b.Description)
 	}
 	w.Flush()
-	fmt.Println("")
+	fmt.Fprintln(out, "")
+
+	if len(details.Backups) < details.TotalBackups {
+		fmt.Fprintf(out, "Showing %d of %d backups.", len(details.Backups), details.TotalBackups)
+		if details.NextCursor != "" {
+			fmt.Fprintf(out, " Next page: --cursor %s", details.NextCursor)
+		}
+		fmt.Fprintln(out, "")
+	}
+}
+
+// formatChunkUsage renders the unique, shared-previous and shared-next columns
+func formatChunkUsage(u *services.ChunkUsage) string {
+	switch {
+	case u == nil:
+		return "-\t-\t-"
+	case u.Error != "":
+		return "unreadable\t-\t-"
+	}
+	return humanize.Bytes(u.UniqueBytes) + "\t" + humanize.Bytes(u.SharedPreviousBytes) + "\t" + humanize.Bytes(u.SharedNextBytes)
 }
 
 func init() {
 	rootCmd.AddCommand(infoCmd)
-	infoCmd.Flags().BoolVarP(&infoJson, "json", "j", false, "Output in JSON format")
+	addOutputFlags(infoCmd)
+	infoCmd.Flags().StringVarP(&infoSelect, "select", "S", "", "Only show backups matching a selector (e.g. 'tag:env=prod since:30d')")
+	infoCmd.Flags().StringVar(&infoSince, "since", "", "Only backups taken on or after a date (YYYY-MM-DD) or duration ago (e.g. 30d)")
+	infoCmd.Flags().StringVar(&infoUntil, "until", "", "Only backups taken before a date (YYYY-MM-DD) or duration ago")
+	infoCmd.Flags().StringVar(&infoSuffix, "suffix", "", "Only backups with this suffix")
+	infoCmd.Flags().StringVar(&infoMime, "mime", "", "Only backups whose MIME type starts with this value")
+	infoCmd.Flags().StringVar(&infoStatus, "status", "", "Only backups with this sidecar status")
+	infoCmd.Flags().StringVar(&infoGrep, "grep", "", "Only backups whose description matches a regular expression")
+	infoCmd.Flags().StringVar(&infoSort, "sort", services.SortByDate, "Sort by date, size or name")
+	infoCmd.Flags().BoolVar(&infoReverse, "reverse", false, "Reverse the sort order")
+	infoCmd.Flags().IntVar(&infoLimit, "limit", 0, "Show at most this many backups (0 for all)")
+	infoCmd.Flags().IntVar(&infoOffset, "offset", 0, "Skip this many backups")
+	infoCmd.Flags().StringVar(&infoCursor, "cursor", "", "Continue after the page that returned this next_cursor")
+	infoCmd.Flags().BoolVar(&infoRefresh, "refresh", false, "Ignore the repository catalog and rescan everything")
+	infoCmd.Flags().BoolVar(&infoUnique, "unique", false, "Show the bytes unique to each backup and shared with its neighbours")
 }
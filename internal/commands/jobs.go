@@ -0,0 +1,326 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"zbwrap/internal/jobs"
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	jobsShowRuns int
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List, show and validate backup jobs",
+	Long: `Backup jobs are declared under "jobs" in the registry. Each names a source (a shell command, a list of
+paths archived with tar, or stdin), the repositories it is backed up to, and optionally a suffix, a
+description template, tags, hooks, retention and a timeout. 'zbwrap run <job>' runs a job; 'zbwrap daemon'
+runs the jobs that have a schedule.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backup jobs and their last run",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		registry := loadRegistry()
+
+		summaries, err := newJobRunner(registry).List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading job history: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "job_list",
+			Data:       summaries,
+			RecordKind: "job",
+			Records:    summaries,
+			Columns: []output.Column{
+				output.Col("name", func(s jobs.Summary) string { return s.Name }),
+				output.Col("source", func(s jobs.Summary) string { return jobs.DescribeSource(s.Source) }),
+				output.Col("targets", func(s jobs.Summary) string { return strings.Join(s.Targets, ";") }),
+				output.Col("suffix", func(s jobs.Summary) string { return jobs.Suffix(s.Job) }),
+				output.Col("schedule", func(s jobs.Summary) string { return s.Schedule }),
+				output.Col("last_run", func(s jobs.Summary) string {
+					if s.LastRun == nil {
+						return ""
+					}
+					return csvTime(s.LastRun.StartedAt)
+				}),
+				output.Col("last_run_ok", func(s jobs.Summary) string {
+					if s.LastRun == nil {
+						return ""
+					}
+					return strconv.FormatBool(s.LastRun.OK)
+				}),
+				output.Col("next_run", func(s jobs.Summary) string {
+					if s.NextRun == nil {
+						return ""
+					}
+					return csvTime(*s.NextRun)
+				}),
+			},
+			Table: func(w io.Writer) { printJobList(w, summaries) },
+		})
+	},
+}
+
+var jobsShowCmd = &cobra.Command{
+	Use:   "show [job]",
+	Short: "Show a backup job and its recent runs",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		registry := loadRegistry()
+
+		job, ok := registry.GetJob(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: job '%s' not found\n", args[0])
+			os.Exit(1)
+		}
+		runs, err := newJobRunner(registry).History(job.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading job history: %v\n", err)
+			os.Exit(1)
+		}
+		if jobsShowRuns >= 0 && len(runs) > jobsShowRuns {
+			runs = runs[:jobsShowRuns]
+		}
+		if runs == nil {
+			runs = []jobs.Run{}
+		}
+
+		data := struct {
+			Job  registries.Job `json:"job"`
+			Runs []jobs.Run     `json:"runs"`
+		}{job, runs}
+		render(format, output.Document{
+			Kind:       "job",
+			Data:       data,
+			RecordKind: "job_run",
+			Records:    runs,
+			Columns: []output.Column{
+				output.Col("started_at", func(r jobs.Run) string { return csvTime(r.StartedAt) }),
+				output.Col("ok", func(r jobs.Run) string { return strconv.FormatBool(r.OK) }),
+				output.Col("duration_seconds", func(r jobs.Run) string { return strconv.FormatFloat(r.DurationSeconds, 'f', 3, 64) }),
+				output.Col("steps", func(r jobs.Run) string { return strconv.Itoa(len(r.Steps)) }),
+				output.Col("failed_steps", func(r jobs.Run) string { return strconv.Itoa(len(failedSteps(r))) }),
+			},
+			Table: func(w io.Writer) { printJob(w, job, runs) },
+		})
+	},
+}
+
+var jobsValidateCmd = &cobra.Command{
+	Use:   "validate [job]",
+	Short: "Check backup job definitions",
+	Long: `Checks every job, or only the given one: names and suffixes, that exactly one source is set, that the
+targets are registered repositories, and that templates, timeouts, hooks, retention selectors and
+schedules parse.
+The exit code is 1 if a problem is found.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		registry := loadRegistry()
+
+		problems := jobs.ValidateAll(registry)
+		checked := len(registry.Jobs)
+		if len(args) == 1 {
+			if _, ok := registry.GetJob(args[0]); !ok {
+				fmt.Fprintf(os.Stderr, "Error: job '%s' not found\n", args[0])
+				os.Exit(1)
+			}
+			filtered := []jobs.Problem{}
+			for _, p := range problems {
+				if p.Job == args[0] {
+					filtered = append(filtered, p)
+				}
+			}
+			problems = filtered
+			checked = 1
+		}
+
+		render(format, output.Document{
+			Kind:       "job_validation",
+			Data:       problems,
+			RecordKind: "job_problem",
+			Records:    problems,
+			Columns: []output.Column{
+				output.Col("job", func(p jobs.Problem) string { return p.Job }),
+				output.Col("message", func(p jobs.Problem) string { return p.Message }),
+			},
+			Table: func(w io.Writer) { printJobProblems(w, problems, checked) },
+		})
+
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// loadRegistry loads the registry, exiting if it cannot be read
+func loadRegistry() *registries.LocalRegistry {
+	registry := registries.NewLocalRegistry()
+	if err := registry.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+		os.Exit(1)
+	}
+	return registry
+}
+
+// failedSteps returns the steps of a run that did not succeed
+func failedSteps(run jobs.Run) []jobs.Step {
+	var failed []jobs.Step
+	for _, s := range run.Steps {
+		if s.Status == jobs.StepFailed {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+func printJobList(out io.Writer, summaries []jobs.Summary) {
+	if len(summaries) == 0 {
+		fmt.Fprintln(out, "No jobs configured.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSOURCE\tTARGETS\tSCHEDULE\tLAST RUN\tRESULT\tNEXT RUN")
+	for _, s := range summaries {
+		lastRun, result, schedule, nextRun := "never", "-", "manual", "-"
+		if s.LastRun != nil {
+			lastRun = s.LastRun.StartedAt.Format("2006-01-02 15:04:05")
+			result = runResult(*s.LastRun)
+		}
+		if s.Schedule != "" {
+			schedule = s.Schedule
+		}
+		if s.NextRun != nil {
+			nextRun = s.NextRun.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, jobs.DescribeSource(s.Source), strings.Join(s.Targets, ", "),
+			schedule, lastRun, result, nextRun)
+	}
+	w.Flush()
+}
+
+func printJob(out io.Writer, job registries.Job, runs []jobs.Run) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Job:\t%s\n", job.Name)
+	fmt.Fprintf(w, "Source:\t%s\n", jobs.DescribeSource(job.Source))
+	fmt.Fprintf(w, "Targets:\t%s\n", strings.Join(job.Targets, ", "))
+	fmt.Fprintf(w, "Suffix:\t%s\n", jobs.Suffix(job))
+	if job.Description != "" {
+		fmt.Fprintf(w, "Description:\t%s\n", job.Description)
+	}
+	if len(job.Tags) > 0 {
+		fmt.Fprintf(w, "Tags:\t%s\n", strings.ReplaceAll(csvTags(job.Tags), ";", ", "))
+	}
+	if job.Retention != nil {
+		retention := fmt.Sprintf("keep last %d", job.Retention.KeepLast)
+		if job.Retention.Select != "" {
+			retention += ", prune " + job.Retention.Select
+		}
+		fmt.Fprintf(w, "Retention:\t%s\n", retention)
+	}
+	if job.Timeout != "" {
+		fmt.Fprintf(w, "Timeout:\t%s\n", job.Timeout)
+	}
+	if job.Schedule != "" {
+		schedule := job.Schedule
+		if job.Jitter != "" {
+			schedule += ", jitter " + job.Jitter
+		}
+		if job.CatchUp {
+			schedule += ", catch up missed runs"
+		}
+		fmt.Fprintf(w, "Schedule:\t%s\n", schedule)
+	}
+	if job.Hooks != nil {
+		fmt.Fprintf(w, "Hooks:\t%s\n", describeHooks(job.Hooks))
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+
+	if len(runs) == 0 {
+		fmt.Fprintln(out, "No runs recorded.")
+		return
+	}
+	w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tRESULT\tDURATION\tDETAILS")
+	for _, run := range runs {
+		var details []string
+		for _, s := range failedSteps(run) {
+			details = append(details, fmt.Sprintf("%s %s: %s", s.Name, s.Alias, s.Error))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fs\t%s\n", run.StartedAt.Format("2006-01-02 15:04:05"), runResult(run),
+			run.DurationSeconds, strings.Join(details, "; "))
+	}
+	w.Flush()
+}
+
+// runResult is the one-word outcome of a run
+func runResult(run jobs.Run) string {
+	if run.OK {
+		return "ok"
+	}
+	return "failed"
+}
+
+// describeHooks counts the hooks of a job per event
+func describeHooks(hooks *registries.Hooks) string {
+	counts := map[string]int{
+		"pre_backup":  len(hooks.PreBackup),
+		"post_backup": len(hooks.PostBackup),
+		"on_failure":  len(hooks.OnFailure),
+		"pre_prune":   len(hooks.PrePrune),
+		"post_gc":     len(hooks.PostGC),
+	}
+	var parts []string
+	for event, n := range counts {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%s (%d)", event, n))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func printJobProblems(out io.Writer, problems []jobs.Problem, checked int) {
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "%d job(s) valid.\n", checked)
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "JOB\tPROBLEM")
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%s\n", p.Job, p.Message)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+	fmt.Fprintf(out, "%d problem(s) found.\n", len(problems))
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsShowCmd)
+	jobsCmd.AddCommand(jobsValidateCmd)
+	jobsShowCmd.Flags().IntVar(&jobsShowRuns, "runs", 10, "Show at most this many recent runs")
+	addOutputFlags(jobsListCmd)
+	addOutputFlags(jobsShowCmd)
+	addOutputFlags(jobsValidateCmd)
+}
@@ -1,23 +1,31 @@
 package commands
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"text/tabwriter"
 
+	"zbwrap/internal/output"
 	"zbwrap/internal/registries"
 
 	"github.com/spf13/cobra"
 )
 
-var listJson bool
+// repositoryEntry is a single row of the repository list
+type repositoryEntry struct {
+	Alias string `json:"alias"`
+	Path  string `json:"path"`
+}
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List managed repositories",
 	Long:  `Lists all ZBackup repositories currently managed by zbwrap.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+
 		registry := registries.NewLocalRegistry()
 		if err := registry.Load(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
@@ -25,26 +33,34 @@ var listCmd = &cobra.Command{
 		}
 
 		repos := registry.List()
-
-		if listJson {
-			output, err := json.MarshalIndent(repos, "", "  ")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Println(string(output))
-		} else {
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-			fmt.Fprintln(w, "ALIAS\tPATH")
-			for alias, path := range repos {
-				fmt.Fprintf(w, "%s\t%s\n", alias, path)
-			}
-			w.Flush()
+		entries := make([]repositoryEntry, 0, len(repos))
+		for alias, path := range repos {
+			entries = append(entries, repositoryEntry{Alias: alias, Path: path})
 		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Alias < entries[j].Alias })
+
+		render(format, output.Document{
+			Kind:       "repository_list",
+			Data:       entries,
+			RecordKind: "repository",
+			Records:    entries,
+			Columns: []output.Column{
+				output.Col("alias", func(e repositoryEntry) string { return e.Alias }),
+				output.Col("path", func(e repositoryEntry) string { return e.Path }),
+			},
+			Table: func(out io.Writer) {
+				w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+				fmt.Fprintln(w, "ALIAS\tPATH")
+				for _, e := range entries {
+					fmt.Fprintf(w, "%s\t%s\n", e.Alias, e.Path)
+				}
+				w.Flush()
+			},
+		})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
-	listCmd.Flags().BoolVar(&listJson, "json", false, "Output in JSON format")
+	addOutputFlags(listCmd)
 }
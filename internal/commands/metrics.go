@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	metricsTextfile string
+	metricsTimeout  time.Duration
+)
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Write repository metrics in the OpenMetrics text format",
+	Long: `Inspects all registered repositories and writes OpenMetrics gauges: whether each repository is
+reachable, its backup count and failed backups, its size by component, the free space of its
+filesystem, the time, duration and size of the newest backup per suffix, the last failed backup and
+the result of the last 'check'.
+
+With --textfile the metrics replace the given file atomically, for the node_exporter textfile
+collector (e.g. /var/lib/node_exporter/textfile_collector/zbwrap.prom). Use 'zbwrap serve --metrics'
+to expose them over HTTP instead.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		families := services.NewRepositoryInspector().Metrics(registry.List(), services.StatusOptions{Timeout: metricsTimeout})
+
+		var err error
+		if metricsTextfile != "" {
+			err = services.WriteOpenMetricsFile(metricsTextfile, families)
+		} else {
+			err = services.WriteOpenMetrics(os.Stdout, families)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing metrics: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.Flags().StringVar(&metricsTextfile, "textfile", "", "Atomically replace this file instead of writing to stdout")
+	metricsCmd.Flags().DurationVar(&metricsTimeout, "timeout", services.DefaultStatusTimeout, "Report a repository as down if it does not answer in time")
+}
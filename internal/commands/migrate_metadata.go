@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDryRun bool
+)
+
+var migrateMetadataCmd = &cobra.Command{
+	Use:   "migrate-metadata [alias]",
+	Short: "Upgrade metadata sidecars to the current schema",
+	Long: `Rewrites every sidecar (.meta or .meta.json) of the repository using the current schema version.
+Each sidecar is copied to <sidecar>.v<N>.bak before being atomically replaced. Unknown fields are kept.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		inspector := services.NewRepositoryInspector()
+		report, err := inspector.MigrateMetadata(alias, repoPath, migrateDryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error migrating metadata: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "migration_report",
+			Data:       report,
+			RecordKind: "migration_item",
+			Records:    report.Items,
+			Columns: []output.Column{
+				output.Col("path", func(item services.MigrationItem) string { return item.Path }),
+				output.Col("from_version", func(item services.MigrationItem) string { return csvInt(int64(item.FromVersion)) }),
+				output.Col("to_version", func(item services.MigrationItem) string { return csvInt(int64(item.ToVersion)) }),
+				output.Col("action", func(item services.MigrationItem) string { return item.Action }),
+				output.Col("backup_copy", func(item services.MigrationItem) string { return item.BackupCopy }),
+				output.Col("error", func(item services.MigrationItem) string { return item.Error }),
+			},
+			Table: func(w io.Writer) { printMigrationReport(w, report) },
+		})
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func printMigrationReport(out io.Writer, report *services.MigrationReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SIDECAR\tVERSION\tACTION\tDETAILS")
+	for _, item := range report.Items {
+		details := item.Error
+		if details == "" && item.BackupCopy != "" {
+			details = "backup copy: " + item.BackupCopy
+		}
+		fmt.Fprintf(w, "%s\t%d -> %d\t%s\t%s\n", item.Path, item.FromVersion, item.ToVersion, item.Action, details)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run: no sidecars were modified.")
+	}
+	fmt.Fprintf(out, "Migration complete for repository '%s': %d upgraded, %d current, %d failed.\n",
+		report.Alias, report.Upgraded, report.Current, report.Failed)
+}
+
+func init() {
+	rootCmd.AddCommand(migrateMetadataCmd)
+	migrateMetadataCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Report which sidecars would be upgraded without writing them")
+	addOutputFlags(migrateMetadataCmd)
+}
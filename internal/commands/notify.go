@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+	"zbwrap/internal/systemd"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	notifyRunTimeout     time.Duration
+	notifyUnitFailedUser bool
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Test notification channels and send digests and stale backup alerts",
+	Long: `Notification channels are configured under "notifications" in the registry. backup, check, prune and
+check-health notify the channels whose rules match their outcome; digests and stale backup alerts are
+sent by 'zbwrap notify run', which is meant to run periodically.`,
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test [channel]",
+	Short: "Send a test notification to a channel",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		delivery, err := newNotifier(registry).Test(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if delivery.Error != "" {
+			fmt.Fprintf(os.Stderr, "Notification to %s failed: %s\n", delivery.Channel, delivery.Error)
+			os.Exit(1)
+		}
+		fmt.Printf("Test notification sent to %s.\n", delivery.Channel)
+	},
+}
+
+var notifyRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Send due digests and stale backup alerts",
+	Long: `Sends the digests whose interval has passed and alerts about repositories whose newest backup is older
+than a stale rule allows. Each stale backup is reported once; a newer backup clears the alert.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		deliveries := newNotifier(registry).Run(services.NewRepositoryInspector(), registry.List(),
+			services.StatusOptions{Timeout: notifyRunTimeout})
+		if deliveries == nil {
+			deliveries = []services.NotifyDelivery{}
+		}
+
+		render(format, output.Document{
+			Kind:       "notification_report",
+			Data:       deliveries,
+			RecordKind: "notification",
+			Records:    deliveries,
+			Columns: []output.Column{
+				output.Col("channel", func(d services.NotifyDelivery) string { return d.Channel }),
+				output.Col("event", func(d services.NotifyDelivery) string { return d.Event }),
+				output.Col("outcome", func(d services.NotifyDelivery) string { return d.Outcome }),
+				output.Col("alias", func(d services.NotifyDelivery) string { return d.Alias }),
+				output.Col("subject", func(d services.NotifyDelivery) string { return d.Subject }),
+				output.Col("error", func(d services.NotifyDelivery) string { return d.Error }),
+			},
+			Table: func(w io.Writer) { printDeliveries(w, deliveries) },
+		})
+
+		for _, d := range deliveries {
+			if d.Error != "" {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var notifyUnitFailedCmd = &cobra.Command{
+	Use:   "unit-failed [unit]",
+	Short: "Notify that the systemd service of a job failed",
+	Long: `Sends a failure notification with the "job" event. The zbwrap-notify@.service unit written by
+'zbwrap systemd generate' runs it when the service of a job fails, which also covers runs that could
+not notify themselves, such as a job killed by systemd or refused by its sandbox.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		registry := loadRegistry()
+
+		unit := args[0]
+		name := systemd.JobFromUnit(unit)
+		if name == "" {
+			name = unit
+		}
+		journal := "journalctl -u " + unit
+		if notifyUnitFailedUser {
+			journal = "journalctl --user -u " + unit
+		}
+		note := services.Notification{Event: services.NotifyEventJob, Outcome: services.NotifyFailure,
+			Subject: fmt.Sprintf("job %s failed", name), Message: fmt.Sprintf("systemd unit %s failed; see '%s'.", unit, journal)}
+
+		failed := false
+		for _, d := range newNotifier(registry).Notify(note) {
+			if d.Error != "" {
+				fmt.Fprintf(os.Stderr, "Notification to %s failed: %s\n", d.Channel, d.Error)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func printDeliveries(out io.Writer, deliveries []services.NotifyDelivery) {
+	if len(deliveries) == 0 {
+		fmt.Fprintln(out, "Nothing to send.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tEVENT\tOUTCOME\tSUBJECT\tERROR")
+	for _, d := range deliveries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Channel, d.Event, d.Outcome, d.Subject, d.Error)
+	}
+	w.Flush()
+}
+
+func newNotifier(registry *registries.LocalRegistry) *services.Notifier {
+	return services.NewNotifier(registry.Notifications, filepath.Dir(registry.ConfigPath()))
+}
+
+// notify sends a notification to the matching channels. Failed deliveries are reported
+// as warnings and do not change the outcome of the command.
+func notify(registry *registries.LocalRegistry, note services.Notification) {
+	if len(registry.Notifications) == 0 {
+		return
+	}
+	for _, d := range newNotifier(registry).Notify(note) {
+		if d.Error != "" {
+			fmt.Fprintf(os.Stderr, "Warning: notification to %s failed: %s\n", d.Channel, d.Error)
+		}
+	}
+}
+
+// checkNotification summarizes a check report, counting problems by class
+func checkNotification(report *services.CheckReport) services.Notification {
+	note := services.Notification{Event: services.NotifyEventCheck, Outcome: services.NotifySuccess, Alias: report.Alias,
+		Subject: fmt.Sprintf("check of %s passed", report.Alias)}
+	if report.OK {
+		return note
+	}
+	counts := make(map[string]int)
+	for _, p := range report.Problems {
+		counts[p.Class]++
+	}
+	classes := make([]string, 0, len(counts))
+	for class, count := range counts {
+		classes = append(classes, fmt.Sprintf("%s: %d", class, count))
+	}
+	sort.Strings(classes)
+	note.Outcome = services.NotifyFailure
+	note.Subject = fmt.Sprintf("check of %s found %d problem(s)", report.Alias, report.ProblemCount)
+	note.Message = strings.Join(classes, ", ")
+	return note
+}
+
+// healthNotifications summarizes a health report per repository, with the worst state of its checks
+func healthNotifications(report *services.HealthReport) []services.Notification {
+	var notes []services.Notification
+	index := make(map[string]int)
+	severity := map[string]int{services.NotifySuccess: 0, services.NotifyWarning: 1, services.NotifyFailure: 2}
+	for _, c := range report.Checks {
+		k, ok := index[c.Alias]
+		if !ok {
+			k = len(notes)
+			index[c.Alias] = k
+			notes = append(notes, services.Notification{Event: services.NotifyEventHealth, Outcome: services.NotifySuccess,
+				Alias: c.Alias, Subject: fmt.Sprintf("%s is healthy", c.Alias)})
+		}
+		if c.State == services.HealthOK {
+			continue
+		}
+		outcome := services.NotifyFailure
+		if c.State == services.HealthWarning {
+			outcome = services.NotifyWarning
+		}
+		note := &notes[k]
+		if severity[outcome] > severity[note.Outcome] {
+			note.Outcome = outcome
+			note.Subject = fmt.Sprintf("%s %s: %s", c.Alias, c.State, c.Message)
+		}
+		if note.Message != "" {
+			note.Message += "\n"
+		}
+		note.Message += fmt.Sprintf("%s %s: %s", c.State, c.Name, c.Message)
+	}
+	return notes
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+	notifyCmd.AddCommand(notifyRunCmd)
+	notifyCmd.AddCommand(notifyUnitFailedCmd)
+	notifyRunCmd.Flags().DurationVar(&notifyRunTimeout, "timeout", services.DefaultStatusTimeout, "Skip a repository that does not answer in time")
+	notifyUnitFailedCmd.Flags().BoolVar(&notifyUnitFailedUser, "user", false, "The unit belongs to the service manager of the user")
+	addOutputFlags(notifyRunCmd)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zbwrap/internal/output"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	outputFormat string
+	outputJson   bool
+)
+
+// addOutputFlags registers --output and its --json shorthand on an information command
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", output.FormatTable, "Output format: table, json, ndjson, csv, yaml or template='{{.Field}}'")
+	cmd.Flags().BoolVarP(&outputJson, "json", "j", false, "Output in JSON format (same as --output json)")
+}
+
+// selectedFormat parses the output flags, exiting on an invalid value
+func selectedFormat() output.Format {
+	value := outputFormat
+	if outputJson {
+		value = output.FormatJSON
+	}
+	format, err := output.ParseFormat(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return format
+}
+
+// render writes a document to stdout, exiting if it cannot be rendered
+func render(format output.Format, doc output.Document) {
+	if err := output.Render(os.Stdout, format, doc); err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering output: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// CSV cell helpers
+
+func csvInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func csvTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneSelect   string
+	pruneKeepLast int
+	pruneDryRun   bool
+	pruneGC       bool
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [alias]",
+	Short: "Delete old backups",
+	Long: `Deletes the backups matching --select, except the newest --keep-last of each suffix, together with their
+sidecars. With only --keep-last, every older backup is deleted.
+
+Use --dry-run to see what would be deleted and how much space it would free: the backup files and sidecars
+right away, and the chunks no remaining backup references once 'zbackup gc' runs. Chunk sizes are counted
+before bundle compression; encrypted repositories are read with the configured password file.
+
+With --gc, 'zbackup gc' runs after the prune to release those chunks. The pre_prune and post_gc hooks
+configured for the repository run before backups are deleted and after gc; a failing pre_prune hook
+aborts the prune.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		opts := services.PruneOptions{KeepLast: pruneKeepLast, DryRun: pruneDryRun}
+		if pruneKeepLast < 0 {
+			fmt.Fprintln(os.Stderr, "Error: --keep-last must not be negative")
+			os.Exit(1)
+		}
+		if pruneSelect != "" {
+			selector, err := selectors.Parse(pruneSelect)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Match = selector.Match
+		}
+		if opts.Match == nil && opts.KeepLast == 0 {
+			fmt.Fprintln(os.Stderr, "Error: specify --select, --keep-last or both")
+			os.Exit(1)
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		opts.PasswordFile = registry.PasswordFile()
+		opts.Hooks = registry.GetSettings(alias).Hooks
+
+		inspector := services.NewRepositoryInspector()
+		report, err := inspector.Prune(alias, repoPath, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning repository: %v\n", err)
+			if !pruneDryRun {
+				notify(registry, services.Notification{Event: services.NotifyEventPrune, Outcome: services.NotifyFailure, Alias: alias,
+					Subject: fmt.Sprintf("prune of %s failed", alias), Message: err.Error()})
+			}
+			os.Exit(1)
+		}
+		if pruneGC && !pruneDryRun {
+			report.GC = services.NewBackupRunner(registry).GC(alias, repoPath, opts.Hooks)
+		}
+
+		render(format, output.Document{
+			Kind:       "prune_report",
+			Data:       report,
+			RecordKind: "prune_item",
+			Records:    report.Items,
+			Columns: []output.Column{
+				output.Col("filename", func(i services.PruneItem) string { return i.Filename }),
+				output.Col("suffix", func(i services.PruneItem) string { return i.Suffix }),
+				output.Col("date", func(i services.PruneItem) string { return csvTime(i.Date) }),
+				output.Col("file_bytes", func(i services.PruneItem) string { return csvInt(i.FileBytes) }),
+				output.Col("unique_bytes", func(i services.PruneItem) string { return csvInt(int64(i.UniqueBytes)) }),
+				output.Col("action", func(i services.PruneItem) string { return i.Action }),
+				output.Col("error", func(i services.PruneItem) string { return i.Error }),
+			},
+			Table: func(w io.Writer) { printPruneReport(w, report) },
+		})
+		if !report.DryRun {
+			notify(registry, pruneNotification(report))
+		}
+
+		if report.Failed > 0 || (report.GC != nil && report.GC.Error != "") {
+			os.Exit(1)
+		}
+	},
+}
+
+// pruneNotification summarizes a prune, failing if a backup could not be deleted or gc failed
+func pruneNotification(report *services.PruneReport) services.Notification {
+	note := services.Notification{Event: services.NotifyEventPrune, Outcome: services.NotifySuccess, Alias: report.Alias,
+		Subject: fmt.Sprintf("prune of %s deleted %d backup(s)", report.Alias, report.Pruned)}
+	var problems []string
+	for _, item := range report.Items {
+		if item.Error != "" {
+			problems = append(problems, item.Filename+": "+item.Error)
+		}
+	}
+	if report.GC != nil && report.GC.Error != "" {
+		problems = append(problems, "gc: "+report.GC.Error)
+	}
+	if len(problems) > 0 {
+		note.Outcome = services.NotifyFailure
+		note.Subject = fmt.Sprintf("prune of %s failed: %d backup(s) deleted, %d failed", report.Alias, report.Pruned, report.Failed)
+		note.Message = strings.Join(problems, "\n")
+	}
+	return note
+}
+
+func printPruneReport(out io.Writer, report *services.PruneReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "BACKUP NAME\tACTION\tFILES\tUNIQUE\tDETAILS")
+	for _, item := range report.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Filename, item.Action, humanize.Bytes(uint64(item.FileBytes)),
+			humanize.Bytes(item.UniqueBytes), item.Error)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+
+	verb := "Deleted"
+	if report.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(out, "%s %d backup(s), keeping %d", verb, report.Pruned, report.Kept)
+	if report.Failed > 0 {
+		fmt.Fprintf(out, ", %d failed", report.Failed)
+	}
+	fmt.Fprintln(out, ".")
+	fmt.Fprintf(out, "Backup files and sidecars: %s\n", humanize.Bytes(uint64(report.FileBytes)))
+	if report.EstimateError != "" {
+		fmt.Fprintf(out, "Chunks: unknown (%s)\n", report.EstimateError)
+	} else {
+		fmt.Fprintf(out, "Chunks freed by 'zbackup gc': %d, %s before compression\n", report.Chunks, humanize.Bytes(report.ChunkBytes))
+	}
+	if report.GC != nil {
+		if report.GC.Error != "" {
+			fmt.Fprintf(out, "Garbage collection failed: %s\n", report.GC.Error)
+		} else {
+			fmt.Fprintf(out, "Garbage collection completed in %.1fs.\n", report.GC.DurationSeconds)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVarP(&pruneSelect, "select", "S", "", "Delete backups matching a selector (e.g. 'suffix:nightly until:90d')")
+	pruneCmd.Flags().IntVar(&pruneKeepLast, "keep-last", 0, "Always keep the newest N backups of each suffix")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only report what would be deleted and freed")
+	pruneCmd.Flags().BoolVar(&pruneGC, "gc", false, "Run 'zbackup gc' after deleting backups")
+	addOutputFlags(pruneCmd)
+}
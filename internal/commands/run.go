@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"zbwrap/internal/jobs"
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [job]",
+	Short: "Run a backup job",
+	Long: `Runs a job declared under "jobs" in the registry: its source is backed up to each target in turn,
+then the job's retention prunes the older backups of its suffix in that target. A target whose backup
+failed is not pruned.
+
+The hooks of each target repository run, followed by the hooks of the job. Interrupting the command
+stops the source, which fails the running backup and skips the remaining targets. Every run is recorded;
+'zbwrap jobs show' lists the recent ones. The exit code is 1 if any step failed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		registry := loadRegistry()
+
+		job, ok := registry.GetJob(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: job '%s' not found\n", args[0])
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		run, err := newJobRunner(registry).Run(ctx, job)
+		stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "job_run",
+			Data:       run,
+			RecordKind: "job_step",
+			Records:    run.Steps,
+			Columns:    jobStepColumns(),
+			Table:      func(w io.Writer) { printJobRun(w, run) },
+		})
+		for _, note := range jobNotifications(job, run) {
+			notify(registry, note)
+		}
+
+		if !run.OK {
+			os.Exit(1)
+		}
+	},
+}
+
+func newJobRunner(registry *registries.LocalRegistry) *jobs.Runner {
+	return jobs.NewRunner(registry, filepath.Dir(registry.ConfigPath()))
+}
+
+func jobStepColumns() []output.Column {
+	return []output.Column{
+		output.Col("name", func(s jobs.Step) string { return s.Name }),
+		output.Col("alias", func(s jobs.Step) string { return s.Alias }),
+		output.Col("started_at", func(s jobs.Step) string { return csvTime(s.StartedAt) }),
+		output.Col("duration_seconds", func(s jobs.Step) string { return strconv.FormatFloat(s.DurationSeconds, 'f', 3, 64) }),
+		output.Col("status", func(s jobs.Step) string { return s.Status }),
+		output.Col("backup", func(s jobs.Step) string { return s.Backup }),
+		output.Col("pruned", func(s jobs.Step) string { return strconv.Itoa(s.Pruned) }),
+		output.Col("error", func(s jobs.Step) string { return s.Error }),
+	}
+}
+
+// jobNotifications reports each backup and prune of a run like the backup and prune commands do
+func jobNotifications(job registries.Job, run *jobs.Run) []services.Notification {
+	suffix := jobs.Suffix(job)
+	var notes []services.Notification
+	for _, step := range run.Steps {
+		note := services.Notification{Outcome: services.NotifySuccess, Alias: step.Alias}
+		switch step.Name {
+		case jobs.StepBackup:
+			note.Event = services.NotifyEventBackup
+			note.Subject = fmt.Sprintf("%s backup of %s completed", suffix, step.Alias)
+			if step.Status != jobs.StepOK {
+				note.Subject = fmt.Sprintf("%s backup of %s failed", suffix, step.Alias)
+			}
+		case jobs.StepPrune:
+			if step.Status == jobs.StepSkipped {
+				continue
+			}
+			note.Event = services.NotifyEventPrune
+			note.Subject = fmt.Sprintf("prune of %s deleted %d backup(s)", step.Alias, step.Pruned)
+			if step.Status != jobs.StepOK {
+				note.Subject = fmt.Sprintf("prune of %s failed", step.Alias)
+			}
+		}
+		if step.Status != jobs.StepOK {
+			note.Outcome = services.NotifyFailure
+			note.Message = step.Error
+		}
+		notes = append(notes, note)
+	}
+	return notes
+}
+
+func printJobRun(out io.Writer, run *jobs.Run) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STEP\tREPOSITORY\tSTATUS\tDURATION\tDETAILS")
+	for _, s := range run.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1fs\t%s\n", s.Name, s.Alias, s.Status, s.DurationSeconds, jobStepDetails(s))
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+
+	result := "completed"
+	if !run.OK {
+		result = "failed"
+	}
+	fmt.Fprintf(out, "Job '%s' %s in %.1fs.\n", run.Job, result, run.DurationSeconds)
+}
+
+// jobStepDetails describes the outcome of a step in one cell
+func jobStepDetails(s jobs.Step) string {
+	switch {
+	case s.Error != "":
+		return s.Error
+	case s.Name == jobs.StepBackup:
+		return s.Backup
+	default:
+		return fmt.Sprintf("deleted %d backup(s)", s.Pruned)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	addOutputFlags(runCmd)
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveMetrics string
+	serveTimeout time.Duration
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve --metrics [host]:port",
+	Short: "Serve repository metrics over HTTP",
+	Long: `Starts an HTTP server exposing the output of 'zbwrap metrics' at /metrics for Prometheus to
+scrape. The registry is read and the repositories are inspected on every scrape, so keep the scrape
+interval well above the time an inspection takes.
+
+The server stops on SIGINT or SIGTERM.`,
+	Example: "  zbwrap serve --metrics :9851\n  zbwrap serve --metrics 127.0.0.1:9851",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if serveMetrics == "" {
+			fmt.Fprintln(os.Stderr, "Error: nothing to serve; use --metrics [host]:port")
+			os.Exit(1)
+		}
+
+		loadRepos := func() (map[string]string, error) {
+			registry := registries.NewLocalRegistry()
+			if err := registry.Load(); err != nil {
+				return nil, err
+			}
+			return registry.List(), nil
+		}
+		if _, err := loadRepos(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", services.NewRepositoryInspector().MetricsHandler(loadRepos, services.StatusOptions{Timeout: serveTimeout}))
+		server := &http.Server{Addr: serveMetrics, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdown)
+		}()
+
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", serveMetrics)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveMetrics, "metrics", "", "Listen address of the /metrics endpoint")
+	serveCmd.Flags().DurationVar(&serveTimeout, "timeout", services.DefaultStatusTimeout, "Report a repository as down if it does not answer in time")
+}
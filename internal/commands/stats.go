@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var statsSince string
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [alias]",
+	Short: "Show deduplication statistics",
+	Long: `Compares the logical bytes ingested (recorded in each sidecar at backup time) with the bundle bytes stored,
+overall and per suffix and month. Bundles are attributed to the backups that were running when they were written,
+so a suffix with a low ratio is a stream that deduplicates badly. Backups made before sizes were recorded are
+counted but not measured.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		var opts services.StatsOptions
+		if statsSince != "" {
+			since, err := selectors.ParseWhen(statsSince, time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid --since: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Since = since
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		inspector := services.NewRepositoryInspector()
+		stats, err := inspector.Stats(alias, repoPath, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error computing statistics: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "dedup_stats",
+			Data:       stats,
+			RecordKind: "dedup_group",
+			Records:    append(append([]services.DedupGroup{}, stats.BySuffix...), stats.ByMonth...),
+			Columns: []output.Column{
+				output.Col("dimension", func(g services.DedupGroup) string { return g.Dimension }),
+				output.Col("key", func(g services.DedupGroup) string { return g.Key }),
+				output.Col("backups", func(g services.DedupGroup) string { return csvInt(int64(g.Backups)) }),
+				output.Col("measured", func(g services.DedupGroup) string { return csvInt(int64(g.Measured)) }),
+				output.Col("logical_bytes", func(g services.DedupGroup) string { return csvInt(g.LogicalBytes) }),
+				output.Col("stored_bytes", func(g services.DedupGroup) string { return csvInt(g.StoredBytes) }),
+				output.Col("dedup_ratio", func(g services.DedupGroup) string { return fmt.Sprintf("%.2f", g.DedupRatio) }),
+			},
+			Table: func(w io.Writer) { printDedupStats(w, stats) },
+		})
+	},
+}
+
+func printDedupStats(out io.Writer, stats *services.DedupStats) {
+	fmt.Fprintf(out, "REPOSITORY: %s [%s]\n", stats.Alias, stats.PhysicalPath)
+	if stats.Since != nil {
+		fmt.Fprintf(out, "SINCE: %s\n", stats.Since.Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(out, "LOGICAL INGESTED: %s (%d of %d backups measured)\n", humanize.Bytes(uint64(stats.LogicalBytes)), stats.Measured, stats.Backups)
+	fmt.Fprintf(out, "PHYSICAL STORED: %s (%s written outside of backups)\n", humanize.Bytes(uint64(stats.PhysicalBytes)), humanize.Bytes(uint64(stats.UnattributedBytes)))
+	fmt.Fprintf(out, "DEDUP RATIO: %s\n", formatRatio(stats.DedupRatio))
+
+	for _, section := range []struct {
+		title  string
+		groups []services.DedupGroup
+	}{{"SUFFIX", stats.BySuffix}, {"MONTH", stats.ByMonth}} {
+		fmt.Fprintln(out, "")
+		w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(w, "%s\tBACKUPS\tLOGICAL\tSTORED\tRATIO\n", section.title)
+		for _, g := range section.groups {
+			backups := fmt.Sprint(g.Backups)
+			if g.Measured < g.Backups {
+				backups = fmt.Sprintf("%d (%d measured)", g.Backups, g.Measured)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.Key, backups, humanize.Bytes(uint64(g.LogicalBytes)),
+				humanize.Bytes(uint64(g.StoredBytes)), formatRatio(g.DedupRatio))
+		}
+		w.Flush()
+	}
+}
+
+// formatRatio renders a deduplication ratio, or "-" when it is unknown
+func formatRatio(ratio float64) string {
+	if ratio == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fx", ratio)
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVar(&statsSince, "since", "", "Only backups and bundles written on or after a date (YYYY-MM-DD) or duration ago (e.g. 90d)")
+	addOutputFlags(statsCmd)
+}
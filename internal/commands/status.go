@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusTimeout time.Duration
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the health of every registered repository",
+	Long: `Inspects all registered repositories in parallel and prints one row per repository: whether it is
+reachable, its encryption, the number of backups, the newest backup and its age, the last failed backup,
+the result of the last 'check', its size and the free space of its filesystem.
+
+Repositories that are missing, unmounted or do not answer within --timeout are shown as offline.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		statuses := services.NewRepositoryInspector().Status(registry.List(), services.StatusOptions{Timeout: statusTimeout})
+
+		render(format, output.Document{
+			Kind:       "repository_status_list",
+			Data:       statuses,
+			RecordKind: "repository_status",
+			Records:    statuses,
+			Columns: []output.Column{
+				output.Col("alias", func(s services.RepoStatus) string { return s.Alias }),
+				output.Col("path", func(s services.RepoStatus) string { return s.Path }),
+				output.Col("state", func(s services.RepoStatus) string { return s.State }),
+				output.Col("error", func(s services.RepoStatus) string { return s.Error }),
+				output.Col("encryption", func(s services.RepoStatus) string { return s.Encryption }),
+				output.Col("backups", func(s services.RepoStatus) string { return strconv.Itoa(s.Backups) }),
+				output.Col("newest_backup", func(s services.RepoStatus) string { return s.NewestBackup }),
+				output.Col("newest_backup_age_seconds", func(s services.RepoStatus) string {
+					if s.NewestBackupDate == nil {
+						return ""
+					}
+					return csvInt(s.NewestBackupAgeSeconds)
+				}),
+				output.Col("last_failure", func(s services.RepoStatus) string {
+					if s.LastFailure == nil {
+						return ""
+					}
+					return s.LastFailure.Filename
+				}),
+				output.Col("last_check_ok", func(s services.RepoStatus) string {
+					if s.LastCheck == nil {
+						return ""
+					}
+					return strconv.FormatBool(s.LastCheck.OK)
+				}),
+				output.Col("size_bytes", func(s services.RepoStatus) string { return csvInt(s.SizeBytes) }),
+				output.Col("available_bytes", func(s services.RepoStatus) string {
+					if s.Filesystem == nil {
+						return ""
+					}
+					return strconv.FormatUint(s.Filesystem.AvailableBytes, 10)
+				}),
+			},
+			Table: func(w io.Writer) { printStatus(w, statuses) },
+		})
+	},
+}
+
+func printStatus(out io.Writer, statuses []services.RepoStatus) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(out, "No repositories registered.")
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ALIAS\tSTATE\tENCRYPTION\tBACKUPS\tNEWEST\tAGE\tLAST FAILURE\tLAST CHECK\tSIZE\tFREE")
+	for _, s := range statuses {
+		if s.State == services.RepoOffline {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t-\t-\t-\n", s.Alias, s.State)
+			continue
+		}
+
+		newest, age := "-", "-"
+		if s.NewestBackupDate != nil {
+			newest = s.NewestBackup
+			age = services.FormatAge(time.Duration(s.NewestBackupAgeSeconds) * time.Second)
+		}
+		failure := "-"
+		if s.LastFailure != nil {
+			failure = fmt.Sprintf("%s (%s)", s.LastFailure.Filename, humanize.Time(s.LastFailure.Time))
+		}
+		check := "-"
+		if s.LastCheck != nil {
+			check = "ok"
+			if !s.LastCheck.OK {
+				check = fmt.Sprintf("%d problem(s)", s.LastCheck.ProblemCount)
+			}
+			check += " " + humanize.Time(s.LastCheck.StartedAt)
+		}
+		free := "-"
+		if s.Filesystem != nil {
+			free = humanize.Bytes(s.Filesystem.AvailableBytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Alias, s.State, s.Encryption, s.Backups,
+			newest, age, failure, check, humanize.Bytes(uint64(s.SizeBytes)), free)
+	}
+	w.Flush()
+
+	for _, s := range statuses {
+		if s.State == services.RepoOffline {
+			fmt.Fprintf(out, "\n%s is offline: %s", s.Alias, s.Error)
+		}
+	}
+	fmt.Fprintln(out, "")
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().DurationVar(&statusTimeout, "timeout", services.DefaultStatusTimeout, "Report a repository as offline if it does not answer in time")
+	addOutputFlags(statusCmd)
+}
@@ -2,23 +2,35 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"text/tabwriter"
 
+	"zbwrap/internal/output"
 	"zbwrap/internal/registries"
 	"zbwrap/internal/services"
 
 	"github.com/spf13/cobra"
 )
 
-var syncDeep bool
+var (
+	syncDeep   bool
+	syncJobs   int
+	syncDryRun bool
+)
 
 var syncCmd = &cobra.Command{
 	Use:   "sync [alias]",
 	Short: "Synchronize repository metadata",
-	Long:  `Scans the repository for missing metadata sidecars and regenerates them. Use --deep to analyze file contents.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Scans the repository for missing metadata sidecars and regenerates them. Use --deep to analyze file contents.
+Backups are processed in parallel (--jobs), with progress on stderr, and failures are reported per backup: a
+backup that cannot be written or, with --deep, restored for sniffing is marked failed with the reason. Use
+--dry-run to preview changes.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		alias := args[0]
+		format := selectedFormat()
 
 		registry := registries.NewLocalRegistry()
 		if err := registry.Load(); err != nil {
@@ -37,22 +49,62 @@ var syncCmd = &cobra.Command{
 			zbackupPath = "zbackup" // Default to PATH lookups if not configured
 		}
 
-		passwordFile := ""
-		if registry.Encryption.Type == "password-file" {
-			passwordFile = registry.Encryption.CredentialsPath
-		}
-
 		inspector := services.NewRepositoryInspector()
-		if err := inspector.Sync(zbackupPath, repoPath, syncDeep, passwordFile); err != nil {
+		report, err := inspector.SyncWithOptions(alias, repoPath, services.SyncOptions{
+			ZBackupPath:  zbackupPath,
+			PasswordFile: registry.PasswordFile(),
+			Deep:         syncDeep,
+			Jobs:         syncJobs,
+			DryRun:       syncDryRun,
+			Progress: func(done, total int, item services.SyncItemResult) {
+				fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", done, total, item.Filename, item.Action)
+			},
+		})
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error syncing repository: %v\n", err)
 			os.Exit(1)
 		}
 
-		fmt.Printf("Synchronization complete for repository '%s'.\n", alias)
+		render(format, output.Document{
+			Kind:       "sync_report",
+			Data:       report,
+			RecordKind: "sync_item",
+			Records:    report.Items,
+			Columns: []output.Column{
+				output.Col("filename", func(item services.SyncItemResult) string { return item.Filename }),
+				output.Col("action", func(item services.SyncItemResult) string { return item.Action }),
+				output.Col("mime_type", func(item services.SyncItemResult) string { return item.MimeType }),
+				output.Col("error", func(item services.SyncItemResult) string { return item.Error }),
+			},
+			Table: func(w io.Writer) { printSyncReport(w, report) },
+		})
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
 	},
 }
 
+func printSyncReport(out io.Writer, report *services.SyncReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "BACKUP NAME\tACTION\tMIME TYPE\tDETAILS")
+	for _, item := range report.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.Filename, item.Action, item.MimeType, item.Error)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run: no sidecars were modified.")
+	}
+	fmt.Fprintf(out, "Synchronization complete for repository '%s': %d created, %d updated, %d unchanged, %d failed.\n",
+		report.Alias, report.Created, report.Updated, report.Unchanged, report.Failed)
+}
+
 func init() {
 	rootCmd.AddCommand(syncCmd)
 	syncCmd.Flags().BoolVar(&syncDeep, "deep", false, "Perform deep inspection (MIME types)")
+	syncCmd.Flags().IntVar(&syncJobs, "jobs", runtime.NumCPU(), "Number of backups to inspect in parallel")
+	syncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "Report what would change without writing sidecars")
+	addOutputFlags(syncCmd)
 }
@@ -0,0 +1,201 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/systemd"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	systemdUser           bool
+	systemdDir            string
+	systemdBinary         string
+	systemdReadWritePaths []string
+)
+
+var systemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "Run scheduled jobs with systemd timers",
+	Long: `Instead of 'zbwrap daemon', systemd can run scheduled jobs: 'zbwrap systemd generate' writes a timer
+and a service for a job, and 'zbwrap systemd status' shows their state.`,
+}
+
+var systemdGenerateCmd = &cobra.Command{
+	Use:   "generate [job]",
+	Short: "Write the systemd service and timer of a scheduled job",
+	Long: `Writes zbwrap-job-<job>.service, which runs 'zbwrap run <job>', and zbwrap-job-<job>.timer, which
+starts it on the job's schedule: OnCalendar from the cron expression, RandomizedDelaySec from the
+jitter, and Persistent=true so that a run missed while the machine was off starts at the next boot,
+whatever catch_up says (catch_up only applies to the daemon).
+
+The service runs sandboxed: the file system is read-only (ProtectSystem=strict) except for the
+repositories of the job, the registry directory and the paths given with --read-write-path, such as
+those a source command writes to. A repository that is missing, such as an unmounted disk, does not
+keep the service from starting; the run fails on that target only. When it fails, it starts zbwrap-notify@.service, which is written
+too and sends a notification with the "job" event ('zbwrap notify unit-failed').
+
+Units go to /etc/systemd/system, or with --user to ~/.config/systemd/user. Existing files are
+replaced. Reload systemd and enable the timer afterwards, as printed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		registry := loadRegistry()
+		job, ok := registry.GetJob(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: job '%s' not found\n", args[0])
+			os.Exit(1)
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		binary := systemdBinary
+		if binary == "" {
+			if binary, err = os.Executable(); err == nil {
+				binary, err = filepath.EvalSymlinks(binary)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error locating zbwrap, use --binary: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if !filepath.IsAbs(binary) {
+			fmt.Fprintf(os.Stderr, "Error: --binary must be an absolute path\n")
+			os.Exit(1)
+		}
+
+		units, err := systemd.Generate(registry, job, systemd.Options{
+			Binary:         binary,
+			User:           systemdUser,
+			Home:           home,
+			StateDir:       filepath.Dir(registry.ConfigPath()),
+			ReadWritePaths: systemdReadWritePaths,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		dir := systemdDir
+		if dir == "" {
+			dir = systemd.DefaultDir(systemdUser, home)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+		for _, unit := range units {
+			path := filepath.Join(dir, unit.Name)
+			if err := os.WriteFile(path, []byte(unit.Content), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing unit: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Wrote %s\n", path)
+		}
+
+		systemctl := "systemctl"
+		if systemdUser {
+			systemctl = "systemctl --user"
+		}
+		fmt.Println("")
+		fmt.Printf("Enable the timer with:\n  %s daemon-reload\n  %s enable --now %s\n", systemctl, systemctl, systemd.TimerName(job.Name))
+	},
+}
+
+var systemdStatusCmd = &cobra.Command{
+	Use:   "status [job...]",
+	Short: "Show the state of the systemd timers and services of jobs",
+	Long: `Asks systemctl about the timer and service of each scheduled job, or of the given jobs: whether the
+timer is installed, enabled and active, its next and last run, and the state and result of the
+service.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := selectedFormat()
+		registry := loadRegistry()
+
+		names := args
+		if len(names) == 0 {
+			for _, job := range registry.Jobs {
+				if job.Schedule != "" {
+					names = append(names, job.Name)
+				}
+			}
+		}
+		for _, name := range names {
+			if _, ok := registry.GetJob(name); !ok {
+				fmt.Fprintf(os.Stderr, "Error: job '%s' not found\n", name)
+				os.Exit(1)
+			}
+		}
+
+		statuses, err := systemd.Status("systemctl", systemdUser, names)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "systemd_status",
+			Data:       statuses,
+			RecordKind: "systemd_job",
+			Records:    statuses,
+			Columns: []output.Column{
+				output.Col("job", func(s systemd.JobStatus) string { return s.Job }),
+				output.Col("timer", func(s systemd.JobStatus) string { return s.Timer }),
+				output.Col("loaded", func(s systemd.JobStatus) string { return strconv.FormatBool(s.Loaded) }),
+				output.Col("enabled", func(s systemd.JobStatus) string { return s.Enabled }),
+				output.Col("active", func(s systemd.JobStatus) string { return s.Active }),
+				output.Col("next_run", func(s systemd.JobStatus) string { return s.NextRun }),
+				output.Col("last_run", func(s systemd.JobStatus) string { return s.LastRun }),
+				output.Col("service", func(s systemd.JobStatus) string { return s.Service }),
+				output.Col("state", func(s systemd.JobStatus) string { return s.State }),
+				output.Col("result", func(s systemd.JobStatus) string { return s.Result }),
+			},
+			Table: func(w io.Writer) { printSystemdStatus(w, statuses) },
+		})
+	},
+}
+
+func printSystemdStatus(out io.Writer, statuses []systemd.JobStatus) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(out, "No scheduled jobs.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "JOB\tTIMER\tNEXT RUN\tLAST RUN\tSERVICE\tRESULT")
+	for _, s := range statuses {
+		timer := "not installed"
+		if s.Loaded {
+			timer = fmt.Sprintf("%s, %s", s.Enabled, s.Active)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Job, timer, orDash(s.NextRun), orDash(s.LastRun), orDash(s.State), orDash(s.Result))
+	}
+	w.Flush()
+}
+
+// orDash shows an empty value as "-"
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(systemdCmd)
+	systemdCmd.AddCommand(systemdGenerateCmd)
+	systemdCmd.AddCommand(systemdStatusCmd)
+	systemdCmd.PersistentFlags().BoolVar(&systemdUser, "user", false, "Use the service manager of the user instead of the system one")
+	systemdGenerateCmd.Flags().StringVar(&systemdDir, "dir", "", "Write the units to this directory instead of the systemd default")
+	systemdGenerateCmd.Flags().StringVar(&systemdBinary, "binary", "", "Absolute path of the zbwrap the units run (default: this executable)")
+	systemdGenerateCmd.Flags().StringArrayVar(&systemdReadWritePaths, "read-write-path", nil, "Let the job write to this path too (repeatable)")
+	addOutputFlags(systemdStatusCmd)
+}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"zbwrap/internal/output"
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+	"zbwrap/internal/zbackup"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifySelect      string
+	verifyBundleCache int
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [alias]",
+	Short: "Restore backups to check that they are intact",
+	Long: `Restores every backup, or those matching --select, with the native reader and discards the data.
+A backup passes when the restored stream matches the size and SHA-256 recorded in its backup file and,
+when present, the logical size and SHA-256 of its sidecar. Encrypted repositories are read with the
+configured password file.
+
+Unlike 'check', which only reads the index and bundles, verify reads every chunk of the selected
+backups. Each run is recorded in the repository's check history next to the runs of 'check'.
+verify exits with status 1 when a backup fails.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := args[0]
+		format := selectedFormat()
+
+		var opts services.VerifyOptions
+		if verifySelect != "" {
+			selector, err := selectors.Parse(verifySelect)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Match = selector.Match
+		}
+
+		registry := registries.NewLocalRegistry()
+		if err := registry.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		repoPath, ok := registry.Get(alias)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Repository not found: %s\n", alias)
+			os.Exit(1)
+		}
+
+		opts.PasswordFile = registry.PasswordFile()
+		opts.BundleCache = verifyBundleCache
+		opts.Progress = func(done, total int, item services.VerifyItem) {
+			result := "ok"
+			if !item.OK {
+				result = "failed: " + item.Error
+			}
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", done, total, item.Filename, result)
+		}
+
+		report, err := services.NewRepositoryInspector().Verify(alias, repoPath, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying repository: %v\n", err)
+			notify(registry, services.Notification{Event: services.NotifyEventVerify, Outcome: services.NotifyFailure, Alias: alias,
+				Subject: fmt.Sprintf("verify of %s could not run", alias), Message: err.Error()})
+			os.Exit(1)
+		}
+
+		render(format, output.Document{
+			Kind:       "verify_report",
+			Data:       report,
+			RecordKind: "verify_item",
+			Records:    report.Items,
+			Columns: []output.Column{
+				output.Col("filename", func(i services.VerifyItem) string { return i.Filename }),
+				output.Col("suffix", func(i services.VerifyItem) string { return i.Suffix }),
+				output.Col("date", func(i services.VerifyItem) string { return csvTime(i.Date) }),
+				output.Col("logical_size", func(i services.VerifyItem) string { return csvInt(i.LogicalSize) }),
+				output.Col("sha256", func(i services.VerifyItem) string { return i.SHA256 }),
+				output.Col("ok", func(i services.VerifyItem) string { return strconv.FormatBool(i.OK) }),
+				output.Col("error", func(i services.VerifyItem) string { return i.Error }),
+			},
+			Table: func(w io.Writer) { printVerifyReport(w, report) },
+		})
+		notify(registry, verifyNotification(report))
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// verifyNotification summarizes a verification, failing if a backup did not verify
+func verifyNotification(report *services.VerifyReport) services.Notification {
+	note := services.Notification{Event: services.NotifyEventVerify, Outcome: services.NotifySuccess, Alias: report.Alias,
+		Subject: fmt.Sprintf("verify of %s passed: %d backup(s)", report.Alias, report.Verified)}
+	if report.Failed == 0 {
+		return note
+	}
+	var problems []string
+	for _, item := range report.Items {
+		if !item.OK {
+			problems = append(problems, item.Filename+": "+item.Error)
+		}
+	}
+	note.Outcome = services.NotifyFailure
+	note.Subject = fmt.Sprintf("verify of %s failed: %d of %d backup(s)", report.Alias, report.Failed, len(report.Items))
+	note.Message = strings.Join(problems, "\n")
+	return note
+}
+
+func printVerifyReport(out io.Writer, report *services.VerifyReport) {
+	if len(report.Items) == 0 {
+		fmt.Fprintln(out, "No backups selected.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "BACKUP NAME\tRESULT\tSIZE\tDETAILS")
+	for _, item := range report.Items {
+		result := "ok"
+		if !item.OK {
+			result = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.Filename, result, humanize.Bytes(uint64(item.LogicalSize)), item.Error)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "")
+	fmt.Fprintf(out, "Verified %d backup(s)", report.Verified)
+	if report.Failed > 0 {
+		fmt.Fprintf(out, ", %d failed", report.Failed)
+	}
+	fmt.Fprintln(out, ".")
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&verifySelect, "select", "S", "", "Verify only the backups matching a selector (e.g. 'suffix:nightly since:7d')")
+	verifyCmd.Flags().IntVar(&verifyBundleCache, "bundle-cache", zbackup.DefaultBundleCacheSize, "Decompressed bundles kept in memory")
+	addOutputFlags(verifyCmd)
+}
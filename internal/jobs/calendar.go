@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// calendarWeekdays are the weekday names of systemd calendar events, Monday first
+var calendarWeekdays = []struct {
+	day  int
+	name string
+}{{1, "Mon"}, {2, "Tue"}, {3, "Wed"}, {4, "Thu"}, {5, "Fri"}, {6, "Sat"}, {0, "Sun"}}
+
+// Calendar returns systemd calendar events (OnCalendar=) matching the same minutes as
+// the schedule. systemd requires both the weekday and the date to match, so a schedule
+// restricting both day fields, which cron matches on either, needs two events.
+func (s *Schedule) Calendar() []string {
+	weekdays := s.dow & 0x7f
+	if s.domRestricted && s.dowRestricted {
+		return []string{s.calendarEvent(weekdays, allValues(1, 31)), s.calendarEvent(allValues(0, 6), s.dom)}
+	}
+	return []string{s.calendarEvent(weekdays, s.dom)}
+}
+
+// calendarEvent formats one calendar event as "[weekdays] *-month-day hour:minute:00"
+func (s *Schedule) calendarEvent(weekdays, days uint64) string {
+	event := fmt.Sprintf("*-%s-%s %s:%s:00", calendarValues(s.month, 1, 12), calendarValues(days, 1, 31),
+		calendarValues(s.hour, 0, 23), calendarValues(s.minute, 0, 59))
+	if weekdays == allValues(0, 6) {
+		return event
+	}
+	var names []string
+	for _, wd := range calendarWeekdays {
+		if weekdays&(1<<uint(wd.day)) != 0 {
+			names = append(names, wd.name)
+		}
+	}
+	return strings.Join(names, ",") + " " + event
+}
+
+// allValues is the bit set of every value from min to max
+func allValues(min, max int) uint64 {
+	var set uint64
+	for v := min; v <= max; v++ {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+// calendarValues formats the values of a bit set as a calendar event field: "*" for
+// every value, "start/step" for a repetition running to the end of the range, and
+// otherwise a list in which runs of three or more values become "first..last"
+func calendarValues(set uint64, min, max int) string {
+	var values []int
+	for v := min; v <= max; v++ {
+		if set&(1<<uint(v)) != 0 {
+			values = append(values, v)
+		}
+	}
+	if len(values) == max-min+1 {
+		return "*"
+	}
+	if len(values) >= 3 {
+		step := values[1] - values[0]
+		repeats := values[len(values)-1]+step > max
+		for i := 2; i < len(values) && repeats; i++ {
+			repeats = values[i]-values[i-1] == step
+		}
+		if repeats && step > 1 {
+			return fmt.Sprintf("%02d/%d", values[0], step)
+		}
+	}
+
+	var parts []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		if j-i >= 2 {
+			parts = append(parts, fmt.Sprintf("%02d..%02d", values[i], values[j]))
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, fmt.Sprintf("%02d", values[k]))
+			}
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
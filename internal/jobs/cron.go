@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleHorizon is how far ahead Next looks before deciding a schedule never matches
+const scheduleHorizon = 5 * 366 * 24 * time.Hour
+
+// scheduleMacros are the shorthands accepted in place of five fields
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is a parsed cron expression, evaluated in local time
+type Schedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// scheduleField describes one of the five fields of a cron expression
+type scheduleField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: weekdayNames},
+}
+
+// ParseSchedule parses a cron expression: five fields (minute, hour, day of month,
+// month and day of week) made of *, values, ranges, lists and /steps, with month and
+// weekday names, or one of @hourly, @daily, @weekly, @monthly and @yearly. As in cron,
+// a day matches either day field when both are restricted.
+func ParseSchedule(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := scheduleMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields or a macro such as @daily", expr)
+	}
+
+	s := &Schedule{expr: expr}
+	bits := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range scheduleFields {
+		set, err := parseScheduleField(fields[i], field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		*bits[i] = set
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseScheduleField returns the values a field matches as a bit set
+func parseScheduleField(text string, field scheduleField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepText, field.name)
+			}
+			step = n
+		}
+
+		low, high := field.min, field.max
+		switch {
+		case rangeText == "*":
+		case strings.Contains(rangeText, "-"):
+			lowText, highText, _ := strings.Cut(rangeText, "-")
+			var err error
+			if low, err = scheduleValue(lowText, field); err != nil {
+				return 0, err
+			}
+			if high, err = scheduleValue(highText, field); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s", rangeText, field.name)
+			}
+		default:
+			value, err := scheduleValue(rangeText, field)
+			if err != nil {
+				return 0, err
+			}
+			// "5/15" runs from 5 to the end of the range
+			low = value
+			if !hasStep {
+				high = value
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// scheduleValue parses a number or name within the bounds of a field
+func scheduleValue(text string, field scheduleField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(text, name) {
+			return i + field.min, nil
+		}
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("invalid %s %q", field.name, text)
+	}
+	return value, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first minute after t that matches the schedule, or the zero time if
+// none does within five years, e.g. for February 30
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	end := t.Add(scheduleHorizon)
+
+	for t.Before(end) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule for the two day fields
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	// 2024-04-03 is a Wednesday
+	from := time.Date(2024, 4, 3, 10, 17, 42, 0, time.UTC)
+	tests := []struct {
+		expr string
+		next string
+	}{
+		{"* * * * *", "2024-04-03 10:18"},
+		{"30 1 * * *", "2024-04-04 01:30"},
+		{"*/15 * * * *", "2024-04-03 10:30"},
+		{"5/20 * * * *", "2024-04-03 10:25"},
+		{"0 9-17/4 * * *", "2024-04-03 13:00"},
+		{"0 0,12 * * *", "2024-04-03 12:00"},
+		{"0 3 * * sun", "2024-04-07 03:00"},
+		{"0 3 * * 7", "2024-04-07 03:00"},
+		{"0 3 * * mon-fri", "2024-04-04 03:00"},
+		{"0 0 1 * *", "2024-05-01 00:00"},
+		{"0 0 31 * *", "2024-05-31 00:00"},
+		{"0 0 29 feb *", "2028-02-29 00:00"},
+		{"0 0 1 jan *", "2025-01-01 00:00"},
+		// Either day field matches when both are restricted
+		{"0 0 15 * fri", "2024-04-05 00:00"},
+		{"@hourly", "2024-04-03 11:00"},
+		{"@daily", "2024-04-04 00:00"},
+		{"@weekly", "2024-04-07 00:00"},
+		{"@monthly", "2024-05-01 00:00"},
+		{"@yearly", "2025-01-01 00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, schedule.Next(from).Format("2006-01-02 15:04"))
+		})
+	}
+
+	schedule, err := ParseSchedule("0 0 30 feb *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(from).IsZero())
+
+	// The next run is strictly after the given time
+	schedule, err = ParseSchedule("30 1 * * *")
+	require.NoError(t, err)
+	at := time.Date(2024, 4, 4, 1, 30, 0, 0, time.UTC)
+	assert.Equal(t, at.Add(24*time.Hour), schedule.Next(at))
+}
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "@often", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "1,,2 * * * *"} {
+		_, err := ParseSchedule(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSchedule_Calendar(t *testing.T) {
+	tests := []struct {
+		expr   string
+		events []string
+	}{
+		{"* * * * *", []string{"*-*-* *:*:00"}},
+		{"30 1 * * *", []string{"*-*-* 01:30:00"}},
+		{"*/15 * * * *", []string{"*-*-* *:00/15:00"}},
+		{"5/20 * * * *", []string{"*-*-* *:05/20:00"}},
+		{"0 9-17/4 * * *", []string{"*-*-* 09,13,17:00:00"}},
+		{"0 8-18 * * mon-fri", []string{"Mon,Tue,Wed,Thu,Fri *-*-* 08..18:00:00"}},
+		{"0 3 * * 7", []string{"Sun *-*-* 03:00:00"}},
+		{"0 0 1,15 jan,jul *", []string{"*-01,07-01,15 00:00:00"}},
+		{"0 0 */2 * *", []string{"*-*-01/2 00:00:00"}},
+		// Either day field matches when both are restricted
+		{"0 0 15 * fri", []string{"Fri *-*-* 00:00:00", "*-*-15 00:00:00"}},
+		{"@weekly", []string{"Sun *-*-* 00:00:00"}},
+		{"@yearly", []string{"*-01-01 00:00:00"}},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.expr)
+		require.NoError(t, err)
+		assert.Equal(t, tt.events, schedule.Calendar(), tt.expr)
+	}
+}
@@ -0,0 +1,351 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"zbwrap/internal/registries"
+)
+
+// DefaultShutdownTimeout is how long running jobs may finish after a shutdown request
+const DefaultShutdownTimeout = time.Minute
+
+// daemonMaxWait bounds each sleep of the daemon, so that changes of the wall clock,
+// such as after a suspend, are noticed
+const daemonMaxWait = time.Minute
+
+// DaemonOptions tunes the daemon
+type DaemonOptions struct {
+	// MaxConcurrent bounds the jobs running at once; 0 means 1
+	MaxConcurrent int
+	// ShutdownTimeout is how long running jobs may finish once a shutdown is requested
+	// before they are cancelled
+	ShutdownTimeout time.Duration
+	// OnRun, if set, is called after each run with the registry the job was run from
+	OnRun func(registry *registries.LocalRegistry, job registries.Job, run *Run)
+	// Logf reports what the daemon does
+	Logf func(format string, args ...interface{})
+}
+
+// Daemon runs the scheduled jobs of the registry. A job does not start while an
+// earlier run of it, or a job sharing one of its targets, is running; it waits in a
+// queue instead.
+type Daemon struct {
+	load     func() (*registries.LocalRegistry, error)
+	stateDir string
+	opts     DaemonOptions
+	// run runs one job; tests replace it
+	run func(ctx context.Context, registry *registries.LocalRegistry, job registries.Job) (*Run, error)
+
+	// The fields below are only used by the goroutine of Run
+	registry  *registries.LocalRegistry
+	scheduled map[string]*scheduledJob
+	queue     []string
+	running   map[string]registries.Job
+	// busy maps the aliases of running jobs to the job using them
+	busy     map[string]string
+	finished chan finishedRun
+}
+
+// scheduledJob is a job with its next run
+type scheduledJob struct {
+	job      registries.Job
+	schedule *Schedule
+	jitter   time.Duration
+	// slot is the next time the schedule matches; next adds the jitter to it
+	slot time.Time
+	next time.Time
+}
+
+// finishedRun is sent by a job goroutine when its run is over
+type finishedRun struct {
+	job registries.Job
+	run *Run
+	err error
+}
+
+// NewDaemon creates a daemon that reads its jobs with load, on start and on reload,
+// and keeps their history in stateDir
+func NewDaemon(load func() (*registries.LocalRegistry, error), stateDir string, opts DaemonOptions) *Daemon {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 1
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	d := &Daemon{
+		load:      load,
+		stateDir:  stateDir,
+		opts:      opts,
+		scheduled: make(map[string]*scheduledJob),
+		running:   make(map[string]registries.Job),
+		busy:      make(map[string]string),
+		finished:  make(chan finishedRun),
+	}
+	d.run = func(ctx context.Context, registry *registries.LocalRegistry, job registries.Job) (*Run, error) {
+		return NewRunner(registry, d.stateDir).Run(ctx, job)
+	}
+	return d
+}
+
+// Run schedules jobs until ctx is cancelled. A value on reload reads the jobs again;
+// runs already started are not affected. On shutdown, running jobs get the shutdown
+// timeout to finish before they are cancelled, which stops their sources and fails
+// their backups cleanly. Run returns once every job has stopped.
+func (d *Daemon) Run(ctx context.Context, reload <-chan struct{}) error {
+	if err := d.reload(true); err != nil {
+		return err
+	}
+
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	for {
+		d.dispatch(jobsCtx)
+		timer := time.NewTimer(d.untilNext(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.shutdown(cancelJobs)
+			return nil
+		case <-reload:
+			if err := d.reload(false); err != nil {
+				d.opts.Logf("Reload failed, keeping the current jobs: %v", err)
+			}
+		case f := <-d.finished:
+			d.finish(f)
+		case <-timer.C:
+			d.enqueueDue(time.Now())
+		}
+		timer.Stop()
+	}
+}
+
+// reload reads the jobs and schedules the valid ones. A job whose schedule did not
+// change keeps its next run. On start, jobs that missed a run are queued if they
+// catch up.
+func (d *Daemon) reload(start bool) error {
+	registry, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	scheduled := make(map[string]*scheduledJob)
+	var catchUp []string
+	for _, job := range registry.Jobs {
+		if job.Schedule == "" {
+			continue
+		}
+		if _, ok := scheduled[job.Name]; ok {
+			d.opts.Logf("Not scheduling job %s: duplicate job name", job.Name)
+			continue
+		}
+		if problems := Validate(registry, job); len(problems) > 0 {
+			d.opts.Logf("Not scheduling job %s: %s", job.Name, strings.Join(problems, "; "))
+			continue
+		}
+		schedule, _ := ParseSchedule(job.Schedule)
+		jitter, _ := jobJitter(job)
+		sj := &scheduledJob{job: job, schedule: schedule, jitter: jitter}
+		if old, ok := d.scheduled[job.Name]; ok && old.job.Schedule == job.Schedule && old.job.Jitter == job.Jitter {
+			sj.slot, sj.next = old.slot, old.next
+		} else {
+			sj.advance(now)
+		}
+		scheduled[job.Name] = sj
+
+		if start && job.CatchUp && d.missedRun(registry, sj, now) {
+			catchUp = append(catchUp, job.Name)
+		}
+	}
+
+	d.registry = registry
+	d.scheduled = scheduled
+	names := make([]string, 0, len(scheduled))
+	for name := range scheduled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	d.opts.Logf("Loaded %d scheduled job(s)", len(names))
+	for _, name := range names {
+		d.opts.Logf("Job %s next runs at %s", name, scheduled[name].next.Format(time.RFC3339))
+	}
+	for _, name := range catchUp {
+		d.opts.Logf("Job %s missed a scheduled run, catching up", name)
+		d.enqueue(name)
+	}
+	return nil
+}
+
+// missedRun tells whether the schedule matched between the last run of a job and now.
+// A job that never ran has no run to catch up.
+func (d *Daemon) missedRun(registry *registries.LocalRegistry, sj *scheduledJob, now time.Time) bool {
+	last, err := NewRunner(registry, d.stateDir).LastRun(sj.job.Name)
+	if err != nil {
+		d.opts.Logf("Cannot read the history of job %s: %v", sj.job.Name, err)
+		return false
+	}
+	if last == nil {
+		return false
+	}
+	missed := sj.schedule.Next(last.StartedAt)
+	return !missed.IsZero() && !missed.After(now)
+}
+
+// advance moves a job to its first scheduled run after now
+func (sj *scheduledJob) advance(now time.Time) {
+	sj.slot = sj.schedule.Next(now)
+	sj.next = sj.slot
+	if sj.jitter > 0 && !sj.slot.IsZero() {
+		sj.next = sj.slot.Add(time.Duration(rand.Int63n(int64(sj.jitter))))
+	}
+}
+
+// untilNext returns how long to sleep before the next scheduled run
+func (d *Daemon) untilNext(now time.Time) time.Duration {
+	wait := daemonMaxWait
+	for _, sj := range d.scheduled {
+		if sj.next.IsZero() {
+			continue
+		}
+		if until := sj.next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// enqueueDue queues the jobs whose scheduled run has come and schedules their next run
+func (d *Daemon) enqueueDue(now time.Time) {
+	names := make([]string, 0, len(d.scheduled))
+	for name, sj := range d.scheduled {
+		if !sj.next.IsZero() && !sj.next.After(now) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.enqueue(name)
+		d.scheduled[name].advance(now)
+	}
+}
+
+// enqueue queues a job unless it is already waiting or running
+func (d *Daemon) enqueue(name string) {
+	if _, ok := d.running[name]; ok {
+		d.opts.Logf("Skipping job %s: the previous run is still in progress", name)
+		return
+	}
+	for _, queued := range d.queue {
+		if queued == name {
+			d.opts.Logf("Skipping job %s: a run is already waiting", name)
+			return
+		}
+	}
+	d.queue = append(d.queue, name)
+}
+
+// dispatch starts the queued jobs that have a free slot and whose targets are idle,
+// in the order they were queued
+func (d *Daemon) dispatch(ctx context.Context) {
+	var waiting []string
+	for _, name := range d.queue {
+		sj, ok := d.scheduled[name]
+		if !ok {
+			// Removed by a reload
+			continue
+		}
+		if len(d.running) >= d.opts.MaxConcurrent || d.conflicts(sj.job) {
+			waiting = append(waiting, name)
+			continue
+		}
+		d.start(ctx, sj.job)
+	}
+	d.queue = waiting
+}
+
+// conflicts tells whether a running job uses one of the targets of job
+func (d *Daemon) conflicts(job registries.Job) bool {
+	for _, alias := range job.Targets {
+		if _, ok := d.busy[alias]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// start runs a job in its own goroutine
+func (d *Daemon) start(ctx context.Context, job registries.Job) {
+	d.running[job.Name] = job
+	for _, alias := range job.Targets {
+		d.busy[alias] = job.Name
+	}
+	d.opts.Logf("Starting job %s", job.Name)
+
+	registry := d.registry
+	go func() {
+		run, err := d.run(ctx, registry, job)
+		if err == nil && d.opts.OnRun != nil {
+			d.opts.OnRun(registry, job, run)
+		}
+		d.finished <- finishedRun{job: job, run: run, err: err}
+	}()
+}
+
+// finish releases the targets of a job and reports its outcome
+func (d *Daemon) finish(f finishedRun) {
+	delete(d.running, f.job.Name)
+	for _, alias := range f.job.Targets {
+		if d.busy[alias] == f.job.Name {
+			delete(d.busy, alias)
+		}
+	}
+
+	switch {
+	case f.err != nil:
+		d.opts.Logf("Job %s did not run: %v", f.job.Name, f.err)
+	case f.run.OK:
+		d.opts.Logf("Job %s completed in %.1fs", f.job.Name, f.run.DurationSeconds)
+	default:
+		var failures []string
+		for _, s := range f.run.Steps {
+			if s.Status != StepOK && s.Error != "" {
+				failures = append(failures, fmt.Sprintf("%s %s: %s", s.Name, s.Alias, s.Error))
+			}
+		}
+		d.opts.Logf("Job %s failed in %.1fs: %s", f.job.Name, f.run.DurationSeconds, strings.Join(failures, "; "))
+	}
+}
+
+// shutdown waits for the running jobs, cancelling them once the shutdown timeout
+// has passed. Queued jobs are dropped.
+func (d *Daemon) shutdown(cancelJobs context.CancelFunc) {
+	d.queue = nil
+	if len(d.running) == 0 {
+		d.opts.Logf("Shutting down")
+		return
+	}
+
+	d.opts.Logf("Shutting down, waiting up to %s for %d running job(s)", d.opts.ShutdownTimeout, len(d.running))
+	timer := time.NewTimer(d.opts.ShutdownTimeout)
+	defer timer.Stop()
+	timeout := timer.C
+	for len(d.running) > 0 {
+		select {
+		case f := <-d.finished:
+			d.finish(f)
+		case <-timeout:
+			d.opts.Logf("Cancelling %d running job(s)", len(d.running))
+			cancelJobs()
+			timeout = nil
+		}
+	}
+	d.opts.Logf("Shutting down")
+}
@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRuns stands in for running jobs: each run reports that it started, then blocks
+// until it is released or its context is cancelled
+type fakeRuns struct {
+	started  chan string
+	release  map[string]chan struct{}
+	mu       sync.Mutex
+	canceled []string
+}
+
+func newFakeRuns(names ...string) *fakeRuns {
+	f := &fakeRuns{started: make(chan string, 10), release: make(map[string]chan struct{})}
+	for _, name := range names {
+		f.release[name] = make(chan struct{})
+	}
+	return f
+}
+
+func (f *fakeRuns) run(ctx context.Context, registry *registries.LocalRegistry, job registries.Job) (*Run, error) {
+	f.started <- job.Name
+	select {
+	case <-f.release[job.Name]:
+		return &Run{Job: job.Name, OK: true}, nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		f.canceled = append(f.canceled, job.Name)
+		f.mu.Unlock()
+		return &Run{Job: job.Name}, nil
+	}
+}
+
+// logLines collects what a daemon logs
+type logLines struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logLines) logf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *logLines) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func scheduledJobDef(name string, targets ...string) registries.Job {
+	return registries.Job{Name: name, Source: registries.JobSource{Command: "true"}, Targets: targets, Schedule: "@daily"}
+}
+
+func TestDaemon_Dispatch(t *testing.T) {
+	runner, _ := newTestRunner(t, "r1", "r2")
+	registry := runner.registry
+	registry.Jobs = []registries.Job{
+		scheduledJobDef("a", "r1"),
+		scheduledJobDef("b", "r1", "r2"),
+		scheduledJobDef("c", "r2"),
+		scheduledJobDef("d", "r2"),
+		{Name: "manual", Source: registries.JobSource{Command: "true"}, Targets: []string{"r1"}},
+		{Name: "broken", Source: registries.JobSource{Stdin: true}, Targets: []string{"r1"}, Schedule: "@daily"},
+	}
+	logs := &logLines{}
+	d := NewDaemon(func() (*registries.LocalRegistry, error) { return registry, nil }, runner.stateDir,
+		DaemonOptions{MaxConcurrent: 2, Logf: logs.logf})
+	fake := newFakeRuns("a", "b", "c", "d")
+	d.run = fake.run
+	require.NoError(t, d.reload(false))
+	assert.Len(t, d.scheduled, 4)
+	assert.True(t, logs.contains("Not scheduling job broken: a stdin source cannot be scheduled"))
+
+	// a and c start; b shares their targets and d shares c's
+	for _, name := range []string{"a", "b", "c", "d"} {
+		d.enqueue(name)
+	}
+	d.enqueue("a")
+	assert.True(t, logs.contains("Skipping job a: a run is already waiting"))
+	ctx := context.Background()
+	d.dispatch(ctx)
+	started := []string{<-fake.started, <-fake.started}
+	assert.ElementsMatch(t, []string{"a", "c"}, started)
+	assert.Equal(t, []string{"b", "d"}, d.queue)
+
+	// A running job is not queued again
+	d.enqueue("a")
+	assert.True(t, logs.contains("Skipping job a: the previous run is still in progress"))
+
+	// Once c finishes, b still waits for a, so d takes the free slot
+	close(fake.release["c"])
+	d.finish(<-d.finished)
+	d.dispatch(ctx)
+	assert.Equal(t, "d", <-fake.started)
+	assert.Equal(t, []string{"b"}, d.queue)
+
+	close(fake.release["a"])
+	d.finish(<-d.finished)
+	d.dispatch(ctx)
+	assert.Equal(t, []string{"b"}, d.queue, "b needs r2, which d is using")
+	close(fake.release["d"])
+	d.finish(<-d.finished)
+	d.dispatch(ctx)
+	assert.Equal(t, "b", <-fake.started)
+	assert.Empty(t, d.queue)
+	assert.True(t, logs.contains("Job c completed"))
+}
+
+func TestDaemon_Run(t *testing.T) {
+	runner, _ := newTestRunner(t, "r1", "r2")
+	registry := runner.registry
+	missed := scheduledJobDef("missed", "r1")
+	missed.CatchUp = true
+	fresh := scheduledJobDef("fresh", "r2")
+	fresh.CatchUp = true
+	registry.Jobs = []registries.Job{missed, fresh}
+	require.NoError(t, runner.record(&Run{Job: "missed", StartedAt: time.Now().Add(-48 * time.Hour)}))
+
+	var mu sync.Mutex
+	loads := 0
+	load := func() (*registries.LocalRegistry, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads++
+		if loads > 1 {
+			registry.Jobs = registry.Jobs[:1]
+		}
+		return registry, nil
+	}
+	logs := &logLines{}
+	d := NewDaemon(load, runner.stateDir, DaemonOptions{ShutdownTimeout: 50 * time.Millisecond, Logf: logs.logf})
+	fake := newFakeRuns("missed", "fresh")
+	d.run = fake.run
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- d.Run(ctx, reload) }()
+
+	// Only the job with a missed run catches up on start
+	select {
+	case name := <-fake.started:
+		assert.Equal(t, "missed", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the missed run was not caught up")
+	}
+	assert.True(t, logs.contains("Job missed missed a scheduled run, catching up"))
+	assert.True(t, logs.contains("Loaded 2 scheduled job(s)"))
+
+	reload <- struct{}{}
+	require.Eventually(t, func() bool { return logs.contains("Loaded 1 scheduled job(s)") }, 5*time.Second, 10*time.Millisecond)
+
+	// The running job gets the shutdown timeout, then is cancelled
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not shut down")
+	}
+	assert.Equal(t, []string{"missed"}, fake.canceled)
+	assert.True(t, logs.contains("Cancelling 1 running job(s)"))
+	assert.Empty(t, fake.started)
+}
+
+func TestDaemon_Run_LoadError(t *testing.T) {
+	d := NewDaemon(func() (*registries.LocalRegistry, error) { return nil, fmt.Errorf("broken registry") }, t.TempDir(), DaemonOptions{})
+	assert.EqualError(t, d.Run(context.Background(), nil), "broken registry")
+}
+
+func TestValidate_Schedule(t *testing.T) {
+	runner, _ := newTestRunner(t, "local")
+	job := scheduledJobDef("pg", "local")
+	assert.Empty(t, Validate(runner.registry, job))
+
+	tests := []struct {
+		edit    func(*registries.Job)
+		problem string
+	}{
+		{func(j *registries.Job) { j.Schedule = "daily" }, `invalid schedule "daily"`},
+		{func(j *registries.Job) { j.Schedule = "0 0 30 feb *" }, "never matches"},
+		{func(j *registries.Job) { j.Jitter = "soon" }, `invalid jitter "soon"`},
+		{func(j *registries.Job) { j.Schedule = "@hourly"; j.Jitter = "1h" }, "jitter 1h is not shorter than the 1h0m0s between runs"},
+		{func(j *registries.Job) { j.Schedule = ""; j.CatchUp = true }, "jitter and catch_up need a schedule"},
+	}
+	for _, tt := range tests {
+		edited := job
+		tt.edit(&edited)
+		problems := Validate(runner.registry, edited)
+		require.NotEmpty(t, problems, tt.problem)
+		assert.Contains(t, problems[0], tt.problem)
+	}
+}
@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+)
+
+// historyLimit is how many runs of each job are kept
+const historyLimit = 100
+
+// historyPath returns the file holding the runs of a job, one JSON document per line
+func (r *Runner) historyPath(job string) string {
+	return filepath.Join(r.stateDir, "runs", job+".jsonl")
+}
+
+// History returns the recorded runs of a job, newest first
+func (r *Runner) History(job string) ([]Run, error) {
+	runs, err := readHistory(r.historyPath(job))
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs, nil
+}
+
+// LastRun returns the newest run of a job, or nil if it never ran
+func (r *Runner) LastRun(job string) (*Run, error) {
+	runs, err := r.History(job)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+// record appends a run to the history of its job, dropping the oldest runs
+func (r *Runner) record(run *Run) error {
+	path := r.historyPath(run.Job)
+	runs, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	runs = append(runs, *run)
+	if len(runs) > historyLimit {
+		runs = runs[len(runs)-historyLimit:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, run := range runs {
+		if err := enc.Encode(run); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return services.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// readHistory reads a history file, oldest run first. A missing file is an empty history;
+// lines that cannot be parsed are skipped with a warning rather than hiding every run.
+func readHistory(path string) ([]Run, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Run
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping invalid line %d of %s: %v\n", line, path, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, scanner.Err()
+}
+
+// Summary describes a job, its newest run and when its schedule matches next
+type Summary struct {
+	registries.Job
+	LastRun *Run       `json:"last_run,omitempty"`
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// List returns the jobs of the registry with their newest and next run
+func (r *Runner) List() ([]Summary, error) {
+	summaries := []Summary{}
+	now := time.Now()
+	for _, job := range r.registry.Jobs {
+		last, err := r.LastRun(job.Name)
+		if err != nil {
+			return nil, err
+		}
+		summary := Summary{Job: job, LastRun: last}
+		if schedule, err := ParseSchedule(job.Schedule); err == nil {
+			if next := schedule.Next(now); !next.IsZero() {
+				summary.NextRun = &next
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
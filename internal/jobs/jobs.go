@@ -0,0 +1,302 @@
+// Package jobs runs the backup jobs declared in the registry.
+//
+// A job streams its source into a backup of each target repository in turn, then
+// applies its retention to the backups it made. Every run is recorded with the timing
+// and outcome of each step.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+)
+
+// Step names
+const (
+	StepBackup = "backup"
+	StepPrune  = "prune"
+)
+
+// Step outcomes
+const (
+	StepOK      = "ok"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+)
+
+// JobTag is the tag naming the job on each backup it makes
+const JobTag = "job"
+
+// Step is one backup or prune of a run
+type Step struct {
+	Name            string    `json:"name"`
+	Alias           string    `json:"alias"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	// Backup is the file written by a backup step
+	Backup string `json:"backup,omitempty"`
+	// Pruned counts the backups deleted by a prune step
+	Pruned int `json:"pruned,omitempty"`
+}
+
+// Run records one run of a job
+type Run struct {
+	Job             string    `json:"job"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// OK is false if any step failed
+	OK    bool   `json:"ok"`
+	Steps []Step `json:"steps"`
+}
+
+// Runner runs jobs and keeps their history
+type Runner struct {
+	registry *registries.LocalRegistry
+	stateDir string
+	// Stdin is the stream backed up by jobs whose source is stdin
+	Stdin io.Reader
+}
+
+// NewRunner creates a runner for the jobs of registry, keeping their history in stateDir
+func NewRunner(registry *registries.LocalRegistry, stateDir string) *Runner {
+	return &Runner{registry: registry, stateDir: stateDir, Stdin: os.Stdin}
+}
+
+// Run runs a job: a backup of each target, each followed by the job's retention. A
+// target whose backup failed is not pruned. Cancelling ctx stops the source of the
+// running backup and skips the remaining targets. The run is appended to the job's
+// history; an invalid job is not run.
+func (r *Runner) Run(ctx context.Context, job registries.Job) (*Run, error) {
+	if problems := Validate(r.registry, job); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid job %s: %s", job.Name, problems[0])
+	}
+
+	run := &Run{Job: job.Name, StartedAt: time.Now(), OK: true, Steps: []Step{}}
+	for _, alias := range job.Targets {
+		if err := ctx.Err(); err != nil {
+			run.Steps = append(run.Steps, Step{Name: StepBackup, Alias: alias, StartedAt: time.Now(), Status: StepSkipped, Error: err.Error()})
+			continue
+		}
+		backup := r.backup(ctx, job, alias)
+		run.Steps = append(run.Steps, backup)
+		if job.Retention == nil {
+			continue
+		}
+		if backup.Status != StepOK {
+			run.Steps = append(run.Steps, Step{Name: StepPrune, Alias: alias, StartedAt: time.Now(), Status: StepSkipped,
+				Error: "backup failed"})
+			continue
+		}
+		run.Steps = append(run.Steps, r.prune(job, alias))
+	}
+
+	for _, step := range run.Steps {
+		if step.Status == StepFailed || (step.Status == StepSkipped && step.Name == StepBackup) {
+			run.OK = false
+		}
+	}
+	run.FinishedAt = time.Now()
+	run.DurationSeconds = run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond).Seconds()
+	if err := r.record(run); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the run of %s: %v\n", job.Name, err)
+	}
+	return run, nil
+}
+
+// backup streams the job's source into a backup of one target
+func (r *Runner) backup(ctx context.Context, job registries.Job, alias string) (step Step) {
+	step = Step{Name: StepBackup, Alias: alias, StartedAt: time.Now(), Status: StepFailed}
+	defer func() {
+		step.DurationSeconds = time.Since(step.StartedAt).Round(time.Millisecond).Seconds()
+	}()
+
+	repoPath, _ := r.registry.Get(alias)
+	description, err := renderDescription(job, alias, step.StartedAt)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+
+	timeout, _ := jobTimeout(job)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	settings := r.registry.GetSettings(alias)
+	opts := services.BackupOptions{
+		Alias:           alias,
+		Suffix:          Suffix(job),
+		Description:     description,
+		Tags:            jobTags(job),
+		CompressedInput: settings.CompressedInput,
+		Hooks:           mergeHooks(settings.Hooks, job.Hooks),
+	}
+	source := openSource(ctx, job.Source, r.Stdin)
+	filename, err := services.NewBackupRunner(r.registry).RunBackup(repoPath, opts, source)
+	source.Close()
+
+	step.Backup = filename
+	switch {
+	case err != nil && ctx.Err() == context.DeadlineExceeded:
+		step.Error = fmt.Sprintf("timed out after %s: %v", timeout, err)
+	case err != nil:
+		step.Error = err.Error()
+	default:
+		step.Status = StepOK
+	}
+	return step
+}
+
+// prune applies the job's retention to the backups of its suffix in one target
+func (r *Runner) prune(job registries.Job, alias string) (step Step) {
+	step = Step{Name: StepPrune, Alias: alias, StartedAt: time.Now(), Status: StepFailed}
+	defer func() {
+		step.DurationSeconds = time.Since(step.StartedAt).Round(time.Millisecond).Seconds()
+	}()
+
+	suffix := Suffix(job)
+	match := func(b services.BackupItem) bool { return b.Suffix == suffix }
+	if job.Retention.Select != "" {
+		selector, err := selectors.Parse(job.Retention.Select)
+		if err != nil {
+			step.Error = err.Error()
+			return step
+		}
+		match = func(b services.BackupItem) bool { return b.Suffix == suffix && selector.Match(b) }
+	}
+
+	repoPath, _ := r.registry.Get(alias)
+	report, err := services.NewRepositoryInspector().Prune(alias, repoPath, services.PruneOptions{
+		Match:        match,
+		KeepLast:     job.Retention.KeepLast,
+		PasswordFile: r.registry.PasswordFile(),
+		Hooks:        mergeHooks(r.registry.GetSettings(alias).Hooks, job.Hooks),
+	})
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	step.Pruned = report.Pruned
+	if report.Failed > 0 {
+		step.Error = fmt.Sprintf("%d backup(s) could not be deleted", report.Failed)
+		return step
+	}
+	step.Status = StepOK
+	return step
+}
+
+// Suffix returns the suffix of the job's backups
+func Suffix(job registries.Job) string {
+	if job.Suffix != "" {
+		return job.Suffix
+	}
+	return job.Name
+}
+
+// DescribeSource summarizes the source of a job in one line
+func DescribeSource(source registries.JobSource) string {
+	switch {
+	case source.Command != "":
+		return "command: " + source.Command
+	case len(source.Paths) > 0:
+		return "paths: " + strings.Join(source.Paths, ", ")
+	case source.Stdin:
+		return "stdin"
+	}
+	return "none"
+}
+
+// jobTimeout parses the timeout of a job; zero means none
+func jobTimeout(job registries.Job) (time.Duration, error) {
+	if job.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(job.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", job.Timeout)
+	}
+	return timeout, nil
+}
+
+// jobJitter parses the jitter of a job; zero means none
+func jobJitter(job registries.Job) (time.Duration, error) {
+	if job.Jitter == "" {
+		return 0, nil
+	}
+	jitter, err := time.ParseDuration(job.Jitter)
+	if err != nil || jitter < 0 {
+		return 0, fmt.Errorf("invalid jitter %q", job.Jitter)
+	}
+	return jitter, nil
+}
+
+// jobTags returns the tags of the job's backups, which always name the job
+func jobTags(job registries.Job) map[string]string {
+	tags := make(map[string]string, len(job.Tags)+1)
+	for key, value := range job.Tags {
+		tags[key] = value
+	}
+	tags[JobTag] = job.Name
+	return tags
+}
+
+// descriptionData is what a description template can refer to
+type descriptionData struct {
+	Job    string
+	Alias  string
+	Suffix string
+	Host   string
+	Time   time.Time
+}
+
+// renderDescription expands the description template of a job for one target
+func renderDescription(job registries.Job, alias string, now time.Time) (string, error) {
+	if job.Description == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("description").Parse(job.Description)
+	if err != nil {
+		return "", fmt.Errorf("invalid description template: %w", err)
+	}
+	host, _ := os.Hostname()
+	var buf bytes.Buffer
+	data := descriptionData{Job: job.Name, Alias: alias, Suffix: Suffix(job), Host: host, Time: now}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid description template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// mergeHooks runs the hooks of a job after those of the repository
+func mergeHooks(repo, job *registries.Hooks) *registries.Hooks {
+	if job == nil {
+		return repo
+	}
+	if repo == nil {
+		return job
+	}
+	join := func(a, b []registries.Hook) []registries.Hook {
+		return append(append([]registries.Hook(nil), a...), b...)
+	}
+	return &registries.Hooks{
+		PreBackup:  join(repo.PreBackup, job.PreBackup),
+		PostBackup: join(repo.PostBackup, job.PostBackup),
+		OnFailure:  join(repo.OnFailure, job.OnFailure),
+		PrePrune:   join(repo.PrePrune, job.PrePrune),
+		PostGC:     join(repo.PostGC, job.PostGC),
+	}
+}
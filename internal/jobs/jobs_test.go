@@ -0,0 +1,308 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRunner registers repositories backed by a zbackup stand-in that stores the
+// stream as the backup file itself
+func newTestRunner(t *testing.T, aliases ...string) (*Runner, map[string]string) {
+	dir := t.TempDir()
+	zbackupPath := filepath.Join(dir, "zbackup-mock")
+	script := "#!/bin/sh\nfor last; do :; done\ncat > \"$last\"\n"
+	require.NoError(t, os.WriteFile(zbackupPath, []byte(script), 0755))
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = zbackupPath
+	registry.Encryption.Type = "none"
+	repos := make(map[string]string)
+	for _, alias := range aliases {
+		repos[alias] = filepath.Join(dir, alias)
+		require.NoError(t, os.MkdirAll(filepath.Join(repos[alias], "backups"), 0755))
+		require.NoError(t, registry.Add(alias, repos[alias]))
+	}
+	return NewRunner(registry, filepath.Join(dir, "state")), repos
+}
+
+func TestRunner_Run(t *testing.T) {
+	runner, repos := newTestRunner(t, "local", "offsite")
+	for _, repoDir := range repos {
+		for _, name := range []string{"2024-01-01_0100-pg.zbk", "2024-01-01_0100-other.zbk"} {
+			require.NoError(t, os.WriteFile(filepath.Join(repoDir, "backups", name), []byte("old"), 0644))
+		}
+	}
+
+	job := registries.Job{
+		Name:        "pg",
+		Source:      registries.JobSource{Command: "printf 'database dump'"},
+		Targets:     []string{"local", "offsite"},
+		Description: "{{.Job}} to {{.Alias}}",
+		Tags:        map[string]string{"db": "main"},
+		Retention:   &registries.JobRetention{KeepLast: 1},
+	}
+	run, err := runner.Run(context.Background(), job)
+	require.NoError(t, err)
+	assert.True(t, run.OK)
+	require.Len(t, run.Steps, 4)
+
+	for i, alias := range []string{"local", "offsite"} {
+		backup, prune := run.Steps[2*i], run.Steps[2*i+1]
+		assert.Equal(t, StepBackup, backup.Name)
+		assert.Equal(t, alias, backup.Alias)
+		assert.Equal(t, StepOK, backup.Status, backup.Error)
+		assert.Equal(t, StepPrune, prune.Name)
+		assert.Equal(t, StepOK, prune.Status, prune.Error)
+		assert.Equal(t, 1, prune.Pruned)
+
+		zbkPath := filepath.Join(repos[alias], "backups", backup.Backup)
+		data, err := os.ReadFile(zbkPath)
+		require.NoError(t, err)
+		assert.Equal(t, "database dump", string(data))
+		meta, _, err := services.ReadSidecar(zbkPath)
+		require.NoError(t, err)
+		assert.Equal(t, "pg to "+alias, meta.Description)
+		assert.Equal(t, map[string]string{"db": "main", "job": "pg"}, meta.Tags)
+
+		// Retention only applies to the job's own suffix
+		assert.NoFileExists(t, filepath.Join(repos[alias], "backups", "2024-01-01_0100-pg.zbk"))
+		assert.FileExists(t, filepath.Join(repos[alias], "backups", "2024-01-01_0100-other.zbk"))
+	}
+
+	history, err := runner.History("pg")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.True(t, history[0].OK)
+	require.Len(t, history[0].Steps, 4)
+	assert.Equal(t, run.Steps[2].Backup, history[0].Steps[2].Backup)
+	assert.Equal(t, run.Steps[3].Pruned, history[0].Steps[3].Pruned)
+}
+
+func TestRunner_Run_Failures(t *testing.T) {
+	runner, repos := newTestRunner(t, "local")
+	backups := filepath.Join(repos["local"], "backups")
+	job := registries.Job{
+		Name:      "dump",
+		Targets:   []string{"local"},
+		Retention: &registries.JobRetention{KeepLast: 1},
+	}
+
+	// A failing source fails the backup, leaves nothing behind and skips retention
+	job.Source.Command = "echo partial; exit 3"
+	run, err := runner.Run(context.Background(), job)
+	require.NoError(t, err)
+	assert.False(t, run.OK)
+	require.Len(t, run.Steps, 2)
+	assert.Equal(t, StepFailed, run.Steps[0].Status)
+	assert.Contains(t, run.Steps[0].Error, "source command failed: exit status 3")
+	assert.Equal(t, StepSkipped, run.Steps[1].Status)
+	matches, err := filepath.Glob(filepath.Join(backups, "*.zbk"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// The source is stopped when the job times out
+	job.Source.Command = "exec sleep 5"
+	job.Timeout = "200ms"
+	run, err = runner.Run(context.Background(), job)
+	require.NoError(t, err)
+	assert.False(t, run.OK)
+	assert.Contains(t, run.Steps[0].Error, "timed out after 200ms")
+	assert.Less(t, run.Steps[0].DurationSeconds, 5.0)
+
+	// A failing pre_backup hook means the source never runs
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	refuse := filepath.Join(dir, "refuse")
+	require.NoError(t, os.WriteFile(refuse, []byte("#!/bin/sh\nexit 1\n"), 0755))
+	job.Source.Command = "touch " + marker
+	job.Timeout = ""
+	job.Hooks = &registries.Hooks{PreBackup: []registries.Hook{{Command: refuse}}}
+	run, err = runner.Run(context.Background(), job)
+	require.NoError(t, err)
+	assert.Contains(t, run.Steps[0].Error, "backup aborted")
+	assert.NoFileExists(t, marker)
+
+	// A cancelled run skips its targets
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run, err = runner.Run(ctx, job)
+	require.NoError(t, err)
+	assert.False(t, run.OK)
+	assert.Equal(t, StepSkipped, run.Steps[0].Status)
+
+	history, err := runner.History("dump")
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	assert.Equal(t, StepSkipped, history[0].Steps[0].Status)
+
+	// Invalid jobs are not run
+	_, err = runner.Run(context.Background(), registries.Job{Name: "broken", Targets: []string{"local"}})
+	assert.Error(t, err)
+	last, err := runner.LastRun("broken")
+	require.NoError(t, err)
+	assert.Nil(t, last)
+}
+
+func TestRunner_Run_Stdin(t *testing.T) {
+	runner, repos := newTestRunner(t, "local")
+	runner.Stdin = strings.NewReader("from stdin")
+
+	run, err := runner.Run(context.Background(), registries.Job{
+		Name:    "piped",
+		Suffix:  "manual",
+		Source:  registries.JobSource{Stdin: true},
+		Targets: []string{"local"},
+	})
+	require.NoError(t, err)
+	require.True(t, run.OK, run.Steps[0].Error)
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}_\d{4}-manual\.zbk$`, run.Steps[0].Backup)
+	data, err := os.ReadFile(filepath.Join(repos["local"], "backups", run.Steps[0].Backup))
+	require.NoError(t, err)
+	assert.Equal(t, "from stdin", string(data))
+}
+
+func TestRunner_Run_NameCollision(t *testing.T) {
+	runner, repos := newTestRunner(t, "local")
+	job := registries.Job{Name: "pg", Source: registries.JobSource{Command: "printf second"}, Targets: []string{"local"}}
+
+	// Backups are named by the minute; retry if the clock moves on between the two runs
+	for attempt := 0; attempt < 3; attempt++ {
+		name := time.Now().Format("2006-01-02_1504") + "-pg.zbk"
+		zbkPath := filepath.Join(repos["local"], "backups", name)
+		require.NoError(t, os.WriteFile(zbkPath, []byte("first"), 0644))
+		require.NoError(t, services.WriteSidecar(services.SidecarPath(zbkPath), services.MetadataSidecar{Status: services.StatusSuccess, Description: "first"}))
+
+		run, err := runner.Run(context.Background(), job)
+		require.NoError(t, err)
+		if run.Steps[0].Backup != name {
+			continue
+		}
+		assert.False(t, run.OK)
+		assert.Contains(t, run.Steps[0].Error, "already exists")
+
+		// The earlier backup and its sidecar are left alone
+		data, err := os.ReadFile(zbkPath)
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(data))
+		meta, _, err := services.ReadSidecar(zbkPath)
+		require.NoError(t, err)
+		assert.Equal(t, services.StatusSuccess, meta.Status)
+		assert.Equal(t, "first", meta.Description)
+		return
+	}
+	t.Fatal("the minute changed during every attempt")
+}
+
+func TestRunner_Run_NameInUse(t *testing.T) {
+	runner, repos := newTestRunner(t, "local")
+	job := registries.Job{Name: "pg", Source: registries.JobSource{Command: "printf second"}, Targets: []string{"local"}}
+
+	// A backup of the same name is still running: only its sidecar exists so far
+	for attempt := 0; attempt < 3; attempt++ {
+		name := time.Now().Format("2006-01-02_1504") + "-pg.zbk"
+		zbkPath := filepath.Join(repos["local"], "backups", name)
+		require.NoError(t, services.WriteSidecar(services.SidecarPath(zbkPath), services.MetadataSidecar{Status: services.StatusInProgress, Description: "first"}))
+
+		run, err := runner.Run(context.Background(), job)
+		require.NoError(t, err)
+		if run.Steps[0].Backup != name {
+			continue
+		}
+		assert.False(t, run.OK)
+		assert.Contains(t, run.Steps[0].Error, "already exists")
+
+		// The running backup keeps its sidecar and its backup file is not written
+		assert.NoFileExists(t, zbkPath)
+		meta, _, err := services.ReadSidecar(zbkPath)
+		require.NoError(t, err)
+		assert.Equal(t, services.StatusInProgress, meta.Status)
+		assert.Equal(t, "first", meta.Description)
+		return
+	}
+	t.Fatal("the minute changed during every attempt")
+}
+
+func TestRunner_HistoryLimit(t *testing.T) {
+	runner, _ := newTestRunner(t)
+	for i := 0; i < historyLimit+5; i++ {
+		require.NoError(t, runner.record(&Run{Job: "pg", DurationSeconds: float64(i)}))
+	}
+	history, err := runner.History("pg")
+	require.NoError(t, err)
+	require.Len(t, history, historyLimit)
+	assert.Equal(t, float64(historyLimit+4), history[0].DurationSeconds)
+	assert.Equal(t, float64(5), history[historyLimit-1].DurationSeconds)
+}
+
+func TestRunner_History_InvalidLines(t *testing.T) {
+	runner, _ := newTestRunner(t)
+	require.NoError(t, runner.record(&Run{Job: "pg", DurationSeconds: 1}))
+	path := runner.historyPath("pg")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"job\": \"pg\", trunc\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// A damaged line neither hides the other runs nor stops new ones being recorded
+	require.NoError(t, runner.record(&Run{Job: "pg", DurationSeconds: 2}))
+	history, err := runner.History("pg")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, float64(2), history[0].DurationSeconds)
+	assert.Equal(t, float64(1), history[1].DurationSeconds)
+}
+
+func TestValidate(t *testing.T) {
+	runner, _ := newTestRunner(t, "local", "offsite")
+	valid := registries.Job{Name: "pg", Source: registries.JobSource{Command: "pg_dumpall"}, Targets: []string{"local"}}
+	assert.Empty(t, Validate(runner.registry, valid))
+
+	tests := []struct {
+		name    string
+		edit    func(*registries.Job)
+		problem string
+	}{
+		{"name", func(j *registries.Job) { j.Name = "../pg" }, `invalid name "../pg"`},
+		{"suffix", func(j *registries.Job) { j.Suffix = "a b" }, `invalid suffix "a b"`},
+		{"no source", func(j *registries.Job) { j.Source = registries.JobSource{} }, "set exactly one of"},
+		{"two sources", func(j *registries.Job) { j.Source.Paths = []string{"/etc"} }, "set exactly one of"},
+		{"relative path", func(j *registries.Job) { j.Source = registries.JobSource{Paths: []string{"etc"}} }, `source path "etc" is not absolute`},
+		{"stdin targets", func(j *registries.Job) {
+			j.Source = registries.JobSource{Stdin: true}
+			j.Targets = []string{"local", "offsite"}
+		}, "a stdin source can only be backed up to one target"},
+		{"no targets", func(j *registries.Job) { j.Targets = nil }, "no targets"},
+		{"unknown target", func(j *registries.Job) { j.Targets = []string{"nas"} }, "target nas is not a registered repository"},
+		{"duplicate target", func(j *registries.Job) { j.Targets = []string{"local", "local"} }, "target local is listed twice"},
+		{"template", func(j *registries.Job) { j.Description = "{{.Nope}}" }, "invalid description template"},
+		{"timeout", func(j *registries.Job) { j.Timeout = "soon" }, `invalid timeout "soon"`},
+		{"hook", func(j *registries.Job) {
+			j.Hooks = &registries.Hooks{PostBackup: []registries.Hook{{Command: "/bin/true", Timeout: "-1s"}}}
+		}, `post_backup hook /bin/true: invalid timeout "-1s"`},
+		{"keep last", func(j *registries.Job) { j.Retention = &registries.JobRetention{Select: "until:30d"} }, "retention.keep_last must be at least 1"},
+		{"select", func(j *registries.Job) { j.Retention = &registries.JobRetention{KeepLast: 1, Select: "bogus:1"} }, "retention.select"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := valid
+			tt.edit(&job)
+			problems := Validate(runner.registry, job)
+			require.NotEmpty(t, problems)
+			assert.Contains(t, problems[0], tt.problem)
+		})
+	}
+
+	runner.registry.Jobs = []registries.Job{valid, valid}
+	assert.Equal(t, []Problem{{Job: "pg", Message: "duplicate job name"}}, ValidateAll(runner.registry))
+}
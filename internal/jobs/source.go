@@ -0,0 +1,200 @@
+package jobs
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zbwrap/internal/registries"
+)
+
+// errSourceClosed stops an archive whose reader went away
+var errSourceClosed = errors.New("source closed")
+
+// openSource returns the stream of a job's source. Nothing runs until the stream is
+// first read, so a backup aborted by its pre_backup hooks never starts the source. A
+// source that fails or is cancelled through ctx ends its stream with an error, which
+// fails the backup.
+func openSource(ctx context.Context, source registries.JobSource, stdin io.Reader) io.ReadCloser {
+	switch {
+	case source.Command != "":
+		return &commandSource{ctx: ctx, command: source.Command}
+	case len(source.Paths) > 0:
+		return &pathsSource{ctx: ctx, paths: source.Paths}
+	default:
+		return io.NopCloser(contextReader{ctx: ctx, r: stdin})
+	}
+}
+
+// commandSource streams the standard output of a shell command
+type commandSource struct {
+	ctx     context.Context
+	command string
+	cmd     *exec.Cmd
+	pr      *io.PipeReader
+	done    chan struct{}
+}
+
+func (s *commandSource) Read(p []byte) (int, error) {
+	if s.pr == nil {
+		s.start()
+	}
+	return s.pr.Read(p)
+}
+
+// start runs the command; its exit status ends the stream once it is reaped
+func (s *commandSource) start() {
+	pr, pw := io.Pipe()
+	s.pr = pr
+	s.done = make(chan struct{})
+
+	cmd := exec.CommandContext(s.ctx, "sh", "-c", s.command)
+	cmd.Stdout = pw
+	cmd.Stderr = os.Stderr
+	// Do not wait forever for children that inherited stdout
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		pw.CloseWithError(fmt.Errorf("source command failed to start: %w", err))
+		close(s.done)
+		return
+	}
+	s.cmd = cmd
+
+	go func() {
+		defer close(s.done)
+		err := cmd.Wait()
+		switch {
+		case err != nil && s.ctx.Err() != nil:
+			err = fmt.Errorf("source command stopped: %w", s.ctx.Err())
+		case err != nil:
+			err = fmt.Errorf("source command failed: %w", err)
+		}
+		pw.CloseWithError(err)
+	}()
+}
+
+// Close stops the command if it is still running
+func (s *commandSource) Close() error {
+	if s.pr == nil {
+		return nil
+	}
+	if s.cmd != nil {
+		_ = s.cmd.Process.Kill()
+	}
+	s.pr.CloseWithError(errSourceClosed)
+	<-s.done
+	return nil
+}
+
+// pathsSource streams a tar archive of files and directories
+type pathsSource struct {
+	ctx   context.Context
+	paths []string
+	pr    *io.PipeReader
+	done  chan struct{}
+}
+
+func (s *pathsSource) Read(p []byte) (int, error) {
+	if s.pr == nil {
+		pr, pw := io.Pipe()
+		s.pr = pr
+		s.done = make(chan struct{})
+		go func() {
+			defer close(s.done)
+			pw.CloseWithError(writeArchive(s.ctx, pw, s.paths))
+		}()
+	}
+	return s.pr.Read(p)
+}
+
+// Close stops the archive if it is still being written
+func (s *pathsSource) Close() error {
+	if s.pr != nil {
+		s.pr.CloseWithError(errSourceClosed)
+		<-s.done
+	}
+	return nil
+}
+
+// writeArchive writes paths and everything below them to w as a tar archive. Entries
+// are named after their absolute path without the leading slash, as tar does.
+func writeArchive(ctx context.Context, w io.Writer, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return addArchiveEntry(ctx, tw, path, info)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", root, err)
+		}
+	}
+	return tw.Close()
+}
+
+// addArchiveEntry writes one file, directory or link. Sockets cannot be archived and
+// are skipped.
+func addArchiveEntry(ctx context.Context, tw *tar.Writer, path string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSocket != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipping socket %s\n", path)
+		return nil
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// A file that shrank while it was read cannot fill its header size
+	if _, err := io.CopyN(tw, contextReader{ctx: ctx, r: f}, header.Size); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// contextReader stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathsSource(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "etc", "conf.d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "etc", "app.conf"), []byte("listen 80\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "etc", "conf.d", "extra.conf"), []byte("debug\n"), 0600))
+	require.NoError(t, os.Symlink("app.conf", filepath.Join(dir, "etc", "current.conf")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes"), []byte("hi"), 0644))
+
+	source := openSource(context.Background(), registries.JobSource{
+		Paths: []string{filepath.Join(dir, "etc"), filepath.Join(dir, "notes")},
+	}, nil)
+	defer source.Close()
+
+	prefix := strings.TrimPrefix(filepath.ToSlash(dir), "/") + "/"
+	entries := make(map[string]string)
+	tr := tar.NewReader(source)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		name := strings.TrimPrefix(header.Name, prefix)
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			entries[name] = "-> " + header.Linkname
+		case tar.TypeDir:
+			entries[name] = "dir"
+		default:
+			entries[name] = string(data)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"etc/":                  "dir",
+		"etc/app.conf":          "listen 80\n",
+		"etc/conf.d/":           "dir",
+		"etc/conf.d/extra.conf": "debug\n",
+		"etc/current.conf":      "-> app.conf",
+		"notes":                 "hi",
+	}, entries)
+}
+
+func TestPathsSource_Errors(t *testing.T) {
+	source := openSource(context.Background(), registries.JobSource{Paths: []string{"/does/not/exist"}}, nil)
+	_, err := io.ReadAll(source)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to archive /does/not/exist")
+	source.Close()
+
+	// Closing an unread archive stops it
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big"), make([]byte, 1<<20), 0644))
+	source = openSource(context.Background(), registries.JobSource{Paths: []string{dir}}, nil)
+	_, err = source.Read(make([]byte, 10))
+	require.NoError(t, err)
+	source.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	source = openSource(ctx, registries.JobSource{Stdin: true}, strings.NewReader("data"))
+	_, err = io.ReadAll(source)
+	assert.ErrorIs(t, err, context.Canceled)
+}
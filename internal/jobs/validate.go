@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"zbwrap/internal/registries"
+	"zbwrap/internal/selectors"
+	"zbwrap/internal/services"
+)
+
+// namePattern restricts job names and suffixes to what is safe in file and unit names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Problem is a mistake in the definition of a job
+type Problem struct {
+	Job     string `json:"job"`
+	Message string `json:"message"`
+}
+
+// ValidateAll checks every job of the registry, including that their names are unique
+func ValidateAll(registry *registries.LocalRegistry) []Problem {
+	problems := []Problem{}
+	seen := make(map[string]bool)
+	for _, job := range registry.Jobs {
+		if seen[job.Name] {
+			problems = append(problems, Problem{Job: job.Name, Message: "duplicate job name"})
+		}
+		seen[job.Name] = true
+		for _, message := range Validate(registry, job) {
+			problems = append(problems, Problem{Job: job.Name, Message: message})
+		}
+	}
+	return problems
+}
+
+// Validate checks the definition of a job against the registry
+func Validate(registry *registries.LocalRegistry, job registries.Job) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !namePattern.MatchString(job.Name) {
+		add("invalid name %q: use letters, digits, '.', '_' and '-'", job.Name)
+	}
+	if job.Suffix != "" && !namePattern.MatchString(job.Suffix) {
+		add("invalid suffix %q: use letters, digits, '.', '_' and '-'", job.Suffix)
+	}
+
+	sources := 0
+	if job.Source.Command != "" {
+		sources++
+	}
+	if len(job.Source.Paths) > 0 {
+		sources++
+		for _, path := range job.Source.Paths {
+			if !filepath.IsAbs(path) {
+				add("source path %q is not absolute", path)
+			}
+		}
+	}
+	if job.Source.Stdin {
+		sources++
+		if len(job.Targets) > 1 {
+			add("a stdin source can only be backed up to one target")
+		}
+	}
+	if sources != 1 {
+		add("set exactly one of source.command, source.paths and source.stdin")
+	}
+
+	if len(job.Targets) == 0 {
+		add("no targets")
+	}
+	targets := make(map[string]bool)
+	for _, alias := range job.Targets {
+		if targets[alias] {
+			add("target %s is listed twice", alias)
+		}
+		targets[alias] = true
+		if _, ok := registry.Get(alias); !ok {
+			add("target %s is not a registered repository", alias)
+		}
+	}
+
+	if _, err := renderDescription(job, "alias", time.Now()); err != nil {
+		add("%v", err)
+	}
+	for key := range job.Tags {
+		if key == "" {
+			add("empty tag key")
+		}
+	}
+	if _, err := jobTimeout(job); err != nil {
+		add("%v", err)
+	}
+	problems = append(problems, validateSchedule(job)...)
+	problems = append(problems, validateHooks(job.Hooks)...)
+
+	if job.Retention != nil {
+		// Keeping at least one protects the backup the run just made
+		if job.Retention.KeepLast < 1 {
+			add("retention.keep_last must be at least 1")
+		}
+		if job.Retention.Select != "" {
+			if _, err := selectors.Parse(job.Retention.Select); err != nil {
+				add("retention.select: %v", err)
+			}
+		}
+	}
+	return problems
+}
+
+// validateSchedule checks the fields 'zbwrap daemon' uses
+func validateSchedule(job registries.Job) []string {
+	if job.Schedule == "" {
+		if job.Jitter != "" || job.CatchUp {
+			return []string{"jitter and catch_up need a schedule"}
+		}
+		return nil
+	}
+
+	var problems []string
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	first := schedule.Next(time.Now())
+	if first.IsZero() {
+		return []string{fmt.Sprintf("schedule %q never matches", job.Schedule)}
+	}
+	if job.Source.Stdin {
+		problems = append(problems, "a stdin source cannot be scheduled")
+	}
+	jitter, err := jobJitter(job)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if second := schedule.Next(first); !second.IsZero() && jitter >= second.Sub(first) {
+		// A longer delay would run into the next scheduled run
+		problems = append(problems, fmt.Sprintf("jitter %s is not shorter than the %s between runs", job.Jitter, second.Sub(first)))
+	}
+	return problems
+}
+
+// validateHooks checks the hooks of a job
+func validateHooks(hooks *registries.Hooks) []string {
+	if hooks == nil {
+		return nil
+	}
+	var problems []string
+	events := []struct {
+		name  string
+		hooks []registries.Hook
+	}{
+		{services.HookPreBackup, hooks.PreBackup},
+		{services.HookPostBackup, hooks.PostBackup},
+		{services.HookOnFailure, hooks.OnFailure},
+		{services.HookPrePrune, hooks.PrePrune},
+		{services.HookPostGC, hooks.PostGC},
+	}
+	for _, event := range events {
+		for _, hook := range event.hooks {
+			if hook.Command == "" {
+				problems = append(problems, fmt.Sprintf("%s hook without a command", event.name))
+			}
+			if hook.Timeout == "" {
+				continue
+			}
+			if timeout, err := time.ParseDuration(hook.Timeout); err != nil || timeout <= 0 {
+				problems = append(problems, fmt.Sprintf("%s hook %s: invalid timeout %q", event.name, hook.Command, hook.Timeout))
+			}
+		}
+	}
+	return problems
+}
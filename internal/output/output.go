@@ -0,0 +1,220 @@
+// Package output renders the results of information commands in the formats
+// selected with --output: table, json, ndjson, csv, yaml or template=<go template>.
+//
+// JSON and YAML documents are wrapped in a versioned envelope:
+//
+//	{"api_version": 1, "kind": "repository", "data": {...}}
+//
+// NDJSON emits one envelope per record, while CSV and templates work on the
+// records directly.
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersion is the version of the JSON envelope and of the documents it carries.
+// It changes only when a field is removed or changes meaning.
+const APIVersion = 1
+
+// Format names
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatCSV      = "csv"
+	FormatYAML     = "yaml"
+	FormatTemplate = "template"
+)
+
+// Envelope wraps every JSON, YAML and NDJSON document
+type Envelope struct {
+	APIVersion int         `json:"api_version" yaml:"api_version"`
+	Kind       string      `json:"kind" yaml:"kind"`
+	Data       interface{} `json:"data" yaml:"data"`
+}
+
+// Column is a single CSV column
+type Column struct {
+	Name  string
+	Value func(record interface{}) string
+}
+
+// Col builds a column from a typed accessor
+func Col[T any](name string, value func(T) string) Column {
+	return Column{Name: name, Value: func(record interface{}) string {
+		return value(record.(T))
+	}}
+}
+
+// Document is what an information command produces
+type Document struct {
+	// Kind names the document in the envelope, e.g. "repository"
+	Kind string
+	// Data is the complete result, used by json and yaml
+	Data interface{}
+	// RecordKind names each record in the ndjson envelope, e.g. "backup"
+	RecordKind string
+	// Records is a slice of records, used by ndjson, csv and template
+	Records interface{}
+	// Columns define the csv output
+	Columns []Column
+	// Table writes the human readable output
+	Table func(w io.Writer)
+}
+
+// Format is a parsed --output value
+type Format struct {
+	Name     string
+	Template *template.Template
+}
+
+// ParseFormat parses an --output value
+func ParseFormat(value string) (Format, error) {
+	if rest, ok := strings.CutPrefix(value, FormatTemplate+"="); ok {
+		tmpl, err := template.New("output").Parse(rest)
+		if err != nil {
+			return Format{}, fmt.Errorf("invalid output template: %w", err)
+		}
+		return Format{Name: FormatTemplate, Template: tmpl}, nil
+	}
+
+	switch value {
+	case "", FormatTable:
+		return Format{Name: FormatTable}, nil
+	case FormatJSON, FormatNDJSON, FormatCSV, FormatYAML:
+		return Format{Name: value}, nil
+	case FormatTemplate:
+		return Format{}, fmt.Errorf("the template format needs a template, e.g. template='{{.Filename}}'")
+	}
+	return Format{}, fmt.Errorf("unknown output format '%s' (expected table, json, ndjson, csv, yaml or template=...)", value)
+}
+
+// Render writes doc to w in the given format
+func Render(w io.Writer, format Format, doc Document) error {
+	switch format.Name {
+	case FormatJSON:
+		data, err := json.MarshalIndent(Envelope{APIVersion: APIVersion, Kind: doc.Kind, Data: doc.Data}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case FormatYAML:
+		return renderYAML(w, doc.Kind, doc.Data)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		return eachRecord(doc.Records, func(record interface{}) error {
+			return enc.Encode(Envelope{APIVersion: APIVersion, Kind: doc.RecordKind, Data: record})
+		})
+	case FormatCSV:
+		return renderCSV(w, doc)
+	case FormatTemplate:
+		return eachRecord(doc.Records, func(record interface{}) error {
+			if err := format.Template.Execute(w, record); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		})
+	default:
+		if doc.Table != nil {
+			doc.Table(w)
+		}
+		return nil
+	}
+}
+
+// renderCSV writes a header row followed by one row per record
+func renderCSV(w io.Writer, doc Document) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(doc.Columns))
+	for i, col := range doc.Columns {
+		header[i] = col.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	err := eachRecord(doc.Records, func(record interface{}) error {
+		row := make([]string, len(doc.Columns))
+		for i, col := range doc.Columns {
+			row[i] = col.Value(record)
+		}
+		return cw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// renderYAML writes the envelope as YAML, using the same field names as the JSON form
+func renderYAML(w io.Writer, kind string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(Envelope{APIVersion: APIVersion, Kind: kind, Data: normalizeNumbers(tree)}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// normalizeNumbers turns json.Number values into integers or floats so YAML prints them unquoted
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			t[k] = normalizeNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = normalizeNumbers(child)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// eachRecord calls fn for every element of the records slice
+func eachRecord(records interface{}, fn func(record interface{}) error) error {
+	if records == nil {
+		return nil
+	}
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return fn(records)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := fn(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
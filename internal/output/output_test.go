@@ -0,0 +1,94 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRecord struct {
+	Name string `json:"name"`
+	Size int64  `json:"size_bytes"`
+}
+
+func testDocument() Document {
+	records := []testRecord{{Name: "a, b", Size: 1234567}, {Name: "c", Size: 2}}
+	return Document{
+		Kind:       "test_list",
+		Data:       map[string]interface{}{"total": 2, "records": records},
+		RecordKind: "test",
+		Records:    records,
+		Columns: []Column{
+			Col("name", func(r testRecord) string { return r.Name }),
+			Col("size_bytes", func(r testRecord) string { return fmt.Sprint(r.Size) }),
+		},
+		Table: func(w io.Writer) { fmt.Fprintln(w, "TABLE") },
+	}
+}
+
+func renderString(t *testing.T, value string) string {
+	format, err := ParseFormat(value)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, Render(&buf, format, testDocument()))
+	return buf.String()
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, value := range []string{"", "table", "json", "ndjson", "csv", "yaml", "template={{.Name}}"} {
+		_, err := ParseFormat(value)
+		assert.NoError(t, err, value)
+	}
+	for _, value := range []string{"xml", "template", "template={{.Name"} {
+		_, err := ParseFormat(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRender_Table(t *testing.T) {
+	assert.Equal(t, "TABLE\n", renderString(t, "table"))
+}
+
+func TestRender_JSONEnvelope(t *testing.T) {
+	var env struct {
+		APIVersion int    `json:"api_version"`
+		Kind       string `json:"kind"`
+		Data       struct {
+			Total   int          `json:"total"`
+			Records []testRecord `json:"records"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(renderString(t, "json")), &env))
+	assert.Equal(t, APIVersion, env.APIVersion)
+	assert.Equal(t, "test_list", env.Kind)
+	assert.Equal(t, 2, env.Data.Total)
+	assert.Equal(t, "a, b", env.Data.Records[0].Name)
+}
+
+func TestRender_NDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(renderString(t, "ndjson")), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `{"api_version":1,"kind":"test","data":{"name":"c","size_bytes":2}}`, lines[1])
+}
+
+func TestRender_CSV(t *testing.T) {
+	assert.Equal(t, "name,size_bytes\n\"a, b\",1234567\nc,2\n", renderString(t, "csv"))
+}
+
+func TestRender_YAML(t *testing.T) {
+	out := renderString(t, "yaml")
+	assert.Contains(t, out, "api_version: 1\n")
+	assert.Contains(t, out, "kind: test_list\n")
+	// Field names follow the JSON form and numbers are not quoted or in exponent form
+	assert.Contains(t, out, "size_bytes: 1234567\n")
+}
+
+func TestRender_Template(t *testing.T) {
+	assert.Equal(t, "a, b=1234567\nc=2\n", renderString(t, "template={{.Name}}={{.Size}}"))
+}
@@ -1,9 +1,11 @@
 package registries
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,19 +19,166 @@ type EncryptionConfig struct {
 	CredentialsPath string `json:"credentials_path,omitempty" mapstructure:"credentials_path"`
 }
 
+// RepositorySettings holds per-repository behaviour overrides
+type RepositorySettings struct {
+	CompressedInput string `json:"compressed_input,omitempty" mapstructure:"compressed_input"`
+	// Health holds the expectations evaluated by check-health
+	Health *HealthExpectations `json:"health,omitempty" mapstructure:"health"`
+	// Hooks are run around backups, prunes and garbage collection
+	Hooks *Hooks `json:"hooks,omitempty" mapstructure:"hooks"`
+}
+
+// Hooks lists the executables run for each repository event, in order
+type Hooks struct {
+	PreBackup  []Hook `json:"pre_backup,omitempty" mapstructure:"pre_backup"`
+	PostBackup []Hook `json:"post_backup,omitempty" mapstructure:"post_backup"`
+	OnFailure  []Hook `json:"on_failure,omitempty" mapstructure:"on_failure"`
+	PrePrune   []Hook `json:"pre_prune,omitempty" mapstructure:"pre_prune"`
+	PostGC     []Hook `json:"post_gc,omitempty" mapstructure:"post_gc"`
+}
+
+// Hook is an executable that receives the event as JSON on stdin
+type Hook struct {
+	Command string   `json:"command" mapstructure:"command"`
+	Args    []string `json:"args,omitempty" mapstructure:"args"`
+	// Timeout is a duration such as 30s or 5m; empty uses the default
+	Timeout string `json:"timeout,omitempty" mapstructure:"timeout"`
+	// Suffixes restricts backup hooks to backups with one of these suffixes; empty matches all
+	Suffixes []string `json:"suffixes,omitempty" mapstructure:"suffixes"`
+}
+
+// Threshold is a warning and a critical limit, either of which may be left empty
+type Threshold struct {
+	Warning  string `json:"warning,omitempty" mapstructure:"warning"`
+	Critical string `json:"critical,omitempty" mapstructure:"critical"`
+}
+
+// HealthExpectations are the limits a repository is expected to stay within.
+// Ages are durations such as 36h, 8d or 2w; sizes are byte counts such as 500GB.
+type HealthExpectations struct {
+	// MaxAge bounds the age of the newest backup of each suffix; "*" covers every suffix
+	MaxAge map[string]Threshold `json:"max_age,omitempty" mapstructure:"max_age"`
+	// MinBackups is the smallest acceptable number of backups
+	MinBackups *Threshold `json:"min_backups,omitempty" mapstructure:"min_backups"`
+	// MaxSize bounds the size of the repository
+	MaxSize *Threshold `json:"max_size,omitempty" mapstructure:"max_size"`
+	// MinFree is the space left on the filesystem, as a size or a percentage such as 10%
+	MinFree *Threshold `json:"min_free,omitempty" mapstructure:"min_free"`
+	// MaxCheckAge bounds the time since the last successful check
+	MaxCheckAge *Threshold `json:"max_check_age,omitempty" mapstructure:"max_check_age"`
+}
+
+// NotificationChannel is a destination for notifications and the rules selecting what it receives
+type NotificationChannel struct {
+	Name string `json:"name" mapstructure:"name"`
+	// Type is "webhook" or "smtp"
+	Type    string         `json:"type" mapstructure:"type"`
+	Webhook *WebhookConfig `json:"webhook,omitempty" mapstructure:"webhook"`
+	SMTP    *SMTPConfig    `json:"smtp,omitempty" mapstructure:"smtp"`
+	// Repositories restricts the channel to these aliases; empty covers every repository
+	Repositories []string           `json:"repositories,omitempty" mapstructure:"repositories"`
+	Rules        []NotificationRule `json:"rules" mapstructure:"rules"`
+}
+
+// WebhookConfig describes an HTTP endpoint receiving notifications as POST requests
+type WebhookConfig struct {
+	URL     string            `json:"url" mapstructure:"url"`
+	Headers map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	// Body is a Go template rendered with the notification; empty sends the notification as JSON
+	Body        string `json:"body,omitempty" mapstructure:"body"`
+	ContentType string `json:"content_type,omitempty" mapstructure:"content_type"`
+	// SecretFile holds the key signing the body with HMAC-SHA256
+	SecretFile string `json:"secret_file,omitempty" mapstructure:"secret_file"`
+}
+
+// SMTPConfig describes a mail server and the message sent through it
+type SMTPConfig struct {
+	// Server is host:port; the port defaults to 25
+	Server       string   `json:"server" mapstructure:"server"`
+	Username     string   `json:"username,omitempty" mapstructure:"username"`
+	PasswordFile string   `json:"password_file,omitempty" mapstructure:"password_file"`
+	From         string   `json:"from" mapstructure:"from"`
+	To           []string `json:"to" mapstructure:"to"`
+	// Subject and Body are Go templates rendered with the notification; empty uses the defaults
+	Subject string `json:"subject,omitempty" mapstructure:"subject"`
+	Body    string `json:"body,omitempty" mapstructure:"body"`
+}
+
+// NotificationRule selects the notifications a channel receives
+type NotificationRule struct {
+	// Kind is "event" (send right away), "digest" (collect and send periodically) or "stale"
+	Kind string `json:"kind" mapstructure:"kind"`
+	// Events restricts event and digest rules to backup, check, verify, prune, health or job; empty covers all
+	Events []string `json:"events,omitempty" mapstructure:"events"`
+	// Outcomes restricts event and digest rules to success, warning or failure; empty covers all
+	Outcomes []string `json:"outcomes,omitempty" mapstructure:"outcomes"`
+	// Interval is how often a digest is sent, such as 24h or 7d; empty means daily
+	Interval string `json:"interval,omitempty" mapstructure:"interval"`
+	// MaxAge is the age of the newest backup at which a stale rule alerts, such as 26h or 2d
+	MaxAge string `json:"max_age,omitempty" mapstructure:"max_age"`
+	// Suffix restricts a stale rule to one suffix; empty checks every suffix
+	Suffix string `json:"suffix,omitempty" mapstructure:"suffix"`
+}
+
+// Job is a named backup definition run by 'zbwrap run'
+type Job struct {
+	Name   string    `json:"name" mapstructure:"name"`
+	Source JobSource `json:"source" mapstructure:"source"`
+	// Targets are the aliases backed up to, one after the other
+	Targets []string `json:"targets" mapstructure:"targets"`
+	// Suffix names the backups; empty uses the job name
+	Suffix string `json:"suffix,omitempty" mapstructure:"suffix"`
+	// Description is a Go template with .Job, .Alias, .Suffix, .Host and .Time
+	Description string            `json:"description,omitempty" mapstructure:"description"`
+	Tags        map[string]string `json:"tags,omitempty" mapstructure:"tags"`
+	// Hooks run in addition to the hooks of each target repository
+	Hooks     *Hooks        `json:"hooks,omitempty" mapstructure:"hooks"`
+	Retention *JobRetention `json:"retention,omitempty" mapstructure:"retention"`
+	// Timeout bounds the source and backup of each target, such as 2h; empty means none
+	Timeout string `json:"timeout,omitempty" mapstructure:"timeout"`
+	// Schedule is the cron expression 'zbwrap daemon' runs the job at; empty means manual only
+	Schedule string `json:"schedule,omitempty" mapstructure:"schedule"`
+	// Jitter delays each scheduled run by a random duration up to this long, such as 10m
+	Jitter string `json:"jitter,omitempty" mapstructure:"jitter"`
+	// CatchUp runs the job when the daemon starts if a scheduled run was missed
+	CatchUp bool `json:"catch_up,omitempty" mapstructure:"catch_up"`
+}
+
+// JobSource produces the stream of a job; exactly one field is set
+type JobSource struct {
+	// Command is run with sh -c and its standard output is backed up
+	Command string `json:"command,omitempty" mapstructure:"command"`
+	// Paths are archived by zbwrap as a tar stream
+	Paths []string `json:"paths,omitempty" mapstructure:"paths"`
+	// Stdin backs up the standard input of 'zbwrap run'
+	Stdin bool `json:"stdin,omitempty" mapstructure:"stdin"`
+}
+
+// JobRetention prunes the job's backups after a successful backup
+type JobRetention struct {
+	// KeepLast always keeps the newest backups of the job's suffix
+	KeepLast int `json:"keep_last,omitempty" mapstructure:"keep_last"`
+	// Select narrows the backups to prune, as 'prune --select' does
+	Select string `json:"select,omitempty" mapstructure:"select"`
+}
+
 // LocalRegistry represents the structure of registry.json and implements RepositoryManager
 type LocalRegistry struct {
-	ZBackupPath  string            `json:"zbackup_path" mapstructure:"zbackup_path"`
-	Repositories map[string]string `json:"repositories" mapstructure:"repositories"`
-	Encryption   EncryptionConfig  `json:"encryption" mapstructure:"encryption"`
-	LastUpdated  time.Time         `json:"last_updated" mapstructure:"last_updated"`
-	mu           sync.RWMutex
+	ZBackupPath   string                        `json:"zbackup_path" mapstructure:"zbackup_path"`
+	Repositories  map[string]string             `json:"repositories" mapstructure:"repositories"`
+	Settings      map[string]RepositorySettings `json:"settings,omitempty" mapstructure:"settings"`
+	Notifications []NotificationChannel         `json:"notifications,omitempty" mapstructure:"notifications"`
+	Jobs          []Job                         `json:"jobs,omitempty" mapstructure:"jobs"`
+	Encryption    EncryptionConfig              `json:"encryption" mapstructure:"encryption"`
+	LastUpdated   time.Time                     `json:"last_updated" mapstructure:"last_updated"`
+	mu            sync.RWMutex
 }
 
 // NewLocalRegistry creates a new registry instance
 func NewLocalRegistry() *LocalRegistry {
 	return &LocalRegistry{
 		Repositories: make(map[string]string),
+		Settings:     make(map[string]RepositorySettings),
 	}
 }
 
@@ -46,9 +195,83 @@ func (r *LocalRegistry) Load() error {
 		return err
 	}
 
-	return viper.Unmarshal(r, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+	if err := viper.Unmarshal(r, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
 		mapstructure.StringToTimeHookFunc(time.RFC3339),
-	)))
+	))); err != nil {
+		return err
+	}
+	return r.restoreKeyCase(viper.ConfigFileUsed())
+}
+
+// caseSensitiveKeys mirrors the maps of the registry file whose keys are data rather
+// than settings: backup suffixes, tag names and HTTP header names
+type caseSensitiveKeys struct {
+	Settings map[string]struct {
+		Health *struct {
+			MaxAge map[string]json.RawMessage `json:"max_age"`
+		} `json:"health"`
+	} `json:"settings"`
+	Notifications []struct {
+		Webhook *struct {
+			Headers map[string]json.RawMessage `json:"headers"`
+		} `json:"webhook"`
+	} `json:"notifications"`
+	Jobs []struct {
+		Tags map[string]json.RawMessage `json:"tags"`
+	} `json:"jobs"`
+}
+
+// restoreKeyCase gives the case-sensitive maps their keys as written in the config file.
+// viper lowercases every key it reads, which would turn a "Nightly" suffix, a "Team" tag
+// or an "X-Token" header into different ones.
+func (r *LocalRegistry) restoreKeyCase(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	var raw caseSensitiveKeys
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for alias, settings := range raw.Settings {
+		// Aliases are looked up lowercased, as viper has always read them
+		current, ok := r.Settings[strings.ToLower(alias)]
+		if !ok || settings.Health == nil || current.Health == nil {
+			continue
+		}
+		current.Health.MaxAge = restoreCase(current.Health.MaxAge, settings.Health.MaxAge)
+	}
+	for i, channel := range raw.Notifications {
+		if i < len(r.Notifications) && channel.Webhook != nil && r.Notifications[i].Webhook != nil {
+			r.Notifications[i].Webhook.Headers = restoreCase(r.Notifications[i].Webhook.Headers, channel.Webhook.Headers)
+		}
+	}
+	for i, job := range raw.Jobs {
+		if i < len(r.Jobs) {
+			r.Jobs[i].Tags = restoreCase(r.Jobs[i].Tags, job.Tags)
+		}
+	}
+	return nil
+}
+
+// restoreCase returns m with its lowercased keys spelled as in keys
+func restoreCase[V any](m map[string]V, keys map[string]json.RawMessage) map[string]V {
+	if len(m) == 0 {
+		return m
+	}
+	restored := make(map[string]V, len(m))
+	for key, value := range m {
+		restored[key] = value
+	}
+	for key := range keys {
+		lower := strings.ToLower(key)
+		if value, ok := m[lower]; ok && key != lower {
+			delete(restored, lower)
+			restored[key] = value
+		}
+	}
+	return restored
 }
 
 // Save writes the registry to config
@@ -59,26 +282,32 @@ func (r *LocalRegistry) Save() error {
 	r.LastUpdated = time.Now()
 	viper.Set("zbackup_path", r.ZBackupPath)
 	viper.Set("repositories", r.Repositories)
+	viper.Set("settings", r.Settings)
+	viper.Set("notifications", r.Notifications)
+	viper.Set("jobs", r.Jobs)
 	viper.Set("encryption", r.Encryption)
 	viper.Set("last_updated", r.LastUpdated)
 
 	// Ensure directory exists
-	configPath := viper.ConfigFileUsed()
-	if configPath == "" {
-		// Fallback if no config file found yet
-		home, _ := os.UserHomeDir()
-		configPath = filepath.Join(home, ".config", "zbwrap", "registry.json")
-	} else {
-		// viper.WriteConfig() works, but if we want to be safe about dir creation:
-		dir := filepath.Dir(configPath)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create config directory: %w", err)
-		}
+	configPath := r.ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
 	return viper.WriteConfigAs(configPath)
 }
 
+// ConfigPath returns the registry file, whether or not it exists yet. State kept
+// next to the registry, such as pending notifications, lives in the same directory.
+func (r *LocalRegistry) ConfigPath() string {
+	if configPath := viper.ConfigFileUsed(); configPath != "" {
+		return configPath
+	}
+	// Fallback if no config file found yet
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "zbwrap", "registry.json")
+}
+
 // Add adds a repository to the registry
 func (r *LocalRegistry) Add(alias, path string) error {
 	r.mu.Lock()
@@ -102,6 +331,16 @@ func (r *LocalRegistry) Add(alias, path string) error {
 	return nil
 }
 
+// PasswordFile returns the password file passed to zbackup, or "" for unencrypted repositories
+func (r *LocalRegistry) PasswordFile() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Encryption.Type == "password-file" {
+		return r.Encryption.CredentialsPath
+	}
+	return ""
+}
+
 // Get retrieves a repository path by alias
 func (r *LocalRegistry) Get(alias string) (string, bool) {
 	r.mu.RLock()
@@ -122,3 +361,37 @@ func (r *LocalRegistry) List() map[string]string {
 	}
 	return copy
 }
+
+// GetJob returns the job with the given name
+func (r *LocalRegistry) GetJob(name string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, job := range r.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// GetSettings returns the settings of a repository, or zero values if none are configured
+func (r *LocalRegistry) GetSettings(alias string) RepositorySettings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Settings[alias]
+}
+
+// SetSettings stores the settings of a registered repository
+func (r *LocalRegistry) SetSettings(alias string, settings RepositorySettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.Repositories[alias]; !exists {
+		return fmt.Errorf("alias '%s' not found", alias)
+	}
+	if r.Settings == nil {
+		r.Settings = make(map[string]RepositorySettings)
+	}
+	r.Settings[alias] = settings
+	return nil
+}
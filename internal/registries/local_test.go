@@ -79,6 +79,45 @@ func TestLocalRegistry_Save_Load(t *testing.T) {
 
 	err = registry.Add("my-repo", repoDir)
 	require.NoError(t, err)
+	health := &HealthExpectations{
+		MaxAge:     map[string]Threshold{"nightly": {Warning: "26h", Critical: "48h"}},
+		MinBackups: &Threshold{Critical: "3"},
+		MinFree:    &Threshold{Warning: "20%", Critical: "5GB"},
+	}
+	hooks := &Hooks{
+		PreBackup: []Hook{{Command: "/usr/local/bin/quiesce", Args: []string{"--flush"}, Timeout: "30s", Suffixes: []string{"db"}}},
+		PostGC:    []Hook{{Command: "/usr/local/bin/notify"}},
+	}
+	require.NoError(t, registry.SetSettings("my-repo", RepositorySettings{Health: health, Hooks: hooks}))
+	registry.Notifications = []NotificationChannel{
+		{
+			Name:    "ops",
+			Type:    "webhook",
+			Webhook: &WebhookConfig{URL: "https://hooks.example.com/zbwrap", Headers: map[string]string{"X-Team": "ops"}, SecretFile: "/etc/zbwrap/hmac"},
+			Rules:   []NotificationRule{{Kind: "event", Outcomes: []string{"failure"}}, {Kind: "stale", MaxAge: "26h", Suffix: "nightly"}},
+		},
+		{
+			Name:         "mail",
+			Type:         "smtp",
+			SMTP:         &SMTPConfig{Server: "mail.example.com:587", From: "zbwrap@example.com", To: []string{"ops@example.com"}},
+			Repositories: []string{"my-repo"},
+			Rules:        []NotificationRule{{Kind: "digest", Interval: "24h"}},
+		},
+	}
+
+	registry.Jobs = []Job{{
+		Name:        "pg",
+		Source:      JobSource{Command: "pg_dumpall"},
+		Targets:     []string{"my-repo"},
+		Description: "{{.Job}} on {{.Host}}",
+		Tags:        map[string]string{"db": "main"},
+		Hooks:       &Hooks{OnFailure: []Hook{{Command: "/usr/local/bin/page"}}},
+		Retention:   &JobRetention{KeepLast: 7, Select: "until:30d"},
+		Timeout:     "2h",
+		Schedule:    "30 1 * * *",
+		Jitter:      "10m",
+		CatchUp:     true,
+	}}
 
 	// Test: Save
 	err = registry.Save()
@@ -100,6 +139,54 @@ func TestLocalRegistry_Save_Load(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, repoDir, path)
 
+	assert.Equal(t, health, newRegistry.GetSettings("my-repo").Health)
+	assert.Equal(t, hooks, newRegistry.GetSettings("my-repo").Hooks)
+	assert.Equal(t, registry.Notifications, newRegistry.Notifications)
+	job, ok := newRegistry.GetJob("pg")
+	assert.True(t, ok)
+	assert.Equal(t, registry.Jobs[0], job)
+	assert.Equal(t, configFile, newRegistry.ConfigPath())
+
 	// Check LastUpdated is populated
 	assert.False(t, newRegistry.LastUpdated.IsZero())
 }
+
+func TestLocalRegistry_Save_Load_KeyCase(t *testing.T) {
+	viper.Reset()
+	configFile := filepath.Join(t.TempDir(), "registry.json")
+	viper.SetConfigFile(configFile)
+
+	registry := NewLocalRegistry()
+	require.NoError(t, registry.Add("my-repo", t.TempDir()))
+	health := &HealthExpectations{MaxAge: map[string]Threshold{
+		"Nightly": {Critical: "48h"},
+		"weekly":  {Critical: "8d"},
+	}}
+	require.NoError(t, registry.SetSettings("my-repo", RepositorySettings{Health: health}))
+	registry.Notifications = []NotificationChannel{{
+		Name:    "ops",
+		Type:    "webhook",
+		Webhook: &WebhookConfig{URL: "https://hooks.example.com/zbwrap", Headers: map[string]string{"X-Auth-Token": "secret", "accept": "*/*"}},
+		Rules:   []NotificationRule{{Kind: "event"}},
+	}}
+	registry.Jobs = []Job{{
+		Name:    "pg",
+		Source:  JobSource{Command: "pg_dumpall"},
+		Targets: []string{"my-repo"},
+		Tags:    map[string]string{"Env": "Prod", "app": "postgres"},
+	}}
+	require.NoError(t, registry.Save())
+
+	// Load as a new process would, without the values set while saving
+	viper.Reset()
+	viper.SetConfigFile(configFile)
+	loaded := NewLocalRegistry()
+	require.NoError(t, loaded.Load())
+
+	assert.Equal(t, health.MaxAge, loaded.GetSettings("my-repo").Health.MaxAge)
+	require.Len(t, loaded.Notifications, 1)
+	assert.Equal(t, registry.Notifications[0].Webhook.Headers, loaded.Notifications[0].Webhook.Headers)
+	job, ok := loaded.GetJob("pg")
+	require.True(t, ok)
+	assert.Equal(t, registry.Jobs[0].Tags, job.Tags)
+}
@@ -0,0 +1,159 @@
+// Package selectors implements the backup selector language shared by all commands.
+//
+// A selector is a whitespace-separated list of terms that must all match:
+//
+//	tag:env=prod suffix:nightly since:30d mime:application/x-tar
+//
+// Supported terms are tag:key=value (or tag:key to require the key), suffix:name,
+// name:glob, mime:type (prefix match), since:<when> and until:<when>, where <when>
+// is a YYYY-MM-DD date or a duration ago such as 12h, 30d or 8w. A leading "-"
+// negates a term.
+package selectors
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"zbwrap/internal/services"
+)
+
+// Selector is a parsed selector expression
+type Selector struct {
+	expr  string
+	terms []term
+}
+
+// term is a single, possibly negated, condition
+type term struct {
+	negate bool
+	match  func(item services.BackupItem) bool
+}
+
+// Parse parses a selector expression relative to the current time
+func Parse(expr string) (*Selector, error) {
+	return ParseAt(expr, time.Now())
+}
+
+// ParseAt parses a selector expression, resolving relative dates against now
+func ParseAt(expr string, now time.Time) (*Selector, error) {
+	s := &Selector{expr: strings.TrimSpace(expr)}
+	for _, word := range strings.Fields(expr) {
+		t, err := parseTerm(word, now)
+		if err != nil {
+			return nil, err
+		}
+		s.terms = append(s.terms, t)
+	}
+	return s, nil
+}
+
+// String returns the original expression
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Empty reports whether the selector has no terms and therefore matches everything
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.terms) == 0
+}
+
+// Match reports whether a backup satisfies every term
+func (s *Selector) Match(item services.BackupItem) bool {
+	if s == nil {
+		return true
+	}
+	for _, t := range s.terms {
+		if t.match(item) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the backups matching the selector, keeping their order
+func (s *Selector) Filter(items []services.BackupItem) []services.BackupItem {
+	matched := []services.BackupItem{}
+	for _, item := range items {
+		if s.Match(item) {
+			matched = append(matched, item)
+		}
+	}
+	return matched
+}
+
+// parseTerm parses a single key:value term
+func parseTerm(word string, now time.Time) (term, error) {
+	t := term{}
+	if strings.HasPrefix(word, "-") {
+		t.negate = true
+		word = word[1:]
+	}
+
+	key, value, ok := strings.Cut(word, ":")
+	if !ok || value == "" {
+		return t, fmt.Errorf("invalid selector term '%s' (expected key:value)", word)
+	}
+
+	switch key {
+	case "tag":
+		tagKey, tagValue, hasValue := strings.Cut(value, "=")
+		t.match = func(item services.BackupItem) bool {
+			v, ok := item.Tags[tagKey]
+			return ok && (!hasValue || v == tagValue)
+		}
+	case "suffix":
+		t.match = func(item services.BackupItem) bool {
+			return item.Suffix == value
+		}
+	case "name":
+		if _, err := path.Match(value, ""); err != nil {
+			return t, fmt.Errorf("invalid name pattern '%s': %w", value, err)
+		}
+		t.match = func(item services.BackupItem) bool {
+			matched, _ := path.Match(value, item.Filename)
+			return matched
+		}
+	case "mime":
+		t.match = func(item services.BackupItem) bool {
+			return strings.HasPrefix(item.MimeType, value)
+		}
+	case "since", "until":
+		at, err := ParseWhen(value, now)
+		if err != nil {
+			return t, fmt.Errorf("invalid %s value '%s': %w", key, value, err)
+		}
+		if key == "since" {
+			t.match = func(item services.BackupItem) bool {
+				return !item.Date.Before(at)
+			}
+		} else {
+			t.match = func(item services.BackupItem) bool {
+				return item.Date.Before(at)
+			}
+		}
+	default:
+		return t, fmt.Errorf("unknown selector key '%s'", key)
+	}
+	return t, nil
+}
+
+// ParseWhen parses a YYYY-MM-DD date or a duration ago (h, d or w units)
+func ParseWhen(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or a duration such as 30d")
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or a duration such as 30d")
+	}
+	return now.Add(-time.Duration(n) * unit), nil
+}
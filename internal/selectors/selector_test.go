@@ -0,0 +1,71 @@
+package selectors
+
+import (
+	"testing"
+	"time"
+
+	"zbwrap/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector_Match(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	items := []services.BackupItem{
+		{
+			Filename: "2024-06-29_0100-nightly.zbk",
+			Suffix:   "nightly",
+			Date:     time.Date(2024, 6, 29, 1, 0, 0, 0, time.UTC),
+			MimeType: "application/x-tar",
+			Tags:     map[string]string{"env": "prod", "host": "db1"},
+		},
+		{
+			Filename: "2024-05-01_0100-nightly.zbk",
+			Suffix:   "nightly",
+			Date:     time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC),
+			MimeType: "text/plain; charset=utf-8",
+			Tags:     map[string]string{"env": "staging"},
+		},
+		{
+			Filename: "2024-06-01_0000-monthly.zbk",
+			Suffix:   "monthly",
+			Date:     time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			MimeType: "application/x-tar",
+		},
+	}
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{"", []string{items[0].Filename, items[1].Filename, items[2].Filename}},
+		{"tag:env=prod suffix:nightly since:30d mime:application/x-tar", []string{items[0].Filename}},
+		{"tag:env", []string{items[0].Filename, items[1].Filename}},
+		{"-tag:env", []string{items[2].Filename}},
+		{"mime:text/plain", []string{items[1].Filename}},
+		{"since:2024-06-01 until:2024-06-29", []string{items[2].Filename}},
+		{"since:2w", []string{items[0].Filename}},
+		{"name:2024-06-*", []string{items[0].Filename, items[2].Filename}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseAt(tt.expr, now)
+			require.NoError(t, err)
+
+			var names []string
+			for _, item := range s.Filter(items) {
+				names = append(names, item.Filename)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, expr := range []string{"nightly", "color:red", "since:yesterday", "since:10y", "name:[", "tag:"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Annotation describes the metadata changes applied by Annotate
+type Annotation struct {
+	// Description replaces the description when set
+	Description *string
+	SetTags     map[string]string
+	RemoveTags  []string
+	Note        string
+	// Author is recorded in the edit history; defaults to user@host
+	Author string
+}
+
+// Empty reports whether the annotation would change nothing
+func (a Annotation) Empty() bool {
+	return a.Description == nil && len(a.SetTags) == 0 && len(a.RemoveTags) == 0 && a.Note == ""
+}
+
+// AnnotateResult is the outcome of annotating one backup
+type AnnotateResult struct {
+	Filename string `json:"filename"`
+	Changes  int    `json:"changes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ParseTag splits a key=value tag
+func ParseTag(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid tag '%s' (expected key=value)", s)
+	}
+	return key, value, nil
+}
+
+// CurrentAuthor identifies the person making an edit as user@host
+func CurrentAuthor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
+
+// Annotate applies the annotation to the sidecars of the named backups.
+// Each sidecar is rewritten atomically and every change is appended to its history.
+func (i *RepositoryInspector) Annotate(repoPath string, names []string, a Annotation) []AnnotateResult {
+	if a.Author == "" {
+		a.Author = CurrentAuthor()
+	}
+	now := time.Now().UTC()
+
+	results := make([]AnnotateResult, 0, len(names))
+	for _, name := range names {
+		if filepath.Ext(name) != ".zbk" {
+			name += ".zbk"
+		}
+		result := AnnotateResult{Filename: name}
+
+		changes, err := annotateBackup(filepath.Join(repoPath, "backups", name), a, now)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Changes = changes
+		results = append(results, result)
+	}
+	return results
+}
+
+// annotateBackup rewrites the sidecar of a single backup
+func annotateBackup(zbkPath string, a Annotation, now time.Time) (int, error) {
+	if _, err := os.Stat(zbkPath); err != nil {
+		return 0, fmt.Errorf("backup not found")
+	}
+
+	meta, metaPath, err := ReadSidecar(zbkPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return 0, err
+		}
+		// Backups without metadata get a skeleton, as sync would create
+		meta = &MetadataSidecar{MimeType: "unknown", Status: "complete"}
+	}
+
+	record := func(field, oldValue, newValue string) {
+		meta.History = append(meta.History, SidecarEdit{
+			Time:     now,
+			Author:   a.Author,
+			Field:    field,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+	before := len(meta.History)
+
+	if a.Description != nil && *a.Description != meta.Description {
+		record("description", meta.Description, *a.Description)
+		meta.Description = *a.Description
+	}
+
+	keys := make([]string, 0, len(a.SetTags))
+	for key := range a.SetTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := a.SetTags[key]
+		if old, ok := meta.Tags[key]; ok && old == value {
+			continue
+		}
+		if meta.Tags == nil {
+			meta.Tags = make(map[string]string)
+		}
+		record("tags."+key, meta.Tags[key], value)
+		meta.Tags[key] = value
+	}
+
+	for _, key := range a.RemoveTags {
+		old, ok := meta.Tags[key]
+		if !ok {
+			continue
+		}
+		record("tags."+key, old, "")
+		delete(meta.Tags, key)
+	}
+
+	if a.Note != "" {
+		meta.Notes = append(meta.Notes, SidecarNote{Time: now, Author: a.Author, Text: a.Note})
+		record("notes", "", a.Note)
+	}
+
+	changes := len(meta.History) - before
+	if changes == 0 {
+		return 0, nil
+	}
+	if err := WriteSidecar(metaPath, *meta); err != nil {
+		return 0, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return changes, nil
+}
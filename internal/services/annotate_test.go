@@ -0,0 +1,66 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_Annotate(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+
+	name := "2024-03-31_2300-monthly.zbk"
+	zbkPath := filepath.Join(backupsDir, name)
+	require.NoError(t, os.WriteFile(zbkPath, []byte("data"), 0644))
+	require.NoError(t, WriteSidecar(zbkPath+SidecarExt, MetadataSidecar{
+		MimeType:    "application/x-tar",
+		Description: "Mrach backup",
+		Status:      StatusSuccess,
+		Tags:        map[string]string{"env": "staging", "ticket": "OPS-1"},
+	}))
+
+	inspector := NewRepositoryInspector()
+	description := "March backup"
+	results := inspector.Annotate(repoDir, []string{"2024-03-31_2300-monthly", "missing.zbk"}, Annotation{
+		Description: &description,
+		SetTags:     map[string]string{"env": "prod"},
+		RemoveTags:  []string{"ticket"},
+		Note:        "verified restore",
+		Author:      "alice@host",
+	})
+
+	require.Len(t, results, 2)
+	assert.Equal(t, name, results[0].Filename)
+	assert.Equal(t, 4, results[0].Changes)
+	assert.Empty(t, results[0].Error)
+	assert.NotEmpty(t, results[1].Error)
+
+	meta, _, err := ReadSidecar(zbkPath)
+	require.NoError(t, err)
+	assert.Equal(t, "March backup", meta.Description)
+	assert.Equal(t, map[string]string{"env": "prod"}, meta.Tags)
+	require.Len(t, meta.Notes, 1)
+	assert.Equal(t, "verified restore", meta.Notes[0].Text)
+
+	require.Len(t, meta.History, 4)
+	assert.Equal(t, "description", meta.History[0].Field)
+	assert.Equal(t, "Mrach backup", meta.History[0].OldValue)
+	assert.Equal(t, "alice@host", meta.History[0].Author)
+	assert.Equal(t, "tags.env", meta.History[1].Field)
+	assert.Equal(t, "staging", meta.History[1].OldValue)
+	assert.Equal(t, "tags.ticket", meta.History[2].Field)
+	assert.Equal(t, "OPS-1", meta.History[2].OldValue)
+	assert.Equal(t, "notes", meta.History[3].Field)
+
+	// Re-applying the same values changes nothing and keeps the history as is
+	results = inspector.Annotate(repoDir, []string{name}, Annotation{Description: &description, Author: "bob@host"})
+	assert.Equal(t, 0, results[0].Changes)
+	meta, _, err = ReadSidecar(zbkPath)
+	require.NoError(t, err)
+	assert.Len(t, meta.History, 4)
+}
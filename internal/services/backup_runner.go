@@ -2,8 +2,10 @@ package services
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"os/exec"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"zbwrap/internal/registries"
+	"zbwrap/internal/zbackup"
 )
 
 // BackupRunner handles the execution of ZBackup processes
@@ -25,81 +28,410 @@ func NewBackupRunner(registry *registries.LocalRegistry) *BackupRunner {
 	}
 }
 
+// BackupOptions describes a single backup run
+type BackupOptions struct {
+	// Alias names the repository in hook events
+	Alias       string
+	Suffix      string
+	Description string
+	Tags        map[string]string
+	// CompressedInput is the policy applied to gzip, xz or zstd input (defaults to warn)
+	CompressedInput string
+	// Hooks are run before and after the backup, and when it fails
+	Hooks *registries.Hooks
+}
+
 // Backup performs a backup operation
 func (r *BackupRunner) Backup(repoPath, suffix, description string, reader io.Reader) error {
+	return r.BackupWithOptions(repoPath, BackupOptions{Suffix: suffix, Description: description}, reader)
+}
+
+// BackupWithOptions performs a backup operation using the given options.
+// A failure runs the on_failure hooks and is recorded in the repository for status reports.
+func (r *BackupRunner) BackupWithOptions(repoPath string, opts BackupOptions, reader io.Reader) error {
+	_, err := r.RunBackup(repoPath, opts, reader)
+	return err
+}
+
+// RunBackup is BackupWithOptions returning the name of the backup file, which is set
+// whether or not the backup succeeded
+func (r *BackupRunner) RunBackup(repoPath string, opts BackupOptions, reader io.Reader) (string, error) {
 	// 1. Generate filename
 	timestamp := time.Now().Format("2006-01-02_1504")
-	filename := fmt.Sprintf("%s-%s.zbk", timestamp, suffix)
+	filename := fmt.Sprintf("%s-%s.zbk", timestamp, opts.Suffix)
+	meta := &MetadataSidecar{
+		Description: opts.Description,
+		Status:      StatusInProgress,
+		Tags:        opts.Tags,
+	}
+	err := r.backup(repoPath, filename, opts, meta, reader)
+	if err == nil {
+		return filename, nil
+	}
+
+	event := backupEvent(HookOnFailure, repoPath, filename, opts, meta)
+	event.Error = err.Error()
+	results, hookErr := runHooks(opts.Hooks, event)
+	if hookErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", hookErr)
+	}
+	recordBackupFailure(repoPath, filename, err, append(meta.Hooks, results...))
+	return filename, err
+}
+
+// backupEvent describes a backup to its hooks
+func backupEvent(event, repoPath, filename string, opts BackupOptions, meta *MetadataSidecar) HookEvent {
+	return HookEvent{
+		Event:      event,
+		Alias:      opts.Alias,
+		Repository: repoPath,
+		Backup:     filename,
+		Suffix:     opts.Suffix,
+		Sidecar:    meta,
+	}
+}
+
+// backup stores the stream and its sidecar, filling in meta as it goes
+func (r *BackupRunner) backup(repoPath, filename string, opts BackupOptions, meta *MetadataSidecar, reader io.Reader) error {
 	backupsDir := filepath.Join(repoPath, "backups")
+	filePath := filepath.Join(backupsDir, filename)
+	metaPath := filePath + SidecarExt
 
 	// Ensure backups directory exists
 	if err := os.MkdirAll(backupsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create backups directory: %w", err)
 	}
 
-	filePath := filepath.Join(backupsDir, filename)
-	metaPath := filePath + ".meta"
+	// Backups with the same suffix started in the same minute get the same name. Creating
+	// the sidecar exclusively claims the name, so the later of two such backups fails
+	// without touching the files of the earlier one.
+	if err := CreateSidecar(metaPath, *meta); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("backup %s already exists", filename)
+		}
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	for _, path := range []string{filePath, filePath + SidecarJSONExt} {
+		if _, err := os.Stat(path); err == nil {
+			os.Remove(metaPath)
+			return fmt.Errorf("backup %s already exists", filename)
+		}
+	}
 
-	// 2. Sniff MIME type from the first 512 bytes
-	sniffBuf := make([]byte, 512)
-	n, err := io.ReadFull(reader, sniffBuf)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return fmt.Errorf("failed to read for MIME detection: %w", err)
+	// From here on the name is ours: a failed backup removes the files it created
+	cleanup := func() {
+		os.Remove(metaPath)
+		os.Remove(filePath)
 	}
-	sniffBuf = sniffBuf[:n]
-	mimeType := DetectMimeType(sniffBuf)
 
-	// Combine sniffBuf and the rest of reader
-	combinedReader := io.MultiReader(bytes.NewReader(sniffBuf), reader)
+	// A failing pre-backup hook aborts the backup before any input is read
+	results, err := runHooks(opts.Hooks, backupEvent(HookPreBackup, repoPath, filename, opts, meta))
+	meta.Hooks = append(meta.Hooks, results...)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("backup aborted: %w", err)
+	}
+
+	// 2. Sniff MIME type and compression from the first 512 bytes
+	input, err := prepareInput(reader, opts.CompressedInput)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	defer input.release()
+	mimeType := DetectMimeType(input.sniffBuf)
 
 	// 3. Prepare ZBackup command
-	args := []string{"backup", filePath}
+	args := append(r.encryptionArgs(), "backup", filePath)
 
-	// Add encryption flags if necessary
-	if r.registry.Encryption.Type == "password-file" && r.registry.Encryption.CredentialsPath != "" {
-		args = append([]string{"--password-file", r.registry.Encryption.CredentialsPath}, args...)
-	} else {
-		// Default to non-encrypted if not specified or explicitly set to "none"
-		args = append([]string{"--non-encrypted"}, args...)
+	// Measure the logical stream for deduplication statistics and later verification
+	stream := newDigestReader(input.reader)
+
+	cmd := exec.Command(r.zbackupPath(), args...)
+	cmd.Stdin = stream
+	cmd.Stderr = os.Stderr // Pipe stderr to our stderr for visibility
+
+	// 4. Update metadata sidecar (marked in progress, will be kept on success)
+	meta.MimeType = mimeType
+	meta.Compression = input.compression
+
+	if err := WriteSidecar(metaPath, *meta); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
-	// Determine zbackup binary path
-	zbackupPath := r.registry.ZBackupPath
-	if zbackupPath == "" {
-		zbackupPath = "zbackup"
+	// 5. Run ZBackup. A failing input leaves a truncated backup behind, which is removed
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return fmt.Errorf("zbackup failed: %w", err)
 	}
 
-	cmd := exec.Command(zbackupPath, args...)
-	cmd.Stdin = combinedReader
-	cmd.Stderr = os.Stderr // Pipe stderr to our stderr for visibility
+	if input.decompressor != nil {
+		if err := input.decompressor.Wait(); err != nil {
+			cleanup()
+			return fmt.Errorf("%s decompression failed: %w", input.compression.Codec, err)
+		}
+	}
 
-	// 4. Create metadata sidecar (temporarily, will be kept on success)
-	meta := MetadataSidecar{
-		MimeType:    mimeType,
-		Description: description,
-		Status:      "success",
+	meta.Status = StatusSuccess
+	meta.LogicalSize = stream.size
+	meta.SHA256 = hex.EncodeToString(stream.hash.Sum(nil))
+	meta.DurationSeconds = time.Since(started).Round(time.Microsecond).Seconds()
+	if err := WriteSidecar(metaPath, *meta); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
-	metaFile, err := os.Create(metaPath)
+	// 6. Post-backup hooks cannot fail a backup that is already stored
+	results, err = runHooks(opts.Hooks, backupEvent(HookPostBackup, repoPath, filename, opts, meta))
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if len(results) > 0 {
+		meta.Hooks = append(meta.Hooks, results...)
+		if err := WriteSidecar(metaPath, *meta); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// RestoreOptions tunes a restore
+type RestoreOptions struct {
+	// Recompress reapplies the codec of backups that were decompressed on ingest
+	Recompress bool
+	// Native rebuilds the stream in Go from the index and bundles instead of running zbackup
+	Native bool
+	// BundleCache bounds the decompressed bundles a native restore keeps in memory
+	BundleCache int
+}
+
+// Restore streams a backup to w. If recompress is set and the backup was
+// decompressed on ingest, the original codec is applied again.
+func (r *BackupRunner) Restore(repoPath, name string, recompress bool, w io.Writer) error {
+	return r.RestoreWithOptions(repoPath, name, RestoreOptions{Recompress: recompress}, w)
+}
+
+// RestoreWithOptions streams a backup to w
+func (r *BackupRunner) RestoreWithOptions(repoPath, name string, opts RestoreOptions, w io.Writer) error {
+	if filepath.Ext(name) != ".zbk" {
+		name += ".zbk"
+	}
+	filePath := filepath.Join(repoPath, "backups", name)
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("backup not found: %s", name)
 	}
 
-	if err := json.NewEncoder(metaFile).Encode(meta); err != nil {
-		metaFile.Close()
-		os.Remove(metaPath)
-		return fmt.Errorf("failed to write metadata: %w", err)
+	var compression *CompressionInfo
+	if opts.Recompress {
+		if meta, _, err := ReadSidecar(filePath); err == nil {
+			compression = meta.Compression
+		}
+		if compression == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s was not decompressed on ingest, restoring as stored\n", name)
+		}
 	}
-	metaFile.Close()
 
-	// 5. Run ZBackup
-	if err := cmd.Run(); err != nil {
-		// Cleanup metadata on failure
-		os.Remove(metaPath)
-		// Also cleanup the partial .zbk file if it exists?
-		// ZBackup might leave it. Spec says "Clean up .meta on zbackup failure."
-		return fmt.Errorf("zbackup failed: %w", err)
+	restore := r.zbackupRestore(filePath)
+	if opts.Native {
+		restore = r.nativeRestore(repoPath, name, opts.BundleCache)
+	}
+
+	if compression == nil {
+		return restore(w)
+	}
+
+	compressor, err := recompressCommand(compression)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	compressor.Stdin = pr
+	compressor.Stdout = recompressOutput(compression, w)
+	compressor.Stderr = os.Stderr
+	if err := compressor.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", compression.Codec, err)
 	}
 
+	restored := make(chan error, 1)
+	go func() {
+		err := restore(pw)
+		pw.CloseWithError(err)
+		restored <- err
+	}()
+	compressErr := compressor.Wait()
+	// Unblock the restore if the compressor stopped reading early
+	pr.Close()
+	if err := <-restored; err != nil {
+		return err
+	}
+	if compressErr != nil {
+		return fmt.Errorf("%s recompression failed: %w", compression.Codec, compressErr)
+	}
 	return nil
 }
+
+// GCResult is the outcome of a zbackup garbage collection
+type GCResult struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+	// Hooks records the post-gc hooks that ran
+	Hooks []HookResult `json:"hooks,omitempty"`
+}
+
+// GC runs zbackup gc to release the chunks no backup references any more, then the
+// post-gc hooks, which also run when gc fails
+func (r *BackupRunner) GC(alias, repoPath string, hooks *registries.Hooks) *GCResult {
+	args := append(r.encryptionArgs(), "gc", repoPath)
+	cmd := exec.Command(r.zbackupPath(), args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	result := &GCResult{}
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		result.Error = fmt.Sprintf("zbackup failed: %v", err)
+	}
+	result.DurationSeconds = time.Since(started).Round(time.Millisecond).Seconds()
+
+	event := HookEvent{Event: HookPostGC, Alias: alias, Repository: repoPath, Error: result.Error}
+	results, err := runHooks(hooks, event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	result.Hooks = results
+	return result
+}
+
+// zbackupRestore restores a backup by running zbackup
+func (r *BackupRunner) zbackupRestore(filePath string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		args := append(r.encryptionArgs(), "restore", filePath)
+		cmd := exec.Command(r.zbackupPath(), args...)
+		cmd.Stdout = w
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("zbackup failed: %w", err)
+		}
+		return nil
+	}
+}
+
+// nativeRestore restores a backup with the Go reader, verifying its SHA-256
+func (r *BackupRunner) nativeRestore(repoPath, name string, bundleCache int) func(io.Writer) error {
+	return func(w io.Writer) error {
+		repo, err := zbackup.OpenWithPasswordFile(repoPath, r.registry.PasswordFile())
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+		if bundleCache > 0 {
+			repo.SetBundleCacheSize(bundleCache)
+		}
+		return repo.Restore(name, w)
+	}
+}
+
+// encryptionArgs returns the zbackup flags matching the registry encryption settings
+func (r *BackupRunner) encryptionArgs() []string {
+	if r.registry.Encryption.Type == "password-file" && r.registry.Encryption.CredentialsPath != "" {
+		return []string{"--password-file", r.registry.Encryption.CredentialsPath}
+	}
+	// Default to non-encrypted if not specified or explicitly set to "none"
+	return []string{"--non-encrypted"}
+}
+
+// zbackupPath determines the zbackup binary path
+func (r *BackupRunner) zbackupPath() string {
+	if r.registry.ZBackupPath == "" {
+		return "zbackup"
+	}
+	return r.registry.ZBackupPath
+}
+
+// digestReader counts and hashes the bytes read through it
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.size += int64(n)
+	d.hash.Write(p[:n])
+	return n, err
+}
+
+// backupInput is the stream handed to zbackup once the compressed input policy is applied
+type backupInput struct {
+	reader io.Reader
+	// sniffBuf holds the first bytes of reader, after decompression
+	sniffBuf []byte
+	// compression is set when the input is decompressed on the way in
+	compression  *CompressionInfo
+	decompressor *exec.Cmd
+}
+
+// prepareInput sniffs the start of reader and applies the compressed input policy:
+// compressed input is rejected, decompressed, or stored as is with a warning
+func prepareInput(reader io.Reader, policy string) (*backupInput, error) {
+	sniffBuf, err := readSniffBuffer(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Combine sniffBuf and the rest of reader
+	input := &backupInput{reader: io.MultiReader(bytes.NewReader(sniffBuf), reader), sniffBuf: sniffBuf}
+
+	// Compressed input defeats deduplication, apply the configured policy
+	compression := DetectCompression(sniffBuf)
+	if compression == nil {
+		return input, nil
+	}
+	switch policy {
+	case CompressedInputReject:
+		return nil, fmt.Errorf("input is %s-compressed, which defeats deduplication (policy: reject)", compression.Codec)
+	case CompressedInputDecompress:
+		decompressor, err := decompressCommand(compression)
+		if err != nil {
+			return nil, err
+		}
+		decompressed, err := pipeThrough(decompressor, input.reader)
+		if err != nil {
+			return nil, err
+		}
+		input.decompressor = decompressor
+		input.compression = compression
+
+		if input.sniffBuf, err = readSniffBuffer(decompressed); err != nil {
+			input.release()
+			return nil, err
+		}
+		input.reader = io.MultiReader(bytes.NewReader(input.sniffBuf), decompressed)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: input is %s-compressed, which defeats deduplication. Use --decompress to store it uncompressed.\n", compression.Codec)
+	}
+	return input, nil
+}
+
+// release unblocks and reaps the decompressor if the consumer stopped reading early
+func (in *backupInput) release() {
+	if in.decompressor != nil {
+		_ = in.decompressor.Process.Kill()
+		_ = in.decompressor.Wait()
+	}
+}
+
+// readSniffBuffer reads up to 512 bytes for content detection
+func readSniffBuffer(reader io.Reader) ([]byte, error) {
+	sniffBuf := make([]byte, 512)
+	n, err := io.ReadFull(reader, sniffBuf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read for MIME detection: %w", err)
+	}
+	return sniffBuf[:n], nil
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// CatalogVersion is the catalog format written by this version of zbwrap.
+// Catalogs with another version are discarded and rebuilt.
+const CatalogVersion = 4
+
+// stateDir holds zbwrap's own state inside a repository, relative to the repository root.
+// It is not part of the repository data and is skipped when measuring disk usage.
+const stateDir = ".zbwrap"
+
+// catalogFile is the catalog location, relative to the repository root
+var catalogFile = filepath.Join(stateDir, "catalog.json")
+
+// catalogLargestFiles is how many of the largest files are remembered per directory
+const catalogLargestFiles = 10
+
+// catalogRacyWindow is how recent a modification time must be for it not to be trusted:
+// a change made within the same timestamp granularity would go unnoticed
+const catalogRacyWindow = 2 * time.Second
+
+// backupDatePattern matches the "YYYY-MM-DD_HHMM" part of a backup name
+var backupDatePattern = regexp.MustCompile(`(\d{4}-\d{2}-\d{2}_\d{4})`)
+
+// catalog caches the expensive parts of an inspection: the size of every directory
+// and the parsed sidecar of every backup. Entries are reused while the modification
+// time and size of what they were built from are unchanged.
+type catalog struct {
+	Version int                      `json:"version"`
+	Dirs    map[string]catalogDir    `json:"dirs"`
+	Backups map[string]catalogBackup `json:"backups"`
+}
+
+// catalogDir caches the files directly inside a directory. A directory's modification
+// time changes whenever an entry is added, removed or renamed, which is how zbackup
+// and zbwrap write every file.
+type catalogDir struct {
+	ModTime   int64    `json:"mtime"`
+	FileBytes int64    `json:"file_bytes"`
+	Files     int      `json:"files"`
+	Subdirs   []string `json:"subdirs,omitempty"`
+	// Largest are the biggest files of the directory, largest first
+	Largest []catalogFileSize `json:"largest,omitempty"`
+}
+
+// catalogFileSize is the size of a single file
+type catalogFileSize struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// catalogBackup caches a backup entry together with the stat data of its files
+type catalogBackup struct {
+	Size           int64      `json:"size"`
+	ModTime        int64      `json:"mtime"`
+	Sidecar        string     `json:"sidecar,omitempty"`
+	SidecarSize    int64      `json:"sidecar_size,omitempty"`
+	SidecarModTime int64      `json:"sidecar_mtime,omitempty"`
+	Item           BackupItem `json:"item"`
+}
+
+// unchanged reports whether the backup and sidecar files still have the recorded stat data
+func (b catalogBackup) unchanged(current catalogBackup) bool {
+	if current.ModTime == 0 || (current.Sidecar != "" && current.SidecarModTime == 0) {
+		return false
+	}
+	return b.Size == current.Size && b.ModTime == current.ModTime && b.Sidecar == current.Sidecar &&
+		b.SidecarSize == current.SidecarSize && b.SidecarModTime == current.SidecarModTime
+}
+
+func newCatalog() *catalog {
+	return &catalog{
+		Version: CatalogVersion,
+		Dirs:    make(map[string]catalogDir),
+		Backups: make(map[string]catalogBackup),
+	}
+}
+
+// loadCatalog reads the catalog of a repository, returning an empty one if it is
+// missing, unreadable or from another catalog version
+func loadCatalog(repoPath string) *catalog {
+	data, err := os.ReadFile(filepath.Join(repoPath, catalogFile))
+	if err != nil {
+		return newCatalog()
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil || c.Version != CatalogVersion {
+		return newCatalog()
+	}
+	if c.Dirs == nil {
+		c.Dirs = make(map[string]catalogDir)
+	}
+	if c.Backups == nil {
+		c.Backups = make(map[string]catalogBackup)
+	}
+	return &c
+}
+
+// save atomically replaces the catalog of a repository
+func (c *catalog) save(repoPath string) error {
+	path := filepath.Join(repoPath, catalogFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+// catalogScan builds a fresh catalog, reusing the entries of the previous one that are still valid
+type catalogScan struct {
+	repoPath string
+	old      *catalog
+	next     *catalog
+	now      time.Time
+}
+
+func newCatalogScan(repoPath string, old *catalog) *catalogScan {
+	return &catalogScan{repoPath: repoPath, old: old, next: newCatalog(), now: time.Now()}
+}
+
+// stamp returns the modification time to record, or 0 if it is too recent to be trusted
+func (s *catalogScan) stamp(t time.Time) int64 {
+	if s.now.Sub(t) < catalogRacyWindow {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// diskUsage returns the total size of the files below rel, skipping zbwrap's state directory
+func (s *catalogScan) diskUsage(rel string) (int64, error) {
+	info, err := os.Stat(filepath.Join(s.repoPath, rel))
+	if err != nil {
+		return 0, err
+	}
+
+	dir, ok := s.old.Dirs[rel]
+	if !ok || dir.ModTime == 0 || dir.ModTime != info.ModTime().UnixNano() {
+		if dir, err = s.readDir(rel); err != nil {
+			return 0, err
+		}
+		dir.ModTime = s.stamp(info.ModTime())
+	}
+	s.next.Dirs[rel] = dir
+
+	total := dir.FileBytes
+	for _, sub := range dir.Subdirs {
+		size, err := s.diskUsage(filepath.Join(rel, sub))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// readDir measures the files directly inside a directory
+func (s *catalogScan) readDir(rel string) (catalogDir, error) {
+	var dir catalogDir
+	entries, err := os.ReadDir(filepath.Join(s.repoPath, rel))
+	if err != nil {
+		return dir, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if rel == "." && entry.Name() == stateDir {
+				continue
+			}
+			dir.Subdirs = append(dir.Subdirs, entry.Name())
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return dir, err
+		}
+		dir.FileBytes += info.Size()
+		dir.Files++
+		dir.Largest = append(dir.Largest, catalogFileSize{Name: entry.Name(), Bytes: info.Size()})
+	}
+
+	sort.SliceStable(dir.Largest, func(i, j int) bool { return dir.Largest[i].Bytes > dir.Largest[j].Bytes })
+	if len(dir.Largest) > catalogLargestFiles {
+		dir.Largest = dir.Largest[:catalogLargestFiles]
+	}
+	return dir, nil
+}
+
+// backups lists the backups of the repository, reading only sidecars that changed
+func (s *catalogScan) backups() ([]BackupItem, error) {
+	items := []BackupItem{}
+
+	backupsDir := filepath.Join(s.repoPath, "backups")
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No backups directory, return empty backups list
+			return items, nil
+		}
+		return nil, err
+	}
+
+	present := make(map[string]os.DirEntry, len(entries))
+	for _, entry := range entries {
+		present[entry.Name()] = entry
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		// Basic filter for .zbk files, though spec implies specific naming schema
+		// But let's assume anything in backups/ might be relevant, forcing .zbk extension check is safer
+		if filepath.Ext(name) != ".zbk" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		current := catalogBackup{Size: info.Size(), ModTime: s.stamp(info.ModTime())}
+		for _, sidecar := range []string{name + SidecarJSONExt, name + SidecarExt} {
+			if e, ok := present[sidecar]; ok {
+				if sidecarInfo, err := e.Info(); err == nil {
+					current.Sidecar = sidecar
+					current.SidecarSize = sidecarInfo.Size()
+					current.SidecarModTime = s.stamp(sidecarInfo.ModTime())
+				}
+				break
+			}
+		}
+
+		if cached, ok := s.old.Backups[name]; ok && cached.unchanged(current) {
+			current.Item = cached.Item
+		} else {
+			current.Item = backupItem(backupsDir, name, info)
+		}
+		s.next.Backups[name] = current
+		items = append(items, current.Item)
+	}
+	return items, nil
+}
+
+// backupItem builds the entry of a single backup from its name and sidecar
+func backupItem(backupsDir, name string, info os.FileInfo) BackupItem {
+	item := BackupItem{
+		Filename:  name,
+		Suffix:    BackupSuffix(name),
+		MimeType:  "unknown", // default
+		SizeBytes: info.Size(),
+	}
+
+	// Parse date
+	// Example: 2024-05-10_0800-initial.zbk
+	matches := backupDatePattern.FindStringSubmatch(name)
+	if len(matches) > 1 {
+		// Layout: YYYY-MM-DD_HHMM
+		parsed, err := time.Parse("2006-01-02_1504", matches[1])
+		if err == nil {
+			item.Date = parsed
+		}
+	} else {
+		// Fallback to file mod time if naming convention isn't followed?
+		// Spec says naming is enforced, but "unknown" items might exist
+		item.Date = info.ModTime()
+	}
+
+	// Check for metadata sidecar
+	if meta, _, err := ReadSidecar(filepath.Join(backupsDir, name)); err == nil {
+		item.HasMetadata = true
+		item.MimeType = meta.MimeType
+		item.Description = meta.Description
+		item.Tags = meta.Tags
+		item.Status = meta.Status
+		item.LogicalSizeBytes = meta.LogicalSize
+		item.DurationSeconds = meta.DurationSeconds
+	}
+	return item
+}
+
+// finish stores the new catalog if anything changed. The catalog is only a cache,
+// so a repository that cannot be written to is inspected without one.
+func (s *catalogScan) finish() {
+	if reflect.DeepEqual(s.old, s.next) {
+		return
+	}
+	_ = s.next.save(s.repoPath)
+}
+
+// RebuildCatalog discards the catalog of a repository and rescans it from scratch
+func (i *RepositoryInspector) RebuildCatalog(repoPath string) error {
+	_, err := i.InspectWithOptions("", repoPath, InspectOptions{Refresh: true})
+	return err
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ageTree moves the modification time of everything below root into the past,
+// so that the catalog trusts it
+func ageTree(t *testing.T, root string, age time.Duration) {
+	when := time.Now().Add(-age)
+	err := filepath.Walk(root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, when, when)
+	})
+	require.NoError(t, err)
+}
+
+func TestInspect_Catalog(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	bundleDir := filepath.Join(repoDir, "bundles", "ab")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+	require.NoError(t, os.MkdirAll(bundleDir, 0755))
+
+	zbkPath := filepath.Join(backupsDir, "2024-01-01_1000-nightly.zbk")
+	require.NoError(t, os.WriteFile(zbkPath, []byte("0123456789"), 0644))
+	require.NoError(t, WriteSidecar(zbkPath+SidecarExt, MetadataSidecar{MimeType: "text/plain", Description: "original"}))
+	require.NoError(t, os.WriteFile(filepath.Join(bundleDir, "bundle1"), make([]byte, 100), 0644))
+	ageTree(t, repoDir, time.Hour)
+
+	inspector := NewRepositoryInspector()
+	first, err := inspector.Inspect("repo", repoDir)
+	require.NoError(t, err)
+	require.Len(t, first.Backups, 1)
+	assert.Equal(t, "original", first.Backups[0].Description)
+	assert.FileExists(t, filepath.Join(repoDir, catalogFile))
+
+	// Tamper with the cached entry: an unchanged sidecar must be served from the catalog
+	cat := loadCatalog(repoDir)
+	entry := cat.Backups["2024-01-01_1000-nightly.zbk"]
+	entry.Item.Description = "cached"
+	cat.Backups["2024-01-01_1000-nightly.zbk"] = entry
+	require.NoError(t, cat.save(repoDir))
+
+	cached, err := inspector.Inspect("repo", repoDir)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", cached.Backups[0].Description)
+	// The catalog itself is not counted
+	assert.Equal(t, first.TotalSizeBytes, cached.TotalSizeBytes)
+
+	refreshed, err := inspector.InspectWithOptions("repo", repoDir, InspectOptions{Refresh: true})
+	require.NoError(t, err)
+	assert.Equal(t, "original", refreshed.Backups[0].Description)
+
+	// A rewritten sidecar and a new bundle invalidate their entries
+	require.NoError(t, WriteSidecar(zbkPath+SidecarExt, MetadataSidecar{MimeType: "text/plain", Description: "modified"}))
+	require.NoError(t, os.WriteFile(filepath.Join(bundleDir, "bundle2"), make([]byte, 50), 0644))
+	ageTree(t, filepath.Join(repoDir, "backups"), 30*time.Minute)
+	ageTree(t, filepath.Join(repoDir, "bundles"), 30*time.Minute)
+
+	updated, err := inspector.Inspect("repo", repoDir)
+	require.NoError(t, err)
+	assert.Equal(t, "modified", updated.Backups[0].Description)
+	assert.Equal(t, first.TotalSizeBytes+50, updated.TotalSizeBytes)
+}
+
+func TestInspect_CatalogIgnoresRecentChanges(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+	zbkPath := filepath.Join(backupsDir, "2024-01-01_1000-nightly.zbk")
+	require.NoError(t, os.WriteFile(zbkPath, []byte("data"), 0644))
+
+	inspector := NewRepositoryInspector()
+	_, err := inspector.Inspect("repo", repoDir)
+	require.NoError(t, err)
+
+	// Files written moments ago are not trusted and are read again
+	data, err := os.ReadFile(filepath.Join(repoDir, catalogFile))
+	require.NoError(t, err)
+	var cat catalog
+	require.NoError(t, json.Unmarshal(data, &cat))
+	assert.Zero(t, cat.Backups["2024-01-01_1000-nightly.zbk"].ModTime)
+	assert.Zero(t, cat.Dirs["backups"].ModTime)
+}
+
+func TestSync_RebuildsCatalog(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(backupsDir, "2024-01-01_1000-nightly.zbk"), []byte("data"), 0644))
+
+	_, err := NewRepositoryInspector().SyncWithOptions("repo", repoDir, SyncOptions{Jobs: 1})
+	require.NoError(t, err)
+
+	cat := loadCatalog(repoDir)
+	require.Contains(t, cat.Backups, "2024-01-01_1000-nightly.zbk")
+	assert.True(t, cat.Backups["2024-01-01_1000-nightly.zbk"].Item.HasMetadata)
+}
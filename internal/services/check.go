@@ -0,0 +1,306 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"zbwrap/internal/zbackup"
+)
+
+// Classes of problems detected by Check
+const (
+	ProblemUnreadableIndex  = "unreadable_index"
+	ProblemUnreadableBackup = "unreadable_backup"
+	ProblemMissingChunk     = "missing_chunk"
+	ProblemUnreadableBundle = "unreadable_bundle"
+	ProblemBundleMismatch   = "bundle_mismatch"
+	ProblemCorruptChunk     = "corrupt_chunk"
+)
+
+// checkHistoryFile records past checks, one JSON summary per line, relative to the repository root
+var checkHistoryFile = filepath.Join(stateDir, "checks.jsonl")
+
+// Commands recorded in the check history
+const (
+	HistoryCheck  = "check"
+	HistoryVerify = "verify"
+)
+
+// checkHistoryLimit is how many checks the history keeps
+const checkHistoryLimit = 100
+
+// CheckOptions tunes a structural check
+type CheckOptions struct {
+	// ReadDataPercent is the share of bundles to decompress and verify, from 0 to 100
+	ReadDataPercent float64
+	// PasswordFile is needed for encrypted repositories
+	PasswordFile string
+	// Seed picks the sampled bundles; zero uses the current time
+	Seed int64
+}
+
+// CheckProblem describes a single integrity problem
+type CheckProblem struct {
+	Class  string `json:"class"`
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// CheckSummary is what the check history records about a run of check or verify
+type CheckSummary struct {
+	// Command is HistoryCheck or HistoryVerify. For verify, Backups counts the verified
+	// backups, BytesRead their restored bytes and ProblemCount those that failed.
+	Command         string    `json:"command"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Backups         int       `json:"backups"`
+	Bundles         int       `json:"bundles"`
+	IndexedChunks   int       `json:"indexed_chunks"`
+	// ReferencedChunks are the distinct chunks used by all backups
+	ReferencedChunks int     `json:"referenced_chunks"`
+	ReadDataPercent  float64 `json:"read_data_percent"`
+	BundlesRead      int     `json:"bundles_read"`
+	BytesRead        int64   `json:"bytes_read"`
+	ChunksVerified   int     `json:"chunks_verified"`
+	ProblemCount     int     `json:"problem_count"`
+	OK               bool    `json:"ok"`
+}
+
+// CheckReport is the outcome of a structural check
+type CheckReport struct {
+	Alias        string `json:"repository_alias"`
+	PhysicalPath string `json:"physical_path"`
+	CheckSummary
+	Problems []CheckProblem `json:"problems"`
+}
+
+// Check verifies the structure of a repository with the native format reader: every chunk
+// referenced by a backup must be indexed, and every indexed bundle must exist and list the
+// same chunks as the index. With ReadDataPercent set, a random sample of bundles is also
+// decompressed and every chunk checked against its hash. The summary is added to the
+// repository's check history.
+func (i *RepositoryInspector) Check(alias, repoPath string, opts CheckOptions) (*CheckReport, error) {
+	if opts.ReadDataPercent < 0 || opts.ReadDataPercent > 100 {
+		return nil, fmt.Errorf("read data percentage must be between 0 and 100")
+	}
+	repo, err := zbackup.OpenWithPasswordFile(repoPath, opts.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	report := &CheckReport{Alias: alias, PhysicalPath: repoPath, Problems: []CheckProblem{}}
+	report.Command = HistoryCheck
+	report.StartedAt = time.Now()
+	report.ReadDataPercent = opts.ReadDataPercent
+	c := &checker{repo: repo, report: report}
+
+	if bundles, ok := c.checkIndex(); ok {
+		c.checkBackups()
+		c.readData(bundles, opts)
+	}
+
+	report.DurationSeconds = time.Since(report.StartedAt).Seconds()
+	report.ProblemCount = len(report.Problems)
+	report.OK = report.ProblemCount == 0
+	if err := appendCheckHistory(repoPath, report.CheckSummary); err != nil {
+		return nil, fmt.Errorf("failed to record check history: %w", err)
+	}
+	return report, nil
+}
+
+// checker holds the state of a single check
+type checker struct {
+	repo   *zbackup.Repository
+	report *CheckReport
+}
+
+func (c *checker) add(class, path, detail string) {
+	c.report.Problems = append(c.report.Problems, CheckProblem{Class: class, Path: path, Detail: detail})
+}
+
+// rel returns a path relative to the repository root for problem reports
+func (c *checker) rel(path string) string {
+	if rel, err := filepath.Rel(c.repo.Path, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// checkIndex compares every indexed bundle with the chunk table of its file and returns
+// the bundles that can be read
+func (c *checker) checkIndex() ([]zbackup.IndexEntry, bool) {
+	entries, err := c.repo.IndexEntries()
+	if err != nil {
+		c.add(ProblemUnreadableIndex, "index", err.Error())
+		return nil, false
+	}
+	index, err := c.repo.Index()
+	if err != nil {
+		c.add(ProblemUnreadableIndex, "index", err.Error())
+		return nil, false
+	}
+	c.report.IndexedChunks = len(index)
+
+	var readable []zbackup.IndexEntry
+	for _, entry := range entries {
+		c.report.Bundles++
+		path := c.rel(zbackup.BundlePath(c.repo.Path, entry.BundleID))
+		info, err := os.Stat(zbackup.BundlePath(c.repo.Path, entry.BundleID))
+		if err != nil {
+			c.add(ProblemMissingBundle, path, fmt.Sprintf("indexed bundle with %d chunks is missing", len(entry.Chunks)))
+			continue
+		}
+		if info.Size() == 0 {
+			c.add(ProblemUnreadableBundle, path, "bundle file is empty")
+			continue
+		}
+
+		chunks, _, err := c.repo.BundleInfo(entry.BundleID)
+		if err != nil {
+			c.add(ProblemUnreadableBundle, path, err.Error())
+			continue
+		}
+		if detail := compareChunks(entry.Chunks, chunks); detail != "" {
+			c.add(ProblemBundleMismatch, path, detail)
+			continue
+		}
+		readable = append(readable, entry)
+	}
+	return readable, true
+}
+
+// compareChunks describes how the chunk table of a bundle differs from its index entry
+func compareChunks(indexed, stored []zbackup.ChunkRecord) string {
+	if len(indexed) != len(stored) {
+		return fmt.Sprintf("index lists %d chunks, bundle holds %d", len(indexed), len(stored))
+	}
+	for k := range indexed {
+		if indexed[k] != stored[k] {
+			return fmt.Sprintf("chunk %d is %s (%d bytes) in the index but %s (%d bytes) in the bundle",
+				k, indexed[k].ID, indexed[k].Size, stored[k].ID, stored[k].Size)
+		}
+	}
+	return ""
+}
+
+// checkBackups confirms that every chunk a backup references is indexed
+func (c *checker) checkBackups() {
+	index, _ := c.repo.Index()
+	names, err := c.repo.ListBackups()
+	if err != nil {
+		c.add(ProblemUnreadableBackup, "backups", err.Error())
+		return
+	}
+
+	referenced := make(map[zbackup.ChunkID]bool)
+	for _, name := range names {
+		c.report.Backups++
+		path := filepath.Join("backups", name)
+		refs, err := c.repo.ReadBackupRefs(name)
+		if err != nil {
+			c.add(ProblemUnreadableBackup, path, err.Error())
+			continue
+		}
+
+		var missing []zbackup.ChunkID
+		for _, chunks := range []map[zbackup.ChunkID]int{refs.MetaChunks, refs.Chunks} {
+			for id := range chunks {
+				referenced[id] = true
+				if _, ok := index[id]; !ok {
+					missing = append(missing, id)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			c.add(ProblemMissingChunk, path, fmt.Sprintf("%d referenced chunk(s) are not indexed, e.g. %s", len(missing), missing[0]))
+		}
+	}
+	c.report.ReferencedChunks = len(referenced)
+}
+
+// readData decompresses a random share of the readable bundles and verifies their chunks
+func (c *checker) readData(bundles []zbackup.IndexEntry, opts CheckOptions) {
+	if opts.ReadDataPercent <= 0 || len(bundles) == 0 {
+		return
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(bundles), func(a, b int) { bundles[a], bundles[b] = bundles[b], bundles[a] })
+
+	sample := int(math.Ceil(float64(len(bundles)) * opts.ReadDataPercent / 100))
+	for _, entry := range bundles[:sample] {
+		bundlePath := zbackup.BundlePath(c.repo.Path, entry.BundleID)
+		c.report.BundlesRead++
+		if info, err := os.Stat(bundlePath); err == nil {
+			c.report.BytesRead += info.Size()
+		}
+
+		bundle, err := c.repo.Bundle(entry.BundleID)
+		if err != nil {
+			c.add(ProblemUnreadableBundle, c.rel(bundlePath), err.Error())
+			continue
+		}
+		c.report.ChunksVerified += len(bundle.Chunks)
+		if corrupt := bundle.CorruptChunks(); len(corrupt) > 0 {
+			c.add(ProblemCorruptChunk, c.rel(bundlePath), fmt.Sprintf("%d chunk(s) do not match their hash, e.g. %s", len(corrupt), corrupt[0]))
+		}
+	}
+}
+
+// appendCheckHistory adds a summary to the check history, keeping the most recent entries
+func appendCheckHistory(repoPath string, summary CheckSummary) error {
+	history, err := CheckHistory(repoPath)
+	if err != nil {
+		return err
+	}
+	history = append(history, summary)
+	if len(history) > checkHistoryLimit {
+		history = history[len(history)-checkHistoryLimit:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range history {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(repoPath, checkHistoryFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// CheckHistory returns the recorded check summaries of a repository, oldest first.
+// Lines that cannot be parsed are skipped.
+func CheckHistory(repoPath string) ([]CheckSummary, error) {
+	f, err := os.Open(filepath.Join(repoPath, checkHistoryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []CheckSummary{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	history := []CheckSummary{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s CheckSummary
+		if err := json.Unmarshal(scanner.Bytes(), &s); err == nil {
+			history = append(history, s)
+		}
+	}
+	return history, scanner.Err()
+}
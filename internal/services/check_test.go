@@ -0,0 +1,141 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"zbwrap/internal/zbackup"
+)
+
+func TestRepositoryInspector_Check(t *testing.T) {
+	inspector := NewRepositoryInspector()
+
+	firstBundle := func(t *testing.T, repoDir string) string {
+		repo, err := zbackup.Open(repoDir)
+		require.NoError(t, err)
+		entries, err := repo.IndexEntries()
+		require.NoError(t, err)
+		require.NotEmpty(t, entries)
+		return zbackup.BundlePath(repoDir, entries[0].BundleID)
+	}
+
+	t.Run("intact repository with full data read", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		report, err := inspector.Check("repo", repoDir, CheckOptions{ReadDataPercent: 100, Seed: 1})
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+		assert.Empty(t, report.Problems)
+		assert.Equal(t, 4, report.Backups)
+		assert.Equal(t, report.Bundles, report.BundlesRead)
+		assert.Equal(t, report.IndexedChunks, report.ChunksVerified)
+		assert.Greater(t, report.ReferencedChunks, 0)
+		assert.Greater(t, report.BytesRead, int64(0))
+	})
+
+	t.Run("structural check reads no data", func(t *testing.T) {
+		report, err := inspector.Check("repo", copyFixtureRepo(t, "series"), CheckOptions{})
+		require.NoError(t, err)
+		assert.True(t, report.OK)
+		assert.Equal(t, 0, report.BundlesRead)
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		require.NoError(t, os.Remove(firstBundle(t, repoDir)))
+
+		report, err := inspector.Check("repo", repoDir, CheckOptions{ReadDataPercent: 100})
+		require.NoError(t, err)
+		assert.False(t, report.OK)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, ProblemMissingBundle, report.Problems[0].Class)
+		assert.Equal(t, report.Bundles-1, report.BundlesRead)
+	})
+
+	t.Run("damaged bundle payload is found by reading data", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		path := firstBundle(t, repoDir)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[len(data)-8] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		report, err := inspector.Check("repo", repoDir, CheckOptions{})
+		require.NoError(t, err)
+		assert.True(t, report.OK)
+
+		report, err = inspector.Check("repo", repoDir, CheckOptions{ReadDataPercent: 100})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Contains(t, []string{ProblemUnreadableBundle, ProblemCorruptChunk}, report.Problems[0].Class)
+	})
+
+	t.Run("unindexed chunks", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		indexFiles, err := zbackup.ListIndexFiles(repoDir)
+		require.NoError(t, err)
+		for _, path := range indexFiles {
+			require.NoError(t, os.Remove(path))
+		}
+
+		report, err := inspector.Check("repo", repoDir, CheckOptions{})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 4)
+		for _, p := range report.Problems {
+			assert.Equal(t, ProblemMissingChunk, p.Class)
+		}
+	})
+
+	t.Run("encrypted repository", func(t *testing.T) {
+		passwordFile := filepath.Join("..", "zbackup", "testdata", "encrypted.password")
+		report, err := inspector.Check("repo", copyFixtureRepo(t, "encrypted"), CheckOptions{ReadDataPercent: 50, PasswordFile: passwordFile})
+		require.NoError(t, err)
+		assert.True(t, report.OK)
+		assert.Equal(t, 2, report.Backups)
+		assert.Greater(t, report.BundlesRead, 0)
+	})
+
+	t.Run("rejects an invalid percentage", func(t *testing.T) {
+		_, err := inspector.Check("repo", copyFixtureRepo(t, "series"), CheckOptions{ReadDataPercent: 150})
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckHistory(t *testing.T) {
+	repoDir := copyFixtureRepo(t, "series")
+	inspector := NewRepositoryInspector()
+
+	history, err := CheckHistory(repoDir)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	_, err = inspector.Check("repo", repoDir, CheckOptions{})
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(repoDir, "backups", "2024-04-03_0200-weekly.zbk")))
+	_, err = inspector.Check("repo", repoDir, CheckOptions{ReadDataPercent: 10})
+	require.NoError(t, err)
+
+	history, err = CheckHistory(repoDir)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 4, history[0].Backups)
+	assert.Equal(t, 3, history[1].Backups)
+	assert.Equal(t, 10.0, history[1].ReadDataPercent)
+	assert.Equal(t, 1, history[1].BundlesRead)
+	assert.True(t, history[1].OK)
+
+	t.Run("keeps the most recent checks", func(t *testing.T) {
+		for k := 0; k < checkHistoryLimit; k++ {
+			require.NoError(t, appendCheckHistory(repoDir, CheckSummary{Backups: k}))
+		}
+		history, err := CheckHistory(repoDir)
+		require.NoError(t, err)
+		require.Len(t, history, checkHistoryLimit)
+		assert.Equal(t, checkHistoryLimit-1, history[len(history)-1].Backups)
+		assert.Equal(t, 0, history[0].Backups)
+	})
+}
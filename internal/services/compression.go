@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Policies for handling already-compressed input, which defeats zbackup deduplication
+const (
+	CompressedInputWarn       = "warn"
+	CompressedInputReject     = "reject"
+	CompressedInputDecompress = "decompress"
+)
+
+// Supported input codecs
+const (
+	CodecGzip = "gzip"
+	CodecXz   = "xz"
+	CodecZstd = "zstd"
+)
+
+// CompressionInfo records the codec and settings of an input stream that was
+// decompressed before being stored, so that restore can recompress it.
+type CompressionInfo struct {
+	Codec   string            `json:"codec"`
+	Level   int               `json:"level,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// ValidCompressedInputPolicy reports whether policy is a known compressed input policy
+func ValidCompressedInputPolicy(policy string) bool {
+	switch policy {
+	case CompressedInputWarn, CompressedInputReject, CompressedInputDecompress:
+		return true
+	}
+	return false
+}
+
+// DetectCompression inspects the magic bytes at the start of a stream.
+// It returns nil if the data does not look like gzip, xz or zstd output.
+func DetectCompression(data []byte) *CompressionInfo {
+	switch {
+	case len(data) >= 10 && data[0] == 0x1f && data[1] == 0x8b:
+		return parseGzipHeader(data)
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return parseXzHeader(data)
+	case len(data) >= 5 && bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return parseZstdHeader(data)
+	}
+	return nil
+}
+
+// parseGzipHeader extracts the level hint, mtime and original name (RFC 1952)
+func parseGzipHeader(data []byte) *CompressionInfo {
+	info := &CompressionInfo{Codec: CodecGzip, Level: 6, Options: map[string]string{}}
+
+	flags := data[3]
+	if mtime := binary.LittleEndian.Uint32(data[4:8]); mtime != 0 {
+		info.Options["mtime"] = time.Unix(int64(mtime), 0).UTC().Format(time.RFC3339)
+	}
+	// XFL: 2 means maximum compression, 4 the fastest algorithm
+	switch data[8] {
+	case 2:
+		info.Level = 9
+	case 4:
+		info.Level = 1
+	}
+
+	pos := 10
+	if flags&0x04 != 0 { // FEXTRA
+		if len(data) < pos+2 {
+			return info
+		}
+		pos += 2 + int(binary.LittleEndian.Uint16(data[pos:pos+2]))
+	}
+	if flags&0x08 != 0 && pos < len(data) { // FNAME
+		if end := bytes.IndexByte(data[pos:], 0); end >= 0 {
+			info.Options["name"] = string(data[pos : pos+end])
+		}
+	}
+	return info
+}
+
+// parseXzHeader extracts the integrity check type from the stream flags
+func parseXzHeader(data []byte) *CompressionInfo {
+	checks := map[byte]string{0x00: "none", 0x01: "crc32", 0x04: "crc64", 0x0a: "sha256"}
+	info := &CompressionInfo{Codec: CodecXz, Level: 6, Options: map[string]string{}}
+	if check, ok := checks[data[7]&0x0f]; ok {
+		info.Options["check"] = check
+	}
+	return info
+}
+
+// parseZstdHeader extracts the content checksum flag from the frame header
+func parseZstdHeader(data []byte) *CompressionInfo {
+	info := &CompressionInfo{Codec: CodecZstd, Level: 3, Options: map[string]string{}}
+	info.Options["checksum"] = strconv.FormatBool(data[4]&0x04 != 0)
+	return info
+}
+
+// decompressCommand returns the command that decompresses the given codec from stdin to stdout
+func decompressCommand(info *CompressionInfo) (*exec.Cmd, error) {
+	switch info.Codec {
+	case CodecGzip:
+		return exec.Command("gzip", "-dc"), nil
+	case CodecXz:
+		return exec.Command("xz", "-dc"), nil
+	case CodecZstd:
+		return exec.Command("zstd", "-dcq"), nil
+	}
+	return nil, fmt.Errorf("unsupported codec: %s", info.Codec)
+}
+
+// recompressCommand returns the command that reproduces an equivalent compressed stream.
+// For gzip, its output must go through recompressOutput to get the original header back.
+func recompressCommand(info *CompressionInfo) (*exec.Cmd, error) {
+	level := fmt.Sprintf("-%d", info.Level)
+	switch info.Codec {
+	case CodecGzip:
+		return exec.Command("gzip", "-c", "-n", level), nil
+	case CodecXz:
+		args := []string{"-c", level}
+		if check := info.Options["check"]; check != "" {
+			args = append(args, "--check="+check)
+		}
+		return exec.Command("xz", args...), nil
+	case CodecZstd:
+		args := []string{"-cq", level}
+		if info.Options["checksum"] == "false" {
+			args = append(args, "--no-check")
+		}
+		return exec.Command("zstd", args...), nil
+	}
+	return nil, fmt.Errorf("unsupported codec: %s", info.Codec)
+}
+
+// recompressOutput wraps the destination of a recompressed stream. gzip -n writes a
+// header without the original file name and modification time; both are put back so
+// that the stream matches what gzip made of the original file.
+func recompressOutput(info *CompressionInfo, w io.Writer) io.Writer {
+	if info.Codec != CodecGzip || (info.Options["name"] == "" && info.Options["mtime"] == "") {
+		return w
+	}
+	return &gzipHeaderWriter{w: w, info: info}
+}
+
+// gzipHeaderWriter rewrites the fixed 10-byte header at the start of a gzip stream
+type gzipHeaderWriter struct {
+	w      io.Writer
+	info   *CompressionInfo
+	header []byte
+	done   bool
+}
+
+func (g *gzipHeaderWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if !g.done {
+		need := 10 - len(g.header)
+		if len(p) < need {
+			g.header = append(g.header, p...)
+			return n, nil
+		}
+		g.header = append(g.header, p[:need]...)
+		p = p[need:]
+		g.done = true
+		if _, err := g.w.Write(restoreGzipHeader(g.header, g.info)); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > 0 {
+		if _, err := g.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// restoreGzipHeader sets the mtime and original name recorded on ingest in a header
+// written by gzip -n (RFC 1952)
+func restoreGzipHeader(header []byte, info *CompressionInfo) []byte {
+	out := append([]byte(nil), header...)
+	if out[0] != 0x1f || out[1] != 0x8b || out[3]&0x08 != 0 {
+		return out
+	}
+	if mtime, err := time.Parse(time.RFC3339, info.Options["mtime"]); err == nil {
+		binary.LittleEndian.PutUint32(out[4:8], uint32(mtime.Unix()))
+	}
+	if name := info.Options["name"]; name != "" {
+		out[3] |= 0x08 // FNAME
+		out = append(append(out, name...), 0)
+	}
+	return out
+}
+
+// pipeThrough starts cmd reading from r and returns its stdout
+func pipeThrough(cmd *exec.Cmd, r io.Reader) (io.ReadCloser, error) {
+	cmd.Stdin = r
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCompression(t *testing.T) {
+	// gzip with maximum compression and an original file name
+	buf := new(bytes.Buffer)
+	zw, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	require.NoError(t, err)
+	zw.Name = "data.tar"
+	zw.ModTime = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	_, err = zw.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	info := DetectCompression(buf.Bytes())
+	require.NotNil(t, info)
+	assert.Equal(t, CodecGzip, info.Codec)
+	assert.Equal(t, 9, info.Level)
+	assert.Equal(t, "data.tar", info.Options["name"])
+	assert.Equal(t, "2024-01-01T10:00:00Z", info.Options["mtime"])
+
+	// xz stream header with CRC64 check
+	xzHeader := []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x04, 0xe6, 0xd6, 0xb4, 0x46}
+	info = DetectCompression(xzHeader)
+	require.NotNil(t, info)
+	assert.Equal(t, CodecXz, info.Codec)
+	assert.Equal(t, "crc64", info.Options["check"])
+
+	// zstd frame header with content checksum
+	zstdHeader := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x24, 0x0b, 0x59, 0x00}
+	info = DetectCompression(zstdHeader)
+	require.NotNil(t, info)
+	assert.Equal(t, CodecZstd, info.Codec)
+	assert.Equal(t, "true", info.Options["checksum"])
+
+	// Plain data is not detected
+	assert.Nil(t, DetectCompression([]byte("Just some plain text content here.")))
+}
+
+func TestRecompressOutput_RestoresGzipHeader(t *testing.T) {
+	// A stream without name and mtime, as gzip -n writes it
+	compressed := new(bytes.Buffer)
+	zw := gzip.NewWriter(compressed)
+	_, err := zw.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	info := &CompressionInfo{Codec: CodecGzip, Level: 6, Options: map[string]string{
+		"name":  "data.tar",
+		"mtime": "2024-01-01T10:00:00Z",
+	}}
+	out := new(bytes.Buffer)
+	w := recompressOutput(info, out)
+	// Split the header across writes
+	for _, b := range compressed.Bytes() {
+		_, err := w.Write([]byte{b})
+		require.NoError(t, err)
+	}
+
+	zr, err := gzip.NewReader(out)
+	require.NoError(t, err)
+	assert.Equal(t, "data.tar", zr.Name)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), zr.ModTime.UTC())
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	// Without recorded fields the stream is passed through unchanged
+	assert.Equal(t, out, recompressOutput(&CompressionInfo{Codec: CodecGzip}, out))
+}
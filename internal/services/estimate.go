@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+	"io"
+
+	"zbwrap/internal/zbackup"
+)
+
+// EstimateReport is what storing a stream would add to a repository
+type EstimateReport struct {
+	PhysicalPath string `json:"physical_path"`
+	zbackup.Estimate
+	// DedupRatio is InputBytes / NewBytes, as in stats, or 0 when nothing is new
+	DedupRatio float64 `json:"dedup_ratio"`
+	// DuplicateShare is the part of the input already stored, between 0 and 1
+	DuplicateShare float64 `json:"duplicate_share"`
+	// Compression is set when the input was decompressed first, as backup --decompress does
+	Compression *CompressionInfo `json:"compression,omitempty"`
+}
+
+// Estimate reads a stream and reports how much of it a backup would add to the repository,
+// chunking it as zbackup does and looking the chunks up in the index. Nothing is written.
+// The compressed input policy of opts is applied as it would be for a backup.
+func (r *BackupRunner) Estimate(repoPath string, opts BackupOptions, reader io.Reader) (*EstimateReport, error) {
+	repo, err := zbackup.OpenWithPasswordFile(repoPath, r.registry.PasswordFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	input, err := prepareInput(reader, opts.CompressedInput)
+	if err != nil {
+		return nil, err
+	}
+	defer input.release()
+
+	estimate, err := repo.Estimate(input.reader)
+	if err != nil {
+		return nil, err
+	}
+	if input.decompressor != nil {
+		if err := input.decompressor.Wait(); err != nil {
+			return nil, fmt.Errorf("%s decompression failed: %w", input.compression.Codec, err)
+		}
+	}
+
+	return &EstimateReport{
+		PhysicalPath:   repoPath,
+		Estimate:       *estimate,
+		DedupRatio:     dedupRatio(int64(estimate.InputBytes), int64(estimate.NewBytes)),
+		DuplicateShare: estimate.DuplicateShare(),
+		Compression:    input.compression,
+	}, nil
+}
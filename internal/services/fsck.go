@@ -0,0 +1,355 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"zbwrap/internal/zbackup"
+)
+
+// Classes of problems detected by Fsck
+const (
+	ProblemMissingInfo      = "missing_info"
+	ProblemOrphanSidecar    = "orphan_sidecar"
+	ProblemCorruptSidecar   = "corrupt_sidecar"
+	ProblemIncompleteBackup = "incomplete_backup"
+	ProblemEmptyBackup      = "empty_backup"
+	ProblemBadName          = "bad_name"
+	ProblemTmpLeftover      = "tmp_leftover"
+	ProblemCorruptIndex     = "corrupt_index"
+	ProblemMissingBundle    = "missing_bundle"
+	ProblemUncheckedIndex   = "unchecked_index"
+)
+
+// Sidecar statuses
+const (
+	StatusInProgress = "in_progress"
+	StatusSuccess    = "success"
+	StatusFailed     = "failed"
+)
+
+// quarantineDir holds files moved aside by repairs, relative to the repository root
+const quarantineDir = ".zbwrap-quarantine"
+
+// staleAge is how old in-progress sidecars and tmp files must be before they are repaired,
+// so that a backup running concurrently is left alone
+const staleAge = 24 * time.Hour
+
+// backupNamePattern matches the enforced YYYY-MM-DD_HHMM-<suffix>.zbk naming schema
+var backupNamePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}_\d{4}-.+\.zbk$`)
+
+// FsckProblem describes a single inconsistency and, if repaired, what was done about it
+type FsckProblem struct {
+	Class  string `json:"class"`
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+	Repair string `json:"repair,omitempty"`
+}
+
+// FsckReport lists all inconsistencies found in a repository
+type FsckReport struct {
+	Alias        string        `json:"repository_alias"`
+	PhysicalPath string        `json:"physical_path"`
+	Repaired     bool          `json:"repaired"`
+	Problems     []FsckProblem `json:"problems"`
+}
+
+// FsckOptions tunes a consistency check
+type FsckOptions struct {
+	Repair bool
+	// PasswordFile decrypts the index of encrypted repositories; without it their
+	// index is not checked
+	PasswordFile string
+	// Log receives a line for every repair action taken
+	Log io.Writer
+}
+
+// Fsck checks a repository for orphaned, corrupt or incomplete sidecars and backups,
+// leftover temporary files and index entries pointing at missing bundles.
+// With Repair set, every problem class gets a safe, logged action; anything
+// removed is moved to a quarantine directory inside the repository. zbackup's
+// own files (info, index and bundles) are never modified.
+func (i *RepositoryInspector) Fsck(alias, repoPath string, opts FsckOptions) (*FsckReport, error) {
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	check := &fsckRun{
+		repoPath:   repoPath,
+		opts:       opts,
+		report:     &FsckReport{Alias: alias, PhysicalPath: repoPath, Repaired: opts.Repair, Problems: []FsckProblem{}},
+		quarantine: filepath.Join(repoPath, quarantineDir, time.Now().Format("2006-01-02_150405")),
+	}
+
+	if _, err := os.Stat(repoPath); err != nil {
+		return nil, fmt.Errorf("failed to access repository: %w", err)
+	}
+
+	if err := check.backups(); err != nil {
+		return nil, err
+	}
+	if err := check.tmp(); err != nil {
+		return nil, err
+	}
+	if err := check.index(); err != nil {
+		return nil, err
+	}
+	return check.report, nil
+}
+
+// fsckRun carries the state of a single Fsck invocation
+type fsckRun struct {
+	repoPath   string
+	opts       FsckOptions
+	report     *FsckReport
+	quarantine string
+}
+
+// add records a problem and, when repairing, runs its repair action
+func (c *fsckRun) add(class, path, detail string, repair func() (string, error)) {
+	problem := FsckProblem{Class: class, Path: c.rel(path), Detail: detail}
+	if c.opts.Repair && repair != nil {
+		action, err := repair()
+		if err != nil {
+			problem.Repair = fmt.Sprintf("failed: %v", err)
+		} else {
+			problem.Repair = action
+		}
+		fmt.Fprintf(c.opts.Log, "%s: %s: %s\n", class, problem.Path, problem.Repair)
+	}
+	c.report.Problems = append(c.report.Problems, problem)
+}
+
+// rel returns path relative to the repository root
+func (c *fsckRun) rel(path string) string {
+	if rel, err := filepath.Rel(c.repoPath, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// moveToQuarantine moves files out of the way, preserving their relative location
+func (c *fsckRun) moveToQuarantine(paths ...string) (string, error) {
+	var moved []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		target := filepath.Join(c.quarantine, c.rel(path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return "", err
+		}
+		moved = append(moved, c.rel(path))
+	}
+	return fmt.Sprintf("moved %s to %s", strings.Join(moved, ", "), c.rel(c.quarantine)), nil
+}
+
+// backups checks backup files and their sidecars
+func (c *fsckRun) backups() error {
+	backupsDir := filepath.Join(c.repoPath, "backups")
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read backups directory: %w", err)
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		present[entry.Name()] = true
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(backupsDir, name)
+
+		if backupName, ok := SidecarBackupName(name); ok {
+			if !present[backupName] {
+				c.add(ProblemOrphanSidecar, path, "sidecar without a backup file", func() (string, error) {
+					return c.moveToQuarantine(path)
+				})
+			}
+			continue
+		}
+		if entry.IsDir() || filepath.Ext(name) != ".zbk" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		metaPath := SidecarPath(path)
+
+		if info.Size() == 0 {
+			c.add(ProblemEmptyBackup, path, "backup file is empty", func() (string, error) {
+				return c.moveToQuarantine(path, metaPath)
+			})
+			continue
+		}
+
+		c.sidecar(path, metaPath)
+
+		if !backupNamePattern.MatchString(name) {
+			c.add(ProblemBadName, path, "name does not match YYYY-MM-DD_HHMM-<suffix>.zbk", func() (string, error) {
+				return c.rename(path, info.ModTime())
+			})
+		}
+	}
+	return nil
+}
+
+// sidecar checks that the sidecar of a backup parses and describes a finished backup
+func (c *fsckRun) sidecar(path, metaPath string) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return
+	}
+
+	var meta MetadataSidecar
+	if err := json.Unmarshal(data, &meta); err != nil {
+		c.add(ProblemCorruptSidecar, metaPath, fmt.Sprintf("invalid JSON: %v", err), func() (string, error) {
+			action, err := c.moveToQuarantine(metaPath)
+			if err != nil {
+				return "", err
+			}
+			skeleton := MetadataSidecar{MimeType: "unknown", Status: "complete"}
+			if err := WriteSidecar(metaPath, skeleton); err != nil {
+				return "", err
+			}
+			return action + " and regenerated a skeleton sidecar", nil
+		})
+		return
+	}
+
+	switch meta.Status {
+	case StatusFailed, StatusInProgress:
+		c.add(ProblemIncompleteBackup, path, fmt.Sprintf("sidecar status is %s", meta.Status), func() (string, error) {
+			if info, err := os.Stat(metaPath); err == nil && meta.Status == StatusInProgress && time.Since(info.ModTime()) < staleAge {
+				return "skipped: backup may still be running", nil
+			}
+			return c.moveToQuarantine(path, metaPath)
+		})
+	}
+}
+
+// rename moves a misnamed backup and its sidecar to a name following the schema,
+// using the file modification time as the backup date
+func (c *fsckRun) rename(path string, modTime time.Time) (string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "skipped: backup was already moved", nil
+	}
+	stem := strings.TrimSuffix(filepath.Base(path), ".zbk")
+	stem = regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(stem, "_")
+	target := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s-%s.zbk", modTime.Format("2006-01-02_1504"), stem))
+
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", c.rel(target))
+	}
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+	metaPath := SidecarPath(path)
+	if err := os.Rename(metaPath, target+strings.TrimPrefix(metaPath, path)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return fmt.Sprintf("renamed to %s", filepath.Base(target)), nil
+}
+
+// tmp checks for files left behind in zbackup's tmp/ directory
+func (c *fsckRun) tmp() error {
+	tmpDir := filepath.Join(c.repoPath, "tmp")
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read tmp directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(tmpDir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		c.add(ProblemTmpLeftover, path, "leftover temporary file", func() (string, error) {
+			if time.Since(info.ModTime()) < staleAge {
+				return "skipped: zbackup may still be running", nil
+			}
+			return c.moveToQuarantine(path)
+		})
+	}
+	return nil
+}
+
+// index checks that every bundle referenced from the index exists
+func (c *fsckRun) index() error {
+	indexDir := filepath.Join(c.repoPath, "index")
+	readIndexFile := zbackup.ReadIndexFile
+
+	info, err := zbackup.ReadStorageInfo(c.repoPath)
+	if err != nil {
+		c.add(ProblemMissingInfo, filepath.Join(c.repoPath, "info"), fmt.Sprintf("cannot read repository info: %v", err), func() (string, error) {
+			return "skipped: the info file cannot be reconstructed", nil
+		})
+	} else if info.Encrypted {
+		if c.opts.PasswordFile == "" {
+			c.add(ProblemUncheckedIndex, indexDir, "index not checked: repository is encrypted", func() (string, error) {
+				return "skipped: configure a password file (encryption.type: password-file) to check the index", nil
+			})
+			return nil
+		}
+		repo, err := zbackup.OpenWithPasswordFile(c.repoPath, c.opts.PasswordFile)
+		if err != nil {
+			c.add(ProblemUncheckedIndex, indexDir, fmt.Sprintf("index not checked: %v", err), func() (string, error) {
+				return "skipped: the index cannot be decrypted", nil
+			})
+			return nil
+		}
+		readIndexFile = repo.ReadIndexFile
+	}
+
+	paths, err := zbackup.ListIndexFiles(c.repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to read index directory: %w", err)
+	}
+
+	for _, path := range paths {
+		entries, err := readIndexFile(path)
+		if err != nil {
+			c.add(ProblemCorruptIndex, path, err.Error(), func() (string, error) {
+				return "skipped: run 'zbackup gc' to rebuild the index", nil
+			})
+			continue
+		}
+
+		var missing []string
+		for _, entry := range entries {
+			if _, err := os.Stat(zbackup.BundlePath(c.repoPath, entry.BundleID)); os.IsNotExist(err) {
+				missing = append(missing, entry.BundleID.String())
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		sort.Strings(missing)
+		c.add(ProblemMissingBundle, path, fmt.Sprintf("references %d missing bundle(s): %s", len(missing), strings.Join(missing, ", ")), func() (string, error) {
+			// The index belongs to zbackup; rewriting it here could lose entries of bundles
+			// that are only temporarily unavailable
+			return "skipped: restore the missing bundles, or run 'zbackup gc' to rebuild the index", nil
+		})
+	}
+	return nil
+}
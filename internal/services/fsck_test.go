@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zbwrap/internal/zbackup"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_Fsck(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "tmp"), 0755))
+
+	write := func(name, content string) string {
+		path := filepath.Join(repoDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	healthy := write("backups/2024-01-01_1000-ok.zbk", "data")
+	write("backups/2024-01-01_1000-ok.zbk.meta", `{"mime_type":"application/x-tar","status":"success"}`)
+	write("backups/2024-01-02_1000-gone.zbk.meta", `{"mime_type":"application/x-tar"}`)
+	corrupt := write("backups/2024-01-03_1000-corrupt.zbk", "data")
+	write("backups/2024-01-03_1000-corrupt.zbk.meta", `{"mime_type":`)
+	failed := write("backups/2024-01-04_1000-failed.zbk", "data")
+	write("backups/2024-01-04_1000-failed.zbk.meta", `{"status":"failed"}`)
+	empty := write("backups/2024-01-05_1000-empty.zbk", "")
+	misnamed := write("backups/nightly.zbk", "data")
+	leftover := write("tmp/abc123", "partial")
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(leftover, old, old))
+	require.NoError(t, os.Chtimes(misnamed, old, old))
+
+	inspector := NewRepositoryInspector()
+
+	report, err := inspector.Fsck("test-alias", repoDir, FsckOptions{})
+	require.NoError(t, err)
+
+	classes := map[string]int{}
+	for _, p := range report.Problems {
+		classes[p.Class]++
+		assert.Empty(t, p.Repair)
+	}
+	assert.Equal(t, map[string]int{
+		ProblemOrphanSidecar:    1,
+		ProblemCorruptSidecar:   1,
+		ProblemIncompleteBackup: 1,
+		ProblemEmptyBackup:      1,
+		ProblemBadName:          1,
+		ProblemTmpLeftover:      1,
+		ProblemMissingInfo:      1,
+	}, classes)
+	assert.FileExists(t, empty)
+
+	// Repair every problem and log the actions
+	log := new(bytes.Buffer)
+	report, err = inspector.Fsck("test-alias", repoDir, FsckOptions{Repair: true, Log: log})
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	for _, p := range report.Problems {
+		assert.NotEmpty(t, p.Repair)
+		assert.NotContains(t, p.Repair, "failed:")
+	}
+	assert.Contains(t, log.String(), ProblemTmpLeftover)
+
+	assert.FileExists(t, healthy)
+	assert.NoFileExists(t, empty)
+	assert.NoFileExists(t, failed)
+	assert.NoFileExists(t, misnamed)
+	assert.NoFileExists(t, leftover)
+	assert.FileExists(t, filepath.Join(backupsDir, old.Format("2006-01-02_1504")+"-nightly.zbk"))
+	assert.FileExists(t, corrupt+".meta")
+
+	// Only the missing info file remains after repair
+	report, err = inspector.Fsck("test-alias", repoDir, FsckOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, ProblemMissingInfo, report.Problems[0].Class)
+}
+
+// copyFixtureRepo copies a fixture repository of the zbackup package to a temporary directory
+func copyFixtureRepo(t *testing.T, name string) string {
+	src := filepath.Join("..", "zbackup", "testdata", name)
+	dst := t.TempDir()
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	require.NoError(t, err)
+	return dst
+}
+
+func TestRepositoryInspector_Fsck_MissingBundle(t *testing.T) {
+	repoDir := copyFixtureRepo(t, "basic")
+	inspector := NewRepositoryInspector()
+
+	report, err := inspector.Fsck("repo", repoDir, FsckOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+
+	paths, err := zbackup.ListIndexFiles(repoDir)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	entries, err := zbackup.ReadIndexFile(paths[0])
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	require.NoError(t, os.Remove(zbackup.BundlePath(repoDir, entries[0].BundleID)))
+	index, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+
+	report, err = inspector.Fsck("repo", repoDir, FsckOptions{Repair: true})
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, ProblemMissingBundle, report.Problems[0].Class)
+	assert.Contains(t, report.Problems[0].Detail, entries[0].BundleID.String())
+	assert.Contains(t, report.Problems[0].Repair, "skipped:")
+
+	// zbackup's index is left alone
+	repaired, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	assert.Equal(t, index, repaired)
+}
+
+func TestRepositoryInspector_Fsck_Encrypted(t *testing.T) {
+	inspector := NewRepositoryInspector()
+	passwordFile := filepath.Join("..", "zbackup", "testdata", "encrypted.password")
+
+	t.Run("without a password file", func(t *testing.T) {
+		report, err := inspector.Fsck("repo", copyFixtureRepo(t, "encrypted"), FsckOptions{})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, ProblemUncheckedIndex, report.Problems[0].Class)
+		assert.Equal(t, "index not checked: repository is encrypted", report.Problems[0].Detail)
+	})
+
+	t.Run("with the password file", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "encrypted")
+		report, err := inspector.Fsck("repo", repoDir, FsckOptions{PasswordFile: passwordFile})
+		require.NoError(t, err)
+		assert.Empty(t, report.Problems)
+
+		bundles, err := filepath.Glob(filepath.Join(repoDir, "bundles", "*", "*"))
+		require.NoError(t, err)
+		require.NotEmpty(t, bundles)
+		require.NoError(t, os.Remove(bundles[0]))
+
+		report, err = inspector.Fsck("repo", repoDir, FsckOptions{PasswordFile: passwordFile})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, ProblemMissingBundle, report.Problems[0].Class)
+	})
+
+	t.Run("with a wrong password", func(t *testing.T) {
+		wrong := filepath.Join(t.TempDir(), "wrong")
+		require.NoError(t, os.WriteFile(wrong, []byte("not the password\n"), 0600))
+		report, err := inspector.Fsck("repo", copyFixtureRepo(t, "encrypted"), FsckOptions{PasswordFile: wrong})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, ProblemUncheckedIndex, report.Problems[0].Class)
+	})
+}
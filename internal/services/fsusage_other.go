@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package services
+
+// filesystemUsage is not available on this platform
+func filesystemUsage(path string) *FilesystemUsage {
+	return nil
+}
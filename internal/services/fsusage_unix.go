@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package services
+
+import "syscall"
+
+// filesystemUsage returns the capacity and inode usage of the filesystem holding path
+func filesystemUsage(path string) *FilesystemUsage {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil
+	}
+	bsize := uint64(st.Bsize)
+	return &FilesystemUsage{
+		TotalBytes:     uint64(st.Blocks) * bsize,
+		FreeBytes:      uint64(st.Bfree) * bsize,
+		AvailableBytes: uint64(st.Bavail) * bsize,
+		TotalInodes:    uint64(st.Files),
+		FreeInodes:     uint64(st.Ffree),
+	}
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zbwrap/internal/registries"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Health states, named and ordered like the states of monitoring plugins
+const (
+	HealthOK       = "OK"
+	HealthWarning  = "WARNING"
+	HealthCritical = "CRITICAL"
+	HealthUnknown  = "UNKNOWN"
+)
+
+// healthSeverity orders states from best to worst; UNKNOWN ranks below CRITICAL as in
+// Nagios, which reports the worst service state
+var healthSeverity = map[string]int{HealthOK: 0, HealthWarning: 1, HealthUnknown: 2, HealthCritical: 3}
+
+// HealthExitCode returns the plugin exit code of a state: 0, 1, 2 or 3 for UNKNOWN
+func HealthExitCode(state string) int {
+	switch state {
+	case HealthOK:
+		return 0
+	case HealthWarning:
+		return 1
+	case HealthCritical:
+		return 2
+	}
+	return 3
+}
+
+// PerfData is a single performance data value in plugin notation:
+// 'label'=value[unit];warn;crit;min;max
+type PerfData struct {
+	Label    string  `json:"label"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Warning  string  `json:"warning,omitempty"`
+	Critical string  `json:"critical,omitempty"`
+	Min      string  `json:"min,omitempty"`
+	Max      string  `json:"max,omitempty"`
+}
+
+// String formats the value for the plugin output, dropping empty trailing fields
+func (p PerfData) String() string {
+	s := fmt.Sprintf("'%s'=%s%s;%s;%s;%s;%s", p.Label, strconv.FormatFloat(p.Value, 'f', -1, 64), p.Unit,
+		p.Warning, p.Critical, p.Min, p.Max)
+	return strings.TrimRight(s, ";")
+}
+
+// HealthCheck is the outcome of evaluating one expectation
+type HealthCheck struct {
+	Alias   string     `json:"alias"`
+	Name    string     `json:"name"`
+	State   string     `json:"state"`
+	Message string     `json:"message"`
+	Perf    []PerfData `json:"perfdata,omitempty"`
+}
+
+// HealthReport is the outcome of check-health
+type HealthReport struct {
+	State    string        `json:"state"`
+	OK       int           `json:"ok"`
+	Warning  int           `json:"warning"`
+	Critical int           `json:"critical"`
+	Unknown  int           `json:"unknown"`
+	Checks   []HealthCheck `json:"checks"`
+}
+
+// CheckHealth evaluates the expectations of each repository, keyed by alias. Every repository
+// is checked for reachability; the other checks come from its expectations, which may be nil.
+func (i *RepositoryInspector) CheckHealth(repos map[string]string, expectations map[string]*registries.HealthExpectations, opts StatusOptions) *HealthReport {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	report := &HealthReport{State: HealthOK, Checks: []HealthCheck{}}
+	for _, status := range i.Status(repos, opts) {
+		for _, check := range evaluateHealth(status, expectations[status.Alias], opts.Now) {
+			report.add(check)
+		}
+	}
+	return report
+}
+
+func (r *HealthReport) add(check HealthCheck) {
+	r.Checks = append(r.Checks, check)
+	switch check.State {
+	case HealthOK:
+		r.OK++
+	case HealthWarning:
+		r.Warning++
+	case HealthCritical:
+		r.Critical++
+	default:
+		r.Unknown++
+	}
+	if healthSeverity[check.State] > healthSeverity[r.State] {
+		r.State = check.State
+	}
+}
+
+// evaluateHealth runs the checks of one repository
+func evaluateHealth(status RepoStatus, exp *registries.HealthExpectations, now time.Time) []HealthCheck {
+	reachable := HealthCheck{Alias: status.Alias, Name: "reachable", State: HealthOK, Message: "repository is online"}
+	if status.State != RepoOnline {
+		reachable.State = HealthCritical
+		reachable.Message = "repository is offline: " + status.Error
+		return []HealthCheck{reachable}
+	}
+	checks := []HealthCheck{reachable}
+	if exp == nil {
+		return checks
+	}
+
+	e := healthEval{status: status}
+	suffixes := make([]string, 0, len(exp.MaxAge))
+	for suffix := range exp.MaxAge {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		checks = append(checks, e.maxAge(suffix, exp.MaxAge[suffix], now))
+	}
+	if exp.MinBackups != nil {
+		checks = append(checks, e.minBackups(*exp.MinBackups))
+	}
+	if exp.MaxSize != nil {
+		checks = append(checks, e.maxSize(*exp.MaxSize))
+	}
+	if exp.MinFree != nil {
+		checks = append(checks, e.minFree(*exp.MinFree))
+	}
+	if exp.MaxCheckAge != nil {
+		checks = append(checks, e.maxCheckAge(*exp.MaxCheckAge, now))
+	}
+	return checks
+}
+
+// healthEval evaluates the expectations of one online repository
+type healthEval struct {
+	status RepoStatus
+}
+
+func (e healthEval) check(name string) HealthCheck {
+	return HealthCheck{Alias: e.status.Alias, Name: name}
+}
+
+func (e healthEval) label(name string) string {
+	return e.status.Alias + "_" + name
+}
+
+// maxAge checks the newest backup of a suffix, or of any suffix for "*"
+func (e healthEval) maxAge(suffix string, t registries.Threshold, now time.Time) HealthCheck {
+	c := e.check("max_age[" + suffix + "]")
+	warn, crit, err := parseThreshold(t, parseAge)
+	if err != nil {
+		return unknown(c, err)
+	}
+
+	var newest time.Time
+	for s, b := range e.status.latest {
+		if (suffix == "*" || s == suffix) && b.Date.After(newest) {
+			newest = b.Date
+		}
+	}
+	what := "newest " + suffix + " backup"
+	if suffix == "*" {
+		what = "newest backup"
+	}
+	if newest.IsZero() {
+		c.State = HealthCritical
+		c.Message = "no " + strings.TrimPrefix(what, "newest ")
+		return c
+	}
+
+	age := now.Sub(newest)
+	c.State = above(age.Seconds(), warn, crit)
+	c.Message = fmt.Sprintf("%s is %s old%s", what, FormatAge(age), limits(c.State, t, "above"))
+	label := "age"
+	if suffix != "*" {
+		label += "_" + suffix
+	}
+	c.Perf = []PerfData{{Label: e.label(label), Value: float64(int64(age.Seconds())), Unit: "s",
+		Warning: perfLimit(warn, ""), Critical: perfLimit(crit, ""), Min: "0"}}
+	return c
+}
+
+func (e healthEval) minBackups(t registries.Threshold) HealthCheck {
+	c := e.check("min_backups")
+	warn, crit, err := parseThreshold(t, parseCount)
+	if err != nil {
+		return unknown(c, err)
+	}
+	n := float64(e.status.Backups)
+	c.State = below(n, warn, crit)
+	c.Message = fmt.Sprintf("%d backup(s)%s", e.status.Backups, limits(c.State, t, "below"))
+	c.Perf = []PerfData{{Label: e.label("backups"), Value: n, Warning: perfLimit(warn, ":"), Critical: perfLimit(crit, ":"), Min: "0"}}
+	return c
+}
+
+func (e healthEval) maxSize(t registries.Threshold) HealthCheck {
+	c := e.check("max_size")
+	warn, crit, err := parseThreshold(t, parseSize)
+	if err != nil {
+		return unknown(c, err)
+	}
+	size := float64(e.status.SizeBytes)
+	c.State = above(size, warn, crit)
+	c.Message = fmt.Sprintf("repository uses %s%s", humanize.Bytes(uint64(e.status.SizeBytes)), limits(c.State, t, "above"))
+	c.Perf = []PerfData{{Label: e.label("size"), Value: size, Unit: "B", Warning: perfLimit(warn, ""), Critical: perfLimit(crit, ""), Min: "0"}}
+	return c
+}
+
+// minFree checks the available space, with limits in bytes or as a percentage of the filesystem
+func (e healthEval) minFree(t registries.Threshold) HealthCheck {
+	c := e.check("min_free")
+	fs := e.status.Filesystem
+	if fs == nil {
+		c.State = HealthUnknown
+		c.Message = "free space is not available on this platform"
+		return c
+	}
+	toBytes := func(s string) (float64, error) {
+		if pct, ok := strings.CutSuffix(s, "%"); ok {
+			p, err := strconv.ParseFloat(pct, 64)
+			if err != nil || p < 0 || p > 100 {
+				return 0, fmt.Errorf("invalid percentage %q", s)
+			}
+			return math.Round(float64(fs.TotalBytes) * p / 100), nil
+		}
+		return parseSize(s)
+	}
+	warn, crit, err := parseThreshold(t, toBytes)
+	if err != nil {
+		return unknown(c, err)
+	}
+	free := float64(fs.AvailableBytes)
+	c.State = below(free, warn, crit)
+	c.Message = fmt.Sprintf("%s available%s", humanize.Bytes(fs.AvailableBytes), limits(c.State, t, "below"))
+	c.Perf = []PerfData{{Label: e.label("free"), Value: free, Unit: "B", Warning: perfLimit(warn, ":"), Critical: perfLimit(crit, ":"),
+		Min: "0", Max: strconv.FormatUint(fs.TotalBytes, 10)}}
+	return c
+}
+
+// maxCheckAge requires a recent check that found no problems
+func (e healthEval) maxCheckAge(t registries.Threshold, now time.Time) HealthCheck {
+	c := e.check("max_check_age")
+	warn, crit, err := parseThreshold(t, parseAge)
+	if err != nil {
+		return unknown(c, err)
+	}
+	last := e.status.LastCheck
+	if last == nil {
+		c.State = HealthCritical
+		c.Message = "repository was never checked"
+		return c
+	}
+	age := now.Sub(last.StartedAt)
+	c.Perf = []PerfData{{Label: e.label("check_age"), Value: float64(int64(age.Seconds())), Unit: "s",
+		Warning: perfLimit(warn, ""), Critical: perfLimit(crit, ""), Min: "0"}}
+	if !last.OK {
+		c.State = HealthCritical
+		c.Message = fmt.Sprintf("last check %s ago found %d problem(s)", FormatAge(age), last.ProblemCount)
+		return c
+	}
+	c.State = above(age.Seconds(), warn, crit)
+	c.Message = fmt.Sprintf("last check passed %s ago%s", FormatAge(age), limits(c.State, t, "above"))
+	return c
+}
+
+func unknown(c HealthCheck, err error) HealthCheck {
+	c.State = HealthUnknown
+	c.Message = "invalid expectation: " + err.Error()
+	return c
+}
+
+// parseThreshold parses both limits with parse; an empty limit is nil
+func parseThreshold(t registries.Threshold, parse func(string) (float64, error)) (warn, crit *float64, err error) {
+	if t.Warning == "" && t.Critical == "" {
+		return nil, nil, fmt.Errorf("neither warning nor critical is set")
+	}
+	for _, l := range []struct {
+		value string
+		out   **float64
+	}{{t.Warning, &warn}, {t.Critical, &crit}} {
+		if l.value == "" {
+			continue
+		}
+		v, err := parse(l.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		*l.out = &v
+	}
+	return warn, crit, nil
+}
+
+// above rates a value that must not exceed its limits
+func above(v float64, warn, crit *float64) string {
+	switch {
+	case crit != nil && v > *crit:
+		return HealthCritical
+	case warn != nil && v > *warn:
+		return HealthWarning
+	}
+	return HealthOK
+}
+
+// below rates a value that must not fall short of its limits
+func below(v float64, warn, crit *float64) string {
+	switch {
+	case crit != nil && v < *crit:
+		return HealthCritical
+	case warn != nil && v < *warn:
+		return HealthWarning
+	}
+	return HealthOK
+}
+
+// limits describes the limit that was crossed, or the closest one when none was
+func limits(state string, t registries.Threshold, direction string) string {
+	switch {
+	case state == HealthCritical:
+		return fmt.Sprintf(" (critical %s %s)", direction, t.Critical)
+	case state == HealthWarning:
+		return fmt.Sprintf(" (warning %s %s)", direction, t.Warning)
+	case t.Warning != "":
+		return fmt.Sprintf(" (warning %s %s)", direction, t.Warning)
+	}
+	return fmt.Sprintf(" (critical %s %s)", direction, t.Critical)
+}
+
+// perfLimit formats a limit in plugin range notation; minimums use a trailing colon,
+// meaning the value is expected to be at least the limit
+func perfLimit(v *float64, suffix string) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64) + suffix
+}
+
+// parseAge parses a duration in seconds: Go syntax such as 36h, or a number of days or weeks such as 8d or 2w
+func parseAge(s string) (float64, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return v * unit.Seconds(), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, expected e.g. 36h, 8d or 2w", s)
+	}
+	return d.Seconds(), nil
+}
+
+func parseCount(s string) (float64, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid count %q", s)
+	}
+	return float64(n), nil
+}
+
+func parseSize(s string) (float64, error) {
+	n, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return float64(n), nil
+}
+
+// FormatAge renders an age in the largest whole unit: minutes, hours or days
+func FormatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_CheckHealth(t *testing.T) {
+	series := copyFixtureRepo(t, "series")
+	// The weekly backup is 48h old and the newest nightly 49h
+	now := time.Date(2024, 4, 5, 2, 0, 0, 0, time.UTC)
+	inspector := NewRepositoryInspector()
+
+	checkByName := func(report *HealthReport) map[string]HealthCheck {
+		checks := make(map[string]HealthCheck)
+		for _, c := range report.Checks {
+			checks[c.Alias+"/"+c.Name] = c
+		}
+		return checks
+	}
+
+	t.Run("reachability only without expectations", func(t *testing.T) {
+		report := inspector.CheckHealth(map[string]string{"series": series}, nil, StatusOptions{Now: now})
+		assert.Equal(t, HealthOK, report.State)
+		require.Len(t, report.Checks, 1)
+		assert.Equal(t, "reachable", report.Checks[0].Name)
+		assert.Equal(t, 0, HealthExitCode(report.State))
+	})
+
+	t.Run("offline repository is critical", func(t *testing.T) {
+		report := inspector.CheckHealth(map[string]string{"gone": filepath.Join(t.TempDir(), "gone")}, nil, StatusOptions{Now: now})
+		assert.Equal(t, HealthCritical, report.State)
+		assert.Equal(t, 1, report.Critical)
+		assert.Equal(t, 2, HealthExitCode(report.State))
+	})
+
+	t.Run("thresholds", func(t *testing.T) {
+		expectations := map[string]*registries.HealthExpectations{"series": {
+			MaxAge: map[string]registries.Threshold{
+				"weekly":  {Warning: "8d", Critical: "15d"},
+				"nightly": {Warning: "26h", Critical: "2d"},
+				"*":       {Warning: "47h"},
+				"monthly": {Critical: "32d"},
+			},
+			MinBackups:  &registries.Threshold{Warning: "5", Critical: "2"},
+			MaxSize:     &registries.Threshold{Critical: "1 KB"},
+			MinFree:     &registries.Threshold{Critical: "0%"},
+			MaxCheckAge: &registries.Threshold{Critical: "8d"},
+		}}
+		report := inspector.CheckHealth(map[string]string{"series": series}, expectations, StatusOptions{Now: now})
+		checks := checkByName(report)
+
+		assert.Equal(t, HealthOK, checks["series/max_age[weekly]"].State)
+		assert.Equal(t, HealthCritical, checks["series/max_age[nightly]"].State)
+		assert.Equal(t, "newest nightly backup is 2d old (critical above 2d)", checks["series/max_age[nightly]"].Message)
+		assert.Equal(t, "'series_age_nightly'=176400s;93600;172800;0", checks["series/max_age[nightly]"].Perf[0].String())
+		assert.Equal(t, HealthWarning, checks["series/max_age[*]"].State)
+		assert.Equal(t, HealthCritical, checks["series/max_age[monthly]"].State)
+		assert.Equal(t, "no monthly backup", checks["series/max_age[monthly]"].Message)
+
+		assert.Equal(t, HealthWarning, checks["series/min_backups"].State)
+		assert.Equal(t, "'series_backups'=4;5:;2:;0", checks["series/min_backups"].Perf[0].String())
+		assert.Equal(t, HealthCritical, checks["series/max_size"].State)
+		assert.Equal(t, HealthOK, checks["series/min_free"].State)
+		assert.Equal(t, HealthCritical, checks["series/max_check_age"].State)
+		assert.Equal(t, "repository was never checked", checks["series/max_check_age"].Message)
+
+		assert.Equal(t, HealthCritical, report.State)
+		assert.Equal(t, len(report.Checks), report.OK+report.Warning+report.Critical+report.Unknown)
+	})
+
+	t.Run("recent failed check", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		require.NoError(t, appendCheckHistory(repoDir, CheckSummary{StartedAt: now.Add(-time.Hour), ProblemCount: 2}))
+		expectations := map[string]*registries.HealthExpectations{"series": {MaxCheckAge: &registries.Threshold{Critical: "8d"}}}
+
+		report := inspector.CheckHealth(map[string]string{"series": repoDir}, expectations, StatusOptions{Now: now})
+		c := checkByName(report)["series/max_check_age"]
+		assert.Equal(t, HealthCritical, c.State)
+		assert.Equal(t, "last check 1h ago found 2 problem(s)", c.Message)
+
+		require.NoError(t, appendCheckHistory(repoDir, CheckSummary{StartedAt: now.Add(-time.Hour), OK: true}))
+		report = inspector.CheckHealth(map[string]string{"series": repoDir}, expectations, StatusOptions{Now: now})
+		assert.Equal(t, HealthOK, report.State)
+	})
+
+	t.Run("invalid expectation is unknown", func(t *testing.T) {
+		expectations := map[string]*registries.HealthExpectations{"series": {MinBackups: &registries.Threshold{Warning: "many"}}}
+		report := inspector.CheckHealth(map[string]string{"series": series}, expectations, StatusOptions{Now: now})
+		assert.Equal(t, HealthUnknown, report.State)
+		assert.Equal(t, 3, HealthExitCode(report.State))
+	})
+}
+
+func TestParseAge(t *testing.T) {
+	for input, want := range map[string]float64{"36h": 36 * 3600, "8d": 8 * 86400, "2w": 14 * 86400, "1.5d": 1.5 * 86400, "90m": 5400} {
+		got, err := parseAge(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+	for _, input := range []string{"", "d", "-1d", "soon"} {
+		_, err := parseAge(input)
+		assert.Error(t, err, input)
+	}
+}
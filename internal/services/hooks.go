@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"zbwrap/internal/registries"
+)
+
+// Hook events
+const (
+	HookPreBackup  = "pre_backup"
+	HookPostBackup = "post_backup"
+	HookOnFailure  = "on_failure"
+	HookPrePrune   = "pre_prune"
+	HookPostGC     = "post_gc"
+)
+
+// DefaultHookTimeout bounds a hook without a timeout of its own
+const DefaultHookTimeout = 5 * time.Minute
+
+// hookOutputLimit is how much of the end of a hook's output is recorded
+const hookOutputLimit = 4096
+
+// HookEvent is the JSON document a hook receives on stdin
+type HookEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Alias      string    `json:"alias,omitempty"`
+	Repository string    `json:"repository"`
+	// Backup, Suffix and Sidecar describe the backup of a backup event
+	Backup  string           `json:"backup,omitempty"`
+	Suffix  string           `json:"suffix,omitempty"`
+	Sidecar *MetadataSidecar `json:"sidecar,omitempty"`
+	// Backups lists the backups a prune is about to delete
+	Backups []string `json:"backups,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// HookResult records one hook run
+type HookResult struct {
+	Event           string    `json:"event"`
+	Command         string    `json:"command"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// ExitCode is -1 if the hook could not be started or was killed
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// Output is the end of what the hook wrote to stdout and stderr
+	Output string `json:"output,omitempty"`
+}
+
+// hooksFor returns the hooks configured for an event
+func hooksFor(hooks *registries.Hooks, event string) []registries.Hook {
+	if hooks == nil {
+		return nil
+	}
+	switch event {
+	case HookPreBackup:
+		return hooks.PreBackup
+	case HookPostBackup:
+		return hooks.PostBackup
+	case HookOnFailure:
+		return hooks.OnFailure
+	case HookPrePrune:
+		return hooks.PrePrune
+	case HookPostGC:
+		return hooks.PostGC
+	}
+	return nil
+}
+
+// runHooks runs the hooks of an event in order. The first failing pre-hook stops the
+// others and its error is returned so that the operation can be aborted; the other
+// hooks all run and their failures are returned together.
+func runHooks(hooks *registries.Hooks, event HookEvent) ([]HookResult, error) {
+	configured := hooksFor(hooks, event.Event)
+	if len(configured) == 0 {
+		return nil, nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	pre := event.Event == HookPreBackup || event.Event == HookPrePrune
+	var results []HookResult
+	var errs []error
+	for _, hook := range configured {
+		if !hookMatches(hook, event) {
+			continue
+		}
+		result := runHook(hook, event.Event, payload)
+		results = append(results, result)
+		if result.Error != "" {
+			errs = append(errs, fmt.Errorf("%s hook %s failed: %s", event.Event, hook.Command, result.Error))
+			if pre {
+				break
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// hookMatches applies the suffix filter of a hook to backup events
+func hookMatches(hook registries.Hook, event HookEvent) bool {
+	if len(hook.Suffixes) == 0 || event.Suffix == "" {
+		return true
+	}
+	for _, suffix := range hook.Suffixes {
+		if suffix == event.Suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// runHook runs a single hook with the event on stdin. Its output is passed through to
+// stderr, keeping stdout free for the command's own output.
+func runHook(hook registries.Hook, event string, payload []byte) HookResult {
+	result := HookResult{Event: event, Command: hook.Command, StartedAt: time.Now(), ExitCode: -1}
+
+	timeout := DefaultHookTimeout
+	if hook.Timeout != "" {
+		parsed, err := time.ParseDuration(hook.Timeout)
+		if err != nil || parsed <= 0 {
+			result.Error = fmt.Sprintf("invalid timeout %q", hook.Timeout)
+			return result
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output := &tailBuffer{limit: hookOutputLimit}
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = io.MultiWriter(os.Stderr, output)
+	cmd.Stderr = cmd.Stdout
+	// Do not wait forever for children that inherited the output pipes
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	result.DurationSeconds = time.Since(result.StartedAt).Round(time.Millisecond).Seconds()
+	result.Output = string(output.data)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err != nil && ctx.Err() == context.DeadlineExceeded:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		result.Error = err.Error()
+	case err != nil:
+		result.Error = err.Error()
+	default:
+		result.ExitCode = 0
+	}
+	return result
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeHook creates an executable shell script hook
+func writeHook(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestRunHooks(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload.json")
+	capture := writeHook(t, dir, "capture", "cat > "+payload+"\necho captured\n")
+	fail := writeHook(t, dir, "fail", "echo quiesce failed >&2\nexit 3\n")
+	slow := writeHook(t, dir, "slow", "sleep 5\n")
+	never := writeHook(t, dir, "never", "touch "+filepath.Join(dir, "ran")+"\n")
+
+	hooks := &registries.Hooks{
+		PreBackup:  []registries.Hook{{Command: capture}, {Command: fail}, {Command: never}},
+		PostBackup: []registries.Hook{{Command: fail}, {Command: capture}},
+		OnFailure:  []registries.Hook{{Command: slow, Timeout: "100ms"}, {Command: capture, Timeout: "soon"}},
+		PostGC:     []registries.Hook{{Command: never, Suffixes: []string{"weekly"}}},
+	}
+	event := HookEvent{Event: HookPreBackup, Alias: "db", Repository: "/srv/repo", Backup: "2024-04-01_0100-nightly.zbk",
+		Suffix: "nightly", Sidecar: &MetadataSidecar{Description: "nightly dump"}}
+
+	// The first failing pre-hook stops the others
+	results, err := runHooks(hooks, event)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pre_backup hook "+fail+" failed")
+	require.Len(t, results, 2)
+	assert.Equal(t, 0, results[0].ExitCode)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "captured\n", results[0].Output)
+	assert.Equal(t, 3, results[1].ExitCode)
+	assert.Equal(t, "quiesce failed\n", results[1].Output)
+	assert.NoFileExists(t, filepath.Join(dir, "ran"))
+
+	data, err := os.ReadFile(payload)
+	require.NoError(t, err)
+	var received HookEvent
+	require.NoError(t, json.Unmarshal(data, &received))
+	assert.Equal(t, HookPreBackup, received.Event)
+	assert.Equal(t, "db", received.Alias)
+	assert.Equal(t, "2024-04-01_0100-nightly.zbk", received.Backup)
+	assert.Equal(t, "nightly dump", received.Sidecar.Description)
+	assert.False(t, received.Time.IsZero())
+
+	// Post-hooks all run, whatever the outcome of the others
+	event.Event = HookPostBackup
+	results, err = runHooks(hooks, event)
+	require.Error(t, err)
+	require.Len(t, results, 2)
+	assert.Empty(t, results[1].Error)
+
+	event.Event = HookOnFailure
+	results, err = runHooks(hooks, event)
+	require.Error(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "timed out after 100ms", results[0].Error)
+	assert.Equal(t, -1, results[0].ExitCode)
+	assert.Less(t, results[0].DurationSeconds, 5.0)
+	assert.Equal(t, `invalid timeout "soon"`, results[1].Error)
+
+	// Suffix filters only apply to backup events
+	event.Event = HookPostGC
+	results, err = runHooks(hooks, event)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	results, err = runHooks(hooks, HookEvent{Event: HookPostGC, Repository: "/srv/repo"})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.FileExists(t, filepath.Join(dir, "ran"))
+
+	results, err = runHooks(nil, event)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 4}
+	b.Write([]byte("ab"))
+	b.Write([]byte("cdef"))
+	assert.Equal(t, "cdef", string(b.data))
+	b.Write([]byte("g"))
+	assert.Equal(t, "defg", string(b.data))
+}
+
+func TestRepositoryInspector_Prune_PrePruneHook(t *testing.T) {
+	repoDir := copyFixtureRepo(t, "series")
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload.json")
+	abort := writeHook(t, dir, "abort", "cat > "+payload+"\nexit 1\n")
+
+	_, err := NewRepositoryInspector().Prune("series", repoDir, PruneOptions{
+		KeepLast: 1,
+		Hooks:    &registries.Hooks{PrePrune: []registries.Hook{{Command: abort}}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "prune aborted")
+	assert.FileExists(t, filepath.Join(repoDir, "backups", "2024-04-01_0100-nightly.zbk"))
+
+	data, err := os.ReadFile(payload)
+	require.NoError(t, err)
+	var received HookEvent
+	require.NoError(t, json.Unmarshal(data, &received))
+	assert.Equal(t, HookPrePrune, received.Event)
+	assert.Equal(t, []string{"2024-04-02_0100-nightly.zbk", "2024-04-01_0100-nightly.zbk"}, received.Backups)
+
+	// Dry runs do not run hooks
+	report, err := NewRepositoryInspector().Prune("series", repoDir, PruneOptions{
+		KeepLast: 1,
+		DryRun:   true,
+		Hooks:    &registries.Hooks{PrePrune: []registries.Hook{{Command: abort}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Pruned)
+	assert.Empty(t, report.Hooks)
+}
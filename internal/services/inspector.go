@@ -1,31 +1,87 @@
 package services
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // BackupItem represents a single backup artifact
 type BackupItem struct {
-	Filename    string    `json:"filename"`
-	Date        time.Time `json:"date"`
-	MimeType    string    `json:"mime_type"`
-	Description string    `json:"description"`
-	HasMetadata bool      `json:"has_metadata"`
+	Filename    string            `json:"filename"`
+	Suffix      string            `json:"suffix"`
+	Date        time.Time         `json:"date"`
+	MimeType    string            `json:"mime_type"`
+	Description string            `json:"description"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Status      string            `json:"status,omitempty"`
+	SizeBytes   int64             `json:"size_bytes"`
+	// LogicalSizeBytes is the size of the stream that was backed up, when recorded
+	LogicalSizeBytes int64 `json:"logical_size_bytes,omitempty"`
+	// DurationSeconds is how long the backup took, when recorded
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	HasMetadata     bool    `json:"has_metadata"`
+	// Unique is the chunk-level footprint, filled in when requested
+	Unique *ChunkUsage `json:"unique,omitempty"`
 }
 
 // RepoDetails holds detailed information about a repository
 type RepoDetails struct {
-	Alias          string       `json:"repository_alias"`
-	PhysicalPath   string       `json:"physical_path"`
-	TotalSizeBytes int64        `json:"total_size_bytes"`
-	Backups        []BackupItem `json:"backups"`
+	Alias          string `json:"repository_alias"`
+	PhysicalPath   string `json:"physical_path"`
+	TotalSizeBytes int64  `json:"total_size_bytes"`
+	// Usage breaks TotalSizeBytes down by repository component
+	Usage *RepoUsage `json:"usage,omitempty"`
+	// TotalBackups counts the backups matching the filters, before pagination
+	TotalBackups int          `json:"total_backups"`
+	NextCursor   string       `json:"next_cursor,omitempty"`
+	Backups      []BackupItem `json:"backups"`
+}
+
+// Sync outcomes for a single backup
+const (
+	SyncCreated   = "created"
+	SyncUpdated   = "updated"
+	SyncUnchanged = "unchanged"
+	SyncFailed    = "failed"
+)
+
+// SyncOptions tunes a synchronization run
+type SyncOptions struct {
+	ZBackupPath  string
+	PasswordFile string
+	Deep         bool
+	Jobs         int
+	DryRun       bool
+	// Progress, if set, is called after each backup with the number of backups done so far.
+	// Calls are serialized.
+	Progress func(done, total int, item SyncItemResult)
+}
+
+// SyncItemResult is the outcome of synchronizing one backup
+type SyncItemResult struct {
+	Filename string `json:"filename"`
+	Action   string `json:"action"`
+	MimeType string `json:"mime_type,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SyncReport summarizes a synchronization run
+type SyncReport struct {
+	Alias        string           `json:"repository_alias"`
+	PhysicalPath string           `json:"physical_path"`
+	DryRun       bool             `json:"dry_run"`
+	Created      int              `json:"created"`
+	Updated      int              `json:"updated"`
+	Unchanged    int              `json:"unchanged"`
+	Failed       int              `json:"failed"`
+	Items        []SyncItemResult `json:"items"`
 }
 
 // RepositoryInspector handles inspection logic
@@ -38,159 +94,216 @@ func NewRepositoryInspector() *RepositoryInspector {
 
 // Inspect gathers details about a repository
 func (i *RepositoryInspector) Inspect(alias, path string) (*RepoDetails, error) {
+	return i.InspectWithOptions(alias, path, InspectOptions{})
+}
+
+// InspectWithOptions gathers details about a repository, filtering, sorting and
+// paginating its backups according to opts
+func (i *RepositoryInspector) InspectWithOptions(alias, path string, opts InspectOptions) (*RepoDetails, error) {
 	details := &RepoDetails{
 		Alias:        alias,
 		PhysicalPath: path,
 		Backups:      []BackupItem{},
 	}
 
-	// Calculate total size
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
-	})
+	// Directory sizes and sidecars are served from the catalog while they are unchanged
+	old := newCatalog()
+	if !opts.Refresh {
+		old = loadCatalog(path)
+	}
+	scan := newCatalogScan(path, old)
+
+	size, err := scan.diskUsage(".")
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate disk usage: %w", err)
 	}
 	details.TotalSizeBytes = size
 
-	// Scan backups directory
-	backupsDir := filepath.Join(path, "backups")
-	if _, err := os.Stat(backupsDir); os.IsNotExist(err) {
-		// No backups directory, return empty backups list
-		return details, nil
-	}
-
-	entries, err := os.ReadDir(backupsDir)
+	details.Backups, err = scan.backups()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read backups directory: %w", err)
 	}
+	details.Usage = scan.usage()
+	scan.finish()
 
-	// Regex for "YYYY-MM-DD_HHMM"
-	// Example: 2024-05-10_0800-initial.zbk
-	reDate := regexp.MustCompile(`(\d{4}-\d{2}-\d{2}_\d{4})`)
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	if err := applyQuery(details, opts); err != nil {
+		return nil, err
+	}
 
-		info, err := entry.Info()
+	if opts.Unique {
+		usage, err := i.ChunkUsage(path, opts.PasswordFile)
 		if err != nil {
-			continue
-		}
-
-		name := info.Name()
-		// Basic filter for .zbk files, though spec implies specific naming schema
-		// But let's assume anything in backups/ might be relevant, forcing .zbk extension check is safer
-		if filepath.Ext(name) != ".zbk" {
-			continue
-		}
-
-		item := BackupItem{
-			Filename: name,
-			MimeType: "unknown", // default
-		}
-
-		// Parse date
-		matches := reDate.FindStringSubmatch(name)
-		if len(matches) > 1 {
-			// Layout: YYYY-MM-DD_HHMM
-			parsed, err := time.Parse("2006-01-02_1504", matches[1])
-			if err == nil {
-				item.Date = parsed
-			}
-		} else {
-			// Fallback to file mod time if naming convention isn't followed?
-			// Spec says naming is enforced, but "unknown" items might exist
-			item.Date = info.ModTime()
+			return nil, err
 		}
-
-		// Check for metadata sidecar
-		metaPath := filepath.Join(backupsDir, name+".meta")
-		if metaBytes, err := os.ReadFile(metaPath); err == nil {
-			var meta MetadataSidecar
-			if err := json.Unmarshal(metaBytes, &meta); err == nil {
-				item.HasMetadata = true
-				item.MimeType = meta.MimeType
-				item.Description = meta.Description
-			}
+		for k := range details.Backups {
+			details.Backups[k].Unique = usage[details.Backups[k].Filename]
 		}
-
-		details.Backups = append(details.Backups, item)
 	}
-
-	// Sort backups by date descending
-	sort.Slice(details.Backups, func(i, j int) bool {
-		return details.Backups[i].Date.After(details.Backups[j].Date)
-	})
-
 	return details, nil
 }
 
+// BackupSuffix returns the <suffix> part of a YYYY-MM-DD_HHMM-<suffix>.zbk name,
+// or an empty string if the name does not follow the schema
+func BackupSuffix(name string) string {
+	if !backupNamePattern.MatchString(name) {
+		return ""
+	}
+	return strings.TrimSuffix(name[len("2006-01-02_1504-"):], ".zbk")
+}
+
 // Sync performs a synchronization of the repository, generating missing metadata.
 // If deep is true, it attempts to detect MIME types by restoring the beginning of the backup.
 func (i *RepositoryInspector) Sync(zbackupPath, repoPath string, deep bool, passwordFile string) error {
+	report, err := i.SyncWithOptions("", repoPath, SyncOptions{
+		ZBackupPath:  zbackupPath,
+		PasswordFile: passwordFile,
+		Deep:         deep,
+		Jobs:         1,
+	})
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("failed to synchronize %d backup(s)", report.Failed)
+	}
+	return nil
+}
+
+// SyncWithOptions synchronizes every backup of the repository through a bounded worker pool.
+// Individual failures are recorded in the report instead of aborting the run.
+func (i *RepositoryInspector) SyncWithOptions(alias, repoPath string, opts SyncOptions) (*SyncReport, error) {
+	report := &SyncReport{
+		Alias:        alias,
+		PhysicalPath: repoPath,
+		DryRun:       opts.DryRun,
+		Items:        []SyncItemResult{},
+	}
+
 	backupsDir := filepath.Join(repoPath, "backups")
 	entries, err := os.ReadDir(backupsDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return report, nil
 		}
-		return fmt.Errorf("failed to read backups directory: %w", err)
+		return nil, fmt.Errorf("failed to read backups directory: %w", err)
 	}
 
+	var names []string
 	for _, entry := range entries {
 		if entry.IsDir() || filepath.Ext(entry.Name()) != ".zbk" {
 			continue
 		}
+		names = append(names, entry.Name())
+	}
 
-		zbkPath := filepath.Join(backupsDir, entry.Name())
-		metaPath := zbkPath + ".meta"
-
-		var meta MetadataSidecar
-		metaExists := false
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
 
-		if data, err := os.ReadFile(metaPath); err == nil {
-			if err := json.Unmarshal(data, &meta); err == nil {
-				metaExists = true
+	results := make([]SyncItemResult, len(names))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	done := 0
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = i.syncBackup(filepath.Join(backupsDir, names[idx]), opts)
+				if opts.Progress != nil {
+					progressMu.Lock()
+					done++
+					opts.Progress(done, len(names), results[idx])
+					progressMu.Unlock()
+				}
 			}
+		}()
+	}
+	for idx := range names {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, result := range results {
+		switch result.Action {
+		case SyncCreated:
+			report.Created++
+		case SyncUpdated:
+			report.Updated++
+		case SyncUnchanged:
+			report.Unchanged++
+		case SyncFailed:
+			report.Failed++
 		}
+	}
+	report.Items = append(report.Items, results...)
 
-		if !metaExists {
-			// Lazy: Create skeleton
-			meta = MetadataSidecar{
-				MimeType: "unknown",
-				Status:   "complete",
-			}
-			if err := i.saveMetadata(metaPath, meta); err != nil {
-				// Don't abort, just log or skip? For now, we return error as this is crucial.
-				// Although failing one shouldn't stop others, we'll try to persist.
-				return fmt.Errorf("failed to write metadata for %s: %w", entry.Name(), err)
-			}
+	if !opts.DryRun {
+		// The catalog is only a cache; a failure to rebuild it does not fail the sync
+		_ = i.RebuildCatalog(repoPath)
+	}
+	return report, nil
+}
+
+// syncBackup brings the sidecar of a single backup up to date. A backup whose content
+// cannot be sniffed is reported as failed; a missing sidecar is still created for it.
+func (i *RepositoryInspector) syncBackup(zbkPath string, opts SyncOptions) SyncItemResult {
+	result := SyncItemResult{Filename: filepath.Base(zbkPath), Action: SyncUnchanged}
+
+	var meta MetadataSidecar
+	existing, metaPath, err := ReadSidecar(zbkPath)
+	created := err != nil
+	if !created {
+		meta = *existing
+	} else {
+		// Lazy: Create skeleton
+		meta = MetadataSidecar{
+			MimeType: "unknown",
+			Status:   "complete",
 		}
+		result.Action = SyncCreated
+	}
 
-		if deep && meta.MimeType == "unknown" {
-			mime := i.SniffMimeType(zbackupPath, zbkPath, passwordFile)
-			if mime != "unknown" {
-				meta.MimeType = mime
-				if err := i.saveMetadata(metaPath, meta); err != nil {
-					return fmt.Errorf("failed to update metadata for %s: %w", entry.Name(), err)
-				}
+	var sniffErr error
+	if opts.Deep && meta.MimeType == "unknown" {
+		var mime string
+		mime, sniffErr = i.SniffMimeType(opts.ZBackupPath, zbkPath, opts.PasswordFile)
+		if sniffErr == nil && mime != "unknown" {
+			meta.MimeType = mime
+			if result.Action == SyncUnchanged {
+				result.Action = SyncUpdated
 			}
 		}
 	}
-	return nil
+	result.MimeType = meta.MimeType
+
+	if result.Action != SyncUnchanged && !opts.DryRun {
+		if err := WriteSidecar(metaPath, meta); err != nil {
+			result.Action = SyncFailed
+			result.Error = fmt.Sprintf("failed to write metadata: %v", err)
+			return result
+		}
+	}
+	if sniffErr != nil {
+		result.Action = SyncFailed
+		result.Error = fmt.Sprintf("failed to detect MIME type: %v", sniffErr)
+		if created && !opts.DryRun {
+			result.Error += " (sidecar created without it)"
+		}
+	}
+	return result
 }
 
-// SniffMimeType attempts to detect the MIME type of a backup by restoring the first 512 bytes.
-func (i *RepositoryInspector) SniffMimeType(zbackupPath, zbkPath, passwordFile string) string {
+// sniffSize is how much of a backup is restored to detect its MIME type
+const sniffSize = 512
+
+// SniffMimeType detects the MIME type of a backup by restoring its first bytes. It fails
+// if zbackup cannot be started, exits with an error before producing any data (such as
+// for a wrong password or a corrupt backup), or the backup is empty.
+func (i *RepositoryInspector) SniffMimeType(zbackupPath, zbkPath, passwordFile string) (string, error) {
 	args := []string{"restore"}
 	if passwordFile != "" {
 		args = append(args, "--password-file", passwordFile)
@@ -202,37 +315,36 @@ func (i *RepositoryInspector) SniffMimeType(zbackupPath, zbkPath, passwordFile s
 	args = append(args, zbkPath)
 
 	cmd := exec.Command(zbackupPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return "unknown"
+		return "", err
 	}
-
 	if err := cmd.Start(); err != nil {
-		return "unknown"
+		return "", fmt.Errorf("failed to start zbackup: %w", err)
 	}
 
-	// We only need the first 512 bytes for MIME detection
-	buf := make([]byte, 512)
-	n, err := stdout.Read(buf)
-
-	// Kill the process as we don't need the rest of the stream
-	// Ignore errors from Kill/Wait as we are terminating forcibly
-	_ = cmd.Process.Kill()
-	_ = cmd.Wait()
-
+	buf := make([]byte, sniffSize)
+	n, readErr := io.ReadFull(stdout, buf)
 	if n == 0 {
-		return "unknown"
+		// Nothing was restored: let zbackup finish to learn why
+		waitErr := cmd.Wait()
+		if waitErr != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("zbackup restore failed: %v: %s", waitErr, msg)
+			}
+			return "", fmt.Errorf("zbackup restore failed: %v", waitErr)
+		}
+		if readErr != nil && readErr != io.EOF {
+			return "", fmt.Errorf("failed to read restored data: %w", readErr)
+		}
+		return "", fmt.Errorf("backup is empty")
 	}
 
-	return DetectMimeType(buf[:n])
-}
-
-// saveMetadata writes the metadata to the specified path
-func (i *RepositoryInspector) saveMetadata(path string, meta MetadataSidecar) error {
-	data, err := json.MarshalIndent(meta, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, data, 0644)
+	// The rest of the stream is not needed
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	return DetectMimeType(buf[:n]), nil
 }
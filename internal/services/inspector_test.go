@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -156,3 +157,91 @@ echo "Repeating content to ensure we have enough bytes if needed."
 	// Make sure it didn't stay unknown
 	assert.NotEqual(t, "unknown", updatedMeta.MimeType)
 }
+
+func TestRepositoryInspector_SyncWithOptions_Report(t *testing.T) {
+	repoDir, err := os.MkdirTemp("", "zbwrap-test-sync-report")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+
+	// A backup without metadata, one with metadata and one whose sidecar cannot be written
+	missing := filepath.Join(backupsDir, "2024-01-01_1000-missing.zbk")
+	require.NoError(t, os.WriteFile(missing, []byte("data"), 0644))
+
+	present := filepath.Join(backupsDir, "2024-01-02_1000-present.zbk")
+	require.NoError(t, os.WriteFile(present, []byte("data"), 0644))
+	metaData, _ := json.Marshal(MetadataSidecar{MimeType: "application/x-tar", Status: "success"})
+	require.NoError(t, os.WriteFile(present+".meta", metaData, 0644))
+
+	broken := filepath.Join(backupsDir, "2024-01-03_1000-broken.zbk")
+	require.NoError(t, os.WriteFile(broken, []byte("data"), 0644))
+	require.NoError(t, os.MkdirAll(broken+".meta", 0755))
+
+	inspector := NewRepositoryInspector()
+
+	// Dry run reports the change without touching any sidecar
+	report, err := inspector.SyncWithOptions("test-alias", repoDir, SyncOptions{Jobs: 2, DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.NoFileExists(t, missing+".meta")
+
+	// A real run keeps going past the failing backup
+	report, err = inspector.SyncWithOptions("test-alias", repoDir, SyncOptions{Jobs: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "test-alias", report.Alias)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.Equal(t, 1, report.Failed)
+	assert.FileExists(t, missing+".meta")
+
+	require.Len(t, report.Items, 3)
+	for _, item := range report.Items {
+		if item.Filename == filepath.Base(broken) {
+			assert.Equal(t, SyncFailed, item.Action)
+			assert.NotEmpty(t, item.Error)
+		}
+	}
+}
+
+func TestRepositoryInspector_SyncWithOptions_SniffFailure(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+	for _, name := range []string{"2024-01-01_1000-a.zbk", "2024-01-02_1000-b.zbk"} {
+		require.NoError(t, os.WriteFile(filepath.Join(backupsDir, name), []byte("data"), 0644))
+	}
+
+	// zbackup refuses the password and restores nothing
+	failing := filepath.Join(repoDir, "zbackup-failing")
+	require.NoError(t, os.WriteFile(failing, []byte("#!/bin/sh\necho 'wrong password' >&2\nexit 1\n"), 0755))
+
+	var progress []string
+	report, err := NewRepositoryInspector().SyncWithOptions("test-alias", repoDir, SyncOptions{
+		ZBackupPath: failing,
+		Deep:        true,
+		Jobs:        2,
+		Progress: func(done, total int, item SyncItemResult) {
+			progress = append(progress, fmt.Sprintf("%d/%d %s", done, total, item.Action))
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Failed)
+	for _, item := range report.Items {
+		assert.Equal(t, SyncFailed, item.Action)
+		assert.Equal(t, "failed to detect MIME type: zbackup restore failed: exit status 1: wrong password (sidecar created without it)", item.Error)
+		assert.FileExists(t, filepath.Join(backupsDir, item.Filename+".meta"))
+	}
+	assert.Equal(t, []string{"1/2 failed", "2/2 failed"}, progress)
+
+	// An empty backup and a missing binary are failures too
+	empty := filepath.Join(repoDir, "zbackup-empty")
+	require.NoError(t, os.WriteFile(empty, []byte("#!/bin/sh\nexit 0\n"), 0755))
+	_, err = NewRepositoryInspector().SniffMimeType(empty, filepath.Join(backupsDir, "2024-01-01_1000-a.zbk"), "")
+	assert.EqualError(t, err, "backup is empty")
+	_, err = NewRepositoryInspector().SniffMimeType(filepath.Join(repoDir, "missing"), filepath.Join(backupsDir, "2024-01-01_1000-a.zbk"), "")
+	assert.ErrorContains(t, err, "failed to start zbackup")
+}
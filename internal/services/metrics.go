@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenMetricsContentType is the media type of the text written by WriteOpenMetrics
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// MetricFamily is a named set of samples sharing a type, unit and help text
+type MetricFamily struct {
+	Name    string
+	Type    string
+	Unit    string
+	Help    string
+	Samples []MetricSample
+}
+
+// MetricSample is a single value of a family, identified by its labels
+type MetricSample struct {
+	Labels []MetricLabel
+	Value  float64
+}
+
+// MetricLabel is a label name and value, kept in the order they are written
+type MetricLabel struct {
+	Name  string
+	Value string
+}
+
+func (f *MetricFamily) add(value float64, labels ...MetricLabel) {
+	f.Samples = append(f.Samples, MetricSample{Labels: labels, Value: value})
+}
+
+func gauge(name, unit, help string) *MetricFamily {
+	return &MetricFamily{Name: name, Type: "gauge", Unit: unit, Help: help}
+}
+
+// Metrics collects the gauges of every repository, keyed by alias. Repositories are
+// inspected like Status does, so offline ones only report zbwrap_repository_up 0.
+// Families without samples are left out.
+func (i *RepositoryInspector) Metrics(repos map[string]string, opts StatusOptions) []MetricFamily {
+	up := gauge("zbwrap_repository_up", "", "Whether the repository could be read (1) or is offline (0).")
+	backups := gauge("zbwrap_repository_backups", "", "Number of backups in the repository.")
+	failed := gauge("zbwrap_repository_failed_backups", "", "Number of backups that failed or never completed.")
+	size := gauge("zbwrap_repository_size_bytes", "bytes", "Disk usage of the repository by component.")
+	fsSize := gauge("zbwrap_filesystem_size_bytes", "bytes", "Size of the filesystem holding the repository.")
+	fsAvail := gauge("zbwrap_filesystem_avail_bytes", "bytes", "Space available to zbwrap on the filesystem holding the repository.")
+	lastTime := gauge("zbwrap_last_backup_timestamp_seconds", "seconds", "Time of the newest backup that did not fail, per suffix.")
+	lastDuration := gauge("zbwrap_last_backup_duration_seconds", "seconds", "Duration of the newest backup that did not fail, per suffix.")
+	lastSize := gauge("zbwrap_last_backup_size_bytes", "bytes", "Stored size of the newest backup that did not fail, per suffix.")
+	lastLogical := gauge("zbwrap_last_backup_logical_size_bytes", "bytes", "Size of the stream of the newest backup that did not fail, per suffix.")
+	failureTime := gauge("zbwrap_last_backup_failure_timestamp_seconds", "seconds", "Time of the most recent failed backup.")
+	checkTime := gauge("zbwrap_last_check_timestamp_seconds", "seconds", "Time the last check started.")
+	checkSuccess := gauge("zbwrap_last_check_success", "", "Whether the last check found no problems.")
+	checkProblems := gauge("zbwrap_last_check_problems", "", "Number of problems found by the last check.")
+
+	for _, s := range i.Status(repos, opts) {
+		repo := MetricLabel{"repository", s.Alias}
+		if s.State != RepoOnline {
+			up.add(0, repo)
+			continue
+		}
+		up.add(1, repo)
+		backups.add(float64(s.Backups), repo)
+		failed.add(float64(s.failed), repo)
+
+		if s.usage != nil {
+			for _, c := range s.usage.Components() {
+				size.add(float64(c.Bytes), repo, MetricLabel{"component", c.Component})
+			}
+		}
+		if s.Filesystem != nil {
+			fsSize.add(float64(s.Filesystem.TotalBytes), repo)
+			fsAvail.add(float64(s.Filesystem.AvailableBytes), repo)
+		}
+
+		suffixes := make([]string, 0, len(s.latest))
+		for suffix := range s.latest {
+			suffixes = append(suffixes, suffix)
+		}
+		sort.Strings(suffixes)
+		for _, suffix := range suffixes {
+			b := s.latest[suffix]
+			labels := []MetricLabel{repo, {"suffix", suffix}}
+			lastTime.add(float64(b.Date.Unix()), labels...)
+			lastSize.add(float64(b.SizeBytes), labels...)
+			if b.DurationSeconds > 0 {
+				lastDuration.add(b.DurationSeconds, labels...)
+			}
+			if b.LogicalSizeBytes > 0 {
+				lastLogical.add(float64(b.LogicalSizeBytes), labels...)
+			}
+		}
+
+		if s.LastFailure != nil {
+			failureTime.add(float64(s.LastFailure.Time.Unix()), repo)
+		}
+		if s.LastCheck != nil {
+			checkTime.add(float64(s.LastCheck.StartedAt.Unix()), repo)
+			checkSuccess.add(boolMetric(s.LastCheck.OK), repo)
+			checkProblems.add(float64(s.LastCheck.ProblemCount), repo)
+		}
+	}
+
+	var families []MetricFamily
+	for _, f := range []*MetricFamily{up, backups, failed, size, fsSize, fsAvail, lastTime, lastDuration,
+		lastSize, lastLogical, failureTime, checkTime, checkSuccess, checkProblems} {
+		if len(f.Samples) > 0 {
+			families = append(families, *f)
+		}
+	}
+	return families
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteOpenMetrics writes the families in the OpenMetrics text format. Only gauges are
+// used, so the output is also valid Prometheus text for the node_exporter textfile collector.
+func WriteOpenMetrics(w io.Writer, families []MetricFamily) error {
+	var buf bytes.Buffer
+	for _, f := range families {
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", f.Name, f.Type)
+		if f.Unit != "" {
+			fmt.Fprintf(&buf, "# UNIT %s %s\n", f.Name, f.Unit)
+		}
+		if f.Help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", f.Name, metricEscaper.Replace(f.Help))
+		}
+		for _, s := range f.Samples {
+			buf.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				labels := make([]string, len(s.Labels))
+				for k, l := range s.Labels {
+					labels[k] = fmt.Sprintf(`%s="%s"`, l.Name, metricEscaper.Replace(l.Value))
+				}
+				buf.WriteString("{" + strings.Join(labels, ",") + "}")
+			}
+			buf.WriteString(" " + strconv.FormatFloat(s.Value, 'f', -1, 64) + "\n")
+		}
+	}
+	buf.WriteString("# EOF\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// metricEscaper escapes label values and help texts
+var metricEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteOpenMetricsFile replaces a file with the families atomically, so that a collector
+// reading it never sees a partial write
+func WriteOpenMetricsFile(path string, families []MetricFamily) error {
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, families); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// MetricsHandler serves the metrics of the repositories returned by repos, which is called
+// on every scrape so that registry changes are picked up without a restart
+func (i *RepositoryInspector) MetricsHandler(repos func() (map[string]string, error), opts StatusOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := repos()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error loading registry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+		_ = WriteOpenMetrics(w, i.Metrics(list, opts))
+	})
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_Metrics(t *testing.T) {
+	series := copyFixtureRepo(t, "series")
+	now := time.Date(2024, 4, 5, 2, 0, 0, 0, time.UTC)
+
+	zbk := filepath.Join(series, "backups", "2024-04-03_0100-nightly.zbk")
+	require.NoError(t, WriteSidecar(zbk+SidecarExt,
+		MetadataSidecar{MimeType: "text/plain", Status: StatusSuccess, LogicalSize: 1234, DurationSeconds: 2.5}))
+	require.NoError(t, os.WriteFile(filepath.Join(series, "backups", "2024-04-04_0100-nightly.zbk"), []byte("partial"), 0644))
+	require.NoError(t, WriteSidecar(filepath.Join(series, "backups", "2024-04-04_0100-nightly.zbk"+SidecarExt),
+		MetadataSidecar{MimeType: "text/plain", Status: StatusFailed}))
+	_, err := NewRepositoryInspector().Check("series", series, CheckOptions{})
+	require.NoError(t, err)
+
+	families := NewRepositoryInspector().Metrics(map[string]string{
+		"series":    series,
+		"unmounted": t.TempDir(),
+	}, StatusOptions{Now: now})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteOpenMetrics(&buf, families))
+	text := buf.String()
+
+	info, err := os.Stat(zbk)
+	require.NoError(t, err)
+	for _, line := range []string{
+		"# TYPE zbwrap_repository_up gauge",
+		`zbwrap_repository_up{repository="series"} 1`,
+		`zbwrap_repository_up{repository="unmounted"} 0`,
+		`zbwrap_repository_backups{repository="series"} 5`,
+		`zbwrap_repository_failed_backups{repository="series"} 1`,
+		"# UNIT zbwrap_repository_size_bytes bytes",
+		`zbwrap_last_backup_timestamp_seconds{repository="series",suffix="nightly"} 1712106000`,
+		`zbwrap_last_backup_timestamp_seconds{repository="series",suffix="weekly"} 1712109600`,
+		`zbwrap_last_backup_duration_seconds{repository="series",suffix="nightly"} 2.5`,
+		`zbwrap_last_backup_size_bytes{repository="series",suffix="nightly"} ` + strconv.FormatInt(info.Size(), 10),
+		`zbwrap_last_backup_logical_size_bytes{repository="series",suffix="nightly"} 1234`,
+		`zbwrap_last_backup_failure_timestamp_seconds{repository="series"} 1712192400`,
+		`zbwrap_last_check_success{repository="series"} 0`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+	for _, component := range []string{"bundles", "index", "backups", "sidecars", "tmp", "other"} {
+		assert.Contains(t, text, `zbwrap_repository_size_bytes{repository="series",component="`+component+`"} `)
+	}
+	// The weekly backup has no sidecar, so it has no duration
+	assert.NotContains(t, text, `zbwrap_last_backup_duration_seconds{repository="series",suffix="weekly"}`)
+	// Offline repositories only report that they are down
+	assert.Equal(t, 1, strings.Count(text, `repository="unmounted"`))
+	assert.True(t, strings.HasSuffix(text, "\n# EOF\n"))
+}
+
+func TestWriteOpenMetrics_Escaping(t *testing.T) {
+	f := gauge("zbwrap_test", "", "Help with a \\ backslash.")
+	f.add(1, MetricLabel{"repository", "a \"quoted\"\nname\\"})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteOpenMetrics(&buf, []MetricFamily{*f}))
+	assert.Equal(t, "# TYPE zbwrap_test gauge\n"+
+		"# HELP zbwrap_test Help with a \\\\ backslash.\n"+
+		"zbwrap_test{repository=\"a \\\"quoted\\\"\\nname\\\\\"} 1\n"+
+		"# EOF\n", buf.String())
+}
+
+func TestWriteOpenMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zbwrap.prom")
+	f := gauge("zbwrap_test", "", "")
+	f.add(42)
+
+	require.NoError(t, WriteOpenMetricsFile(path, []MetricFamily{*f}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE zbwrap_test gauge\nzbwrap_test 42\n# EOF\n", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+}
+
+func TestRepositoryInspector_MetricsHandler(t *testing.T) {
+	series := copyFixtureRepo(t, "series")
+	repos := map[string]string{"series": series}
+	var loadErr error
+	handler := NewRepositoryInspector().MetricsHandler(func() (map[string]string, error) {
+		return repos, loadErr
+	}, StatusOptions{})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, OpenMetricsContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), `zbwrap_repository_up{repository="series"} 1`)
+
+	// The registry is read on every scrape
+	repos["other"] = t.TempDir()
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), `zbwrap_repository_up{repository="other"} 0`)
+
+	loadErr = errors.New("registry is corrupt")
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,527 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"zbwrap/internal/registries"
+)
+
+// Notification outcomes, from best to worst
+const (
+	NotifySuccess = "success"
+	NotifyWarning = "warning"
+	NotifyFailure = "failure"
+)
+
+// Notification events
+const (
+	NotifyEventBackup = "backup"
+	NotifyEventCheck  = "check"
+	NotifyEventVerify = "verify"
+	NotifyEventPrune  = "prune"
+	NotifyEventHealth = "health"
+	NotifyEventStale  = "stale"
+	NotifyEventDigest = "digest"
+	NotifyEventTest   = "test"
+	// NotifyEventJob reports a job whose systemd unit failed
+	NotifyEventJob = "job"
+)
+
+// Notification rule kinds
+const (
+	RuleEvent  = "event"
+	RuleDigest = "digest"
+	RuleStale  = "stale"
+)
+
+// DefaultDigestInterval is how often a digest rule without an interval sends
+const DefaultDigestInterval = 24 * time.Hour
+
+// notifyTimeout bounds the delivery of a single notification
+const notifyTimeout = 30 * time.Second
+
+// maxPendingNotifications bounds a digest whose channel keeps failing; the oldest are dropped
+const maxPendingNotifications = 1000
+
+// notifyStateFile keeps pending digests and sent stale alerts, next to the registry
+const notifyStateFile = "notifications.json"
+
+var outcomeSeverity = map[string]int{NotifySuccess: 0, NotifyWarning: 1, NotifyFailure: 2}
+
+// Notification describes the outcome of an operation; it is what channel templates render
+type Notification struct {
+	Event   string    `json:"event"`
+	Outcome string    `json:"outcome"`
+	Alias   string    `json:"alias,omitempty"`
+	Host    string    `json:"host"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Message string    `json:"message,omitempty"`
+	// Digest holds the notifications collected by a digest
+	Digest []Notification `json:"digest,omitempty"`
+}
+
+// NotifyDelivery is the outcome of sending one notification to one channel
+type NotifyDelivery struct {
+	Channel string `json:"channel"`
+	Event   string `json:"event"`
+	Outcome string `json:"outcome"`
+	Alias   string `json:"alias,omitempty"`
+	Subject string `json:"subject"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Notifier delivers notifications to the channels configured in the registry
+type Notifier struct {
+	channels  []registries.NotificationChannel
+	statePath string
+	client    *http.Client
+}
+
+// NewNotifier creates a notifier keeping its state in stateDir, normally the registry directory
+func NewNotifier(channels []registries.NotificationChannel, stateDir string) *Notifier {
+	return &Notifier{
+		channels:  channels,
+		statePath: filepath.Join(stateDir, notifyStateFile),
+		client:    &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+// notifyState is what the notifier remembers between runs, per channel
+type notifyState struct {
+	Channels map[string]*channelState `json:"channels"`
+}
+
+type channelState struct {
+	Pending    []Notification `json:"pending,omitempty"`
+	LastDigest time.Time      `json:"last_digest,omitempty"`
+	// Stale maps alias/suffix to the newest backup already reported as stale
+	Stale map[string]string `json:"stale,omitempty"`
+}
+
+// Notify sends a notification to the channels with a matching event rule and queues it
+// for those with a matching digest rule. A failed delivery does not stop the others.
+func (n *Notifier) Notify(note Notification) []NotifyDelivery {
+	note = stamp(note)
+
+	var deliveries []NotifyDelivery
+	var state *notifyState
+	for _, channel := range n.channels {
+		if !channelCovers(channel, note.Alias) {
+			continue
+		}
+		send, queue := false, false
+		for _, rule := range channel.Rules {
+			if !ruleMatches(rule, note) {
+				continue
+			}
+			switch rule.Kind {
+			case RuleEvent:
+				send = true
+			case RuleDigest:
+				queue = true
+			}
+		}
+		if send {
+			deliveries = append(deliveries, n.deliver(channel, note))
+		}
+		if queue {
+			if state == nil {
+				state = n.loadState()
+			}
+			cs := state.channel(channel.Name)
+			cs.Pending = append(cs.Pending, note)
+			if len(cs.Pending) > maxPendingNotifications {
+				cs.Pending = cs.Pending[len(cs.Pending)-maxPendingNotifications:]
+			}
+		}
+	}
+	if state != nil {
+		if err := n.saveState(state); err != nil {
+			deliveries = append(deliveries, NotifyDelivery{Event: note.Event, Outcome: note.Outcome, Alias: note.Alias,
+				Subject: note.Subject, Error: fmt.Sprintf("failed to queue for digest: %v", err)})
+		}
+	}
+	return deliveries
+}
+
+// Run sends the digests that are due and alerts about stale backups of the repositories,
+// keyed by alias. It is meant to be called periodically, such as from a timer.
+func (n *Notifier) Run(inspector *RepositoryInspector, repos map[string]string, opts StatusOptions) []NotifyDelivery {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	state := n.loadState()
+
+	var statuses []RepoStatus
+	var deliveries []NotifyDelivery
+	for _, channel := range n.channels {
+		cs := state.channel(channel.Name)
+		for _, rule := range channel.Rules {
+			switch rule.Kind {
+			case RuleDigest:
+				if d, sent := n.sendDigest(channel, rule, cs, opts.Now); sent {
+					deliveries = append(deliveries, d)
+				}
+			case RuleStale:
+				if statuses == nil {
+					statuses = inspector.Status(repos, opts)
+				}
+				deliveries = append(deliveries, n.sendStale(channel, rule, cs, statuses, opts.Now)...)
+			}
+		}
+	}
+
+	if err := n.saveState(state); err != nil {
+		deliveries = append(deliveries, NotifyDelivery{Event: NotifyEventDigest, Error: fmt.Sprintf("failed to save notification state: %v", err)})
+	}
+	return deliveries
+}
+
+// Test sends a test notification to a channel, whatever its rules
+func (n *Notifier) Test(name string) (NotifyDelivery, error) {
+	for _, channel := range n.channels {
+		if channel.Name == name {
+			note := stamp(Notification{Event: NotifyEventTest, Outcome: NotifySuccess, Subject: "test notification from zbwrap",
+				Message: "The " + name + " channel is configured correctly."})
+			return n.deliver(channel, note), nil
+		}
+	}
+	return NotifyDelivery{}, fmt.Errorf("notification channel not found: %s", name)
+}
+
+// sendDigest sends the pending notifications once the interval has passed since the last digest
+func (n *Notifier) sendDigest(channel registries.NotificationChannel, rule registries.NotificationRule, cs *channelState, now time.Time) (NotifyDelivery, bool) {
+	interval := DefaultDigestInterval
+	if rule.Interval != "" {
+		parsed, err := parseInterval(rule.Interval)
+		if err != nil {
+			return NotifyDelivery{Channel: channel.Name, Event: NotifyEventDigest, Error: fmt.Sprintf("invalid digest interval %q", rule.Interval)}, true
+		}
+		interval = parsed
+	}
+	if len(cs.Pending) == 0 || now.Sub(cs.LastDigest) < interval {
+		return NotifyDelivery{}, false
+	}
+
+	digest := stamp(Notification{Event: NotifyEventDigest, Outcome: NotifySuccess, Time: now, Digest: cs.Pending})
+	counts := make(map[string]int)
+	for _, note := range cs.Pending {
+		counts[note.Outcome]++
+		if outcomeSeverity[note.Outcome] > outcomeSeverity[digest.Outcome] {
+			digest.Outcome = note.Outcome
+		}
+	}
+	digest.Subject = fmt.Sprintf("%d notification(s): %d failure(s), %d warning(s), %d success(es)",
+		len(cs.Pending), counts[NotifyFailure], counts[NotifyWarning], counts[NotifySuccess])
+	if !cs.LastDigest.IsZero() {
+		digest.Message = "Since " + cs.LastDigest.Format(time.RFC1123)
+	}
+
+	d := n.deliver(channel, digest)
+	if d.Error == "" {
+		cs.Pending = nil
+		cs.LastDigest = now
+	}
+	return d, true
+}
+
+// sendStale alerts once for each newest backup older than the rule allows; a newer
+// backup clears the alert
+func (n *Notifier) sendStale(channel registries.NotificationChannel, rule registries.NotificationRule, cs *channelState, statuses []RepoStatus, now time.Time) []NotifyDelivery {
+	maxAge, err := parseInterval(rule.MaxAge)
+	if err != nil {
+		return []NotifyDelivery{{Channel: channel.Name, Event: NotifyEventStale, Error: fmt.Sprintf("invalid max_age %q", rule.MaxAge)}}
+	}
+
+	var deliveries []NotifyDelivery
+	for _, status := range statuses {
+		if status.State != RepoOnline || !channelCovers(channel, status.Alias) {
+			continue
+		}
+		suffixes := make([]string, 0, len(status.latest))
+		for suffix := range status.latest {
+			if rule.Suffix == "" || rule.Suffix == suffix {
+				suffixes = append(suffixes, suffix)
+			}
+		}
+		sort.Strings(suffixes)
+
+		for _, suffix := range suffixes {
+			b := status.latest[suffix]
+			key := status.Alias + "/" + suffix
+			age := now.Sub(b.Date)
+			if age <= maxAge {
+				delete(cs.Stale, key)
+				continue
+			}
+			if cs.Stale[key] == b.Filename {
+				continue
+			}
+			note := stamp(Notification{
+				Event:   NotifyEventStale,
+				Outcome: NotifyFailure,
+				Alias:   status.Alias,
+				Time:    now,
+				Subject: fmt.Sprintf("newest %s backup of %s is %s old", suffix, status.Alias, FormatAge(age)),
+				Message: fmt.Sprintf("%s was made %s ago; a new backup was expected within %s.", b.Filename, FormatAge(age), FormatAge(maxAge)),
+			})
+			d := n.deliver(channel, note)
+			if d.Error == "" {
+				if cs.Stale == nil {
+					cs.Stale = make(map[string]string)
+				}
+				cs.Stale[key] = b.Filename
+			}
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries
+}
+
+// parseInterval parses a positive age such as 36h, 8d or 2w
+func parseInterval(s string) (time.Duration, error) {
+	seconds, err := parseAge(s)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// stamp fills in the host and time of a notification
+func stamp(note Notification) Notification {
+	if note.Time.IsZero() {
+		note.Time = time.Now()
+	}
+	if note.Host == "" {
+		note.Host, _ = os.Hostname()
+	}
+	return note
+}
+
+func channelCovers(channel registries.NotificationChannel, alias string) bool {
+	if len(channel.Repositories) == 0 || alias == "" {
+		return true
+	}
+	return contains(channel.Repositories, alias)
+}
+
+func ruleMatches(rule registries.NotificationRule, note Notification) bool {
+	if len(rule.Events) > 0 && !contains(rule.Events, note.Event) {
+		return false
+	}
+	return len(rule.Outcomes) == 0 || contains(rule.Outcomes, note.Outcome)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends a notification through a channel
+func (n *Notifier) deliver(channel registries.NotificationChannel, note Notification) NotifyDelivery {
+	d := NotifyDelivery{Channel: channel.Name, Event: note.Event, Outcome: note.Outcome, Alias: note.Alias, Subject: note.Subject}
+	var err error
+	switch {
+	case channel.Type == "webhook" && channel.Webhook != nil:
+		err = n.sendWebhook(channel.Webhook, note)
+	case channel.Type == "smtp" && channel.SMTP != nil:
+		err = sendMail(channel.SMTP, note)
+	default:
+		err = fmt.Errorf("unsupported channel type %q or missing %s settings", channel.Type, channel.Type)
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return d
+}
+
+// sendWebhook posts the rendered body. With a secret, the X-Zbwrap-Signature header
+// carries "sha256=" and the hex HMAC-SHA256 of the body.
+func (n *Notifier) sendWebhook(cfg *registries.WebhookConfig, note Notification) error {
+	var body []byte
+	contentType := cfg.ContentType
+	if cfg.Body == "" {
+		data, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		body = data
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	} else {
+		rendered, err := renderNotification("body", cfg.Body, note)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "zbwrap")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	if cfg.SecretFile != "" {
+		secret, err := readSecret(cfg.SecretFile)
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		req.Header.Set("X-Zbwrap-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+const defaultMailSubject = `[zbwrap] {{.Outcome}}: {{.Subject}}`
+
+const defaultMailBody = `{{.Subject}}
+{{if .Message}}
+{{.Message}}
+{{end}}{{range .Digest}}
+- {{.Time.Format "2006-01-02 15:04"}} {{.Outcome}} {{.Event}}{{if .Alias}} {{.Alias}}{{end}}: {{.Subject}}{{if .Message}} ({{.Message}}){{end}}{{end}}
+
+Host: {{.Host}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+`
+
+// sendMail sends a plain text message, using STARTTLS when the server offers it
+func sendMail(cfg *registries.SMTPConfig, note Notification) error {
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("smtp channel needs from and to addresses")
+	}
+	subjectTemplate, bodyTemplate := cfg.Subject, cfg.Body
+	if subjectTemplate == "" {
+		subjectTemplate = defaultMailSubject
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = defaultMailBody
+	}
+	subject, err := renderNotification("subject", subjectTemplate, note)
+	if err != nil {
+		return err
+	}
+	body, err := renderNotification("body", bodyTemplate, note)
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", note.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	server := cfg.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "25")
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		password, err := readSecret(cfg.PasswordFile)
+		if err != nil {
+			return err
+		}
+		host, _, _ := net.SplitHostPort(server)
+		auth = smtp.PlainAuth("", cfg.Username, string(password), host)
+	}
+	return smtp.SendMail(server, auth, cfg.From, cfg.To, []byte(msg.String()))
+}
+
+// renderNotification executes a channel template; the json function encodes a value
+func renderNotification(name, text string, note Notification) (string, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, note); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// readSecret reads a key or password file, without its trailing newline
+func readSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+func (s *notifyState) channel(name string) *channelState {
+	cs, ok := s.Channels[name]
+	if !ok {
+		cs = &channelState{}
+		s.Channels[name] = cs
+	}
+	return cs
+}
+
+// loadState reads the notifier state; a missing or unreadable file starts afresh
+func (n *Notifier) loadState() *notifyState {
+	state := &notifyState{}
+	if data, err := os.ReadFile(n.statePath); err == nil {
+		_ = json.Unmarshal(data, state)
+	}
+	if state.Channels == nil {
+		state.Channels = make(map[string]*channelState)
+	}
+	return state
+}
+
+func (n *Notifier) saveState(state *notifyState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(n.statePath), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(n.statePath, append(data, '\n'), 0600)
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest is a request received by the test webhook
+type webhookRequest struct {
+	Header http.Header
+	Body   string
+}
+
+// startWebhook starts a local HTTP server recording the requests it receives
+func startWebhook(t *testing.T, status int) (string, func() []webhookRequest) {
+	var mu sync.Mutex
+	var requests []webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, webhookRequest{Header: r.Header.Clone(), Body: string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookRequest(nil), requests...)
+	}
+}
+
+// smtpMessage is a message received by the SMTP stand-in
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// startSMTP starts a minimal SMTP server accepting every message
+func startSMTP(t *testing.T) (string, <-chan smtpMessage) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 localhost ESMTP stand-in\r\n")
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(verb, "MAIL FROM:"):
+			msg.From = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			fmt.Fprint(conn, "250 OK\r\n")
+		case strings.HasPrefix(verb, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			fmt.Fprint(conn, "250 OK\r\n")
+		case verb == "DATA":
+			fmt.Fprint(conn, "354 End data with <CR><LF>.<CR><LF>\r\n")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msg.Data = data.String()
+			messages <- msg
+			msg = smtpMessage{}
+			fmt.Fprint(conn, "250 OK\r\n")
+		case verb == "QUIT":
+			fmt.Fprint(conn, "221 Bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 localhost\r\n")
+		}
+	}
+}
+
+func TestNotifier_Webhook(t *testing.T) {
+	url, received := startWebhook(t, http.StatusOK)
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("s3cret\n"), 0600))
+
+	notifier := NewNotifier([]registries.NotificationChannel{
+		{
+			Name:    "json",
+			Type:    "webhook",
+			Webhook: &registries.WebhookConfig{URL: url, SecretFile: secretFile, Headers: map[string]string{"X-Team": "ops"}},
+			Rules:   []registries.NotificationRule{{Kind: RuleEvent, Outcomes: []string{NotifyFailure}}},
+		},
+		{
+			Name:         "chat",
+			Type:         "webhook",
+			Webhook:      &registries.WebhookConfig{URL: url, Body: `{"text": {{json .Subject}}}`, ContentType: "application/json"},
+			Repositories: []string{"db"},
+			Rules:        []registries.NotificationRule{{Kind: RuleEvent, Events: []string{NotifyEventBackup}}},
+		},
+	}, t.TempDir())
+
+	deliveries := notifier.Notify(Notification{Event: NotifyEventBackup, Outcome: NotifyFailure, Alias: "db",
+		Subject: `backup of db failed: "disk full"`})
+	require.Len(t, deliveries, 2)
+	for _, d := range deliveries {
+		assert.Empty(t, d.Error)
+	}
+
+	requests := received()
+	require.Len(t, requests, 2)
+	var note Notification
+	require.NoError(t, json.Unmarshal([]byte(requests[0].Body), &note))
+	assert.Equal(t, NotifyFailure, note.Outcome)
+	assert.Equal(t, "db", note.Alias)
+	assert.NotEmpty(t, note.Host)
+	assert.False(t, note.Time.IsZero())
+	assert.Equal(t, "ops", requests[0].Header.Get("X-Team"))
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(requests[0].Body))
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), requests[0].Header.Get("X-Zbwrap-Signature"))
+
+	assert.Equal(t, `{"text": "backup of db failed: \"disk full\""}`, requests[1].Body)
+	assert.Equal(t, "application/json", requests[1].Header.Get("Content-Type"))
+	assert.Empty(t, requests[1].Header.Get("X-Zbwrap-Signature"))
+
+	// Outcome, event and repository filters
+	assert.Empty(t, notifier.Notify(Notification{Event: NotifyEventCheck, Outcome: NotifySuccess, Alias: "db"}))
+	assert.Len(t, notifier.Notify(Notification{Event: NotifyEventBackup, Outcome: NotifySuccess, Alias: "db"}), 1)
+	assert.Empty(t, notifier.Notify(Notification{Event: NotifyEventBackup, Outcome: NotifySuccess, Alias: "web"}))
+	assert.Len(t, received(), 3)
+}
+
+func TestNotifier_WebhookErrors(t *testing.T) {
+	url, _ := startWebhook(t, http.StatusBadGateway)
+	notifier := NewNotifier([]registries.NotificationChannel{
+		{Name: "down", Type: "webhook", Webhook: &registries.WebhookConfig{URL: url}, Rules: []registries.NotificationRule{{Kind: RuleEvent}}},
+		{Name: "template", Type: "webhook", Webhook: &registries.WebhookConfig{URL: url, Body: "{{.Missing"}, Rules: []registries.NotificationRule{{Kind: RuleEvent}}},
+		{Name: "untyped", Rules: []registries.NotificationRule{{Kind: RuleEvent}}},
+	}, t.TempDir())
+
+	deliveries := notifier.Notify(Notification{Event: NotifyEventPrune, Outcome: NotifyFailure})
+	require.Len(t, deliveries, 3)
+	assert.Equal(t, "webhook answered 502 Bad Gateway", deliveries[0].Error)
+	assert.Contains(t, deliveries[1].Error, "invalid body template")
+	assert.Contains(t, deliveries[2].Error, "unsupported channel type")
+}
+
+func TestNotifier_SMTP(t *testing.T) {
+	addr, messages := startSMTP(t)
+	notifier := NewNotifier([]registries.NotificationChannel{{
+		Name:  "mail",
+		Type:  "smtp",
+		SMTP:  &registries.SMTPConfig{Server: addr, From: "zbwrap@example.com", To: []string{"ops@example.com", "dba@example.com"}},
+		Rules: []registries.NotificationRule{{Kind: RuleEvent}},
+	}}, t.TempDir())
+
+	deliveries := notifier.Notify(Notification{Event: NotifyEventCheck, Outcome: NotifyFailure, Alias: "db",
+		Subject: "check of db found 2 problem(s)", Message: "missing_chunk: 2"})
+	require.Len(t, deliveries, 1)
+	require.Empty(t, deliveries[0].Error)
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "zbwrap@example.com", msg.From)
+		assert.Equal(t, []string{"ops@example.com", "dba@example.com"}, msg.To)
+		assert.Contains(t, msg.Data, "Subject: [zbwrap] failure: check of db found 2 problem(s)\r\n")
+		assert.Contains(t, msg.Data, "To: ops@example.com, dba@example.com\r\n")
+		assert.Contains(t, msg.Data, "\r\nmissing_chunk: 2\r\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	delivery, err := notifier.Test("mail")
+	require.NoError(t, err)
+	assert.Empty(t, delivery.Error)
+	msg := <-messages
+	assert.Contains(t, msg.Data, "test notification from zbwrap")
+
+	_, err = notifier.Test("missing")
+	assert.Error(t, err)
+}
+
+func TestNotifier_Digest(t *testing.T) {
+	url, received := startWebhook(t, http.StatusOK)
+	stateDir := t.TempDir()
+	channels := []registries.NotificationChannel{{
+		Name:    "daily",
+		Type:    "webhook",
+		Webhook: &registries.WebhookConfig{URL: url},
+		Rules:   []registries.NotificationRule{{Kind: RuleDigest, Outcomes: []string{NotifyWarning, NotifyFailure}}},
+	}}
+	now := time.Date(2024, 4, 5, 8, 0, 0, 0, time.UTC)
+
+	// Notifications are queued, not sent, and survive across notifiers
+	assert.Empty(t, NewNotifier(channels, stateDir).Notify(Notification{Event: NotifyEventBackup, Outcome: NotifyFailure, Alias: "db", Subject: "backup of db failed"}))
+	assert.Empty(t, NewNotifier(channels, stateDir).Notify(Notification{Event: NotifyEventHealth, Outcome: NotifyWarning, Alias: "web", Subject: "web is low on space"}))
+	assert.Empty(t, NewNotifier(channels, stateDir).Notify(Notification{Event: NotifyEventBackup, Outcome: NotifySuccess, Alias: "db"}))
+	assert.Empty(t, received())
+
+	notifier := NewNotifier(channels, stateDir)
+	deliveries := notifier.Run(NewRepositoryInspector(), nil, StatusOptions{Now: now})
+	require.Len(t, deliveries, 1)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, "2 notification(s): 1 failure(s), 1 warning(s), 0 success(es)", deliveries[0].Subject)
+
+	requests := received()
+	require.Len(t, requests, 1)
+	var digest Notification
+	require.NoError(t, json.Unmarshal([]byte(requests[0].Body), &digest))
+	assert.Equal(t, NotifyEventDigest, digest.Event)
+	assert.Equal(t, NotifyFailure, digest.Outcome)
+	require.Len(t, digest.Digest, 2)
+	assert.Equal(t, "backup of db failed", digest.Digest[0].Subject)
+
+	// Nothing is sent until the interval has passed and something is pending
+	notifier.Notify(Notification{Event: NotifyEventPrune, Outcome: NotifyFailure, Alias: "db"})
+	assert.Empty(t, notifier.Run(NewRepositoryInspector(), nil, StatusOptions{Now: now.Add(23 * time.Hour)}))
+	assert.Len(t, notifier.Run(NewRepositoryInspector(), nil, StatusOptions{Now: now.Add(25 * time.Hour)}), 1)
+	assert.Empty(t, notifier.Run(NewRepositoryInspector(), nil, StatusOptions{Now: now.Add(50 * time.Hour)}))
+	assert.Len(t, received(), 2)
+}
+
+func TestNotifier_Stale(t *testing.T) {
+	series := copyFixtureRepo(t, "series")
+	url, received := startWebhook(t, http.StatusOK)
+	notifier := NewNotifier([]registries.NotificationChannel{{
+		Name:    "stale",
+		Type:    "webhook",
+		Webhook: &registries.WebhookConfig{URL: url},
+		Rules:   []registries.NotificationRule{{Kind: RuleStale, MaxAge: "36h", Suffix: "nightly"}},
+	}}, t.TempDir())
+	repos := map[string]string{"series": series}
+	inspector := NewRepositoryInspector()
+
+	// The newest nightly backup is from 2024-04-03 01:00
+	assert.Empty(t, notifier.Run(inspector, repos, StatusOptions{Now: time.Date(2024, 4, 4, 12, 0, 0, 0, time.UTC)}))
+
+	now := time.Date(2024, 4, 5, 2, 0, 0, 0, time.UTC)
+	deliveries := notifier.Run(inspector, repos, StatusOptions{Now: now})
+	require.Len(t, deliveries, 1)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, "newest nightly backup of series is 2d old", deliveries[0].Subject)
+
+	// Each stale backup is reported once
+	assert.Empty(t, notifier.Run(inspector, repos, StatusOptions{Now: now.Add(time.Hour)}))
+
+	// A new backup clears the alert, so the next staleness is reported again
+	require.NoError(t, os.WriteFile(filepath.Join(series, "backups", "2024-04-05_0100-nightly.zbk"), []byte("new"), 0644))
+	assert.Empty(t, notifier.Run(inspector, repos, StatusOptions{Now: now.Add(2 * time.Hour)}))
+	assert.Len(t, notifier.Run(inspector, repos, StatusOptions{Now: now.Add(48 * time.Hour)}), 1)
+	assert.Len(t, received(), 2)
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"zbwrap/internal/registries"
+)
+
+// Prune outcomes for a single backup
+const (
+	PruneWouldDelete = "would_delete"
+	PruneDeleted     = "deleted"
+	PruneFailed      = "failed"
+)
+
+// PruneOptions selects the backups to delete
+type PruneOptions struct {
+	// Match selects candidates, such as a parsed selector; nil selects every backup
+	Match func(BackupItem) bool
+	// KeepLast protects the newest backups of each suffix, whether or not they match
+	KeepLast int
+	DryRun   bool
+	// PasswordFile is needed to estimate freed chunks in encrypted repositories
+	PasswordFile string
+	// Hooks are run before backups are deleted
+	Hooks *registries.Hooks
+}
+
+// PruneItem is the outcome of pruning one backup
+type PruneItem struct {
+	Filename string    `json:"filename"`
+	Suffix   string    `json:"suffix"`
+	Date     time.Time `json:"date"`
+	// FileBytes is the size of the backup file and its sidecars
+	FileBytes int64 `json:"file_bytes"`
+	// UniqueBytes are chunks referenced by no other backup
+	UniqueBytes uint64 `json:"unique_bytes"`
+	Action      string `json:"action"`
+	Error       string `json:"error,omitempty"`
+}
+
+// PruneReport summarizes a prune run
+type PruneReport struct {
+	Alias        string `json:"repository_alias"`
+	PhysicalPath string `json:"physical_path"`
+	DryRun       bool   `json:"dry_run"`
+	Pruned       int    `json:"pruned"`
+	Kept         int    `json:"kept"`
+	Failed       int    `json:"failed"`
+	// FileBytes is freed immediately by deleting backup files and sidecars
+	FileBytes int64 `json:"file_bytes"`
+	// Chunks and ChunkBytes are referenced only by the pruned backups; zbackup gc
+	// releases them. ChunkBytes are sizes before bundle compression.
+	Chunks     int    `json:"chunks"`
+	ChunkBytes uint64 `json:"chunk_bytes"`
+	// EstimateError explains why chunks could not be counted, e.g. for encrypted repositories
+	EstimateError string      `json:"estimate_error,omitempty"`
+	Items         []PruneItem `json:"items"`
+	// Hooks records the pre-prune hooks that ran
+	Hooks []HookResult `json:"hooks,omitempty"`
+	// GC is the garbage collection run after the prune, if requested
+	GC *GCResult `json:"gc,omitempty"`
+}
+
+// Prune deletes the selected backups and their sidecars, or with DryRun only reports
+// what deleting them would free
+func (i *RepositoryInspector) Prune(alias, repoPath string, opts PruneOptions) (*PruneReport, error) {
+	if opts.Match == nil && opts.KeepLast <= 0 {
+		return nil, fmt.Errorf("nothing to prune: select backups or keep the last N of each suffix")
+	}
+
+	details, err := i.InspectWithOptions(alias, repoPath, InspectOptions{SortBy: SortByDate})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PruneReport{Alias: alias, PhysicalPath: repoPath, DryRun: opts.DryRun, Items: []PruneItem{}}
+
+	// Backups are sorted newest first
+	kept := make(map[string]int)
+	var selected []BackupItem
+	for _, b := range details.Backups {
+		if kept[b.Suffix] < opts.KeepLast {
+			kept[b.Suffix]++
+			report.Kept++
+			continue
+		}
+		if opts.Match != nil && !opts.Match(b) {
+			report.Kept++
+			continue
+		}
+		selected = append(selected, b)
+	}
+
+	// Chunk references must be read before anything is deleted
+	analysis, err := analyzeChunks(repoPath, opts.PasswordFile)
+	unique := make(map[string]uint64)
+	if err != nil {
+		report.EstimateError = err.Error()
+	} else {
+		for _, b := range analysis.Backups {
+			unique[b.Name] = b.UniqueBytes
+		}
+	}
+
+	// A failing pre-prune hook aborts the prune before anything is deleted
+	if !opts.DryRun && len(selected) > 0 {
+		event := HookEvent{Event: HookPrePrune, Alias: alias, Repository: repoPath}
+		for _, b := range selected {
+			event.Backups = append(event.Backups, b.Filename)
+		}
+		results, err := runHooks(opts.Hooks, event)
+		report.Hooks = results
+		if err != nil {
+			return nil, fmt.Errorf("prune aborted: %w", err)
+		}
+	}
+
+	backupsDir := filepath.Join(repoPath, "backups")
+	var pruned []string
+	for _, b := range selected {
+		zbkPath := filepath.Join(backupsDir, b.Filename)
+		item := PruneItem{
+			Filename:    b.Filename,
+			Suffix:      b.Suffix,
+			Date:        b.Date,
+			FileBytes:   b.SizeBytes,
+			UniqueBytes: unique[b.Filename],
+			Action:      PruneWouldDelete,
+		}
+		sidecars := existingSidecars(zbkPath)
+		for _, path := range sidecars {
+			if info, err := os.Stat(path); err == nil {
+				item.FileBytes += info.Size()
+			}
+		}
+
+		if !opts.DryRun {
+			item.Action = PruneDeleted
+			if err := deleteBackup(zbkPath, sidecars); err != nil {
+				item.Action = PruneFailed
+				item.Error = err.Error()
+			}
+		}
+
+		if item.Action == PruneFailed {
+			report.Failed++
+		} else {
+			report.Pruned++
+			report.FileBytes += item.FileBytes
+			pruned = append(pruned, b.Filename)
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	if analysis != nil {
+		report.Chunks, report.ChunkBytes = analysis.FreedChunks(pruned)
+	}
+	return report, nil
+}
+
+// existingSidecars returns the sidecar files of a backup that exist
+func existingSidecars(zbkPath string) []string {
+	var paths []string
+	for _, ext := range []string{SidecarExt, SidecarJSONExt} {
+		if _, err := os.Stat(zbkPath + ext); err == nil {
+			paths = append(paths, zbkPath+ext)
+		}
+	}
+	return paths
+}
+
+// deleteBackup removes a backup file, then its sidecars. A sidecar left behind by a
+// failure is reported by fsck as orphaned.
+func deleteBackup(zbkPath string, sidecars []string) error {
+	if err := os.Remove(zbkPath); err != nil {
+		return err
+	}
+	for _, path := range sidecars {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("backup deleted but sidecar was not: %w", err)
+		}
+	}
+	return nil
+}
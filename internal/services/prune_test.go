@@ -0,0 +1,114 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_InspectUnique(t *testing.T) {
+	repoDir := copyFixtureRepo(t, "series")
+
+	details, err := NewRepositoryInspector().InspectWithOptions("repo", repoDir, InspectOptions{SortBy: SortByName, Unique: true})
+	require.NoError(t, err)
+	require.Len(t, details.Backups, 4)
+
+	usage := make(map[string]ChunkUsage)
+	for _, b := range details.Backups {
+		require.NotNil(t, b.Unique, b.Filename)
+		usage[b.Filename] = *b.Unique
+	}
+
+	assert.Equal(t, ChunkUsage{Chunks: 3, ChunkBytes: 168, UniqueBytes: 0, SharedNextBytes: 120}, usage["2024-04-01_0100-nightly.zbk"])
+	assert.Equal(t, ChunkUsage{Chunks: 3, ChunkBytes: 174, UniqueBytes: 0, SharedPreviousBytes: 120, SharedNextBytes: 114}, usage["2024-04-02_0100-nightly.zbk"])
+	assert.Equal(t, ChunkUsage{Chunks: 3, ChunkBytes: 154, UniqueBytes: 40, SharedPreviousBytes: 114}, usage["2024-04-03_0100-nightly.zbk"])
+	assert.Equal(t, ChunkUsage{Chunks: 3, ChunkBytes: 138, UniqueBytes: 30}, usage["2024-04-03_0200-weekly.zbk"])
+}
+
+func TestRepositoryInspector_Prune(t *testing.T) {
+	inspector := NewRepositoryInspector()
+	nightly := func(item BackupItem) bool { return item.Suffix == "nightly" }
+
+	t.Run("requires a selection", func(t *testing.T) {
+		_, err := inspector.Prune("repo", copyFixtureRepo(t, "series"), PruneOptions{DryRun: true})
+		assert.Error(t, err)
+	})
+
+	t.Run("dry run keeps everything", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		report, err := inspector.Prune("repo", repoDir, PruneOptions{Match: nightly, DryRun: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, report.Pruned)
+		assert.Equal(t, 1, report.Kept)
+		// beta, delta and epsilon are not used by the weekly backup
+		assert.Equal(t, 3, report.Chunks)
+		assert.Equal(t, uint64(154), report.ChunkBytes)
+		assert.Empty(t, report.EstimateError)
+		for _, item := range report.Items {
+			assert.Equal(t, PruneWouldDelete, item.Action)
+			assert.FileExists(t, filepath.Join(repoDir, "backups", item.Filename))
+		}
+	})
+
+	t.Run("keep last protects the newest of each suffix", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		sidecar := filepath.Join(repoDir, "backups", "2024-04-01_0100-nightly.zbk"+SidecarExt)
+		require.NoError(t, WriteSidecar(sidecar, MetadataSidecar{MimeType: "text/plain"}))
+
+		report, err := inspector.Prune("repo", repoDir, PruneOptions{KeepLast: 1})
+		require.NoError(t, err)
+
+		require.Len(t, report.Items, 2)
+		assert.Equal(t, "2024-04-02_0100-nightly.zbk", report.Items[0].Filename)
+		assert.Equal(t, "2024-04-01_0100-nightly.zbk", report.Items[1].Filename)
+		assert.Equal(t, 2, report.Kept)
+		// Every chunk of the pruned backups is still used by a kept one
+		assert.Equal(t, 0, report.Chunks)
+		assert.Equal(t, uint64(0), report.ChunkBytes)
+
+		for _, item := range report.Items {
+			assert.Equal(t, PruneDeleted, item.Action)
+			assert.NoFileExists(t, filepath.Join(repoDir, "backups", item.Filename))
+		}
+		assert.NoFileExists(t, sidecar)
+		assert.FileExists(t, filepath.Join(repoDir, "backups", "2024-04-03_0100-nightly.zbk"))
+		assert.FileExists(t, filepath.Join(repoDir, "backups", "2024-04-03_0200-weekly.zbk"))
+	})
+
+	t.Run("estimate fails softly", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		require.NoError(t, os.Remove(filepath.Join(repoDir, "info")))
+
+		report, err := inspector.Prune("repo", repoDir, PruneOptions{Match: nightly, DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Pruned)
+		assert.NotEmpty(t, report.EstimateError)
+		assert.Greater(t, report.FileBytes, int64(0))
+	})
+}
+
+func TestRepositoryInspector_ChunkUsage_Encrypted(t *testing.T) {
+	repoDir := copyFixtureRepo(t, "encrypted")
+	passwordFile := filepath.Join("..", "zbackup", "testdata", "encrypted.password")
+	inspector := NewRepositoryInspector()
+
+	_, err := inspector.ChunkUsage(repoDir, "")
+	assert.ErrorContains(t, err, "encrypted")
+
+	usage, err := inspector.ChunkUsage(repoDir, passwordFile)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	first, second := usage["2024-05-01_0100-secret.zbk"], usage["2024-05-02_0100-secret.zbk"]
+	assert.Empty(t, first.Error)
+	assert.Equal(t, uint64(120), first.SharedNextBytes)
+	assert.Equal(t, uint64(120), second.SharedPreviousBytes)
+
+	report, err := inspector.Prune("repo", repoDir, PruneOptions{KeepLast: 1, DryRun: true, PasswordFile: passwordFile})
+	require.NoError(t, err)
+	assert.Empty(t, report.EstimateError)
+	assert.Equal(t, 1, report.Pruned)
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort orders for backups
+const (
+	SortByDate = "date"
+	SortBySize = "size"
+	SortByName = "name"
+)
+
+// InspectOptions filters, sorts and paginates the backups returned by InspectWithOptions.
+// Zero values disable the corresponding filter.
+type InspectOptions struct {
+	Since    time.Time
+	Until    time.Time
+	Suffix   string
+	MimeType string // prefix match
+	Status   string
+	Grep     *regexp.Regexp // matched against the description
+	// Match is an additional predicate, such as a parsed selector
+	Match func(BackupItem) bool
+
+	// SortBy is date (newest first, the default), size (largest first) or name (A-Z)
+	SortBy  string
+	Reverse bool
+
+	Limit  int
+	Offset int
+	// Cursor continues after the last backup of a previous page
+	Cursor string
+
+	// Refresh ignores the repository catalog and rescans everything
+	Refresh bool
+	// Unique reads chunk references to fill in BackupItem.Unique
+	Unique bool
+	// PasswordFile decrypts the chunk references of encrypted repositories
+	PasswordFile string
+}
+
+// pageCursor is the decoded form of RepoDetails.NextCursor
+type pageCursor struct {
+	SortBy    string    `json:"s"`
+	Reverse   bool      `json:"r"`
+	Filename  string    `json:"f"`
+	Date      time.Time `json:"d"`
+	SizeBytes int64     `json:"b"`
+}
+
+// ValidSortBy reports whether sortBy is a known sort order
+func ValidSortBy(sortBy string) bool {
+	switch sortBy {
+	case "", SortByDate, SortBySize, SortByName:
+		return true
+	}
+	return false
+}
+
+// applyQuery filters, sorts and paginates details.Backups in place
+func applyQuery(details *RepoDetails, opts InspectOptions) error {
+	if !ValidSortBy(opts.SortBy) {
+		return fmt.Errorf("invalid sort order '%s' (expected date, size or name)", opts.SortBy)
+	}
+	if opts.SortBy == "" {
+		opts.SortBy = SortByDate
+	}
+
+	matched := []BackupItem{}
+	for _, item := range details.Backups {
+		if opts.matches(item) {
+			matched = append(matched, item)
+		}
+	}
+
+	less := backupLess(opts.SortBy, opts.Reverse)
+	sort.SliceStable(matched, func(i, j int) bool {
+		return less(matched[i], matched[j])
+	})
+	details.TotalBackups = len(matched)
+
+	start := 0
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return err
+		}
+		if cursor.SortBy != opts.SortBy || cursor.Reverse != opts.Reverse {
+			return fmt.Errorf("cursor was created with a different sort order")
+		}
+		last := BackupItem{Filename: cursor.Filename, Date: cursor.Date, SizeBytes: cursor.SizeBytes}
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(last, matched[i])
+		})
+	}
+	start += opts.Offset
+	if start > len(matched) {
+		start = len(matched)
+	}
+
+	end := len(matched)
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+	}
+	details.Backups = matched[start:end]
+
+	details.NextCursor = ""
+	if end < len(matched) && end > start {
+		last := matched[end-1]
+		details.NextCursor = encodeCursor(pageCursor{
+			SortBy:    opts.SortBy,
+			Reverse:   opts.Reverse,
+			Filename:  last.Filename,
+			Date:      last.Date,
+			SizeBytes: last.SizeBytes,
+		})
+	}
+	return nil
+}
+
+// matches applies the filters of opts to a single backup
+func (opts InspectOptions) matches(item BackupItem) bool {
+	if !opts.Since.IsZero() && item.Date.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && !item.Date.Before(opts.Until) {
+		return false
+	}
+	if opts.Suffix != "" && item.Suffix != opts.Suffix {
+		return false
+	}
+	if opts.MimeType != "" && !strings.HasPrefix(item.MimeType, opts.MimeType) {
+		return false
+	}
+	if opts.Status != "" && item.Status != opts.Status {
+		return false
+	}
+	if opts.Grep != nil && !opts.Grep.MatchString(item.Description) {
+		return false
+	}
+	if opts.Match != nil && !opts.Match(item) {
+		return false
+	}
+	return true
+}
+
+// backupLess returns the ordering for sortBy, breaking ties by file name
+// so that cursors always point at a unique position
+func backupLess(sortBy string, reverse bool) func(a, b BackupItem) bool {
+	return func(a, b BackupItem) bool {
+		if reverse {
+			a, b = b, a
+		}
+		switch sortBy {
+		case SortBySize:
+			if a.SizeBytes != b.SizeBytes {
+				return a.SizeBytes > b.SizeBytes
+			}
+		case SortByDate:
+			if !a.Date.Equal(b.Date) {
+				return a.Date.After(b.Date)
+			}
+		}
+		return a.Filename < b.Filename
+	}
+}
+
+// encodeCursor serializes a cursor into an opaque string
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_InspectWithOptions(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+
+	backups := []struct {
+		name        string
+		size        int
+		description string
+		status      string
+	}{
+		{"2024-01-01_0100-nightly.zbk", 30, "db dump", StatusSuccess},
+		{"2024-01-02_0100-nightly.zbk", 10, "db dump", StatusSuccess},
+		{"2024-01-03_0100-nightly.zbk", 50, "web assets", StatusFailed},
+		{"2024-01-04_0100-monthly.zbk", 20, "db full", StatusSuccess},
+		{"2024-01-05_0100-nightly.zbk", 40, "db dump", StatusSuccess},
+	}
+	for _, b := range backups {
+		path := filepath.Join(backupsDir, b.name)
+		require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", b.size)), 0644))
+		require.NoError(t, WriteSidecar(path+SidecarExt, MetadataSidecar{
+			MimeType:    "application/x-tar",
+			Description: b.description,
+			Status:      b.status,
+		}))
+	}
+
+	inspector := NewRepositoryInspector()
+	names := func(d *RepoDetails) []string {
+		var out []string
+		for _, b := range d.Backups {
+			out = append(out, b.Filename)
+		}
+		return out
+	}
+
+	// Filters
+	details, err := inspector.InspectWithOptions("a", repoDir, InspectOptions{
+		Suffix: "nightly",
+		Status: StatusSuccess,
+		Grep:   regexp.MustCompile(`^db`),
+		Since:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-01-05_0100-nightly.zbk", "2024-01-02_0100-nightly.zbk"}, names(details))
+	assert.Equal(t, 2, details.TotalBackups)
+	assert.Empty(t, details.NextCursor)
+
+	// Sorting
+	details, err = inspector.InspectWithOptions("a", repoDir, InspectOptions{SortBy: SortBySize})
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-03_0100-nightly.zbk", details.Backups[0].Filename)
+	assert.Equal(t, int64(50), details.Backups[0].SizeBytes)
+
+	details, err = inspector.InspectWithOptions("a", repoDir, InspectOptions{SortBy: SortByName, Reverse: true, Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-01-04_0100-monthly.zbk"}, names(details))
+	assert.Equal(t, 5, details.TotalBackups)
+
+	// Paging with cursors visits every backup exactly once, oldest first
+	var visited []string
+	opts := InspectOptions{Reverse: true, Limit: 2}
+	for page := 0; page < 5; page++ {
+		details, err = inspector.InspectWithOptions("a", repoDir, opts)
+		require.NoError(t, err)
+		visited = append(visited, names(details)...)
+		if details.NextCursor == "" {
+			break
+		}
+		opts.Cursor = details.NextCursor
+	}
+	assert.Equal(t, []string{
+		"2024-01-01_0100-nightly.zbk",
+		"2024-01-02_0100-nightly.zbk",
+		"2024-01-03_0100-nightly.zbk",
+		"2024-01-04_0100-monthly.zbk",
+		"2024-01-05_0100-nightly.zbk",
+	}, visited)
+
+	// Cursors are tied to their sort order
+	_, err = inspector.InspectWithOptions("a", repoDir, InspectOptions{Cursor: opts.Cursor})
+	assert.Error(t, err)
+	_, err = inspector.InspectWithOptions("a", repoDir, InspectOptions{SortBy: "color"})
+	assert.Error(t, err)
+}
@@ -2,15 +2,49 @@ package services
 
 import (
 	"bytes"
+	"encoding/json"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 // MetadataSidecar represents the .meta.json file structure
 type MetadataSidecar struct {
-	MimeType    string `json:"mime_type"`
-	Description string `json:"description"`
-	Status      string `json:"status,omitempty"`
+	SchemaVersion int    `json:"schema_version"`
+	MimeType      string `json:"mime_type"`
+	Description   string `json:"description"`
+	Status        string `json:"status,omitempty"`
+	// LogicalSize and SHA256 describe the stream handed to zbackup, after any decompression
+	LogicalSize int64  `json:"logical_size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	// DurationSeconds is how long zbackup took to store the stream
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	// Hooks records the pre- and post-backup hooks that ran
+	Hooks []HookResult `json:"hooks,omitempty"`
+	// Compression is set when compressed input was decompressed before storage
+	Compression *CompressionInfo  `json:"compression,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Notes       []SidecarNote     `json:"notes,omitempty"`
+	// History is the append-only log of edits made after the backup was created
+	History []SidecarEdit `json:"history,omitempty"`
+	// Extra holds fields unknown to this version, preserved across rewrites
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// SidecarNote is a free-text note attached to a backup after the fact
+type SidecarNote struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+}
+
+// SidecarEdit records a single change to a sidecar field
+type SidecarEdit struct {
+	Time     time.Time `json:"time"`
+	Author   string    `json:"author"`
+	Field    string    `json:"field"`
+	OldValue string    `json:"old_value"`
+	NewValue string    `json:"new_value"`
 }
 
 // DetectMimeType uses the 'file' command to detect MIME type from byte slice.
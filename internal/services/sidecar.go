@@ -0,0 +1,321 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// SidecarSchemaVersion is the sidecar schema written by this version of zbwrap.
+// Sidecars without a schema_version field are version 0.
+const SidecarSchemaVersion = 1
+
+// Sidecar file extensions. New sidecars use SidecarExt; SidecarJSONExt is the
+// name used by the specification and is accepted everywhere a sidecar is read.
+const (
+	SidecarExt     = ".meta"
+	SidecarJSONExt = ".meta.json"
+)
+
+// sidecarKnownFields caches the JSON keys of MetadataSidecar
+var (
+	sidecarKnownFields     map[string]bool
+	sidecarKnownFieldsOnce sync.Once
+)
+
+// sidecarFields is MetadataSidecar without its custom JSON methods
+type sidecarFields MetadataSidecar
+
+// UnmarshalJSON decodes the known fields and keeps any unknown ones,
+// so that a read-modify-write round trip does not drop them.
+func (m *MetadataSidecar) UnmarshalJSON(data []byte) error {
+	var known sidecarFields
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+
+	fields := knownSidecarFields()
+	for key := range all {
+		if fields[key] {
+			delete(all, key)
+		}
+	}
+	if len(all) == 0 {
+		all = nil
+	}
+
+	*m = MetadataSidecar(known)
+	m.Extra = all
+	return nil
+}
+
+// MarshalJSON encodes the known fields followed by any preserved unknown ones
+func (m MetadataSidecar) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(sidecarFields(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for key, value := range m.Extra {
+		if _, exists := all[key]; !exists {
+			all[key] = value
+		}
+	}
+	return json.Marshal(all)
+}
+
+// knownSidecarFields returns the JSON keys declared on MetadataSidecar
+func knownSidecarFields() map[string]bool {
+	sidecarKnownFieldsOnce.Do(func() {
+		sidecarKnownFields = make(map[string]bool)
+		t := reflect.TypeOf(sidecarFields{})
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				sidecarKnownFields[name] = true
+			}
+		}
+	})
+	return sidecarKnownFields
+}
+
+// SidecarPath returns the sidecar location of a backup: an existing sidecar under
+// either accepted name, or the default name for a new one.
+func SidecarPath(zbkPath string) string {
+	if _, err := os.Stat(zbkPath + SidecarJSONExt); err == nil {
+		return zbkPath + SidecarJSONExt
+	}
+	return zbkPath + SidecarExt
+}
+
+// SidecarBackupName returns the backup file name a sidecar file name belongs to
+func SidecarBackupName(name string) (string, bool) {
+	for _, ext := range []string{SidecarJSONExt, SidecarExt} {
+		if strings.HasSuffix(name, ".zbk"+ext) {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+	return "", false
+}
+
+// ReadSidecar reads the sidecar of a backup. It returns the path it was read from,
+// which is also the path updates should be written to.
+func ReadSidecar(zbkPath string) (*MetadataSidecar, string, error) {
+	path := SidecarPath(zbkPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, path, err
+	}
+	var meta MetadataSidecar
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, path, fmt.Errorf("invalid sidecar %s: %w", filepath.Base(path), err)
+	}
+	return &meta, path, nil
+}
+
+// WriteSidecar atomically replaces the sidecar at path, stamping the current schema
+// version unless the sidecar comes from a newer zbwrap.
+func WriteSidecar(path string, meta MetadataSidecar) error {
+	data, err := marshalSidecar(meta)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+// CreateSidecar writes a new sidecar at path. It fails with an error satisfying
+// os.IsExist if the file is already there, so it can be used to claim a backup name.
+func CreateSidecar(path string, meta MetadataSidecar) error {
+	data, err := marshalSidecar(meta)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// marshalSidecar encodes a sidecar, stamping the current schema version unless the
+// sidecar comes from a newer zbwrap
+func marshalSidecar(meta MetadataSidecar) ([]byte, error) {
+	if meta.SchemaVersion < SidecarSchemaVersion {
+		meta.SchemaVersion = SidecarSchemaVersion
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it into place,
+// so that readers never see a partly written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Migration outcomes for a single sidecar
+const (
+	MigrationUpgraded = "upgraded"
+	MigrationCurrent  = "current"
+	MigrationFailed   = "failed"
+)
+
+// MigrationItem is the outcome of migrating one sidecar
+type MigrationItem struct {
+	Path        string `json:"path"`
+	FromVersion int    `json:"from_version"`
+	ToVersion   int    `json:"to_version"`
+	Action      string `json:"action"`
+	BackupCopy  string `json:"backup_copy,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// MigrationReport summarizes a metadata migration
+type MigrationReport struct {
+	Alias        string          `json:"repository_alias"`
+	PhysicalPath string          `json:"physical_path"`
+	DryRun       bool            `json:"dry_run"`
+	Upgraded     int             `json:"upgraded"`
+	Current      int             `json:"current"`
+	Failed       int             `json:"failed"`
+	Items        []MigrationItem `json:"items"`
+}
+
+// MigrateMetadata upgrades every sidecar of a repository to the current schema version.
+// Each sidecar is copied to <sidecar>.v<N>.bak before being atomically rewritten in place.
+func (i *RepositoryInspector) MigrateMetadata(alias, repoPath string, dryRun bool) (*MigrationReport, error) {
+	report := &MigrationReport{Alias: alias, PhysicalPath: repoPath, DryRun: dryRun, Items: []MigrationItem{}}
+
+	backupsDir := filepath.Join(repoPath, "backups")
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return report, nil
+		}
+		return nil, fmt.Errorf("failed to read backups directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, ok := SidecarBackupName(entry.Name()); !ok {
+			continue
+		}
+
+		path := filepath.Join(backupsDir, entry.Name())
+		item := migrateSidecar(path, dryRun)
+		item.Path = filepath.Join("backups", entry.Name())
+
+		switch item.Action {
+		case MigrationUpgraded:
+			report.Upgraded++
+		case MigrationCurrent:
+			report.Current++
+		case MigrationFailed:
+			report.Failed++
+		}
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+// migrateSidecar upgrades a single sidecar file
+func migrateSidecar(path string, dryRun bool) MigrationItem {
+	item := MigrationItem{Action: MigrationCurrent}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		item.Action = MigrationFailed
+		item.Error = err.Error()
+		return item
+	}
+	var meta MetadataSidecar
+	if err := json.Unmarshal(data, &meta); err != nil {
+		item.Action = MigrationFailed
+		item.Error = fmt.Sprintf("invalid JSON: %v", err)
+		return item
+	}
+
+	item.FromVersion = meta.SchemaVersion
+	item.ToVersion = meta.SchemaVersion
+	if meta.SchemaVersion >= SidecarSchemaVersion {
+		return item
+	}
+
+	upgradeSidecar(&meta)
+	item.Action = MigrationUpgraded
+	item.ToVersion = SidecarSchemaVersion
+	item.BackupCopy = fmt.Sprintf("%s.v%d.bak", filepath.Base(path), item.FromVersion)
+	if dryRun {
+		return item
+	}
+
+	if err := WriteFileAtomic(filepath.Join(filepath.Dir(path), item.BackupCopy), data, 0644); err != nil {
+		item.Action = MigrationFailed
+		item.Error = fmt.Sprintf("failed to keep a backup copy: %v", err)
+		return item
+	}
+	if err := WriteSidecar(path, meta); err != nil {
+		item.Action = MigrationFailed
+		item.Error = err.Error()
+	}
+	return item
+}
+
+// upgradeSidecar applies the schema changes between the sidecar version and the current one
+func upgradeSidecar(meta *MetadataSidecar) {
+	// Version 0 -> 1: the schema version is recorded and a missing MIME type is explicit
+	if meta.SchemaVersion < 1 && meta.MimeType == "" {
+		meta.MimeType = "unknown"
+	}
+	meta.SchemaVersion = SidecarSchemaVersion
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataSidecar_PreservesUnknownFields(t *testing.T) {
+	input := `{"mime_type":"application/x-tar","description":"old","owner":"ops","labels":{"a":1}}`
+
+	var meta MetadataSidecar
+	require.NoError(t, json.Unmarshal([]byte(input), &meta))
+	assert.Equal(t, "application/x-tar", meta.MimeType)
+	assert.Equal(t, 0, meta.SchemaVersion)
+	assert.Len(t, meta.Extra, 2)
+
+	meta.Description = "new"
+	data, err := json.Marshal(meta)
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "new", fields["description"])
+	assert.Equal(t, "ops", fields["owner"])
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, fields["labels"])
+}
+
+func TestReadSidecar_AcceptsBothNames(t *testing.T) {
+	backupsDir := t.TempDir()
+
+	legacy := filepath.Join(backupsDir, "2024-01-01_1000-legacy.zbk")
+	require.NoError(t, os.WriteFile(legacy+SidecarExt, []byte(`{"mime_type":"text/plain"}`), 0644))
+	spec := filepath.Join(backupsDir, "2024-01-02_1000-spec.zbk")
+	require.NoError(t, os.WriteFile(spec+SidecarJSONExt, []byte(`{"mime_type":"application/zip"}`), 0644))
+
+	meta, path, err := ReadSidecar(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", meta.MimeType)
+	assert.Equal(t, legacy+SidecarExt, path)
+
+	meta, path, err = ReadSidecar(spec)
+	require.NoError(t, err)
+	assert.Equal(t, "application/zip", meta.MimeType)
+	assert.Equal(t, spec+SidecarJSONExt, path)
+
+	name, ok := SidecarBackupName("2024-01-02_1000-spec.zbk.meta.json")
+	assert.True(t, ok)
+	assert.Equal(t, "2024-01-02_1000-spec.zbk", name)
+}
+
+func TestRepositoryInspector_MigrateMetadata(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+
+	oldPath := filepath.Join(backupsDir, "2024-01-01_1000-old.zbk.meta.json")
+	original := []byte(`{"description":"legacy","custom":"keep me"}`)
+	require.NoError(t, os.WriteFile(oldPath, original, 0644))
+
+	currentPath := filepath.Join(backupsDir, "2024-01-02_1000-new.zbk.meta")
+	require.NoError(t, WriteSidecar(currentPath, MetadataSidecar{MimeType: "text/plain"}))
+
+	inspector := NewRepositoryInspector()
+
+	// Dry run leaves files untouched
+	report, err := inspector.MigrateMetadata("test-alias", repoDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Upgraded)
+	assert.Equal(t, 1, report.Current)
+	data, err := os.ReadFile(oldPath)
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+
+	report, err = inspector.MigrateMetadata("test-alias", repoDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Upgraded)
+	assert.Equal(t, 0, report.Failed)
+
+	// The original is kept as a backup copy
+	data, err = os.ReadFile(oldPath + ".v0.bak")
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+
+	meta, _, err := ReadSidecar(filepath.Join(backupsDir, "2024-01-01_1000-old.zbk"))
+	require.NoError(t, err)
+	assert.Equal(t, SidecarSchemaVersion, meta.SchemaVersion)
+	assert.Equal(t, "unknown", meta.MimeType)
+	assert.Equal(t, "legacy", meta.Description)
+	assert.Equal(t, json.RawMessage(`"keep me"`), meta.Extra["custom"])
+
+	// A second run has nothing left to do
+	report, err = inspector.MigrateMetadata("test-alias", repoDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Upgraded)
+	assert.Equal(t, 2, report.Current)
+}
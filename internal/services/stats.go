@@ -0,0 +1,199 @@
+package services
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Dimensions of a deduplication breakdown
+const (
+	StatsBySuffix = "suffix"
+	StatsByMonth  = "month"
+)
+
+// StatsOptions tunes deduplication statistics
+type StatsOptions struct {
+	// Since restricts the statistics to backups and bundles written on or after this time
+	Since time.Time
+}
+
+// DedupGroup aggregates the backups sharing a suffix or a month
+type DedupGroup struct {
+	Dimension string `json:"dimension"`
+	Key       string `json:"key"`
+	Backups   int    `json:"backups"`
+	// Measured counts the backups whose sidecar records a logical size
+	Measured     int   `json:"measured"`
+	LogicalBytes int64 `json:"logical_bytes"`
+	// StoredBytes is the size of the bundles written while these backups ran
+	// (by suffix) or during the month (by month)
+	StoredBytes int64 `json:"stored_bytes"`
+	// DedupRatio is LogicalBytes / StoredBytes, or 0 when either is unknown
+	DedupRatio float64 `json:"dedup_ratio"`
+}
+
+// DedupStats summarizes how well a repository deduplicates
+type DedupStats struct {
+	Alias        string     `json:"repository_alias"`
+	PhysicalPath string     `json:"physical_path"`
+	Since        *time.Time `json:"since,omitempty"`
+	Backups      int        `json:"backups"`
+	Measured     int        `json:"measured"`
+	LogicalBytes int64      `json:"logical_bytes"`
+	// PhysicalBytes is the size of all bundles, or of those written since Since
+	PhysicalBytes int64   `json:"physical_bytes"`
+	DedupRatio    float64 `json:"dedup_ratio"`
+	// UnattributedBytes were written outside of any backup, e.g. by zbackup gc or raw zbackup runs
+	UnattributedBytes int64        `json:"unattributed_bytes"`
+	BySuffix          []DedupGroup `json:"by_suffix"`
+	ByMonth           []DedupGroup `json:"by_month"`
+}
+
+// bundleFile is the size and write time of one bundle
+type bundleFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// backupWindow is the time during which a backup was running
+type backupWindow struct {
+	suffix     string
+	start, end time.Time
+}
+
+// Stats computes deduplication statistics from the logical sizes recorded in sidecars
+// and the size of the bundles. Bundles are attributed to the backups that were running
+// when they were written, which is how new data reaches the repository.
+func (i *RepositoryInspector) Stats(alias, repoPath string, opts StatsOptions) (*DedupStats, error) {
+	details, err := i.InspectWithOptions(alias, repoPath, InspectOptions{Since: opts.Since})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DedupStats{Alias: alias, PhysicalPath: repoPath, BySuffix: []DedupGroup{}, ByMonth: []DedupGroup{}}
+	if !opts.Since.IsZero() {
+		stats.Since = &opts.Since
+	}
+
+	bySuffix := make(map[string]*DedupGroup)
+	byMonth := make(map[string]*DedupGroup)
+	group := func(groups map[string]*DedupGroup, dimension, key string) *DedupGroup {
+		if g, ok := groups[key]; ok {
+			return g
+		}
+		g := &DedupGroup{Dimension: dimension, Key: key}
+		groups[key] = g
+		return g
+	}
+
+	var windows []backupWindow
+	for _, b := range details.Backups {
+		suffix := b.Suffix
+		if suffix == "" {
+			suffix = "(none)"
+		}
+		// Backup names carry the local start time, truncated to the minute
+		start := time.Date(b.Date.Year(), b.Date.Month(), b.Date.Day(), b.Date.Hour(), b.Date.Minute(), 0, 0, time.Local)
+
+		for _, g := range []*DedupGroup{group(bySuffix, StatsBySuffix, suffix), group(byMonth, StatsByMonth, start.Format("2006-01"))} {
+			g.Backups++
+			if b.LogicalSizeBytes > 0 {
+				g.Measured++
+				g.LogicalBytes += b.LogicalSizeBytes
+			}
+		}
+		stats.Backups++
+		if b.LogicalSizeBytes > 0 {
+			stats.Measured++
+			stats.LogicalBytes += b.LogicalSizeBytes
+		}
+
+		if info, err := os.Stat(filepath.Join(repoPath, "backups", b.Filename)); err == nil {
+			windows = append(windows, backupWindow{suffix: suffix, start: start, end: info.ModTime()})
+		}
+	}
+
+	bundles, err := listBundleFiles(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		if bundle.modTime.Before(opts.Since) {
+			continue
+		}
+		stats.PhysicalBytes += bundle.size
+		group(byMonth, StatsByMonth, bundle.modTime.In(time.Local).Format("2006-01")).StoredBytes += bundle.size
+
+		var running []backupWindow
+		for _, w := range windows {
+			if !bundle.modTime.Before(w.start) && !bundle.modTime.After(w.end) {
+				running = append(running, w)
+			}
+		}
+		if len(running) == 0 {
+			stats.UnattributedBytes += bundle.size
+			continue
+		}
+		// Backups running concurrently share the bundle
+		for n, w := range running {
+			share := bundle.size / int64(len(running))
+			if n == 0 {
+				share += bundle.size % int64(len(running))
+			}
+			group(bySuffix, StatsBySuffix, w.suffix).StoredBytes += share
+		}
+	}
+
+	stats.DedupRatio = dedupRatio(stats.LogicalBytes, stats.PhysicalBytes)
+	stats.BySuffix = sortedGroups(bySuffix)
+	stats.ByMonth = sortedGroups(byMonth)
+	return stats, nil
+}
+
+// listBundleFiles returns the size and write time of every bundle
+func listBundleFiles(repoPath string) ([]bundleFile, error) {
+	var bundles []bundleFile
+	err := filepath.WalkDir(filepath.Join(repoPath, "bundles"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		bundles = append(bundles, bundleFile{size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundles directory: %w", err)
+	}
+	return bundles, nil
+}
+
+// sortedGroups returns the groups ordered by key, with their ratios filled in
+func sortedGroups(groups map[string]*DedupGroup) []DedupGroup {
+	sorted := make([]DedupGroup, 0, len(groups))
+	for _, g := range groups {
+		g.DedupRatio = dedupRatio(g.LogicalBytes, g.StoredBytes)
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Key < sorted[b].Key })
+	return sorted
+}
+
+func dedupRatio(logical, stored int64) float64 {
+	if logical == 0 || stored == 0 {
+		return 0
+	}
+	return float64(logical) / float64(stored)
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_AttributesBundlesToBackups(t *testing.T) {
+	repoDir := t.TempDir()
+	backupsDir := filepath.Join(repoDir, "backups")
+	require.NoError(t, os.MkdirAll(backupsDir, 0755))
+
+	at := func(s string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+		require.NoError(t, err)
+		return parsed
+	}
+	backup := func(name string, logical int64, end time.Time) {
+		path := filepath.Join(backupsDir, name)
+		require.NoError(t, os.WriteFile(path, []byte("zbk"), 0644))
+		require.NoError(t, os.Chtimes(path, end, end))
+		if logical > 0 {
+			require.NoError(t, WriteSidecar(path+SidecarExt, MetadataSidecar{MimeType: "text/plain", LogicalSize: logical}))
+		}
+	}
+	bundle := func(name string, size int, written time.Time) {
+		path := filepath.Join(repoDir, "bundles", name[:2], name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+		require.NoError(t, os.Chtimes(path, written, written))
+	}
+
+	backup("2024-01-10_0100-db.zbk", 10000, at("2024-01-10 01:30:00"))
+	backup("2024-02-10_0100-db.zbk", 10000, at("2024-02-10 01:10:00"))
+	backup("2024-02-11_0100-media.zbk", 3000, at("2024-02-11 01:10:00"))
+	backup("2024-02-12_0100-media.zbk", 0, at("2024-02-12 01:10:00"))
+
+	bundle("aa01", 1000, at("2024-01-10 01:20:00"))
+	bundle("aa02", 500, at("2024-02-10 01:05:00"))
+	bundle("bb01", 3000, at("2024-02-11 01:05:00"))
+	bundle("cc01", 700, at("2024-02-20 12:00:00"))
+
+	stats, err := NewRepositoryInspector().Stats("repo", repoDir, StatsOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, stats.Backups)
+	assert.Equal(t, 3, stats.Measured)
+	assert.Equal(t, int64(23000), stats.LogicalBytes)
+	assert.Equal(t, int64(5200), stats.PhysicalBytes)
+	assert.Equal(t, int64(700), stats.UnattributedBytes)
+
+	require.Len(t, stats.BySuffix, 2)
+	db := stats.BySuffix[0]
+	assert.Equal(t, "db", db.Key)
+	assert.Equal(t, int64(20000), db.LogicalBytes)
+	assert.Equal(t, int64(1500), db.StoredBytes)
+	assert.InDelta(t, 13.33, db.DedupRatio, 0.01)
+
+	media := stats.BySuffix[1]
+	assert.Equal(t, 2, media.Backups)
+	assert.Equal(t, 1, media.Measured)
+	assert.InDelta(t, 1.0, media.DedupRatio, 0.001)
+
+	require.Len(t, stats.ByMonth, 2)
+	assert.Equal(t, "2024-01", stats.ByMonth[0].Key)
+	assert.Equal(t, int64(1000), stats.ByMonth[0].StoredBytes)
+	assert.Equal(t, "2024-02", stats.ByMonth[1].Key)
+	assert.Equal(t, int64(4200), stats.ByMonth[1].StoredBytes)
+
+	// A window keeps only recent backups and bundles
+	windowed, err := NewRepositoryInspector().Stats("repo", repoDir, StatsOptions{Since: at("2024-02-01 00:00:00")})
+	require.NoError(t, err)
+	assert.Equal(t, 3, windowed.Backups)
+	assert.Equal(t, int64(4200), windowed.PhysicalBytes)
+	require.NotNil(t, windowed.Since)
+}
+
+func TestStats_EmptyRepository(t *testing.T) {
+	stats, err := NewRepositoryInspector().Stats("repo", t.TempDir(), StatsOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, stats.Backups)
+	assert.Zero(t, stats.DedupRatio)
+	assert.Empty(t, stats.BySuffix)
+}
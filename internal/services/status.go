@@ -0,0 +1,230 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"zbwrap/internal/zbackup"
+)
+
+// Repository states reported by Status
+const (
+	RepoOnline  = "online"
+	RepoOffline = "offline"
+)
+
+// DefaultStatusTimeout bounds how long Status waits for a single repository, such as a
+// hung network mount
+const DefaultStatusTimeout = 10 * time.Second
+
+// lastFailureFile records the most recent failed backup, relative to the repository root
+var lastFailureFile = filepath.Join(stateDir, "last_failure.json")
+
+// BackupFailure describes a backup that did not complete
+type BackupFailure struct {
+	Filename string    `json:"filename"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error"`
+	// Hooks records the hooks that ran for the failed backup, including on_failure
+	Hooks []HookResult `json:"hooks,omitempty"`
+}
+
+// RepoStatus is the health summary of one repository
+type RepoStatus struct {
+	Alias string `json:"alias"`
+	Path  string `json:"path"`
+	State string `json:"state"`
+	// Error explains why an offline repository could not be read
+	Error string `json:"error,omitempty"`
+	// Encryption is "none" or "aes-128", as recorded in the repository info file
+	Encryption string `json:"encryption,omitempty"`
+	Backups    int    `json:"backups"`
+	// NewestBackup is the most recent backup that did not fail
+	NewestBackup           string         `json:"newest_backup,omitempty"`
+	NewestBackupDate       *time.Time     `json:"newest_backup_date,omitempty"`
+	NewestBackupAgeSeconds int64          `json:"newest_backup_age_seconds,omitempty"`
+	LastFailure            *BackupFailure `json:"last_failure,omitempty"`
+	// LastCheck is the most recent entry of the check history
+	LastCheck  *CheckSummary    `json:"last_check,omitempty"`
+	SizeBytes  int64            `json:"size_bytes"`
+	Filesystem *FilesystemUsage `json:"filesystem,omitempty"`
+
+	// latest holds the newest backup of each suffix that did not fail
+	latest map[string]BackupItem
+	// failed counts the backups that failed or never completed
+	failed int
+	usage  *RepoUsage
+}
+
+// StatusOptions tunes a status run
+type StatusOptions struct {
+	// Timeout bounds the inspection of each repository; zero uses DefaultStatusTimeout
+	Timeout time.Duration
+	// Now is the reference time for backup ages; zero uses the current time
+	Now time.Time
+}
+
+// Status inspects every repository in parallel, keyed by alias, and returns their
+// summaries sorted by alias. Repositories that are missing, unmounted or too slow to
+// answer are reported as offline rather than failing the whole run.
+func (i *RepositoryInspector) Status(repos map[string]string, opts StatusOptions) []RepoStatus {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultStatusTimeout
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	aliases := make([]string, 0, len(repos))
+	for alias := range repos {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	statuses := make([]RepoStatus, len(aliases))
+	var wg sync.WaitGroup
+	for k, alias := range aliases {
+		wg.Add(1)
+		go func(k int, alias string) {
+			defer wg.Done()
+			statuses[k] = i.statusWithTimeout(alias, repos[alias], opts)
+		}(k, alias)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// statusWithTimeout gives up on a repository that does not answer in time. The
+// inspection keeps running in the background until the filesystem returns.
+func (i *RepositoryInspector) statusWithTimeout(alias, path string, opts StatusOptions) RepoStatus {
+	done := make(chan RepoStatus, 1)
+	go func() { done <- i.repoStatus(alias, path, opts.Now) }()
+
+	select {
+	case status := <-done:
+		return status
+	case <-time.After(opts.Timeout):
+		return RepoStatus{Alias: alias, Path: path, State: RepoOffline, Error: fmt.Sprintf("no answer within %s", opts.Timeout)}
+	}
+}
+
+func (i *RepositoryInspector) repoStatus(alias, path string, now time.Time) RepoStatus {
+	status := RepoStatus{Alias: alias, Path: path, State: RepoOffline}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if !info.IsDir() {
+		status.Error = "not a directory"
+		return status
+	}
+	// An unmounted mount point is usually an empty directory
+	storage, err := zbackup.ReadStorageInfo(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			status.Error = "no zbackup info file; is the repository mounted?"
+		} else {
+			status.Error = err.Error()
+		}
+		return status
+	}
+	status.Encryption = "none"
+	if storage.Encrypted {
+		status.Encryption = "aes-128"
+	}
+
+	details, err := i.Inspect(alias, path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.State = RepoOnline
+	status.SizeBytes = details.TotalSizeBytes
+	status.usage = details.Usage
+	if details.Usage != nil {
+		status.Filesystem = details.Usage.Filesystem
+	}
+
+	// Backups are sorted newest first
+	status.Backups = details.TotalBackups
+	status.latest = make(map[string]BackupItem)
+	for _, b := range details.Backups {
+		if failure := failedBackup(b, now); failure != nil {
+			status.failed++
+			if status.LastFailure == nil || failure.Time.After(status.LastFailure.Time) {
+				status.LastFailure = failure
+			}
+			continue
+		}
+		if _, ok := status.latest[b.Suffix]; !ok {
+			status.latest[b.Suffix] = b
+		}
+		if status.NewestBackup == "" {
+			date := b.Date
+			status.NewestBackup = b.Filename
+			status.NewestBackupDate = &date
+			status.NewestBackupAgeSeconds = int64(now.Sub(date).Seconds())
+		}
+	}
+
+	if recorded, err := LastBackupFailure(path); err == nil && recorded != nil {
+		if status.LastFailure == nil || recorded.Time.After(status.LastFailure.Time) {
+			status.LastFailure = recorded
+		}
+	}
+	if history, err := CheckHistory(path); err == nil && len(history) > 0 {
+		status.LastCheck = &history[len(history)-1]
+	}
+	return status
+}
+
+// failedBackup reports a backup whose sidecar says it failed, or is still in progress
+// long after it started
+func failedBackup(b BackupItem, now time.Time) *BackupFailure {
+	switch {
+	case b.Status == StatusFailed:
+		return &BackupFailure{Filename: b.Filename, Time: b.Date, Error: "sidecar status is failed"}
+	case b.Status == StatusInProgress && now.Sub(b.Date) > staleAge:
+		return &BackupFailure{Filename: b.Filename, Time: b.Date, Error: "backup never completed"}
+	}
+	return nil
+}
+
+// recordBackupFailure remembers a failed backup for status reports. Errors are ignored:
+// the backup error itself is what the caller reports.
+func recordBackupFailure(repoPath, filename string, backupErr error, hooks []HookResult) {
+	failure := BackupFailure{Filename: filename, Time: time.Now(), Error: backupErr.Error(), Hooks: hooks}
+	data, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(repoPath, lastFailureFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	_ = WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// LastBackupFailure returns the most recent failed backup recorded in a repository, or nil
+func LastBackupFailure(repoPath string) (*BackupFailure, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, lastFailureFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var failure BackupFailure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
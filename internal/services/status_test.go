@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryInspector_Status(t *testing.T) {
+	series := copyFixtureRepo(t, "series")
+	encrypted := copyFixtureRepo(t, "encrypted")
+	unmounted := t.TempDir()
+	now := time.Date(2024, 4, 5, 2, 0, 0, 0, time.UTC)
+
+	// A stale in-progress sidecar counts as a failure, and is not the newest backup
+	require.NoError(t, os.WriteFile(filepath.Join(series, "backups", "2024-04-04_0100-nightly.zbk"), []byte("partial"), 0644))
+	require.NoError(t, WriteSidecar(filepath.Join(series, "backups", "2024-04-04_0100-nightly.zbk"+SidecarExt),
+		MetadataSidecar{MimeType: "text/plain", Status: StatusInProgress}))
+	_, err := NewRepositoryInspector().Check("series", series, CheckOptions{})
+	require.NoError(t, err)
+
+	statuses := NewRepositoryInspector().Status(map[string]string{
+		"series":    series,
+		"encrypted": encrypted,
+		"unmounted": unmounted,
+		"missing":   filepath.Join(unmounted, "gone"),
+	}, StatusOptions{Now: now})
+	require.Len(t, statuses, 4)
+	byAlias := make(map[string]RepoStatus)
+	for _, s := range statuses {
+		byAlias[s.Alias] = s
+	}
+	assert.Equal(t, []string{"encrypted", "missing", "series", "unmounted"},
+		[]string{statuses[0].Alias, statuses[1].Alias, statuses[2].Alias, statuses[3].Alias})
+
+	s := byAlias["series"]
+	assert.Equal(t, RepoOnline, s.State)
+	assert.Equal(t, "none", s.Encryption)
+	assert.Equal(t, 5, s.Backups)
+	assert.Equal(t, "2024-04-03_0200-weekly.zbk", s.NewestBackup)
+	assert.Equal(t, int64(48*3600), s.NewestBackupAgeSeconds)
+	require.NotNil(t, s.LastFailure)
+	assert.Equal(t, "2024-04-04_0100-nightly.zbk", s.LastFailure.Filename)
+	require.NotNil(t, s.LastCheck)
+	assert.False(t, s.LastCheck.OK)
+	assert.Greater(t, s.SizeBytes, int64(0))
+
+	assert.Equal(t, RepoOnline, byAlias["encrypted"].State)
+	assert.Equal(t, "aes-128", byAlias["encrypted"].Encryption)
+	assert.Nil(t, byAlias["encrypted"].LastCheck)
+
+	for _, alias := range []string{"unmounted", "missing"} {
+		assert.Equal(t, RepoOffline, byAlias[alias].State, alias)
+		assert.NotEmpty(t, byAlias[alias].Error, alias)
+	}
+}
+
+func TestLastBackupFailure(t *testing.T) {
+	repoDir := t.TempDir()
+
+	failure, err := LastBackupFailure(repoDir)
+	require.NoError(t, err)
+	assert.Nil(t, failure)
+
+	recordBackupFailure(repoDir, "2024-04-01_0100-a.zbk", errors.New("zbackup failed"), nil)
+	recordBackupFailure(repoDir, "2024-04-02_0100-b.zbk", errors.New("input rejected"), nil)
+	failure, err = LastBackupFailure(repoDir)
+	require.NoError(t, err)
+	require.NotNil(t, failure)
+	assert.Equal(t, "2024-04-02_0100-b.zbk", failure.Filename)
+	assert.Equal(t, "input rejected", failure.Error)
+}
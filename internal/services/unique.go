@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"zbwrap/internal/zbackup"
+)
+
+// ChunkUsage is the chunk-level footprint of a backup, read from the repository index
+// and backup files. Byte counts are chunk sizes before bundle compression.
+type ChunkUsage struct {
+	Chunks     int    `json:"chunks"`
+	ChunkBytes uint64 `json:"chunk_bytes"`
+	// UniqueBytes are referenced by no other backup; deleting the backup frees them after zbackup gc
+	UniqueBytes uint64 `json:"unique_bytes"`
+	// SharedPreviousBytes and SharedNextBytes are shared with the previous and next
+	// backup of the same suffix
+	SharedPreviousBytes uint64 `json:"shared_previous_bytes"`
+	SharedNextBytes     uint64 `json:"shared_next_bytes"`
+	Error               string `json:"error,omitempty"`
+}
+
+// ChunkUsage computes the chunk-level footprint of every backup in the repository, keyed by file name.
+// passwordFile is needed for encrypted repositories.
+func (i *RepositoryInspector) ChunkUsage(repoPath, passwordFile string) (map[string]*ChunkUsage, error) {
+	analysis, err := analyzeChunks(repoPath, passwordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*ChunkUsage, len(analysis.Backups))
+	series := make(map[string][]string)
+	for _, b := range analysis.Backups {
+		usage[b.Name] = &ChunkUsage{
+			Chunks:      b.Chunks,
+			ChunkBytes:  b.ChunkBytes,
+			UniqueBytes: b.UniqueBytes,
+			Error:       b.Error,
+		}
+		if b.Error == "" {
+			suffix := BackupSuffix(b.Name)
+			series[suffix] = append(series[suffix], b.Name)
+		}
+	}
+
+	// Names start with the backup date, so each series is already in chronological order
+	for _, names := range series {
+		sort.Strings(names)
+		for k := 1; k < len(names); k++ {
+			shared := analysis.SharedBytes(names[k-1], names[k])
+			usage[names[k-1]].SharedNextBytes = shared
+			usage[names[k]].SharedPreviousBytes = shared
+		}
+	}
+	return usage, nil
+}
+
+// analyzeChunks reads the chunk references of every backup in the repository
+func analyzeChunks(repoPath, passwordFile string) (*zbackup.Analysis, error) {
+	repo, err := zbackup.OpenWithPasswordFile(repoPath, passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	analysis, err := repo.Analyze()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk references: %w", err)
+	}
+	return analysis, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// largestBundles is how many of the largest bundles are reported
+const largestBundles = 10
+
+// ComponentUsage is the space taken by one part of a repository
+type ComponentUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// BundleUsage is the size of a single bundle file
+type BundleUsage struct {
+	ID    string `json:"id"`
+	Bytes int64  `json:"bytes"`
+}
+
+// FilesystemUsage describes the filesystem holding a repository
+type FilesystemUsage struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	FreeBytes      uint64 `json:"free_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+	TotalInodes    uint64 `json:"total_inodes"`
+	FreeInodes     uint64 `json:"free_inodes"`
+}
+
+// RepoUsage breaks the disk usage of a repository down by component
+type RepoUsage struct {
+	Bundles  ComponentUsage `json:"bundles"`
+	Index    ComponentUsage `json:"index"`
+	Backups  ComponentUsage `json:"backups"`
+	Sidecars ComponentUsage `json:"sidecars"`
+	Tmp      ComponentUsage `json:"tmp"`
+	// Other covers the info file, quarantined files and anything else
+	Other          ComponentUsage   `json:"other"`
+	LargestBundles []BundleUsage    `json:"largest_bundles"`
+	Filesystem     *FilesystemUsage `json:"filesystem,omitempty"`
+}
+
+// UsageComponent is a named row of a usage breakdown
+type UsageComponent struct {
+	Component string `json:"component"`
+	Bytes     int64  `json:"bytes"`
+	Files     int    `json:"files"`
+}
+
+// Components lists the breakdown in display order
+func (u *RepoUsage) Components() []UsageComponent {
+	rows := []struct {
+		name  string
+		usage ComponentUsage
+	}{
+		{"bundles", u.Bundles},
+		{"index", u.Index},
+		{"backups", u.Backups},
+		{"sidecars", u.Sidecars},
+		{"tmp", u.Tmp},
+		{"other", u.Other},
+	}
+	components := make([]UsageComponent, 0, len(rows))
+	for _, row := range rows {
+		components = append(components, UsageComponent{Component: row.name, Bytes: row.usage.Bytes, Files: row.usage.Files})
+	}
+	return components
+}
+
+// UsageReport is the result of DiskUsage
+type UsageReport struct {
+	Alias          string    `json:"repository_alias"`
+	PhysicalPath   string    `json:"physical_path"`
+	TotalSizeBytes int64     `json:"total_size_bytes"`
+	Usage          RepoUsage `json:"usage"`
+}
+
+// DiskUsage reports how the space of a repository is split between its components
+func (i *RepositoryInspector) DiskUsage(alias, path string, refresh bool) (*UsageReport, error) {
+	details, err := i.InspectWithOptions(alias, path, InspectOptions{Refresh: refresh})
+	if err != nil {
+		return nil, err
+	}
+	return &UsageReport{
+		Alias:          alias,
+		PhysicalPath:   path,
+		TotalSizeBytes: details.TotalSizeBytes,
+		Usage:          *details.Usage,
+	}, nil
+}
+
+// usage derives the breakdown from a completed scan
+func (s *catalogScan) usage() *RepoUsage {
+	usage := &RepoUsage{LargestBundles: []BundleUsage{}}
+
+	for rel, dir := range s.next.Dirs {
+		files := ComponentUsage{Bytes: dir.FileBytes, Files: dir.Files}
+		switch strings.Split(filepath.ToSlash(rel), "/")[0] {
+		case "bundles":
+			usage.Bundles.add(files)
+			for _, f := range dir.Largest {
+				usage.LargestBundles = append(usage.LargestBundles, BundleUsage{ID: f.Name, Bytes: f.Bytes})
+			}
+		case "index":
+			usage.Index.add(files)
+		case "tmp":
+			usage.Tmp.add(files)
+		case "backups":
+			if rel != "backups" {
+				usage.Other.add(files)
+				continue
+			}
+			// Backup files and sidecars are counted separately; the rest of the directory is other
+			for _, b := range s.next.Backups {
+				usage.Backups.add(ComponentUsage{Bytes: b.Size, Files: 1})
+				files.Bytes -= b.Size
+				files.Files--
+				if b.Sidecar != "" {
+					usage.Sidecars.add(ComponentUsage{Bytes: b.SidecarSize, Files: 1})
+					files.Bytes -= b.SidecarSize
+					files.Files--
+				}
+			}
+			usage.Other.add(files)
+		default:
+			usage.Other.add(files)
+		}
+	}
+
+	sort.Slice(usage.LargestBundles, func(a, b int) bool {
+		if usage.LargestBundles[a].Bytes != usage.LargestBundles[b].Bytes {
+			return usage.LargestBundles[a].Bytes > usage.LargestBundles[b].Bytes
+		}
+		return usage.LargestBundles[a].ID < usage.LargestBundles[b].ID
+	})
+	if len(usage.LargestBundles) > largestBundles {
+		usage.LargestBundles = usage.LargestBundles[:largestBundles]
+	}
+
+	usage.Filesystem = filesystemUsage(s.repoPath)
+	return usage
+}
+
+func (c *ComponentUsage) add(other ComponentUsage) {
+	c.Bytes += other.Bytes
+	c.Files += other.Files
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskUsage_Breakdown(t *testing.T) {
+	repoDir := t.TempDir()
+	write := func(rel string, size int) {
+		path := filepath.Join(repoDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	}
+
+	write("info", 10)
+	write("index/idx1", 20)
+	write("tmp/partial", 30)
+	write("backups/2024-01-01_1000-nightly.zbk", 40)
+	write("backups/2024-01-01_1000-nightly.zbk.meta", 5)
+	write("backups/2024-01-01_1000-nightly.zbk.meta.v0.bak", 3)
+	for i := 1; i <= 12; i++ {
+		write(fmt.Sprintf("bundles/%02x/bundle%02d", i%2, i), 100*i)
+	}
+
+	report, err := NewRepositoryInspector().DiskUsage("repo", repoDir, false)
+	require.NoError(t, err)
+	u := report.Usage
+
+	assert.Equal(t, ComponentUsage{Bytes: 7800, Files: 12}, u.Bundles)
+	assert.Equal(t, ComponentUsage{Bytes: 20, Files: 1}, u.Index)
+	assert.Equal(t, ComponentUsage{Bytes: 30, Files: 1}, u.Tmp)
+	assert.Equal(t, ComponentUsage{Bytes: 40, Files: 1}, u.Backups)
+	assert.Equal(t, ComponentUsage{Bytes: 5, Files: 1}, u.Sidecars)
+	assert.Equal(t, ComponentUsage{Bytes: 13, Files: 2}, u.Other)
+
+	var total int64
+	for _, c := range u.Components() {
+		total += c.Bytes
+	}
+	assert.Equal(t, report.TotalSizeBytes, total)
+
+	require.Len(t, u.LargestBundles, largestBundles)
+	assert.Equal(t, BundleUsage{ID: "bundle12", Bytes: 1200}, u.LargestBundles[0])
+	assert.Equal(t, "bundle03", u.LargestBundles[largestBundles-1].ID)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"zbwrap/internal/zbackup"
+)
+
+// VerifyOptions selects the backups to verify
+type VerifyOptions struct {
+	// Match selects backups, such as a parsed selector; nil selects every backup
+	Match func(BackupItem) bool
+	// PasswordFile is needed for encrypted repositories
+	PasswordFile string
+	// BundleCache bounds the decompressed bundles kept in memory; zero keeps the default
+	BundleCache int
+	// Progress, if set, is called after each backup
+	Progress func(done, total int, item VerifyItem)
+}
+
+// VerifyItem is the outcome of verifying one backup
+type VerifyItem struct {
+	Filename string    `json:"filename"`
+	Suffix   string    `json:"suffix"`
+	Date     time.Time `json:"date"`
+	// LogicalSize and SHA256 describe the restored stream
+	LogicalSize     int64   `json:"logical_size"`
+	SHA256          string  `json:"sha256,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	OK              bool    `json:"ok"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// VerifyReport summarizes a verification run
+type VerifyReport struct {
+	Alias        string       `json:"repository_alias"`
+	PhysicalPath string       `json:"physical_path"`
+	Verified     int          `json:"verified"`
+	Failed       int          `json:"failed"`
+	Items        []VerifyItem `json:"items"`
+}
+
+// Verify restores the selected backups with the native reader and discards the data.
+// A backup passes when the restored stream matches the size and SHA-256 recorded in its
+// backup file and, when present, the logical size and SHA-256 of its sidecar. The run is
+// added to the repository's check history.
+func (i *RepositoryInspector) Verify(alias, repoPath string, opts VerifyOptions) (*VerifyReport, error) {
+	details, err := i.InspectWithOptions(alias, repoPath, InspectOptions{SortBy: SortByDate})
+	if err != nil {
+		return nil, err
+	}
+	repo, err := zbackup.OpenWithPasswordFile(repoPath, opts.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	if opts.BundleCache > 0 {
+		repo.SetBundleCacheSize(opts.BundleCache)
+	}
+
+	var selected []BackupItem
+	for _, b := range details.Backups {
+		if opts.Match == nil || opts.Match(b) {
+			selected = append(selected, b)
+		}
+	}
+
+	report := &VerifyReport{Alias: alias, PhysicalPath: repoPath, Items: []VerifyItem{}}
+	summary := CheckSummary{Command: HistoryVerify, StartedAt: time.Now()}
+	for n, b := range selected {
+		item := verifyBackup(repo, b)
+		if item.OK {
+			report.Verified++
+		} else {
+			report.Failed++
+		}
+		summary.BytesRead += item.LogicalSize
+		report.Items = append(report.Items, item)
+		if opts.Progress != nil {
+			opts.Progress(n+1, len(selected), item)
+		}
+	}
+
+	summary.DurationSeconds = time.Since(summary.StartedAt).Seconds()
+	summary.Backups = len(selected)
+	summary.ProblemCount = report.Failed
+	summary.OK = report.Failed == 0
+	if err := appendCheckHistory(repoPath, summary); err != nil {
+		return nil, fmt.Errorf("failed to record check history: %w", err)
+	}
+	return report, nil
+}
+
+// verifyBackup restores one backup and compares it with its backup file and sidecar
+func verifyBackup(repo *zbackup.Repository, b BackupItem) VerifyItem {
+	item := VerifyItem{Filename: b.Filename, Suffix: b.Suffix, Date: b.Date}
+	started := time.Now()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	err := repo.Restore(b.Filename, counter)
+	item.LogicalSize = counter.n
+	item.DurationSeconds = time.Since(started).Seconds()
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	item.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// Sidecars of older backups and of backups regenerated by sync record neither
+	if meta, _, err := ReadSidecar(filepath.Join(repo.Path, "backups", b.Filename)); err == nil {
+		if meta.LogicalSize > 0 && meta.LogicalSize != item.LogicalSize {
+			item.Error = fmt.Sprintf("restored %d bytes, the sidecar records %d", item.LogicalSize, meta.LogicalSize)
+			return item
+		}
+		if meta.SHA256 != "" && meta.SHA256 != item.SHA256 {
+			item.Error = "sha256 differs from the sidecar"
+			return item
+		}
+	}
+	item.OK = true
+	return item
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"zbwrap/internal/zbackup"
+)
+
+func TestRepositoryInspector_Verify(t *testing.T) {
+	inspector := NewRepositoryInspector()
+
+	t.Run("selected backups", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		var progress []int
+		report, err := inspector.Verify("repo", repoDir, VerifyOptions{
+			Match:    func(b BackupItem) bool { return b.Suffix == "nightly" },
+			Progress: func(done, total int, item VerifyItem) { progress = append(progress, done) },
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, report.Verified)
+		assert.Equal(t, 0, report.Failed)
+		assert.Equal(t, []int{1, 2, 3}, progress)
+		for _, item := range report.Items {
+			assert.True(t, item.OK, item.Filename)
+			assert.Equal(t, "nightly", item.Suffix)
+			assert.Greater(t, item.LogicalSize, int64(0))
+			assert.Len(t, item.SHA256, 64)
+		}
+
+		history, err := CheckHistory(repoDir)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, HistoryVerify, history[0].Command)
+		assert.Equal(t, 3, history[0].Backups)
+		assert.True(t, history[0].OK)
+	})
+
+	t.Run("sidecar mismatch", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		path := filepath.Join(repoDir, "backups", "2024-04-03_0200-weekly.zbk")
+		require.NoError(t, WriteSidecar(SidecarPath(path), MetadataSidecar{Status: StatusSuccess, SHA256: "00"}))
+
+		report, err := inspector.Verify("repo", repoDir, VerifyOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Verified)
+		assert.Equal(t, 1, report.Failed)
+		for _, item := range report.Items {
+			if item.Filename == filepath.Base(path) {
+				assert.False(t, item.OK)
+				assert.Contains(t, item.Error, "sidecar")
+			}
+		}
+
+		history, err := CheckHistory(repoDir)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.False(t, history[0].OK)
+		assert.Equal(t, 1, history[0].ProblemCount)
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		repoDir := copyFixtureRepo(t, "series")
+		repo, err := zbackup.Open(repoDir)
+		require.NoError(t, err)
+		entries, err := repo.IndexEntries()
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.NoError(t, os.Remove(zbackup.BundlePath(repoDir, entry.BundleID)))
+		}
+
+		report, err := inspector.Verify("repo", repoDir, VerifyOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 0, report.Verified)
+		assert.Equal(t, 4, report.Failed)
+		assert.NotEmpty(t, report.Items[0].Error)
+	})
+
+	t.Run("encrypted repository", func(t *testing.T) {
+		passwordFile := filepath.Join("..", "zbackup", "testdata", "encrypted.password")
+		report, err := inspector.Verify("repo", copyFixtureRepo(t, "encrypted"), VerifyOptions{PasswordFile: passwordFile})
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Verified)
+
+		_, err = inspector.Verify("repo", copyFixtureRepo(t, "encrypted"), VerifyOptions{})
+		assert.Error(t, err)
+	})
+}
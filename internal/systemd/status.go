@@ -0,0 +1,102 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// statusProperties are the properties read from the timer and service of each job
+var statusProperties = []string{"Id", "LoadState", "ActiveState", "UnitFileState", "Result",
+	"NextElapseUSecRealtime", "LastTriggerUSec"}
+
+// JobStatus is what systemd reports about the units of a job
+type JobStatus struct {
+	Job   string `json:"job"`
+	Timer string `json:"timer"`
+	// Loaded tells whether systemd knows the timer, i.e. whether its unit file is installed
+	Loaded bool `json:"loaded"`
+	// Enabled is the unit file state of the timer, such as enabled or disabled
+	Enabled string `json:"enabled"`
+	// Active is the state of the timer, active while it is scheduling runs
+	Active  string `json:"active"`
+	NextRun string `json:"next_run,omitempty"`
+	LastRun string `json:"last_run,omitempty"`
+	Service string `json:"service"`
+	// State is the state of the service: inactive, activating while the job runs, or failed
+	State string `json:"state"`
+	// Result is the result of the last run of the service, such as success or exit-code
+	Result string `json:"result"`
+}
+
+// Status asks systemctl, or the service manager of the user with user, about the
+// units of the given jobs
+func Status(systemctl string, user bool, names []string) ([]JobStatus, error) {
+	if len(names) == 0 {
+		return []JobStatus{}, nil
+	}
+	args := []string{"show", "--property=" + strings.Join(statusProperties, ",")}
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	for _, name := range names {
+		args = append(args, TimerName(name), ServiceName(name))
+	}
+
+	cmd := exec.Command(systemctl, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	units := parseShow(out)
+	statuses := make([]JobStatus, 0, len(names))
+	for _, name := range names {
+		timer, service := units[TimerName(name)], units[ServiceName(name)]
+		statuses = append(statuses, JobStatus{
+			Job:     name,
+			Timer:   TimerName(name),
+			Loaded:  timer["LoadState"] == "loaded",
+			Enabled: timer["UnitFileState"],
+			Active:  timer["ActiveState"],
+			NextRun: timer["NextElapseUSecRealtime"],
+			LastRun: timer["LastTriggerUSec"],
+			Service: ServiceName(name),
+			State:   service["ActiveState"],
+			Result:  service["Result"],
+		})
+	}
+	return statuses, nil
+}
+
+// parseShow parses the output of systemctl show: a block of Key=value lines per unit,
+// separated by empty lines. Unset values ("" or "n/a") are left out.
+func parseShow(out []byte) map[string]map[string]string {
+	units := make(map[string]map[string]string)
+	properties := make(map[string]string)
+	flush := func() {
+		if id := properties["Id"]; id != "" {
+			units[id] = properties
+		}
+		properties = make(map[string]string)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && value != "" && value != "n/a" {
+			properties[key] = value
+		}
+	}
+	flush()
+	return units
+}
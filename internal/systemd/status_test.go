@@ -0,0 +1,83 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSystemctl writes a systemctl that records its arguments and prints output
+func fakeSystemctl(t *testing.T, output string) (string, string) {
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	outputPath := filepath.Join(dir, "output")
+	require.NoError(t, os.WriteFile(outputPath, []byte(output), 0644))
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\ncat " + outputPath + "\n"
+	path := filepath.Join(dir, "systemctl")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0755))
+	return path, argsPath
+}
+
+func TestStatus(t *testing.T) {
+	systemctl, argsPath := fakeSystemctl(t, `Id=zbwrap-job-pg.timer
+LoadState=loaded
+ActiveState=active
+UnitFileState=enabled
+Result=success
+NextElapseUSecRealtime=Thu 2024-04-04 01:30:00 UTC
+LastTriggerUSec=Wed 2024-04-03 01:41:12 UTC
+
+Id=zbwrap-job-pg.service
+LoadState=loaded
+ActiveState=failed
+UnitFileState=static
+Result=exit-code
+NextElapseUSecRealtime=
+LastTriggerUSec=
+
+Id=zbwrap-job-home.timer
+LoadState=not-found
+ActiveState=inactive
+UnitFileState=
+Result=success
+NextElapseUSecRealtime=
+LastTriggerUSec=n/a
+
+Id=zbwrap-job-home.service
+LoadState=not-found
+ActiveState=inactive
+UnitFileState=
+Result=success
+`)
+
+	statuses, err := Status(systemctl, true, []string{"pg", "home"})
+	require.NoError(t, err)
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "--user show --property=Id,LoadState,ActiveState,UnitFileState,Result,NextElapseUSecRealtime,LastTriggerUSec "+
+		"zbwrap-job-pg.timer zbwrap-job-pg.service zbwrap-job-home.timer zbwrap-job-home.service\n", string(args))
+
+	assert.Equal(t, []JobStatus{{
+		Job: "pg", Timer: "zbwrap-job-pg.timer", Loaded: true, Enabled: "enabled", Active: "active",
+		NextRun: "Thu 2024-04-04 01:30:00 UTC", LastRun: "Wed 2024-04-03 01:41:12 UTC",
+		Service: "zbwrap-job-pg.service", State: "failed", Result: "exit-code",
+	}, {
+		Job: "home", Timer: "zbwrap-job-home.timer", Active: "inactive",
+		Service: "zbwrap-job-home.service", State: "inactive", Result: "success",
+	}}, statuses)
+}
+
+func TestStatus_Failure(t *testing.T) {
+	dir := t.TempDir()
+	systemctl := filepath.Join(dir, "systemctl")
+	require.NoError(t, os.WriteFile(systemctl, []byte("#!/bin/sh\necho 'Failed to connect to bus' >&2\nexit 1\n"), 0755))
+	_, err := Status(systemctl, false, []string{"pg"})
+	assert.EqualError(t, err, "systemctl failed: exit status 1: Failed to connect to bus")
+
+	statuses, err := Status(systemctl, false, nil)
+	require.NoError(t, err)
+	assert.Empty(t, statuses)
+}
@@ -0,0 +1,254 @@
+// Package systemd writes systemd units that run backup jobs on their schedules, as an
+// alternative to 'zbwrap daemon', and reports the state of those units.
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zbwrap/internal/jobs"
+	"zbwrap/internal/registries"
+)
+
+// unitPrefix starts the names of the units of a job
+const unitPrefix = "zbwrap-job-"
+
+// NotifyUnit is the template unit started when the service of a job fails; its
+// instance is the name of the failed unit
+const NotifyUnit = "zbwrap-notify@.service"
+
+// Options describe how the units run zbwrap
+type Options struct {
+	// Binary is the absolute path of the zbwrap executable
+	Binary string
+	// User generates units for the service manager of the user instead of the system one
+	User bool
+	// Home is the home directory zbwrap reads its registry from. System services get
+	// no HOME of their own, so their units set it.
+	Home string
+	// StateDir holds the registry, the job history and the notification state, which
+	// the job must be able to write
+	StateDir string
+	// ReadWritePaths are further paths the job may write, such as those its source
+	// command writes to
+	ReadWritePaths []string
+}
+
+// Unit is a generated unit file
+type Unit struct {
+	Name    string
+	Content string
+}
+
+// ServiceName returns the name of the service unit of a job
+func ServiceName(job string) string {
+	return unitPrefix + job + ".service"
+}
+
+// TimerName returns the name of the timer unit of a job
+func TimerName(job string) string {
+	return unitPrefix + job + ".timer"
+}
+
+// JobFromUnit returns the job a unit belongs to, or "" for other units
+func JobFromUnit(unit string) string {
+	for _, suffix := range []string{".service", ".timer"} {
+		if strings.HasPrefix(unit, unitPrefix) && strings.HasSuffix(unit, suffix) {
+			return strings.TrimSuffix(strings.TrimPrefix(unit, unitPrefix), suffix)
+		}
+	}
+	return ""
+}
+
+// DefaultDir returns where systemd looks for units written by an administrator, or by
+// the user with user
+func DefaultDir(user bool, home string) string {
+	if user {
+		if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+			return filepath.Join(configHome, "systemd", "user")
+		}
+		return filepath.Join(home, ".config", "systemd", "user")
+	}
+	return "/etc/systemd/system"
+}
+
+// Generate returns the service and timer units of a scheduled job, and the
+// notification unit they report failures to. The service runs 'zbwrap run' in a
+// sandbox in which only the repositories of the job and the state directory are
+// writable; the timer mirrors the schedule and jitter of the job and catches up on
+// runs missed while the machine was off.
+func Generate(registry *registries.LocalRegistry, job registries.Job, opts Options) ([]Unit, error) {
+	if job.Schedule == "" {
+		return nil, fmt.Errorf("job '%s' has no schedule", job.Name)
+	}
+	if problems := jobs.Validate(registry, job); len(problems) > 0 {
+		return nil, fmt.Errorf("job '%s' is invalid: %s", job.Name, strings.Join(problems, "; "))
+	}
+	schedule, err := jobs.ParseSchedule(job.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	var jitter time.Duration
+	if job.Jitter != "" {
+		if jitter, err = time.ParseDuration(job.Jitter); err != nil {
+			return nil, err
+		}
+	}
+
+	// A target that is not mounted must not keep the service from starting: the run
+	// then fails on that target alone and reports it
+	writable := []string{opts.StateDir}
+	required := map[string]bool{filepath.Clean(opts.StateDir): true}
+	for _, alias := range job.Targets {
+		path, _ := registry.Get(alias)
+		writable = append(writable, path)
+	}
+	for _, path := range opts.ReadWritePaths {
+		writable = append(writable, path)
+		required[filepath.Clean(path)] = true
+	}
+
+	return []Unit{
+		{Name: ServiceName(job.Name), Content: serviceUnit(job, writable, required, opts)},
+		{Name: TimerName(job.Name), Content: timerUnit(job, schedule, jitter)},
+		{Name: NotifyUnit, Content: notifyUnit(opts)},
+	}, nil
+}
+
+// serviceUnit writes the service of a job. Writable paths that are not required are
+// prefixed with '-', which makes systemd ignore them when they do not exist.
+func serviceUnit(job registries.Job, writable []string, required map[string]bool, opts Options) string {
+	u := &unitWriter{}
+	u.comment(fmt.Sprintf("Backup job %s, generated by 'zbwrap systemd generate %s'.", job.Name, job.Name))
+	u.comment("Generate it again after changing the job instead of editing it.")
+	u.section("Unit")
+	u.set("Description", "zbwrap backup job "+job.Name)
+	if !opts.User {
+		u.set("Wants", "network-online.target")
+		u.set("After", "network-online.target")
+	}
+	u.set("OnFailure", strings.TrimSuffix(NotifyUnit, ".service")+"%n.service")
+
+	u.section("Service")
+	u.set("Type", "oneshot")
+	u.set("ExecStart", command(opts.Binary, "run", job.Name))
+	if !opts.User {
+		u.set("Environment", quote(escape("HOME="+opts.Home)))
+	}
+	u.set("Nice", "10")
+	u.set("IOSchedulingClass", "idle")
+	u.set("ProtectSystem", "strict")
+	for _, path := range uniquePaths(writable) {
+		if !required[path] {
+			path = "-" + path
+		}
+		u.set("ReadWritePaths", quote(escape(path)))
+	}
+	u.set("PrivateTmp", "true")
+	u.set("NoNewPrivileges", "true")
+	if !opts.User {
+		// These need privileges the service manager of a user does not have
+		u.set("ProtectHome", "read-only")
+		u.set("PrivateDevices", "true")
+		u.set("ProtectKernelTunables", "true")
+		u.set("ProtectKernelModules", "true")
+		u.set("ProtectControlGroups", "true")
+		u.set("RestrictSUIDSGID", "true")
+		u.set("LockPersonality", "true")
+	}
+	return u.String()
+}
+
+func timerUnit(job registries.Job, schedule *jobs.Schedule, jitter time.Duration) string {
+	u := &unitWriter{}
+	u.comment(fmt.Sprintf("Schedule of backup job %s (%s), generated by 'zbwrap systemd generate %s'.",
+		job.Name, job.Schedule, job.Name))
+	u.section("Unit")
+	u.set("Description", "Schedule of zbwrap backup job "+job.Name)
+
+	u.section("Timer")
+	for _, event := range schedule.Calendar() {
+		u.set("OnCalendar", event)
+	}
+	if jitter > 0 {
+		u.set("RandomizedDelaySec", fmt.Sprint(int64((jitter+time.Second-1)/time.Second)))
+	}
+	u.set("Persistent", "true")
+
+	u.section("Install")
+	u.set("WantedBy", "timers.target")
+	return u.String()
+}
+
+func notifyUnit(opts Options) string {
+	u := &unitWriter{}
+	u.comment("Reports failed zbwrap job units, generated by 'zbwrap systemd generate'.")
+	u.section("Unit")
+	u.set("Description", "zbwrap failure notification for %i")
+
+	u.section("Service")
+	u.set("Type", "oneshot")
+	if opts.User {
+		u.set("ExecStart", command(opts.Binary, "notify", "unit-failed", "--user", "%i"))
+	} else {
+		u.set("ExecStart", command(opts.Binary, "notify", "unit-failed", "%i"))
+		u.set("Environment", quote(escape("HOME="+opts.Home)))
+	}
+	return u.String()
+}
+
+// unitWriter builds a unit file
+type unitWriter struct {
+	strings.Builder
+}
+
+func (u *unitWriter) comment(text string) {
+	u.WriteString("# " + text + "\n")
+}
+
+func (u *unitWriter) section(name string) {
+	u.WriteString("\n[" + name + "]\n")
+}
+
+func (u *unitWriter) set(key, value string) {
+	u.WriteString(key + "=" + value + "\n")
+}
+
+// command formats a command line for ExecStart=. The arguments other than the binary
+// are passed as they are, so that they may use specifiers such as %i.
+func command(binary string, args ...string) string {
+	return strings.Join(append([]string{quote(strings.ReplaceAll(escape(binary), "$", "$$"))}, args...), " ")
+}
+
+// escape keeps systemd from expanding specifiers in a value
+func escape(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// quote puts a value containing spaces or quotes in double quotes
+func quote(value string) string {
+	if !strings.ContainsAny(value, " \t\"'\\") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// uniquePaths returns the cleaned paths without duplicates, in order
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,97 @@
+package systemd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zbwrap/internal/registries"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden unit files")
+
+func testRegistry() *registries.LocalRegistry {
+	registry := registries.NewLocalRegistry()
+	registry.Repositories = map[string]string{
+		"local":   "/srv/backups/local",
+		"offsite": "/mnt/offsite/zbackup",
+	}
+	registry.Jobs = []registries.Job{{
+		Name:      "pg",
+		Source:    registries.JobSource{Command: "pg_dumpall"},
+		Targets:   []string{"local", "offsite"},
+		Schedule:  "30 1 * * *",
+		Jitter:    "15m",
+		CatchUp:   true,
+		Retention: &registries.JobRetention{KeepLast: 14},
+	}, {
+		Name:     "home",
+		Source:   registries.JobSource{Paths: []string{"/home/alice"}},
+		Targets:  []string{"local"},
+		Schedule: "0 12 1 * mon",
+	}}
+	return registry
+}
+
+func TestGenerate_Golden(t *testing.T) {
+	registry := testRegistry()
+	variants := map[string]Options{
+		"system": {Binary: "/usr/local/bin/zbwrap", Home: "/root", StateDir: "/root/.config/zbwrap",
+			ReadWritePaths: []string{"/var/lib/dumps", "/srv/backups/local/"}},
+		"user": {Binary: "/home/alice/bin/zbwrap", User: true, Home: "/home/alice", StateDir: "/home/alice/.config/zbwrap"},
+	}
+	for variant, opts := range variants {
+		for _, job := range registry.Jobs {
+			units, err := Generate(registry, job, opts)
+			require.NoError(t, err)
+			require.Len(t, units, 3)
+			for _, unit := range units {
+				t.Run(variant+"/"+unit.Name, func(t *testing.T) {
+					goldenPath := filepath.Join("testdata", variant, unit.Name+".golden")
+					if *update {
+						require.NoError(t, os.MkdirAll(filepath.Dir(goldenPath), 0755))
+						require.NoError(t, os.WriteFile(goldenPath, []byte(unit.Content), 0644))
+					}
+					want, err := os.ReadFile(goldenPath)
+					require.NoError(t, err)
+					assert.Equal(t, string(want), unit.Content)
+				})
+			}
+		}
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	registry := testRegistry()
+
+	manual := registry.Jobs[0]
+	manual.Schedule, manual.Jitter, manual.CatchUp = "", "", false
+	_, err := Generate(registry, manual, Options{})
+	assert.EqualError(t, err, "job 'pg' has no schedule")
+
+	unknown := registry.Jobs[0]
+	unknown.Targets = []string{"nowhere"}
+	_, err = Generate(registry, unknown, Options{})
+	assert.ErrorContains(t, err, "job 'pg' is invalid: ")
+}
+
+func TestGenerate_Escaping(t *testing.T) {
+	registry := testRegistry()
+	registry.Repositories["local"] = "/srv/my backups/100%"
+	units, err := Generate(registry, registry.Jobs[1], Options{Binary: "/opt/zb $wrap/zbwrap", Home: "/root", StateDir: "/root/.config/zbwrap"})
+	require.NoError(t, err)
+	assert.Contains(t, units[0].Content, "ExecStart=\"/opt/zb $$wrap/zbwrap\" run home\n")
+	// Targets that may be missing are prefixed with - inside the quotes, where systemd looks for it
+	assert.Contains(t, units[0].Content, "ReadWritePaths=\"-/srv/my backups/100%%\"\n")
+}
+
+func TestJobFromUnit(t *testing.T) {
+	assert.Equal(t, "pg", JobFromUnit(ServiceName("pg")))
+	assert.Equal(t, "db.main", JobFromUnit(TimerName("db.main")))
+	assert.Equal(t, "", JobFromUnit("sshd.service"))
+	assert.Equal(t, "", JobFromUnit(NotifyUnit))
+}
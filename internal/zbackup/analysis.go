@@ -0,0 +1,164 @@
+package zbackup
+
+// BackupChunks describes the chunks referenced by one backup
+type BackupChunks struct {
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"`
+	Iterations uint32 `json:"iterations"`
+	// ChunkRefs counts chunk references, including repeats and instruction stream chunks
+	ChunkRefs int `json:"chunk_refs"`
+	// Chunks and ChunkBytes count distinct chunks
+	Chunks      int    `json:"chunks"`
+	ChunkBytes  uint64 `json:"chunk_bytes"`
+	InlineBytes uint64 `json:"inline_bytes"`
+	// UniqueChunks are referenced by no other backup; deleting the backup frees UniqueBytes
+	UniqueChunks int    `json:"unique_chunks"`
+	UniqueBytes  uint64 `json:"unique_bytes"`
+	// MissingChunks are referenced but not listed in the index
+	MissingChunks int    `json:"missing_chunks"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Analysis is the chunk-level view of a repository
+type Analysis struct {
+	IndexedChunks      int            `json:"indexed_chunks"`
+	IndexedBytes       uint64         `json:"indexed_bytes"`
+	ReferencedChunks   int            `json:"referenced_chunks"`
+	UnreferencedChunks int            `json:"unreferenced_chunks"`
+	UnreferencedBytes  uint64         `json:"unreferenced_bytes"`
+	Backups            []BackupChunks `json:"backups"`
+
+	index  map[ChunkID]ChunkLocation
+	sets   map[string]map[ChunkID]int
+	owners map[ChunkID]int
+}
+
+// Analyze counts the chunks referenced by every backup, and those unique to each.
+// Chunk sizes come from the index, so only iterated backups need bundles to be read.
+// A backup that cannot be read is reported with an error; its chunks count as unreferenced.
+func (r *Repository) Analyze() (*Analysis, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	names, err := r.ListBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{
+		IndexedChunks: len(index),
+		Backups:       []BackupChunks{},
+		index:         index,
+		sets:          make(map[string]map[ChunkID]int),
+	}
+	for _, loc := range index {
+		analysis.IndexedBytes += uint64(loc.Size)
+	}
+
+	sets := make([]map[ChunkID]int, len(names))
+	owners := make(map[ChunkID]int)
+	for i, name := range names {
+		backup := BackupChunks{Name: name}
+		refs, err := r.ReadBackupRefs(name)
+		if err != nil {
+			backup.Error = err.Error()
+			analysis.Backups = append(analysis.Backups, backup)
+			continue
+		}
+
+		set := make(map[ChunkID]int, len(refs.Chunks)+len(refs.MetaChunks))
+		for id, n := range refs.Chunks {
+			set[id] += n
+		}
+		for id, n := range refs.MetaChunks {
+			set[id] += n
+		}
+		sets[i] = set
+		analysis.sets[name] = set
+
+		backup.Size = refs.Info.Size
+		backup.Iterations = refs.Info.Iterations
+		backup.InlineBytes = refs.InlineBytes
+		for id, n := range set {
+			backup.ChunkRefs += n
+			backup.Chunks++
+			owners[id]++
+			if loc, ok := index[id]; ok {
+				backup.ChunkBytes += uint64(loc.Size)
+			} else {
+				backup.MissingChunks++
+			}
+		}
+		analysis.Backups = append(analysis.Backups, backup)
+	}
+
+	for i := range analysis.Backups {
+		for id := range sets[i] {
+			if owners[id] != 1 {
+				continue
+			}
+			analysis.Backups[i].UniqueChunks++
+			if loc, ok := index[id]; ok {
+				analysis.Backups[i].UniqueBytes += uint64(loc.Size)
+			}
+		}
+	}
+
+	for id, loc := range index {
+		if owners[id] > 0 {
+			analysis.ReferencedChunks++
+			continue
+		}
+		analysis.UnreferencedChunks++
+		analysis.UnreferencedBytes += uint64(loc.Size)
+	}
+	analysis.owners = owners
+	return analysis, nil
+}
+
+// SharedBytes returns the size of the chunks referenced by both backups
+func (a *Analysis) SharedBytes(x, y string) uint64 {
+	setX, setY := a.sets[x], a.sets[y]
+	if len(setY) < len(setX) {
+		setX, setY = setY, setX
+	}
+	var shared uint64
+	for id := range setX {
+		if _, ok := setY[id]; !ok {
+			continue
+		}
+		if loc, ok := a.index[id]; ok {
+			shared += uint64(loc.Size)
+		}
+	}
+	return shared
+}
+
+// FreedChunks returns the chunks that no backup would reference once the named
+// backups are deleted, and their total size. zbackup releases them on the next gc.
+func (a *Analysis) FreedChunks(names []string) (int, uint64) {
+	refs := make(map[ChunkID]int)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		for id := range a.sets[name] {
+			refs[id]++
+		}
+	}
+	var chunks int
+	var size uint64
+	for id, n := range refs {
+		if a.owners[id] != n {
+			continue
+		}
+		chunks++
+		if loc, ok := a.index[id]; ok {
+			size += uint64(loc.Size)
+		}
+	}
+	return chunks, size
+}
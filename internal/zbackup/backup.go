@@ -0,0 +1,120 @@
+package zbackup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+)
+
+// BackupInfo is the content of a backup file
+type BackupInfo struct {
+	// Data is the serialized instruction stream. When Iterations is above zero,
+	// restoring Data yields the instruction stream of the next level down.
+	Data       []byte
+	Iterations uint32
+	// Size and SHA256 describe the restored backup
+	Size   uint64
+	SHA256 []byte
+	// Time is the Unix time of the backup, when recorded
+	Time int64
+}
+
+// Instruction is a single step of a backup's instruction stream: emit a chunk,
+// emit literal bytes, or both in that order
+type Instruction struct {
+	HasChunk bool
+	Chunk    ChunkID
+	Bytes    []byte
+}
+
+// ReadBackupFile parses a backup file of an unencrypted repository
+func ReadBackupFile(path string) (*BackupInfo, error) {
+	return readBackupFile(path, nil)
+}
+
+// readBackupFile parses a backup file, decrypting it with key when set
+func readBackupFile(path string, key *Key) (*BackupInfo, error) {
+	f, err := openFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version, err := f.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if version != FileFormatVersion {
+		return nil, fmt.Errorf("unsupported backup file version %d", version)
+	}
+
+	data, err := f.readMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup info: %w", err)
+	}
+
+	info := &BackupInfo{}
+	err = decodeFields(data, func(fl field) error {
+		switch fl.num {
+		case 1:
+			info.Data = fl.bytes
+		case 2:
+			info.Iterations = uint32(fl.varint)
+		case 3:
+			info.Size = fl.varint
+		case 4:
+			info.SHA256 = fl.bytes
+		case 5:
+			info.Time = int64(fl.varint)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse backup info: %w", err)
+	}
+
+	if err := f.checkAdler32(); err != nil {
+		return nil, fmt.Errorf("backup file %s: %w", filepath.Base(path), err)
+	}
+	return info, nil
+}
+
+// ParseInstructions decodes a serialized instruction stream
+func ParseInstructions(data []byte) ([]Instruction, error) {
+	var instructions []Instruction
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, fmt.Errorf("failed to read backup instruction: %w", errTruncated)
+		}
+		instr, err := parseInstruction(data[n : n+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		data = data[n+int(size):]
+		instructions = append(instructions, instr)
+	}
+	return instructions, nil
+}
+
+// parseInstruction decodes a single BackupInstruction message
+func parseInstruction(msg []byte) (Instruction, error) {
+	var instr Instruction
+	err := decodeFields(msg, func(fl field) error {
+		switch fl.num {
+		case 1:
+			if len(fl.bytes) != IDSize {
+				return fmt.Errorf("invalid chunk id length %d", len(fl.bytes))
+			}
+			instr.HasChunk = true
+			copy(instr.Chunk[:], fl.bytes)
+		case 2:
+			instr.Bytes = fl.bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return instr, fmt.Errorf("failed to parse backup instruction: %w", err)
+	}
+	return instr, nil
+}
@@ -0,0 +1,144 @@
+package zbackup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Bundle payload compression methods
+const (
+	CompressionLZMA = "lzma"
+	CompressionLZO  = "lzo1x_1"
+)
+
+// ErrUnsupportedCompression is returned for bundles whose payload cannot be decompressed
+var ErrUnsupportedCompression = errors.New("unsupported bundle compression method")
+
+// Bundle is a decompressed bundle: the chunks it stores, concatenated in the order of its records
+type Bundle struct {
+	CompressionMethod string
+	Chunks            []ChunkRecord
+	Payload           []byte
+	// spans maps each chunk to its [start, end) range in Payload
+	spans map[ChunkID][2]int
+}
+
+// Chunk returns the data of a chunk stored in the bundle
+func (b *Bundle) Chunk(id ChunkID) ([]byte, bool) {
+	span, ok := b.spans[id]
+	if !ok {
+		return nil, false
+	}
+	return b.Payload[span[0]:span[1]], true
+}
+
+// CorruptChunks returns the chunks whose data does not match the SHA-1 half of their id
+func (b *Bundle) CorruptChunks() []ChunkID {
+	var corrupt []ChunkID
+	for _, c := range b.Chunks {
+		data, _ := b.Chunk(c.ID)
+		if !c.ID.Matches(data) {
+			corrupt = append(corrupt, c.ID)
+		}
+	}
+	return corrupt
+}
+
+// ReadBundleInfo reads the chunk records of a bundle without decompressing its payload
+func ReadBundleInfo(path string) ([]ChunkRecord, string, error) {
+	f, err := openFile(path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	return readBundleInfo(f, path)
+}
+
+// readBundleInfo reads the header and chunk records at the start of a bundle file
+func readBundleInfo(f *fileReader, path string) ([]ChunkRecord, string, error) {
+	version, method, err := f.readBundleHeader()
+	if err != nil {
+		return nil, "", err
+	}
+	if version != FileFormatVersion {
+		return nil, "", fmt.Errorf("unsupported bundle file version %d", version)
+	}
+
+	data, err := f.readMessage()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read bundle info: %w", err)
+	}
+	chunks, err := parseBundleInfo(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := f.checkAdler32(); err != nil {
+		return nil, "", fmt.Errorf("bundle %s: %w", filepath.Base(path), err)
+	}
+	return chunks, method, nil
+}
+
+// ReadBundle reads and decompresses a bundle of an unencrypted repository
+func ReadBundle(path string) (*Bundle, error) {
+	return readBundle(path, nil)
+}
+
+// readBundle reads and decompresses a bundle, decrypting it with key when set
+func readBundle(path string, key *Key) (*Bundle, error) {
+	f, err := openFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunks, method, err := readBundleInfo(f, path)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := f.readTrailer()
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", filepath.Base(path), err)
+	}
+
+	spans := make(map[ChunkID][2]int, len(chunks))
+	size := 0
+	for _, c := range chunks {
+		spans[c.ID] = [2]int{size, size + int(c.Size)}
+		size += int(c.Size)
+	}
+	payload, err := decompress(method, compressed, size)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", filepath.Base(path), err)
+	}
+	return &Bundle{CompressionMethod: method, Chunks: chunks, Payload: payload, spans: spans}, nil
+}
+
+// decompress expands a bundle payload of the given uncompressed size
+func decompress(method string, data []byte, size int) ([]byte, error) {
+	switch method {
+	case CompressionLZMA:
+		// zbackup's lzma method writes a single .xz stream
+		r, err := xz.ReaderConfig{SingleStream: true}.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lzma payload: %w", err)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, fmt.Errorf("invalid lzma payload: %w", err)
+		}
+		if n, _ := io.Copy(io.Discard, r); n != 0 {
+			return nil, fmt.Errorf("lzma payload is %d bytes longer than its chunks", n)
+		}
+		return payload, nil
+	case CompressionLZO:
+		return decompressLZO(data, size)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, method)
+}
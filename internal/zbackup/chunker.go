@@ -0,0 +1,232 @@
+package zbackup
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// rollingMultiplier is the base of zbackup's polynomial rolling hash
+const rollingMultiplier = 257
+
+// rollingHash is zbackup's RollingHash: a polynomial hash modulo 2^64 over a window of
+// bytes, which are treated as signed chars like in the C++ implementation
+type rollingHash struct {
+	factor     uint64
+	nextFactor uint64
+	value      uint64
+	count      int
+}
+
+func newRollingHash() *rollingHash {
+	h := &rollingHash{}
+	h.reset()
+	return h
+}
+
+func (h *rollingHash) reset() {
+	h.factor, h.nextFactor, h.value, h.count = 0, 1, 0, 0
+}
+
+// rollIn appends a byte to the window
+func (h *rollingHash) rollIn(c byte) {
+	h.factor = h.nextFactor
+	h.nextFactor *= rollingMultiplier
+	h.value = h.value*rollingMultiplier + uint64(int8(c))
+	h.count++
+}
+
+// rotate drops out from the start of the window and appends in
+func (h *rollingHash) rotate(in, out byte) {
+	h.value = (h.value-uint64(int8(out))*h.factor)*rollingMultiplier + uint64(int8(in))
+}
+
+func (h *rollingHash) digest() uint64 {
+	return h.value + h.nextFactor
+}
+
+// NewChunkID computes the id zbackup gives a chunk: the first 16 bytes of its SHA-1
+// followed by its rolling hash in little-endian order
+func NewChunkID(data []byte) ChunkID {
+	h := newRollingHash()
+	for _, c := range data {
+		h.rollIn(c)
+	}
+	return newChunkID(data, h.digest())
+}
+
+func newChunkID(data []byte, rolling uint64) ChunkID {
+	var id ChunkID
+	sum := sha1.Sum(data)
+	copy(id[:16], sum[:16])
+	binary.LittleEndian.PutUint64(id[16:], rolling)
+	return id
+}
+
+// Estimate is what storing a stream would add to a repository
+type Estimate struct {
+	InputBytes uint64 `json:"input_bytes"`
+	Chunks     int    `json:"chunks"`
+	// NewChunks and NewBytes are not in the repository yet and would be stored; sizes are
+	// before bundle compression. Chunks repeated within the stream are counted once.
+	NewChunks int    `json:"new_chunks"`
+	NewBytes  uint64 `json:"new_bytes"`
+	// DuplicateChunks and DuplicateBytes are already stored
+	DuplicateChunks int    `json:"duplicate_chunks"`
+	DuplicateBytes  uint64 `json:"duplicate_bytes"`
+}
+
+// DuplicateShare is the part of the input that deduplicates against the repository or
+// earlier in the stream, between 0 and 1
+func (e *Estimate) DuplicateShare() float64 {
+	if e.InputBytes == 0 {
+		return 0
+	}
+	return float64(e.DuplicateBytes) / float64(e.InputBytes)
+}
+
+// Estimate splits a stream into chunks the way zbackup's BackupCreator does and looks them
+// up in the index, without writing anything. A window of chunk_max_size bytes slides over
+// the input; whenever its rolling hash and SHA-1 match a stored chunk, the bytes before
+// the window become a new chunk and the window becomes a reference. Bytes that match
+// nothing are cut into chunks of chunk_max_size.
+func (r *Repository) Estimate(src io.Reader) (*Estimate, error) {
+	if r.Info.ChunkMaxSize == 0 {
+		return nil, errors.New("repository has no chunk_max_size")
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	e := newEstimator(index, int(r.Info.ChunkMaxSize))
+
+	in := bufio.NewReader(src)
+	for {
+		b, err := in.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+		e.add(b)
+	}
+	e.finish()
+	return &e.result, nil
+}
+
+// estimator keeps the state of BackupCreator: a ring buffer holding the window and the
+// bytes that fell out of it, waiting to be saved as a chunk
+type estimator struct {
+	max     int
+	known   map[ChunkID]bool
+	rolling map[uint64]bool
+
+	hash    *rollingHash
+	window  []byte // ring buffer of max bytes
+	head    int    // oldest byte of a full window
+	pending []byte
+
+	result Estimate
+}
+
+func newEstimator(index map[ChunkID]ChunkLocation, max int) *estimator {
+	e := &estimator{
+		max:     max,
+		known:   make(map[ChunkID]bool, len(index)),
+		rolling: make(map[uint64]bool, len(index)),
+		hash:    newRollingHash(),
+		window:  make([]byte, 0, max),
+		pending: make([]byte, 0, max),
+	}
+	for id := range index {
+		e.remember(id)
+	}
+	return e
+}
+
+func (e *estimator) remember(id ChunkID) {
+	e.known[id] = true
+	e.rolling[binary.LittleEndian.Uint64(id[16:])] = true
+}
+
+// add feeds one byte of input
+func (e *estimator) add(b byte) {
+	e.result.InputBytes++
+	if len(e.window) < e.max {
+		e.window = append(e.window, b)
+		e.hash.rollIn(b)
+	} else {
+		out := e.window[e.head]
+		e.pending = append(e.pending, out)
+		if len(e.pending) == e.max {
+			e.save(e.pending)
+		}
+		e.window[e.head] = b
+		e.head = (e.head + 1) % e.max
+		e.hash.rotate(b, out)
+	}
+	if len(e.window) == e.max {
+		e.match()
+	}
+}
+
+// match turns the window into a chunk reference when it is a stored chunk
+func (e *estimator) match() {
+	digest := e.hash.digest()
+	if !e.rolling[digest] {
+		return
+	}
+	data := e.windowData()
+	id := newChunkID(data, digest)
+	if !e.known[id] {
+		return
+	}
+	if len(e.pending) > 0 {
+		e.save(e.pending)
+	}
+	e.result.Chunks++
+	e.result.DuplicateChunks++
+	e.result.DuplicateBytes += uint64(len(data))
+	e.window = e.window[:0]
+	e.head = 0
+	e.hash.reset()
+}
+
+// windowData returns the window contents in input order
+func (e *estimator) windowData() []byte {
+	data := make([]byte, 0, len(e.window))
+	data = append(data, e.window[e.head:]...)
+	return append(data, e.window[:e.head]...)
+}
+
+// save records a chunk cut from the input, which zbackup stores unless its id is known
+func (e *estimator) save(data []byte) {
+	id := NewChunkID(data)
+	e.result.Chunks++
+	if e.known[id] {
+		e.result.DuplicateChunks++
+		e.result.DuplicateBytes += uint64(len(data))
+	} else {
+		e.result.NewChunks++
+		e.result.NewBytes += uint64(len(data))
+		e.remember(id)
+	}
+	e.pending = e.pending[:0]
+}
+
+// finish saves what is left: the pending bytes and the window, as one chunk or, when they
+// exceed chunk_max_size, as a full chunk and the rest
+func (e *estimator) finish() {
+	rest := append(e.pending, e.windowData()...)
+	if len(rest) > e.max {
+		e.save(rest[:e.max])
+		rest = rest[e.max:]
+	}
+	if len(rest) > 0 {
+		e.save(rest)
+	}
+}
@@ -0,0 +1,79 @@
+package zbackup
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollingHash_RotateMatchesRollIn(t *testing.T) {
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1)).Read(data)
+	const window = 64
+
+	rotating := newRollingHash()
+	for _, c := range data[:window] {
+		rotating.rollIn(c)
+	}
+	for start := 1; start+window <= len(data); start++ {
+		rotating.rotate(data[start+window-1], data[start-1])
+
+		fresh := newRollingHash()
+		for _, c := range data[start : start+window] {
+			fresh.rollIn(c)
+		}
+		require.Equal(t, fresh.digest(), rotating.digest(), "window at %d", start)
+	}
+}
+
+func TestRepository_Estimate(t *testing.T) {
+	repo, err := Open(filepath.Join("testdata", "chunked"))
+	require.NoError(t, err)
+	input, err := os.ReadFile(filepath.Join("testdata", "chunked.input"))
+	require.NoError(t, err)
+
+	t.Run("stored stream", func(t *testing.T) {
+		estimate, err := repo.Estimate(bytes.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, Estimate{InputBytes: 1000, Chunks: 16, DuplicateChunks: 16, DuplicateBytes: 1000}, *estimate)
+		assert.Equal(t, 1.0, estimate.DuplicateShare())
+	})
+
+	t.Run("inserted bytes shift the chunk boundaries", func(t *testing.T) {
+		shifted := append([]byte("0123456789"), input[:500]...)
+		shifted = append(shifted, "inserted"...)
+		shifted = append(shifted, input[500:]...)
+
+		estimate, err := repo.Estimate(bytes.NewReader(shifted))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1018), estimate.InputBytes)
+		// The prefix becomes a chunk of its own; the chunk the insertion lands in and the
+		// inserted bytes are new, everything after it is found again by the window
+		assert.Equal(t, 3, estimate.NewChunks)
+		assert.Equal(t, uint64(10+64+8), estimate.NewBytes)
+		assert.Equal(t, estimate.InputBytes, estimate.NewBytes+estimate.DuplicateBytes)
+	})
+
+	t.Run("new data repeated within the stream", func(t *testing.T) {
+		block := make([]byte, 256)
+		rand.New(rand.NewSource(7)).Read(block)
+		estimate, err := repo.Estimate(bytes.NewReader(bytes.Repeat(block, 4)))
+		require.NoError(t, err)
+		assert.Equal(t, 4, estimate.NewChunks)
+		assert.Equal(t, uint64(256), estimate.NewBytes)
+		assert.Equal(t, uint64(768), estimate.DuplicateBytes)
+		assert.InDelta(t, 0.75, estimate.DuplicateShare(), 0.001)
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		estimate, err := repo.Estimate(bytes.NewReader(nil))
+		require.NoError(t, err)
+		assert.Equal(t, Estimate{}, *estimate)
+		assert.Equal(t, 0.0, estimate.DuplicateShare())
+	})
+}
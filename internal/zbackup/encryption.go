@@ -0,0 +1,133 @@
+package zbackup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeySize is the size of the AES-128 repository key and of the key derived from the password
+const KeySize = 16
+
+// ErrWrongPassword is returned when the password does not decrypt the repository key
+var ErrWrongPassword = errors.New("wrong repository password")
+
+// errCorrupt is returned when a file does not decrypt to correctly padded data
+var errCorrupt = errors.New("file does not decrypt: corrupt or encrypted with another key")
+
+// EncryptionKeyInfo is the password-protected repository key stored in the info file
+type EncryptionKeyInfo struct {
+	Salt         []byte
+	Rounds       uint32
+	EncryptedKey []byte
+	// KeyCheckHMAC is the HMAC-SHA1 of KeyCheckInput under the decrypted key
+	KeyCheckInput []byte
+	KeyCheckHMAC  []byte
+}
+
+// Key is a decrypted repository key
+type Key struct {
+	block cipher.Block
+}
+
+// DecryptKey derives a key from the password with PBKDF2-HMAC-SHA1, uses it to decrypt
+// the repository key and verifies the result against the stored HMAC, as zbackup does
+func DecryptKey(info *EncryptionKeyInfo, password []byte) (*Key, error) {
+	if len(info.EncryptedKey) != KeySize {
+		return nil, fmt.Errorf("invalid encrypted key length %d", len(info.EncryptedKey))
+	}
+	derived, err := aes.NewCipher(pbkdf2SHA1(password, info.Salt, int(info.Rounds), KeySize))
+	if err != nil {
+		return nil, err
+	}
+	// A single block under a zero IV, i.e. plain AES
+	key := make([]byte, KeySize)
+	derived.Decrypt(key, info.EncryptedKey)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(info.KeyCheckInput)
+	if !hmac.Equal(mac.Sum(nil), info.KeyCheckHMAC) {
+		return nil, ErrWrongPassword
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{block: block}, nil
+}
+
+// ReadPasswordFile reads a password the way zbackup's --password-file does: the whole
+// file, less a single trailing newline
+func ReadPasswordFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+// decrypt returns the plain text of an encrypted file. zbackup encrypts whole files with
+// AES-128-CBC under a zero IV, starting with a random block that serves as the real IV
+// and ending with PKCS#7 padding.
+func (k *Key) decrypt(data []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errCorrupt
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(k.block, make([]byte, aes.BlockSize)).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errCorrupt
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errCorrupt
+		}
+	}
+	return plain[aes.BlockSize : len(plain)-pad], nil
+}
+
+// encrypt is the inverse of decrypt
+func (k *Key) encrypt(plain []byte) ([]byte, error) {
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := make([]byte, aes.BlockSize, aes.BlockSize+len(plain)+pad)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	data = append(data, plain...)
+	data = append(data, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(k.block, make([]byte, aes.BlockSize)).CryptBlocks(data, data)
+	return data, nil
+}
+
+// pbkdf2SHA1 implements PBKDF2 (RFC 8018) with HMAC-SHA1
+func pbkdf2SHA1(password, salt []byte, rounds, size int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < rounds; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:size]
+}
@@ -0,0 +1,166 @@
+package zbackup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileFormatVersion is the only file format version understood by this package
+const FileFormatVersion = 1
+
+// ErrChecksum is returned when an Adler-32 checksum stored in a file does not match its contents
+var ErrChecksum = errors.New("adler32 checksum mismatch")
+
+// fileReader reads the varint-delimited protobuf messages zbackup stores in its files,
+// keeping a running Adler-32 of every byte consumed so far.
+type fileReader struct {
+	r     *bufio.Reader
+	sum   hash.Hash32
+	close func() error
+}
+
+// openFile opens a zbackup file for reading. Files of encrypted repositories are
+// decrypted in memory with key; a nil key reads the file as plain text.
+func openFile(path string, key *Key) (*fileReader, error) {
+	if key != nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := key.decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		return &fileReader{r: bufio.NewReader(bytes.NewReader(plain)), sum: adler32.New()}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader{r: bufio.NewReader(f), sum: adler32.New(), close: f.Close}, nil
+}
+
+// Close releases the underlying file
+func (f *fileReader) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
+
+// Read implements io.Reader over the remaining plain text, feeding the checksum
+func (f *fileReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.sum.Write(p[:n])
+	return n, err
+}
+
+// readFull reads exactly len(p) bytes
+func (f *fileReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(f, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadByte implements io.ByteReader for varint decoding
+func (f *fileReader) ReadByte() (byte, error) {
+	b, err := f.r.ReadByte()
+	if err == nil {
+		f.sum.Write([]byte{b})
+	}
+	return b, err
+}
+
+// readMessage reads a single varint32-length-prefixed message
+func (f *fileReader) readMessage() ([]byte, error) {
+	size, err := binary.ReadUvarint(f)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if size > 1<<30 {
+		return nil, fmt.Errorf("message size %d is too large", size)
+	}
+	buf := make([]byte, size)
+	if err := f.readFull(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readHeader reads the leading FileHeader and returns its version
+func (f *fileReader) readHeader() (uint32, error) {
+	version, _, err := f.readBundleHeader()
+	return version, err
+}
+
+// readBundleHeader reads a BundleFileHeader, which extends FileHeader with the
+// compression method of the bundle payload
+func (f *fileReader) readBundleHeader() (uint32, string, error) {
+	data, err := f.readMessage()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read file header: %w", err)
+	}
+	var version uint32
+	method := CompressionLZMA
+	err = decodeFields(data, func(fl field) error {
+		switch {
+		case fl.num == 1 && fl.wireType == wireVarint:
+			version = uint32(fl.varint)
+		case fl.num == 2 && fl.wireType == wireBytes:
+			method = string(fl.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to parse file header: %w", err)
+	}
+	return version, method, nil
+}
+
+// readTrailer reads the rest of the file, which ends with the Adler-32 of everything
+// before it, and returns the data preceding the checksum
+func (f *fileReader) readTrailer() ([]byte, error) {
+	rest, err := io.ReadAll(f.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < 4 {
+		return nil, fmt.Errorf("failed to read checksum: %w", io.ErrUnexpectedEOF)
+	}
+	data, stored := rest[:len(rest)-4], rest[len(rest)-4:]
+	f.sum.Write(data)
+	if binary.LittleEndian.Uint32(stored) != f.sum.Sum32() {
+		return nil, ErrChecksum
+	}
+	return data, nil
+}
+
+// checkAdler32 verifies the little-endian Adler-32 of everything consumed so far.
+// The checksum bytes themselves become part of any later checksum.
+func (f *fileReader) checkAdler32() error {
+	expected := f.sum.Sum32()
+	var stored [4]byte
+	if err := f.readFull(stored[:]); err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	if binary.LittleEndian.Uint32(stored[:]) != expected {
+		return ErrChecksum
+	}
+	return nil
+}
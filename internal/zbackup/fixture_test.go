@@ -0,0 +1,291 @@
+package zbackup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// fixtureRepo writes small zbackup repositories for the golden-file tests.
+// It follows the on-disk format zbackup uses for unencrypted repositories.
+type fixtureRepo struct {
+	t     *testing.T
+	path  string
+	index []IndexEntry
+	// key encrypts every file but info, when set
+	key *Key
+	// chunkID names stored chunks; fixtureChunkID when nil
+	chunkID func([]byte) ChunkID
+}
+
+func newFixtureRepo(t *testing.T, path string) *fixtureRepo {
+	createFixtureDirs(t, path)
+	writeTestStorageInfo(t, path, 64, 1024)
+	return &fixtureRepo{t: t, path: path}
+}
+
+// newEncryptedFixtureRepo creates a repository the way "zbackup init --password-file" does,
+// with a fixed salt and key so that only the random IV blocks change between runs
+func newEncryptedFixtureRepo(t *testing.T, path, password string) *fixtureRepo {
+	createFixtureDirs(t, path)
+
+	rawKey := []byte("fixture repo key")
+	keyInfo := EncryptionKeyInfo{Salt: bytes.Repeat([]byte{0x5a}, 32), Rounds: 10000, KeyCheckInput: bytes.Repeat([]byte{0xc3}, 64)}
+	derived, err := aes.NewCipher(pbkdf2SHA1([]byte(password), keyInfo.Salt, int(keyInfo.Rounds), KeySize))
+	require.NoError(t, err)
+	keyInfo.EncryptedKey = make([]byte, KeySize)
+	derived.Encrypt(keyInfo.EncryptedKey, rawKey)
+	mac := hmac.New(sha1.New, rawKey)
+	mac.Write(keyInfo.KeyCheckInput)
+	keyInfo.KeyCheckHMAC = mac.Sum(nil)
+
+	encoded := appendBytesField(nil, 1, keyInfo.Salt)
+	encoded = appendVarintField(encoded, 2, uint64(keyInfo.Rounds))
+	encoded = appendBytesField(encoded, 3, keyInfo.EncryptedKey)
+	encoded = appendBytesField(encoded, 4, keyInfo.KeyCheckInput)
+	encoded = appendBytesField(encoded, 5, keyInfo.KeyCheckHMAC)
+	msg := appendVarintField(nil, 1, 64)
+	msg = appendVarintField(msg, 2, 1024)
+	msg = appendBytesField(msg, 3, encoded)
+
+	f, err := createFile(filepath.Join(path, "info"), nil)
+	require.NoError(t, err)
+	require.NoError(t, f.writeMessage(msg))
+	require.NoError(t, f.writeAdler32())
+	require.NoError(t, f.Close())
+
+	key, err := DecryptKey(&keyInfo, []byte(password))
+	require.NoError(t, err)
+	return &fixtureRepo{t: t, path: path, key: key}
+}
+
+func createFixtureDirs(t *testing.T, path string) {
+	require.NoError(t, os.RemoveAll(path))
+	for _, dir := range []string{"backups", "bundles", "index", "tmp"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(path, dir), 0755))
+	}
+}
+
+// fixtureChunkID derives a stable chunk id: a 16-byte SHA-1 prefix followed by 8 bytes
+// standing in for the rolling hash
+func fixtureChunkID(data []byte) ChunkID {
+	var id ChunkID
+	sum := sha1.Sum(data)
+	copy(id[:16], sum[:16])
+	binary.LittleEndian.PutUint64(id[16:], uint64(len(data)))
+	return id
+}
+
+// addBundle stores chunks in a new bundle and records it in the pending index
+func (f *fixtureRepo) addBundle(method string, chunks ...[]byte) []ChunkID {
+	var entry IndexEntry
+	var payload []byte
+	ids := make([]ChunkID, len(chunks))
+	for i, data := range chunks {
+		if f.chunkID != nil {
+			ids[i] = f.chunkID(data)
+		} else {
+			ids[i] = fixtureChunkID(data)
+		}
+		entry.Chunks = append(entry.Chunks, ChunkRecord{ID: ids[i], Size: uint32(len(data))})
+		payload = append(payload, data...)
+	}
+	sum := sha256.Sum256(payload)
+	copy(entry.BundleID[:], sum[:])
+
+	var compressed bytes.Buffer
+	if method == CompressionLZMA {
+		w, err := xz.NewWriter(&compressed)
+		require.NoError(f.t, err)
+		_, err = w.Write(payload)
+		require.NoError(f.t, err)
+		require.NoError(f.t, w.Close())
+	} else {
+		compressed.Write(lzoPayload(payload))
+	}
+
+	path := BundlePath(f.path, entry.BundleID)
+	require.NoError(f.t, os.MkdirAll(filepath.Dir(path), 0755))
+	file, err := os.Create(path)
+	require.NoError(f.t, err)
+	w := newFileWriter(file, f.key)
+	header := appendVarintField(nil, 1, FileFormatVersion)
+	header = appendBytesField(header, 2, []byte(method))
+	require.NoError(f.t, w.writeMessage(header))
+	require.NoError(f.t, w.writeMessage(encodeBundleInfo(entry.Chunks)))
+	require.NoError(f.t, w.writeAdler32())
+	require.NoError(f.t, w.write(compressed.Bytes()))
+	require.NoError(f.t, w.writeAdler32())
+	require.NoError(f.t, w.Close())
+
+	f.index = append(f.index, entry)
+	return ids
+}
+
+// instructionStream serializes instructions as zbackup stores them
+func instructionStream(instructions []Instruction) []byte {
+	var data []byte
+	for _, instr := range instructions {
+		var msg []byte
+		if instr.HasChunk {
+			msg = appendBytesField(msg, 1, instr.Chunk[:])
+		}
+		if instr.Bytes != nil {
+			msg = appendBytesField(msg, 2, instr.Bytes)
+		}
+		data = binary.AppendUvarint(data, uint64(len(msg)))
+		data = append(data, msg...)
+	}
+	return data
+}
+
+// addBackup writes a backup file for the given instruction stream. With iterations set,
+// the stream itself is stored in a bundle and the backup file references it instead.
+func (f *fixtureRepo) addBackup(name string, restored []byte, instructions []Instruction, iterations int, method string) {
+	data := instructionStream(instructions)
+	for i := 0; i < iterations; i++ {
+		half := len(data) / 2
+		ids := f.addBundle(method, data[:half], data[half:len(data)-1])
+		data = instructionStream([]Instruction{
+			{HasChunk: true, Chunk: ids[0]},
+			{HasChunk: true, Chunk: ids[1], Bytes: data[len(data)-1:]},
+		})
+	}
+
+	digest := sha256.Sum256(restored)
+	msg := appendBytesField(nil, 1, data)
+	msg = appendVarintField(msg, 2, uint64(iterations))
+	msg = appendVarintField(msg, 3, uint64(len(restored)))
+	msg = appendBytesField(msg, 4, digest[:])
+	msg = appendVarintField(msg, 5, uint64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()))
+
+	w, err := createFile(filepath.Join(f.path, "backups", name), f.key)
+	require.NoError(f.t, err)
+	require.NoError(f.t, w.writeMessage(msg))
+	require.NoError(f.t, w.writeAdler32())
+	require.NoError(f.t, w.Close())
+}
+
+// finish writes the index file
+func (f *fixtureRepo) finish() {
+	sort.Slice(f.index, func(i, j int) bool { return f.index[i].BundleID.String() < f.index[j].BundleID.String() })
+	require.NoError(f.t, writeIndexFile(filepath.Join(f.path, "index", "0000000000000000000000000000000000000000000000aa"), f.index, f.key))
+}
+
+// stream builds the instructions and restored data of a backup made of chunks and literal bytes
+func stream(parts ...interface{}) ([]byte, []Instruction) {
+	var restored []byte
+	var instructions []Instruction
+	for _, part := range parts {
+		switch p := part.(type) {
+		case []byte:
+			instructions = append(instructions, Instruction{HasChunk: true, Chunk: fixtureChunkID(p)})
+			restored = append(restored, p...)
+		case string:
+			instructions = append(instructions, Instruction{Bytes: []byte(p)})
+			restored = append(restored, p...)
+		}
+	}
+	return restored, instructions
+}
+
+// fixturePassword protects the encrypted fixture; testdata/encrypted.password holds it too
+const fixturePassword = "correct horse battery staple"
+
+// generateFixtures rebuilds the fixture repositories under testdata
+func generateFixtures(t *testing.T) {
+	alpha := bytes.Repeat([]byte("alpha "), 10)
+	beta := bytes.Repeat([]byte("beta "), 12)
+	gamma := bytes.Repeat([]byte("gamma "), 8)
+	delta := bytes.Repeat([]byte("delta "), 9)
+	orphan := []byte("chunk left behind by a deleted backup")
+
+	basic := newFixtureRepo(t, filepath.Join("testdata", "basic"))
+	basic.addBundle(CompressionLZMA, alpha, beta)
+	basic.addBundle(CompressionLZMA, gamma, delta, orphan)
+	restored, instructions := stream(alpha, beta, gamma, "tail of a")
+	basic.addBackup("2024-01-01_0100-a.zbk", restored, instructions, 0, CompressionLZMA)
+	restored, instructions = stream(alpha, beta, beta, delta, "tail of b")
+	basic.addBackup("2024-01-02_0100-b.zbk", restored, instructions, 0, CompressionLZMA)
+	basic.finish()
+
+	iterated := newFixtureRepo(t, filepath.Join("testdata", "iterated"))
+	iterated.addBundle(CompressionLZMA, alpha, beta, gamma)
+	restored, instructions = stream(alpha, "-", beta, "-", gamma, alpha, "end")
+	iterated.addBackup("2024-02-01_0100-iterated.zbk", restored, instructions, 2, CompressionLZMA)
+	restored, instructions = stream(gamma)
+	iterated.addBackup("2024-02-02_0100-plain.zbk", restored, instructions, 0, CompressionLZMA)
+	iterated.finish()
+
+	// A series of nightly backups next to a weekly one, for neighbour and prune accounting
+	epsilon := bytes.Repeat([]byte("epsilon "), 5)
+	zeta := bytes.Repeat([]byte("zeta "), 6)
+	series := newFixtureRepo(t, filepath.Join("testdata", "series"))
+	series.addBundle(CompressionLZMA, alpha, beta, gamma)
+	series.addBundle(CompressionLZMA, delta, epsilon, zeta)
+	for _, b := range []struct {
+		name  string
+		parts []interface{}
+	}{
+		{"2024-04-01_0100-nightly.zbk", []interface{}{alpha, beta, gamma}},
+		{"2024-04-02_0100-nightly.zbk", []interface{}{alpha, beta, delta}},
+		{"2024-04-03_0100-nightly.zbk", []interface{}{beta, delta, epsilon}},
+		{"2024-04-03_0200-weekly.zbk", []interface{}{alpha, gamma, zeta}},
+	} {
+		restored, instructions = stream(b.parts...)
+		series.addBackup(b.name, restored, instructions, 0, CompressionLZMA)
+	}
+	series.finish()
+
+	encrypted := newEncryptedFixtureRepo(t, filepath.Join("testdata", "encrypted"), fixturePassword)
+	encrypted.addBundle(CompressionLZMA, alpha, beta, gamma)
+	restored, instructions = stream(alpha, beta, "encrypted tail")
+	encrypted.addBackup("2024-05-01_0100-secret.zbk", restored, instructions, 0, CompressionLZMA)
+	restored, instructions = stream(gamma, beta, alpha, "x")
+	encrypted.addBackup("2024-05-02_0100-secret.zbk", restored, instructions, 1, CompressionLZMA)
+	encrypted.finish()
+
+	lzo := newFixtureRepo(t, filepath.Join("testdata", "lzo"))
+	lzo.addBundle(CompressionLZO, alpha, beta)
+	restored, instructions = stream(alpha, beta)
+	lzo.addBackup("2024-03-01_0100-plain.zbk", restored, instructions, 0, CompressionLZO)
+	restored, instructions = stream(beta, alpha, "x")
+	lzo.addBackup("2024-03-02_0100-iterated.zbk", restored, instructions, 1, CompressionLZO)
+	lzo.finish()
+
+	// A stream stored the way zbackup chunks it, with real chunk ids, for estimates
+	input := make([]byte, 1000)
+	rand.New(rand.NewSource(42)).Read(input)
+	chunked := newFixtureRepo(t, filepath.Join("testdata", "chunked"))
+	chunked.chunkID = NewChunkID
+	var parts [][]byte
+	for rest := input; len(rest) > 0; {
+		n := len(rest)
+		if n > 64 {
+			n = 64
+		}
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+	instructions = nil
+	for _, id := range chunked.addBundle(CompressionLZMA, parts...) {
+		instructions = append(instructions, Instruction{HasChunk: true, Chunk: id})
+	}
+	chunked.addBackup("2024-06-01_0100-stream.zbk", input, instructions, 0, CompressionLZMA)
+	chunked.finish()
+	require.NoError(t, os.WriteFile(filepath.Join("testdata", "chunked.input"), input, 0644))
+
+	require.NoError(t, os.WriteFile(filepath.Join("testdata", "encrypted.password"), []byte(fixturePassword+"\n"), 0600))
+}
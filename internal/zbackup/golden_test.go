@@ -0,0 +1,273 @@
+package zbackup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "regenerate the fixture repositories and golden files")
+
+// repositoryDump is everything the reader extracts from a fixture repository
+type repositoryDump struct {
+	Info     *StorageInfo `json:"info"`
+	Bundles  []bundleDump `json:"bundles"`
+	Backups  []backupDump `json:"backups"`
+	Analysis *Analysis    `json:"analysis"`
+}
+
+type bundleDump struct {
+	ID            string `json:"id"`
+	Compression   string `json:"compression"`
+	Chunks        int    `json:"chunks"`
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type backupDump struct {
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	Iterations     uint32 `json:"iterations"`
+	SHA256         string `json:"sha256"`
+	Time           int64  `json:"time"`
+	Instructions   int    `json:"instructions"`
+	RestoredSHA256 string `json:"restored_sha256,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// dumpRepository reads a repository through the public API
+func dumpRepository(t *testing.T, path, passwordFile string) *repositoryDump {
+	repo, err := OpenWithPasswordFile(path, passwordFile)
+	require.NoError(t, err)
+	dump := &repositoryDump{Info: repo.Info}
+
+	index, err := repo.Index()
+	require.NoError(t, err)
+	assert.NotEmpty(t, index)
+	entries, err := repo.IndexEntries()
+	require.NoError(t, err)
+	seen := make(map[BundleID]bool)
+	for _, entry := range entries {
+		if seen[entry.BundleID] {
+			continue
+		}
+		seen[entry.BundleID] = true
+
+		b := bundleDump{ID: entry.BundleID.String()}
+		chunks, method, err := repo.BundleInfo(entry.BundleID)
+		require.NoError(t, err)
+		b.Compression = method
+		b.Chunks = len(chunks)
+		assert.Equal(t, entry.Chunks, chunks, "index and bundle disagree")
+
+		if bundle, err := repo.Bundle(entry.BundleID); err != nil {
+			b.Error = err.Error()
+		} else {
+			sum := sha256.Sum256(bundle.Payload)
+			b.PayloadSHA256 = hex.EncodeToString(sum[:])
+		}
+		dump.Bundles = append(dump.Bundles, b)
+	}
+
+	names, err := repo.ListBackups()
+	require.NoError(t, err)
+	for _, name := range names {
+		b := backupDump{Name: name}
+		info, err := repo.BackupInfo(name)
+		require.NoError(t, err)
+		b.Size, b.Iterations, b.Time = info.Size, info.Iterations, info.Time
+		b.SHA256 = hex.EncodeToString(info.SHA256)
+
+		refs, err := repo.ReadBackupRefs(name)
+		if err != nil {
+			b.Error = err.Error()
+			dump.Backups = append(dump.Backups, b)
+			continue
+		}
+		b.Instructions = len(refs.Instructions)
+
+		// Replaying the instructions must reproduce the recorded digest
+		hash := sha256.New()
+		var size uint64
+		for _, instr := range refs.Instructions {
+			if instr.HasChunk {
+				data, err := repo.ReadChunk(instr.Chunk)
+				if err != nil {
+					b.Error = err.Error()
+					break
+				}
+				hash.Write(data)
+				size += uint64(len(data))
+			}
+			hash.Write(instr.Bytes)
+			size += uint64(len(instr.Bytes))
+		}
+		if b.Error != "" {
+			dump.Backups = append(dump.Backups, b)
+			continue
+		}
+		b.RestoredSHA256 = hex.EncodeToString(hash.Sum(nil))
+		assert.Equal(t, b.SHA256, b.RestoredSHA256, name)
+		assert.Equal(t, info.Size, size, name)
+		dump.Backups = append(dump.Backups, b)
+	}
+
+	dump.Analysis, err = repo.Analyze()
+	require.NoError(t, err)
+	return dump
+}
+
+func TestGoldenRepositories(t *testing.T) {
+	if *update {
+		generateFixtures(t)
+	}
+
+	for _, name := range []string{"basic", "iterated", "series", "lzo", "encrypted", "chunked"} {
+		t.Run(name, func(t *testing.T) {
+			passwordFile := ""
+			if name == "encrypted" {
+				passwordFile = filepath.Join("testdata", "encrypted.password")
+			}
+			got, err := json.MarshalIndent(dumpRepository(t, filepath.Join("testdata", name), passwordFile), "", "  ")
+			require.NoError(t, err)
+			got = append(got, '\n')
+
+			goldenPath := filepath.Join("testdata", name+".golden.json")
+			if *update {
+				require.NoError(t, os.WriteFile(goldenPath, got, 0644))
+			}
+			want, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+func TestAnalyze_UniqueChunks(t *testing.T) {
+	repo, err := Open(filepath.Join("testdata", "basic"))
+	require.NoError(t, err)
+	analysis, err := repo.Analyze()
+	require.NoError(t, err)
+
+	require.Len(t, analysis.Backups, 2)
+	a, b := analysis.Backups[0], analysis.Backups[1]
+	// a: alpha, beta, gamma; b: alpha, beta, beta, delta
+	assert.Equal(t, 3, a.Chunks)
+	assert.Equal(t, 3, a.ChunkRefs)
+	assert.Equal(t, 1, a.UniqueChunks)
+	assert.Equal(t, uint64(48), a.UniqueBytes)
+	assert.Equal(t, 3, b.Chunks)
+	assert.Equal(t, 4, b.ChunkRefs)
+	assert.Equal(t, 1, b.UniqueChunks)
+	assert.Equal(t, uint64(54), b.UniqueBytes)
+	assert.Equal(t, 1, analysis.UnreferencedChunks)
+}
+
+func TestAnalysis_SharedAndFreed(t *testing.T) {
+	repo, err := Open(filepath.Join("testdata", "basic"))
+	require.NoError(t, err)
+	analysis, err := repo.Analyze()
+	require.NoError(t, err)
+
+	a, b := "2024-01-01_0100-a.zbk", "2024-01-02_0100-b.zbk"
+	// alpha and beta are shared
+	assert.Equal(t, uint64(120), analysis.SharedBytes(a, b))
+	assert.Equal(t, uint64(120), analysis.SharedBytes(b, a))
+	assert.Equal(t, uint64(0), analysis.SharedBytes(a, "missing.zbk"))
+
+	chunks, size := analysis.FreedChunks([]string{a})
+	assert.Equal(t, 1, chunks)
+	assert.Equal(t, uint64(48), size)
+
+	// Deleting both frees every referenced chunk, but not the orphan
+	chunks, size = analysis.FreedChunks([]string{a, b, a})
+	assert.Equal(t, 4, chunks)
+	assert.Equal(t, uint64(222), size)
+}
+
+func TestOpen_LZOBundles(t *testing.T) {
+	repo, err := Open(filepath.Join("testdata", "lzo"))
+	require.NoError(t, err)
+	names, err := repo.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	for _, name := range names {
+		info, err := repo.BackupInfo(name)
+		require.NoError(t, err)
+		var restored bytes.Buffer
+		require.NoError(t, repo.Restore(name, &restored), name)
+		sum := sha256.Sum256(restored.Bytes())
+		assert.Equal(t, info.SHA256, sum[:], name)
+	}
+}
+
+func TestOpen_UnsupportedCompression(t *testing.T) {
+	_, err := decompress("zstd", []byte("payload"), 7)
+	assert.ErrorIs(t, err, ErrUnsupportedCompression)
+	assert.Contains(t, err.Error(), "zstd")
+}
+
+func TestOpen_Encrypted(t *testing.T) {
+	repoDir := filepath.Join("testdata", "encrypted")
+
+	_, err := Open(repoDir)
+	assert.ErrorIs(t, err, ErrEncrypted)
+
+	_, err = OpenWithPassword(repoDir, []byte("wrong password"))
+	assert.ErrorIs(t, err, ErrWrongPassword)
+
+	repo, err := OpenWithPassword(repoDir, []byte(fixturePassword))
+	require.NoError(t, err)
+	assert.True(t, repo.Info.Encrypted)
+	names, err := repo.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	_, err = repo.ReadBackupRefs(names[1])
+	require.NoError(t, err)
+
+	// Encrypted files cannot be read as plain text
+	_, err = ReadBackupFile(filepath.Join(repoDir, "backups", names[0]))
+	assert.Error(t, err)
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	block, err := aes.NewCipher([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	key := &Key{block: block}
+
+	for _, size := range []int{0, 1, 15, 16, 17, 100} {
+		plain := bytes.Repeat([]byte{'z'}, size)
+		data, err := key.encrypt(plain)
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(data)%aes.BlockSize)
+		decrypted, err := key.decrypt(data)
+		require.NoError(t, err)
+		assert.Equal(t, plain, decrypted, "size %d", size)
+	}
+
+	// A flipped bit in the last block breaks the padding
+	data, err := key.encrypt([]byte("payload"))
+	require.NoError(t, err)
+	data[len(data)-aes.BlockSize-1] ^= 0x01
+	_, err = key.decrypt(data)
+	assert.Error(t, err)
+}
+
+func TestPBKDF2SHA1(t *testing.T) {
+	// RFC 6070 test vectors
+	assert.Equal(t, "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+		hex.EncodeToString(pbkdf2SHA1([]byte("password"), []byte("salt"), 1, 20)))
+	assert.Equal(t, "4b007901b765489abead49d926f721d065a429c1",
+		hex.EncodeToString(pbkdf2SHA1([]byte("password"), []byte("salt"), 4096, 20)))
+	assert.Equal(t, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+		hex.EncodeToString(pbkdf2SHA1([]byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25)))
+}
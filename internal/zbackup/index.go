@@ -0,0 +1,178 @@
+package zbackup
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IDSize is the size of bundle and chunk identifiers
+const IDSize = 24
+
+// BundleID identifies a bundle file
+type BundleID [IDSize]byte
+
+// String returns the hex form used for bundle file names
+func (id BundleID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ChunkID identifies a chunk: a 16-byte cryptographic hash followed by the 8-byte rolling hash
+type ChunkID [IDSize]byte
+
+// String returns the hex form of the chunk id
+func (id ChunkID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Matches reports whether data hashes to the cryptographic half of the id: the first
+// 16 bytes of its SHA-1
+func (id ChunkID) Matches(data []byte) bool {
+	sum := sha1.Sum(data)
+	return bytes.Equal(id[:16], sum[:16])
+}
+
+// ChunkRecord describes a chunk stored in a bundle
+type ChunkRecord struct {
+	ID   ChunkID
+	Size uint32
+}
+
+// IndexEntry lists the chunks stored in one bundle
+type IndexEntry struct {
+	BundleID BundleID
+	Chunks   []ChunkRecord
+}
+
+// BundlePath returns the location of a bundle inside the repository
+func BundlePath(repoPath string, id BundleID) string {
+	name := id.String()
+	return filepath.Join(repoPath, "bundles", name[:2], name)
+}
+
+// ListIndexFiles returns the paths of all index files of a repository, sorted by name
+func ListIndexFiles(repoPath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(repoPath, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		paths = append(paths, filepath.Join(repoPath, "index", entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ReadIndexFile parses an index file of an unencrypted repository
+func ReadIndexFile(path string) ([]IndexEntry, error) {
+	return readIndexFile(path, nil)
+}
+
+// readIndexFile parses an index file, decrypting it with key when set
+func readIndexFile(path string, key *Key) ([]IndexEntry, error) {
+	f, err := openFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version, err := f.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if version != FileFormatVersion {
+		return nil, fmt.Errorf("unsupported index file version %d", version)
+	}
+
+	var entries []IndexEntry
+	for {
+		data, err := f.readMessage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index bundle header: %w", err)
+		}
+
+		var id []byte
+		if err := decodeFields(data, func(fl field) error {
+			if fl.num == 1 && fl.wireType == wireBytes {
+				id = fl.bytes
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to parse index bundle header: %w", err)
+		}
+
+		// A header without an id terminates the index
+		if id == nil {
+			break
+		}
+		if len(id) != IDSize {
+			return nil, fmt.Errorf("invalid bundle id length %d", len(id))
+		}
+
+		data, err = f.readMessage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle info: %w", err)
+		}
+		chunks, err := parseBundleInfo(data)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := IndexEntry{Chunks: chunks}
+		copy(entry.BundleID[:], id)
+		entries = append(entries, entry)
+	}
+
+	if err := f.checkAdler32(); err != nil {
+		return nil, fmt.Errorf("index file %s: %w", filepath.Base(path), err)
+	}
+	return entries, nil
+}
+
+// parseBundleInfo decodes the list of chunk records of a bundle
+func parseBundleInfo(data []byte) ([]ChunkRecord, error) {
+	var chunks []ChunkRecord
+	err := decodeFields(data, func(fl field) error {
+		if fl.num != 1 || fl.wireType != wireBytes {
+			return nil
+		}
+		var record ChunkRecord
+		var idLen int
+		err := decodeFields(fl.bytes, func(cf field) error {
+			switch cf.num {
+			case 1:
+				idLen = copy(record.ID[:], cf.bytes)
+				if len(cf.bytes) != IDSize {
+					idLen = len(cf.bytes)
+				}
+			case 2:
+				record.Size = uint32(cf.varint)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if idLen != IDSize {
+			return fmt.Errorf("invalid chunk id length %d", idLen)
+		}
+		chunks = append(chunks, record)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bundle info: %w", err)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,66 @@
+package zbackup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestStorageInfo writes an unencrypted "info" file
+func writeTestStorageInfo(t *testing.T, repoPath string, chunkMaxSize, bundleMaxPayloadSize uint32) {
+	f, err := createFile(filepath.Join(repoPath, "info"), nil)
+	require.NoError(t, err)
+	msg := appendVarintField(nil, 1, uint64(chunkMaxSize))
+	msg = appendVarintField(msg, 2, uint64(bundleMaxPayloadSize))
+	require.NoError(t, f.writeMessage(msg))
+	require.NoError(t, f.writeAdler32())
+	require.NoError(t, f.Close())
+}
+
+func TestReadStorageInfo(t *testing.T) {
+	repoDir := t.TempDir()
+	writeTestStorageInfo(t, repoDir, 65536, 2097152)
+
+	info, err := ReadStorageInfo(repoDir)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(65536), info.ChunkMaxSize)
+	assert.Equal(t, uint32(2097152), info.BundleMaxPayloadSize)
+	assert.Equal(t, "lzma", info.DefaultCompressionMethod)
+	assert.False(t, info.Encrypted)
+}
+
+func TestWriteReadIndexFile(t *testing.T) {
+	repoDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "index"), 0755))
+
+	var first, second IndexEntry
+	first.BundleID[0] = 0xab
+	first.Chunks = []ChunkRecord{{Size: 100}, {Size: 200}}
+	first.Chunks[0].ID[0] = 1
+	first.Chunks[1].ID[0] = 2
+	second.BundleID[0] = 0xcd
+	second.Chunks = []ChunkRecord{{Size: 300}}
+
+	path := filepath.Join(repoDir, "index", "0123456789abcdef")
+	require.NoError(t, writeIndexFile(path, []IndexEntry{first, second}, nil))
+
+	paths, err := ListIndexFiles(repoDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, paths)
+
+	entries, err := ReadIndexFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []IndexEntry{first, second}, entries)
+	assert.Equal(t, filepath.Join(repoDir, "bundles", "ab", first.BundleID.String()), BundlePath(repoDir, first.BundleID))
+
+	// Any corruption is caught by the checksum
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	_, err = ReadIndexFile(path)
+	assert.ErrorIs(t, err, ErrChecksum)
+}
@@ -0,0 +1,91 @@
+package zbackup
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// StorageInfo mirrors the repository-wide settings stored in the zbackup "info" file
+type StorageInfo struct {
+	ChunkMaxSize             uint32 `json:"chunk_max_size"`
+	BundleMaxPayloadSize     uint32 `json:"bundle_max_payload_size"`
+	DefaultCompressionMethod string `json:"default_compression_method"`
+	Encrypted                bool   `json:"encrypted"`
+	// EncryptionKey is set for encrypted repositories
+	EncryptionKey *EncryptionKeyInfo `json:"-"`
+}
+
+// ReadStorageInfo parses the "info" file at the root of a repository.
+// The info file is never encrypted, even in encrypted repositories.
+func ReadStorageInfo(repoPath string) (*StorageInfo, error) {
+	f, err := openFile(filepath.Join(repoPath, "info"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version, err := f.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if version != FileFormatVersion {
+		return nil, fmt.Errorf("unsupported info file version %d", version)
+	}
+
+	data, err := f.readMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage info: %w", err)
+	}
+
+	info := &StorageInfo{DefaultCompressionMethod: "lzma"}
+	err = decodeFields(data, func(fl field) error {
+		switch fl.num {
+		case 1:
+			info.ChunkMaxSize = uint32(fl.varint)
+		case 2:
+			info.BundleMaxPayloadSize = uint32(fl.varint)
+		case 3:
+			info.Encrypted = true
+			key, err := parseEncryptionKeyInfo(fl.bytes)
+			if err != nil {
+				return err
+			}
+			info.EncryptionKey = key
+		case 4:
+			info.DefaultCompressionMethod = string(fl.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse storage info: %w", err)
+	}
+
+	if err := f.checkAdler32(); err != nil {
+		return nil, fmt.Errorf("info file: %w", err)
+	}
+	return info, nil
+}
+
+// parseEncryptionKeyInfo decodes the EncryptionKeyInfo message of the info file
+func parseEncryptionKeyInfo(data []byte) (*EncryptionKeyInfo, error) {
+	key := &EncryptionKeyInfo{}
+	err := decodeFields(data, func(fl field) error {
+		switch fl.num {
+		case 1:
+			key.Salt = fl.bytes
+		case 2:
+			key.Rounds = uint32(fl.varint)
+		case 3:
+			key.EncryptedKey = fl.bytes
+		case 4:
+			key.KeyCheckInput = fl.bytes
+		case 5:
+			key.KeyCheckHMAC = fl.bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encryption key: %w", err)
+	}
+	return key, nil
+}
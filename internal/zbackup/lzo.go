@@ -0,0 +1,223 @@
+package zbackup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errLZOCorrupt reports an LZO1X stream that cannot be decoded
+var errLZOCorrupt = errors.New("invalid lzo payload")
+
+// lzoSizeHeader is the uncompressed size zbackup writes before the LZO1X stream, as a
+// little-endian uint64, since the format does not record it
+const lzoSizeHeader = 8
+
+// States of the LZO1X decoder, which decide what an instruction below 16 means
+const (
+	// lzoStateStart: the next instruction may start a literal run
+	lzoStateStart = iota
+	// lzoStateAfterRun: a literal run of four or more bytes was just copied
+	lzoStateAfterRun
+	// lzoStateAfterMatch: a match was followed by one to three literals
+	lzoStateAfterMatch
+)
+
+// decompressLZO expands a bundle payload written by zbackup's lzo1x_1 method: the
+// uncompressed size followed by an LZO1X stream
+func decompressLZO(data []byte, size int) ([]byte, error) {
+	if len(data) < lzoSizeHeader {
+		return nil, fmt.Errorf("%w: missing size header", errLZOCorrupt)
+	}
+	if declared := binary.LittleEndian.Uint64(data); declared != uint64(size) {
+		return nil, fmt.Errorf("%w: declares %d bytes, its chunks hold %d", errLZOCorrupt, declared, size)
+	}
+	return lzo1xDecompress(data[lzoSizeHeader:], size)
+}
+
+// lzoDecoder holds the input and output of an LZO1X decompression
+type lzoDecoder struct {
+	in  []byte
+	ip  int
+	out []byte
+}
+
+// lzo1xDecompress decodes an LZO1X stream, such as written by lzo1x_1_compress, which
+// must expand to exactly size bytes. It follows lzo1x_decompress_safe of liblzo:
+// every read and copy is bounds checked.
+func lzo1xDecompress(in []byte, size int) ([]byte, error) {
+	d := &lzoDecoder{in: in, out: make([]byte, 0, size)}
+
+	state := lzoStateStart
+	// A first byte above 17 starts with a short literal run
+	if len(in) > 0 && in[0] > 17 {
+		d.ip = 1
+		n := int(in[0]) - 17
+		if err := d.literals(n, size); err != nil {
+			return nil, err
+		}
+		state = lzoStateAfterRun
+		if n < 4 {
+			state = lzoStateAfterMatch
+		}
+	}
+
+	for {
+		inst, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		var dist, n int
+		switch {
+		case inst >= 64:
+			// M2: length 3 to 8, distance up to 2 KiB
+			next, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + (inst>>2)&7 + next<<3
+			n = inst>>5 + 1
+		case inst >= 32:
+			// M3: distance up to 16 KiB
+			if n, err = d.length(inst&31, 31); err != nil {
+				return nil, err
+			}
+			n += 2
+			le, err := d.le16()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + le>>2
+		case inst >= 16:
+			// M4: distance from 16 KiB to 48 KiB, or the end of the stream
+			if n, err = d.length(inst&7, 7); err != nil {
+				return nil, err
+			}
+			n += 2
+			le, err := d.le16()
+			if err != nil {
+				return nil, err
+			}
+			dist = (inst&8)<<11 + le>>2
+			if dist == 0 {
+				return d.finish(size)
+			}
+			dist += 0x4000
+		case state == lzoStateStart:
+			// A literal run of four or more bytes
+			if n, err = d.length(inst, 15); err != nil {
+				return nil, err
+			}
+			if err := d.literals(n+3, size); err != nil {
+				return nil, err
+			}
+			state = lzoStateAfterRun
+			continue
+		case state == lzoStateAfterRun:
+			// M1 after a literal run: three bytes from 2 KiB to 3 KiB back
+			next, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + 0x800 + inst>>2 + next<<2
+			n = 3
+		default:
+			// M1: two bytes up to 1 KiB back
+			next, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			dist = 1 + inst>>2 + next<<2
+			n = 2
+		}
+
+		if err := d.match(dist, n, size); err != nil {
+			return nil, err
+		}
+		// The low bits of the last instruction byte but one count the literals that follow
+		trailing := int(d.in[d.ip-2] & 3)
+		if err := d.literals(trailing, size); err != nil {
+			return nil, err
+		}
+		state = lzoStateStart
+		if trailing > 0 {
+			state = lzoStateAfterMatch
+		}
+	}
+}
+
+func (d *lzoDecoder) byte() (int, error) {
+	if d.ip >= len(d.in) {
+		return 0, fmt.Errorf("%w: truncated", errLZOCorrupt)
+	}
+	d.ip++
+	return int(d.in[d.ip-1]), nil
+}
+
+func (d *lzoDecoder) le16() (int, error) {
+	if d.ip+2 > len(d.in) {
+		return 0, fmt.Errorf("%w: truncated", errLZOCorrupt)
+	}
+	d.ip += 2
+	return int(binary.LittleEndian.Uint16(d.in[d.ip-2:])), nil
+}
+
+// length decodes the length field of an instruction: a non-zero value is the length
+// itself, zero continues with a byte per 255 and a final non-zero byte added to max
+func (d *lzoDecoder) length(value, max int) (int, error) {
+	if value != 0 {
+		return value, nil
+	}
+	n := max
+	for {
+		b, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0 {
+			return n + b, nil
+		}
+		n += 255
+	}
+}
+
+// literals copies n bytes from the input
+func (d *lzoDecoder) literals(n, size int) error {
+	if d.ip+n > len(d.in) {
+		return fmt.Errorf("%w: truncated", errLZOCorrupt)
+	}
+	if len(d.out)+n > size {
+		return fmt.Errorf("%w: output exceeds %d bytes", errLZOCorrupt, size)
+	}
+	d.out = append(d.out, d.in[d.ip:d.ip+n]...)
+	d.ip += n
+	return nil
+}
+
+// match copies n bytes starting dist bytes back in the output, which may overlap
+func (d *lzoDecoder) match(dist, n, size int) error {
+	if dist > len(d.out) {
+		return fmt.Errorf("%w: match distance %d before the start", errLZOCorrupt, dist)
+	}
+	if len(d.out)+n > size {
+		return fmt.Errorf("%w: output exceeds %d bytes", errLZOCorrupt, size)
+	}
+	from := len(d.out) - dist
+	for k := 0; k < n; k++ {
+		d.out = append(d.out, d.out[from+k])
+	}
+	return nil
+}
+
+// finish checks the end of the stream: nothing may follow the end marker, and the
+// output must have the expected size
+func (d *lzoDecoder) finish(size int) ([]byte, error) {
+	if d.ip != len(d.in) {
+		return nil, fmt.Errorf("%w: %d bytes after the end marker", errLZOCorrupt, len(d.in)-d.ip)
+	}
+	if len(d.out) != size {
+		return nil, fmt.Errorf("%w: decoded %d bytes, expected %d", errLZOCorrupt, len(d.out), size)
+	}
+	return d.out, nil
+}
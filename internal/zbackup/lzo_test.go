@@ -0,0 +1,170 @@
+package zbackup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lzo1xCompress is a greedy LZO1X encoder for the fixtures and tests. Its output can be
+// read by any LZO1X decoder, although it is less compact than lzo1x_1_compress.
+func lzo1xCompress(src []byte) []byte {
+	var out []byte
+	// stateByte is the byte whose low bits count the literals after the last match, or -1
+	stateByte := -1
+	literals := func(lits []byte) {
+		n := len(lits)
+		switch {
+		case n == 0:
+			return
+		case len(out) == 0 && n <= 238:
+			out = append(out, byte(17+n))
+		case n <= 3 && stateByte >= 0:
+			out[stateByte] |= byte(n)
+		default:
+			out = lzoAppendLength(out, 0, n-3, 15)
+		}
+		out = append(out, lits...)
+	}
+
+	table := make(map[uint32]int)
+	start := 0
+	for pos := 0; pos+4 <= len(src); {
+		key := binary.LittleEndian.Uint32(src[pos:])
+		candidate, ok := table[key]
+		table[key] = pos
+		if !ok || pos-candidate > 0xbfff {
+			pos++
+			continue
+		}
+		length := 4
+		for pos+length < len(src) && src[candidate+length] == src[pos+length] {
+			length++
+		}
+
+		literals(src[start:pos])
+		dist := pos - candidate
+		switch {
+		case length <= 8 && dist <= 0x800:
+			out = append(out, byte((length-1)<<5|(dist-1)&7<<2), byte((dist-1)>>3))
+			stateByte = len(out) - 2
+		case dist <= 0x4000:
+			out = lzoAppendLength(out, 32, length-2, 31)
+			out = binary.LittleEndian.AppendUint16(out, uint16((dist-1)<<2))
+			stateByte = len(out) - 2
+		default:
+			high := (dist - 0x4000) >> 11 & 8
+			out = lzoAppendLength(out, byte(16|high), length-2, 7)
+			out = binary.LittleEndian.AppendUint16(out, uint16((dist-0x4000)&0x3fff<<2))
+			stateByte = len(out) - 2
+		}
+		pos += length
+		start = pos
+	}
+	literals(src[start:])
+	return append(out, 0x11, 0, 0)
+}
+
+// lzoAppendLength appends an instruction with a length field of up to max bits, using
+// zero bytes for each further 255 when the length does not fit
+func lzoAppendLength(out []byte, inst byte, length, max int) []byte {
+	if length <= max {
+		return append(out, inst|byte(length))
+	}
+	out = append(out, inst)
+	for length -= max; length > 255; length -= 255 {
+		out = append(out, 0)
+	}
+	return append(out, byte(length))
+}
+
+// lzoPayload frames data the way zbackup's lzo1x_1 method stores a bundle payload
+func lzoPayload(data []byte) []byte {
+	return append(binary.LittleEndian.AppendUint64(nil, uint64(len(data))), lzo1xCompress(data)...)
+}
+
+func TestLZO1XDecompress_Vectors(t *testing.T) {
+	long := bytes.Repeat([]byte("0123456789"), 30)
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		// lzo1x_1_compress stores short inputs as one literal run
+		{"literals only", []byte{0x16, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0}, []byte("hello")},
+		// Three literals, then M3 copying nine bytes from three back
+		{"overlapping match", []byte{0x14, 'a', 'b', 'c', 0x27, 0x08, 0x00, 0x11, 0, 0}, []byte("abcabcabcabc")},
+		// Four literals, then M2 copying four bytes from four back, followed by one literal
+		{"trailing literal", []byte{0x15, 'a', 'b', 'c', 'd', 0x6d, 0x00, '!', 0x11, 0, 0}, []byte("abcdabcd!")},
+		// A literal run whose length continues over two bytes: 15 + 255 + 27 + 3
+		{"long literal run", append(append([]byte{0x00, 0x00, 27}, long...), 0x11, 0, 0), long},
+		// Two literals, an M1 match of two bytes, then M2 repeating all four
+		{"m1 match", []byte{0x13, 'x', 'y', 0x04, 0x00, 0x6c, 0x00, 0x11, 0, 0}, []byte("xyxyxyxy")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lzo1xDecompress(tc.in, len(tc.want))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLZO1XDecompress_RoundTrip(t *testing.T) {
+	random := make([]byte, 70000)
+	rand.New(rand.NewSource(7)).Read(random)
+	// Repeats 20 KiB back need M4 matches
+	far := append(append(append([]byte{}, random[:20000]...), random[:3000]...), bytes.Repeat([]byte{'z'}, 1000)...)
+
+	for name, data := range map[string][]byte{
+		"empty":       {},
+		"short":       []byte("ab"),
+		"text":        bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 200),
+		"zeros":       make([]byte, 5000),
+		"random":      random,
+		"far matches": far,
+	} {
+		t.Run(name, func(t *testing.T) {
+			compressed := lzo1xCompress(data)
+			got, err := lzo1xDecompress(compressed, len(data))
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, got))
+		})
+	}
+}
+
+func TestLZO1XDecompress_Corrupt(t *testing.T) {
+	valid := lzo1xCompress(bytes.Repeat([]byte("abcdefgh"), 50))
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		size int
+	}{
+		{"empty", nil, 0},
+		{"truncated", valid[:len(valid)-4], 400},
+		{"data after the end", append(append([]byte{}, valid...), 0), 400},
+		{"too short", valid, 399},
+		{"too long", valid, 401},
+		{"distance before the start", []byte{0x15, 'a', 'b', 'c', 'd', 0x7c, 0x01, 0x11, 0, 0}, 12},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := lzo1xDecompress(tc.in, tc.size)
+			assert.ErrorIs(t, err, errLZOCorrupt)
+		})
+	}
+}
+
+func TestDecompress_LZO(t *testing.T) {
+	data := bytes.Repeat([]byte("bundle payload "), 20)
+	got, err := decompress(CompressionLZO, lzoPayload(data), len(data))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	_, err = decompress(CompressionLZO, lzoPayload(data), len(data)+1)
+	assert.ErrorIs(t, err, errLZOCorrupt)
+	_, err = decompress(CompressionLZO, []byte{1, 2, 3}, 3)
+	assert.ErrorIs(t, err, errLZOCorrupt)
+}
@@ -0,0 +1,74 @@
+package zbackup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protobuf wire types used by the zbackup messages
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// field is a single decoded protobuf field
+type field struct {
+	num      int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// decodeFields walks the fields of a serialized protobuf message in order.
+// Only the wire types used by zbackup are supported.
+func decodeFields(data []byte, fn func(f field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+
+		f := field{num: int(key >> 3), wireType: int(key & 7)}
+		switch f.wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errTruncated
+			}
+			f.varint = v
+			data = data[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errTruncated
+			}
+			f.bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			f.varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", f.wireType)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
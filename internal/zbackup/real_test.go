@@ -0,0 +1,151 @@
+package zbackup
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// realRepositories are created by the zbackup binary rather than by this package's own
+// writer, so that the reader, decryption, restore and chunker are checked against what
+// zbackup actually writes. They live in testdata/zbackup; 'go test -update' recreates
+// them when zbackup is installed.
+var realRepositories = []struct {
+	name string
+	// size is the length of the backed up stream, made of blocks repeated as needed
+	size, block int
+	// options are passed to zbackup backup
+	options   []string
+	encrypted bool
+}{
+	{name: "plain", size: 256 << 10, block: 256 << 10},
+	{name: "lzo", size: 256 << 10, block: 256 << 10, options: []string{"--compression", "lzo"}},
+	{name: "encrypted", size: 256 << 10, block: 256 << 10, encrypted: true},
+	// A long stream whose instruction list zbackup stores as a further backup level
+	{name: "iterated", size: 4 << 20, block: 96 << 10},
+}
+
+// realBackupName is the backup each real repository holds
+func realBackupName(name string) string {
+	return fmt.Sprintf("2024-07-01_0100-%s.zbk", name)
+}
+
+// realInput is the deterministic stream backed up into a real repository: lines of
+// numbers and words, compressible but not trivially so
+func realInput(name string, size, block int) []byte {
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel",
+		"india", "juliett", "kilo", "lima", "mike", "november", "oscar", "papa"}
+	r := rand.New(rand.NewSource(int64(crc32.ChecksumIEEE([]byte(name)))))
+	var buf bytes.Buffer
+	for buf.Len() < block {
+		fmt.Fprintf(&buf, "%07d %s %s %s\n", r.Intn(10000000), words[r.Intn(len(words))],
+			words[r.Intn(len(words))], words[r.Intn(len(words))])
+	}
+	unit := buf.Bytes()[:block]
+	return bytes.Repeat(unit, (size+block-1)/block)[:size]
+}
+
+// createRealRepositories runs zbackup to recreate the repositories under root
+func createRealRepositories(t *testing.T, zbackup, root string) {
+	passwordFile, err := filepath.Abs(filepath.Join("testdata", "encrypted.password"))
+	require.NoError(t, err)
+	run := func(stdin []byte, args ...string) {
+		cmd := exec.Command(zbackup, args...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "zbackup %v: %s", args, out)
+	}
+
+	for _, rr := range realRepositories {
+		path := filepath.Join(root, rr.name)
+		require.NoError(t, os.RemoveAll(path))
+		require.NoError(t, os.MkdirAll(root, 0755))
+
+		encryption := []string{"--non-encrypted"}
+		if rr.encrypted {
+			encryption = []string{"--password-file", passwordFile}
+		}
+		run(nil, append(encryption, "init", path)...)
+		args := append(append(encryption, rr.options...), "backup", filepath.Join(path, "backups", realBackupName(rr.name)))
+		run(realInput(rr.name, rr.size, rr.block), args...)
+		// zbackup leaves an empty tmp directory, which git would not keep
+		require.NoError(t, os.RemoveAll(filepath.Join(path, "tmp")))
+	}
+}
+
+func TestRealRepositories(t *testing.T) {
+	root := filepath.Join("testdata", "zbackup")
+	if *update {
+		if zbackup, err := exec.LookPath("zbackup"); err == nil {
+			createRealRepositories(t, zbackup, root)
+		} else {
+			t.Logf("zbackup is not installed, keeping %s as it is", root)
+		}
+	}
+
+	for _, rr := range realRepositories {
+		rr := rr
+		t.Run(rr.name, func(t *testing.T) {
+			path := filepath.Join(root, rr.name)
+			if _, err := os.Stat(filepath.Join(path, "info")); err != nil {
+				t.Skipf("no repository created by zbackup in %s; run 'go test -update' with zbackup installed", path)
+			}
+
+			passwordFile := ""
+			if rr.encrypted {
+				_, err := Open(path)
+				require.ErrorIs(t, err, ErrEncrypted)
+				passwordFile = filepath.Join("testdata", "encrypted.password")
+			}
+			repo, err := OpenWithPasswordFile(path, passwordFile)
+			require.NoError(t, err)
+			assert.Equal(t, rr.encrypted, repo.Info.Encrypted)
+			input := realInput(rr.name, rr.size, rr.block)
+
+			// Restoring reproduces the stream zbackup was given
+			info, err := repo.BackupInfo(realBackupName(rr.name))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(len(input)), info.Size)
+			if rr.name == "iterated" {
+				assert.Greater(t, info.Iterations, uint32(0))
+			}
+			var restored bytes.Buffer
+			require.NoError(t, repo.Restore(realBackupName(rr.name), &restored))
+			assert.True(t, bytes.Equal(input, restored.Bytes()), "restored data differs")
+
+			// Every chunk decompresses and carries the id the chunker computes for it
+			entries, err := repo.IndexEntries()
+			require.NoError(t, err)
+			require.NotEmpty(t, entries)
+			for _, entry := range entries {
+				bundle, err := repo.Bundle(entry.BundleID)
+				require.NoError(t, err)
+				if rr.name == "lzo" {
+					assert.Equal(t, CompressionLZO, bundle.CompressionMethod)
+				} else {
+					assert.Equal(t, CompressionLZMA, bundle.CompressionMethod)
+				}
+				assert.Empty(t, bundle.CorruptChunks())
+				for _, c := range bundle.Chunks {
+					data, ok := bundle.Chunk(c.ID)
+					require.True(t, ok)
+					assert.Equal(t, c.ID, NewChunkID(data), "chunk id of bundle %s", entry.BundleID)
+				}
+			}
+
+			// Chunking the same stream again finds every chunk zbackup stored
+			estimate, err := repo.Estimate(bytes.NewReader(input))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(len(input)), estimate.InputBytes)
+			assert.Zero(t, estimate.NewBytes)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package zbackup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrEncrypted is returned when opening an encrypted repository without a password
+var ErrEncrypted = errors.New("repository is encrypted")
+
+// ChunkLocation is where the index says a chunk is stored
+type ChunkLocation struct {
+	Bundle BundleID
+	Size   uint32
+}
+
+// Repository reads the chunks and backups of a zbackup repository without the zbackup binary
+type Repository struct {
+	Path string
+	Info *StorageInfo
+
+	// key decrypts the files of encrypted repositories
+	key   *Key
+	index map[ChunkID]ChunkLocation
+	// Recently read bundles; consecutive chunks usually share a bundle
+	cache *bundleCache
+}
+
+// Open reads the info file of an unencrypted repository; see OpenWithPassword for encrypted ones
+func Open(repoPath string) (*Repository, error) {
+	info, err := ReadStorageInfo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Encrypted {
+		return nil, ErrEncrypted
+	}
+	return &Repository{Path: repoPath, Info: info, cache: newBundleCache(DefaultBundleCacheSize)}, nil
+}
+
+// OpenWithPassword opens a repository, decrypting its key with password if it is encrypted
+func OpenWithPassword(repoPath string, password []byte) (*Repository, error) {
+	info, err := ReadStorageInfo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	repo := &Repository{Path: repoPath, Info: info, cache: newBundleCache(DefaultBundleCacheSize)}
+	if info.EncryptionKey != nil {
+		if repo.key, err = DecryptKey(info.EncryptionKey, password); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// OpenWithPasswordFile opens a repository with the password stored in a file, as passed
+// to zbackup --password-file. An empty path opens an unencrypted repository.
+func OpenWithPasswordFile(repoPath, passwordFile string) (*Repository, error) {
+	if passwordFile == "" {
+		return Open(repoPath)
+	}
+	password, err := ReadPasswordFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password file: %w", err)
+	}
+	return OpenWithPassword(repoPath, password)
+}
+
+// IndexEntries returns the entries of every index file, in file order
+func (r *Repository) IndexEntries() ([]IndexEntry, error) {
+	paths, err := ListIndexFiles(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index directory: %w", err)
+	}
+	var all []IndexEntry
+	for _, path := range paths {
+		entries, err := r.ReadIndexFile(path)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, entries...)
+	}
+	return all, nil
+}
+
+// ReadIndexFile parses one index file of the repository, decrypting it with the
+// repository key if it is encrypted
+func (r *Repository) ReadIndexFile(path string) ([]IndexEntry, error) {
+	return readIndexFile(path, r.key)
+}
+
+// BundleInfo reads the compression method and chunk records of a bundle without decompressing it
+func (r *Repository) BundleInfo(id BundleID) ([]ChunkRecord, string, error) {
+	path := BundlePath(r.Path, id)
+	f, err := openFile(path, r.key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	return readBundleInfo(f, path)
+}
+
+// Bundle reads and decompresses a bundle
+func (r *Repository) Bundle(id BundleID) (*Bundle, error) {
+	return readBundle(BundlePath(r.Path, id), r.key)
+}
+
+// BackupInfo reads a backup file from the backups directory
+func (r *Repository) BackupInfo(name string) (*BackupInfo, error) {
+	return readBackupFile(filepath.Join(r.Path, "backups", name), r.key)
+}
+
+// Index returns the location of every chunk listed in the index files, loading them on first use
+func (r *Repository) Index() (map[ChunkID]ChunkLocation, error) {
+	if r.index != nil {
+		return r.index, nil
+	}
+
+	entries, err := r.IndexEntries()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[ChunkID]ChunkLocation)
+	for _, entry := range entries {
+		for _, c := range entry.Chunks {
+			index[c.ID] = ChunkLocation{Bundle: entry.BundleID, Size: c.Size}
+		}
+	}
+	r.index = index
+	return index, nil
+}
+
+// ReadChunk returns the data of a chunk. The slice shares memory with the bundle cache
+// and must not be modified.
+func (r *Repository) ReadChunk(id ChunkID) ([]byte, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := index[id]
+	if !ok {
+		return nil, fmt.Errorf("chunk %s is not in the index", id)
+	}
+
+	bundle, ok := r.cache.get(loc.Bundle)
+	if !ok {
+		if bundle, err = r.Bundle(loc.Bundle); err != nil {
+			return nil, err
+		}
+		r.cache.put(loc.Bundle, bundle)
+	}
+	data, ok := bundle.Chunk(id)
+	if !ok {
+		return nil, fmt.Errorf("chunk %s is missing from bundle %s", id, loc.Bundle)
+	}
+	return data, nil
+}
+
+// ListBackups returns the names of the .zbk backup files, sorted
+func (r *Repository) ListBackups() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.Path, "backups"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".zbk" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// BackupRefs lists the chunks a backup needs
+type BackupRefs struct {
+	Info *BackupInfo
+	// Chunks counts the references to each chunk of the restored data
+	Chunks map[ChunkID]int
+	// MetaChunks hold the instruction streams of iterated backups
+	MetaChunks map[ChunkID]int
+	// Instructions is the final instruction stream
+	Instructions []Instruction
+	// InlineBytes are emitted from the backup file itself rather than from chunks
+	InlineBytes uint64
+}
+
+// ReadBackupRefs reads a backup file and resolves its iterations down to the
+// instruction stream that produces the backed-up data
+func (r *Repository) ReadBackupRefs(name string) (*BackupRefs, error) {
+	info, err := r.BackupInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &BackupRefs{Info: info, Chunks: make(map[ChunkID]int), MetaChunks: make(map[ChunkID]int)}
+	data := info.Data
+	for level := info.Iterations; ; level-- {
+		instructions, err := ParseInstructions(data)
+		if err != nil {
+			return nil, err
+		}
+		if level == 0 {
+			refs.Instructions = instructions
+			break
+		}
+
+		// Restore this level to obtain the instruction stream of the next one
+		var next []byte
+		for _, instr := range instructions {
+			if instr.HasChunk {
+				refs.MetaChunks[instr.Chunk]++
+				chunk, err := r.ReadChunk(instr.Chunk)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, chunk...)
+			}
+			next = append(next, instr.Bytes...)
+		}
+		data = next
+	}
+
+	for _, instr := range refs.Instructions {
+		if instr.HasChunk {
+			refs.Chunks[instr.Chunk]++
+		}
+		refs.InlineBytes += uint64(len(instr.Bytes))
+	}
+	return refs, nil
+}
@@ -0,0 +1,162 @@
+package zbackup
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultBundleCacheSize is the number of decompressed bundles a repository keeps in memory.
+// Bundles hold at most bundle_max_payload_size bytes each (2 MiB by default).
+const DefaultBundleCacheSize = 16
+
+// ErrRestoreMismatch is returned when restored data does not match the size or SHA-256
+// recorded in the backup file
+var ErrRestoreMismatch = errors.New("restored data does not match the backup")
+
+// maxInstructionSize bounds a single instruction, which holds at most one chunk id and
+// a run of literal bytes no longer than a chunk
+const maxInstructionSize = 64 << 20
+
+// bundleCache keeps the most recently used bundles
+type bundleCache struct {
+	size    int
+	order   *list.List // of BundleID, most recent first
+	entries map[BundleID]*list.Element
+	bundles map[BundleID]*Bundle
+}
+
+func newBundleCache(size int) *bundleCache {
+	if size < 1 {
+		size = 1
+	}
+	return &bundleCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[BundleID]*list.Element),
+		bundles: make(map[BundleID]*Bundle),
+	}
+}
+
+// get returns a cached bundle, marking it as recently used
+func (c *bundleCache) get(id BundleID) (*Bundle, bool) {
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return c.bundles[id], true
+}
+
+// put adds a bundle, evicting the least recently used one when full
+func (c *bundleCache) put(id BundleID, bundle *Bundle) {
+	if _, ok := c.entries[id]; ok {
+		return
+	}
+	for c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(BundleID)
+		delete(c.entries, evicted)
+		delete(c.bundles, evicted)
+	}
+	c.entries[id] = c.order.PushFront(id)
+	c.bundles[id] = bundle
+}
+
+// SetBundleCacheSize changes how many decompressed bundles are kept in memory
+func (r *Repository) SetBundleCacheSize(size int) {
+	r.cache = newBundleCache(size)
+}
+
+// instructionReader executes an instruction stream read from src, producing the data it describes
+type instructionReader struct {
+	repo    *Repository
+	src     *bufio.Reader
+	pending [][]byte
+}
+
+func (r *Repository) newInstructionReader(src io.Reader) *instructionReader {
+	return &instructionReader{repo: r, src: bufio.NewReader(src)}
+}
+
+// Read implements io.Reader
+func (ir *instructionReader) Read(p []byte) (int, error) {
+	for len(ir.pending) == 0 {
+		if err := ir.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, ir.pending[0])
+	ir.pending[0] = ir.pending[0][n:]
+	if len(ir.pending[0]) == 0 {
+		ir.pending = ir.pending[1:]
+	}
+	return n, nil
+}
+
+// next reads one instruction and queues its output
+func (ir *instructionReader) next() error {
+	size, err := binary.ReadUvarint(ir.src)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read backup instruction: %w", errTruncated)
+		}
+		return err // io.EOF ends the stream
+	}
+	if size > maxInstructionSize {
+		return fmt.Errorf("backup instruction size %d is too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(ir.src, msg); err != nil {
+		return fmt.Errorf("failed to read backup instruction: %w", errTruncated)
+	}
+	instr, err := parseInstruction(msg)
+	if err != nil {
+		return err
+	}
+	if instr.HasChunk {
+		chunk, err := ir.repo.ReadChunk(instr.Chunk)
+		if err != nil {
+			return err
+		}
+		ir.pending = append(ir.pending, chunk)
+	}
+	if len(instr.Bytes) > 0 {
+		ir.pending = append(ir.pending, instr.Bytes)
+	}
+	return nil
+}
+
+// Restore writes the data of a backup to w and verifies it against the size and
+// SHA-256 recorded in the backup file. Iterated backups are expanded level by level
+// as a stream, so memory use is bounded by the bundle cache rather than the backup size.
+func (r *Repository) Restore(name string, w io.Writer) error {
+	info, err := r.BackupInfo(name)
+	if err != nil {
+		return err
+	}
+
+	// Each level restores the instruction stream of the next; the last one produces the data
+	var data io.Reader = bytes.NewReader(info.Data)
+	for level := uint32(0); level <= info.Iterations; level++ {
+		data = r.newInstructionReader(data)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), data)
+	if err != nil {
+		return err
+	}
+	if uint64(size) != info.Size {
+		return fmt.Errorf("%w: restored %d bytes, expected %d", ErrRestoreMismatch, size, info.Size)
+	}
+	if len(info.SHA256) > 0 && !bytes.Equal(hash.Sum(nil), info.SHA256) {
+		return fmt.Errorf("%w: sha256 differs", ErrRestoreMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package zbackup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Restore(t *testing.T) {
+	for _, fixture := range []struct {
+		name, passwordFile string
+	}{
+		{"basic", ""},
+		{"iterated", ""},
+		{"series", ""},
+		{"encrypted", filepath.Join("testdata", "encrypted.password")},
+	} {
+		t.Run(fixture.name, func(t *testing.T) {
+			repo, err := OpenWithPasswordFile(filepath.Join("testdata", fixture.name), fixture.passwordFile)
+			require.NoError(t, err)
+			// A single cached bundle forces evictions in the multi-bundle fixtures
+			repo.SetBundleCacheSize(1)
+
+			names, err := repo.ListBackups()
+			require.NoError(t, err)
+			require.NotEmpty(t, names)
+			for _, name := range names {
+				var out bytes.Buffer
+				require.NoError(t, repo.Restore(name, &out), name)
+
+				info, err := repo.BackupInfo(name)
+				require.NoError(t, err)
+				sum := sha256.Sum256(out.Bytes())
+				assert.Equal(t, info.SHA256, sum[:], name)
+				assert.Equal(t, info.Size, uint64(out.Len()), name)
+			}
+		})
+	}
+}
+
+func TestRepository_Restore_DetectsMismatch(t *testing.T) {
+	repoDir := t.TempDir()
+	for _, dir := range []string{"backups", "bundles", "index"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(repoDir, dir), 0755))
+	}
+	repo := &fixtureRepo{t: t, path: repoDir}
+	writeTestStorageInfo(t, repoDir, 64, 1024)
+
+	chunk := []byte("the only chunk")
+	repo.addBundle(CompressionLZMA, chunk)
+	_, instructions := stream(chunk)
+	// The recorded digest is of different data
+	repo.addBackup("2024-01-01_0100-bad.zbk", []byte("something else"), instructions, 0, CompressionLZMA)
+	repo.finish()
+
+	r, err := Open(repoDir)
+	require.NoError(t, err)
+	err = r.Restore("2024-01-01_0100-bad.zbk", &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrRestoreMismatch)
+}
+
+func TestBundleCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newBundleCache(2)
+	a, b, c := BundleID{1}, BundleID{2}, BundleID{3}
+	cache.put(a, &Bundle{})
+	cache.put(b, &Bundle{})
+	_, ok := cache.get(a)
+	require.True(t, ok)
+	cache.put(c, &Bundle{})
+
+	_, ok = cache.get(b)
+	assert.False(t, ok, "b was least recently used")
+	_, ok = cache.get(a)
+	assert.True(t, ok)
+	_, ok = cache.get(c)
+	assert.True(t, ok)
+}
+
+func TestBundle_CorruptChunks(t *testing.T) {
+	repo, err := Open(filepath.Join("testdata", "basic"))
+	require.NoError(t, err)
+	entries, err := repo.IndexEntries()
+	require.NoError(t, err)
+
+	bundle, err := repo.Bundle(entries[0].BundleID)
+	require.NoError(t, err)
+	assert.Empty(t, bundle.CorruptChunks())
+
+	bundle.Payload[0] ^= 0xff
+	assert.Equal(t, []ChunkID{entries[0].Chunks[0].ID}, bundle.CorruptChunks())
+}
@@ -0,0 +1,147 @@
+package zbackup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/adler32"
+	"os"
+	"path/filepath"
+)
+
+// fileWriter is the counterpart of fileReader, used to build the fixture repositories
+type fileWriter struct {
+	w   *bufio.Writer
+	f   *os.File
+	sum hash.Hash32
+	// With a key, the plain text is collected and encrypted on Close
+	key   *Key
+	plain bytes.Buffer
+}
+
+// createFile creates a zbackup file and writes its FileHeader. A nil key writes plain text.
+func createFile(path string, key *Key) (*fileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	fw := newFileWriter(f, key)
+	if err := fw.writeMessage(appendVarintField(nil, 1, FileFormatVersion)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fw, nil
+}
+
+// newFileWriter wraps a file opened for writing
+func newFileWriter(f *os.File, key *Key) *fileWriter {
+	return &fileWriter{w: bufio.NewWriter(f), f: f, sum: adler32.New(), key: key}
+}
+
+// write feeds p to the file and the running checksum
+func (f *fileWriter) write(p []byte) error {
+	f.sum.Write(p)
+	if f.key != nil {
+		f.plain.Write(p)
+		return nil
+	}
+	_, err := f.w.Write(p)
+	return err
+}
+
+// writeMessage writes a varint32-length-prefixed message
+func (f *fileWriter) writeMessage(msg []byte) error {
+	if err := f.write(binary.AppendUvarint(nil, uint64(len(msg)))); err != nil {
+		return err
+	}
+	return f.write(msg)
+}
+
+// writeAdler32 appends the checksum of everything written so far
+func (f *fileWriter) writeAdler32() error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], f.sum.Sum32())
+	return f.write(buf[:])
+}
+
+// Close encrypts the file if needed, then flushes and syncs it
+func (f *fileWriter) Close() error {
+	if f.key != nil {
+		data, err := f.key.encrypt(f.plain.Bytes())
+		if err == nil {
+			_, err = f.w.Write(data)
+		}
+		if err != nil {
+			f.f.Close()
+			return err
+		}
+	}
+	if err := f.w.Flush(); err != nil {
+		f.f.Close()
+		return err
+	}
+	if err := f.f.Sync(); err != nil {
+		f.f.Close()
+		return err
+	}
+	return f.f.Close()
+}
+
+// writeIndexFile writes an index file, encrypting it with key when set.
+// The file is written next to path and renamed into place once complete.
+func writeIndexFile(path string, entries []IndexEntry, key *Key) error {
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := createFile(tmpPath, key)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		for _, entry := range entries {
+			if err := f.writeMessage(appendBytesField(nil, 1, entry.BundleID[:])); err != nil {
+				return err
+			}
+			if err := f.writeMessage(encodeBundleInfo(entry.Chunks)); err != nil {
+				return err
+			}
+		}
+		// Final record without a bundle id
+		if err := f.writeMessage(nil); err != nil {
+			return err
+		}
+		return f.writeAdler32()
+	}()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// encodeBundleInfo serializes the chunk records of a bundle
+func encodeBundleInfo(chunks []ChunkRecord) []byte {
+	var buf []byte
+	for _, chunk := range chunks {
+		record := appendBytesField(nil, 1, chunk.ID[:])
+		record = appendVarintField(record, 2, uint64(chunk.Size))
+		buf = appendBytesField(buf, 1, record)
+	}
+	return buf
+}
+
+// appendVarintField encodes a varint field
+func appendVarintField(buf []byte, num int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendBytesField encodes a length-delimited field
+func appendBytesField(buf []byte, num int, v []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
@@ -4,14 +4,21 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 	"zbwrap/internal/registries"
 	"zbwrap/internal/services"
+	"zbwrap/internal/zbackup"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,6 +35,21 @@ exit 0
 	require.NoError(t, err)
 }
 
+// createStoringZBackup creates a dummy script that stores the backup stream as the
+// .zbk file itself and replays it on restore
+func createStoringZBackup(t *testing.T, path string) {
+	content := `#!/bin/sh
+# Mock zbackup: the last argument is the backup file
+for last; do :; done
+case " $* " in
+  *" restore "*) cat "$last" ;;
+  *" backup "*) cat > "$last" ;;
+esac
+`
+	err := os.WriteFile(path, []byte(content), 0755)
+	require.NoError(t, err)
+}
+
 func TestE2E_Backup_MimeTypes(t *testing.T) {
 	// 1. Setup Environment
 	tempDir, err := os.MkdirTemp("", "zbwrap-e2e")
@@ -146,3 +168,382 @@ func TestE2E_Backup_MimeTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestE2E_Backup_Decompress_Recompress(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "zbwrap-e2e-gzip")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+
+	zbackupPath := filepath.Join(tempDir, "zbackup-mock")
+	createStoringZBackup(t, zbackupPath)
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = zbackupPath
+	registry.Encryption.Type = "none"
+	require.NoError(t, registry.Add("test-repo", repoDir))
+
+	runner := services.NewBackupRunner(registry)
+
+	plain := []byte(strings.Repeat("deduplicate me please\n", 100))
+	compressed := new(bytes.Buffer)
+	zw, err := gzip.NewWriterLevel(compressed, gzip.BestSpeed)
+	require.NoError(t, err)
+	_, err = zw.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// Reject policy refuses compressed input
+	err = runner.BackupWithOptions(repoDir, services.BackupOptions{
+		Suffix:          "rejected",
+		CompressedInput: services.CompressedInputReject,
+	}, bytes.NewReader(compressed.Bytes()))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "gzip")
+	failure, err := services.LastBackupFailure(repoDir)
+	require.NoError(t, err)
+	require.NotNil(t, failure)
+	assert.Contains(t, failure.Filename, "-rejected.zbk")
+
+	// Decompress policy stores the plain stream and records the codec
+	err = runner.BackupWithOptions(repoDir, services.BackupOptions{
+		Suffix:          "gz",
+		Tags:            map[string]string{"env": "test"},
+		CompressedInput: services.CompressedInputDecompress,
+	}, bytes.NewReader(compressed.Bytes()))
+	require.NoError(t, err)
+
+	backupsDir := filepath.Join(repoDir, "backups")
+	matches, err := filepath.Glob(filepath.Join(backupsDir, "*-gz.zbk"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	stored, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	assert.Equal(t, plain, stored)
+
+	data, err := os.ReadFile(matches[0] + ".meta")
+	require.NoError(t, err)
+	var meta services.MetadataSidecar
+	require.NoError(t, json.Unmarshal(data, &meta))
+	require.NotNil(t, meta.Compression)
+	assert.Equal(t, "gzip", meta.Compression.Codec)
+	assert.Equal(t, 1, meta.Compression.Level)
+	assert.Equal(t, map[string]string{"env": "test"}, meta.Tags)
+	// The logical size and digest describe the decompressed stream
+	assert.Equal(t, int64(len(plain)), meta.LogicalSize)
+	digest := sha256.Sum256(plain)
+	assert.Equal(t, hex.EncodeToString(digest[:]), meta.SHA256)
+	assert.Greater(t, meta.DurationSeconds, 0.0)
+
+	// Restore with --recompress yields a gzip stream of the original content
+	out := new(bytes.Buffer)
+	err = runner.Restore(repoDir, filepath.Base(matches[0]), true, out)
+	require.NoError(t, err)
+
+	zr, err := gzip.NewReader(out)
+	require.NoError(t, err)
+	restored := new(bytes.Buffer)
+	_, err = restored.ReadFrom(zr)
+	require.NoError(t, err)
+	assert.Equal(t, plain, restored.Bytes())
+}
+
+func TestE2E_Backup_Recompress_Identical(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip is not installed")
+	}
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+
+	zbackupPath := filepath.Join(tempDir, "zbackup-mock")
+	createStoringZBackup(t, zbackupPath)
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = zbackupPath
+	registry.Encryption.Type = "none"
+	require.NoError(t, registry.Add("test-repo", repoDir))
+	runner := services.NewBackupRunner(registry)
+
+	// gzip stores the name and mtime of the file it compresses
+	source := filepath.Join(tempDir, "dump.sql")
+	require.NoError(t, os.WriteFile(source, []byte(strings.Repeat("INSERT INTO t VALUES (1);\n", 200)), 0644))
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(source, mtime, mtime))
+	original, err := exec.Command("gzip", "-c", "-9", source).Output()
+	require.NoError(t, err)
+
+	err = runner.BackupWithOptions(repoDir, services.BackupOptions{
+		Suffix:          "gz",
+		CompressedInput: services.CompressedInputDecompress,
+	}, bytes.NewReader(original))
+	require.NoError(t, err)
+	matches, err := filepath.Glob(filepath.Join(repoDir, "backups", "*-gz.zbk"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	// The recompressed stream is the original file, header included
+	out := new(bytes.Buffer)
+	require.NoError(t, runner.Restore(repoDir, filepath.Base(matches[0]), true, out))
+	assert.Equal(t, original, out.Bytes())
+}
+
+func TestE2E_Backup_Hooks(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+
+	zbackupPath := filepath.Join(tempDir, "zbackup-mock")
+	createStoringZBackup(t, zbackupPath)
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = zbackupPath
+	registry.Encryption.Type = "none"
+	require.NoError(t, registry.Add("test-repo", repoDir))
+	runner := services.NewBackupRunner(registry)
+
+	// Each hook appends the event it receives to a log
+	events := filepath.Join(tempDir, "events.jsonl")
+	logHook := filepath.Join(tempDir, "log-hook")
+	require.NoError(t, os.WriteFile(logHook, []byte("#!/bin/sh\ncat >> "+events+"\necho >> "+events+"\n"), 0755))
+	refuse := filepath.Join(tempDir, "refuse")
+	require.NoError(t, os.WriteFile(refuse, []byte("#!/bin/sh\nexit 1\n"), 0755))
+
+	hooks := &registries.Hooks{
+		PreBackup:  []registries.Hook{{Command: logHook}, {Command: refuse, Suffixes: []string{"refused"}}},
+		PostBackup: []registries.Hook{{Command: logHook}},
+		OnFailure:  []registries.Hook{{Command: logHook}},
+	}
+	readEvents := func() []services.HookEvent {
+		data, err := os.ReadFile(events)
+		require.NoError(t, err)
+		var list []services.HookEvent
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event services.HookEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			list = append(list, event)
+		}
+		return list
+	}
+
+	// A successful backup runs the pre- and post-backup hooks and records them in the sidecar
+	err := runner.BackupWithOptions(repoDir, services.BackupOptions{
+		Alias:       "test-repo",
+		Suffix:      "db",
+		Description: "hooked",
+		Hooks:       hooks,
+	}, strings.NewReader("database dump"))
+	require.NoError(t, err)
+
+	list := readEvents()
+	require.Len(t, list, 2)
+	assert.Equal(t, services.HookPreBackup, list[0].Event)
+	assert.Equal(t, "test-repo", list[0].Alias)
+	assert.Equal(t, "db", list[0].Suffix)
+	assert.Equal(t, services.StatusInProgress, list[0].Sidecar.Status)
+	assert.Equal(t, services.HookPostBackup, list[1].Event)
+	assert.Equal(t, list[0].Backup, list[1].Backup)
+	assert.Equal(t, services.StatusSuccess, list[1].Sidecar.Status)
+	assert.Equal(t, "hooked", list[1].Sidecar.Description)
+	assert.Equal(t, int64(len("database dump")), list[1].Sidecar.LogicalSize)
+
+	meta, _, err := services.ReadSidecar(filepath.Join(repoDir, "backups", list[0].Backup))
+	require.NoError(t, err)
+	require.Len(t, meta.Hooks, 2)
+	assert.Equal(t, services.HookPreBackup, meta.Hooks[0].Event)
+	assert.Equal(t, services.HookPostBackup, meta.Hooks[1].Event)
+	assert.Equal(t, 0, meta.Hooks[1].ExitCode)
+
+	// A failing pre-backup hook aborts the backup and runs the failure hooks
+	require.NoError(t, os.Remove(events))
+	err = runner.BackupWithOptions(repoDir, services.BackupOptions{
+		Alias:  "test-repo",
+		Suffix: "refused",
+		Hooks:  hooks,
+	}, strings.NewReader("never stored"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backup aborted")
+
+	matches, err := filepath.Glob(filepath.Join(repoDir, "backups", "*-refused.zbk*"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	list = readEvents()
+	require.Len(t, list, 2)
+	assert.Equal(t, services.HookPreBackup, list[0].Event)
+	assert.Equal(t, services.HookOnFailure, list[1].Event)
+	assert.Contains(t, list[1].Error, "pre_backup hook "+refuse+" failed")
+
+	failure, err := services.LastBackupFailure(repoDir)
+	require.NoError(t, err)
+	require.NotNil(t, failure)
+	require.Len(t, failure.Hooks, 3)
+	assert.Equal(t, 1, failure.Hooks[1].ExitCode)
+	assert.Equal(t, services.HookOnFailure, failure.Hooks[2].Event)
+}
+
+func TestE2E_GC_Hooks(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+
+	// The mock records its arguments and fails the second time
+	calls := filepath.Join(tempDir, "calls")
+	zbackupPath := filepath.Join(tempDir, "zbackup-mock")
+	require.NoError(t, os.WriteFile(zbackupPath, []byte("#!/bin/sh\n[ -f "+calls+" ] && exit 1\necho \"$@\" > "+calls+"\n"), 0755))
+	payload := filepath.Join(tempDir, "payload.json")
+	hook := filepath.Join(tempDir, "hook")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\ncat > "+payload+"\n"), 0755))
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = zbackupPath
+	registry.Encryption.Type = "none"
+	require.NoError(t, registry.Add("test-repo", repoDir))
+	runner := services.NewBackupRunner(registry)
+	hooks := &registries.Hooks{PostGC: []registries.Hook{{Command: hook}}}
+
+	result := runner.GC("test-repo", repoDir, hooks)
+	assert.Empty(t, result.Error)
+	require.Len(t, result.Hooks, 1)
+	args, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "--non-encrypted gc "+repoDir+"\n", string(args))
+
+	// post_gc hooks also learn about a failed gc
+	result = runner.GC("test-repo", repoDir, hooks)
+	assert.Contains(t, result.Error, "zbackup failed")
+	data, err := os.ReadFile(payload)
+	require.NoError(t, err)
+	var event services.HookEvent
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, services.HookPostGC, event.Event)
+	assert.Equal(t, "test-repo", event.Alias)
+	assert.Equal(t, result.Error, event.Error)
+}
+
+// copyFixtureRepo copies a zbackup fixture repository into dir
+func copyFixtureRepo(t *testing.T, name, dir string) {
+	src := filepath.Join("..", "internal", "zbackup", "testdata", name)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, rel), data, 0644)
+	})
+	require.NoError(t, err)
+}
+
+func TestE2E_Restore_Native(t *testing.T) {
+	tempDir := t.TempDir()
+	passwordFile, err := filepath.Abs(filepath.Join("..", "internal", "zbackup", "testdata", "encrypted.password"))
+	require.NoError(t, err)
+
+	for _, fixture := range []struct {
+		name, passwordFile string
+	}{
+		{"iterated", ""},
+		{"encrypted", passwordFile},
+	} {
+		t.Run(fixture.name, func(t *testing.T) {
+			repoDir := filepath.Join(tempDir, fixture.name)
+			copyFixtureRepo(t, fixture.name, repoDir)
+
+			registry := registries.NewLocalRegistry()
+			// No zbackup binary is needed
+			registry.ZBackupPath = filepath.Join(tempDir, "missing-zbackup")
+			if fixture.passwordFile != "" {
+				registry.Encryption = registries.EncryptionConfig{Type: "password-file", CredentialsPath: fixture.passwordFile}
+			}
+			runner := services.NewBackupRunner(registry)
+
+			repo, err := zbackup.OpenWithPasswordFile(repoDir, fixture.passwordFile)
+			require.NoError(t, err)
+			names, err := repo.ListBackups()
+			require.NoError(t, err)
+			for _, name := range names {
+				info, err := repo.BackupInfo(name)
+				require.NoError(t, err)
+
+				out := new(bytes.Buffer)
+				opts := services.RestoreOptions{Native: true, BundleCache: 1}
+				require.NoError(t, runner.RestoreWithOptions(repoDir, strings.TrimSuffix(name, ".zbk"), opts, out))
+				digest := sha256.Sum256(out.Bytes())
+				assert.Equal(t, info.SHA256, digest[:], name)
+			}
+
+			// --recompress applies the recorded codec to the natively restored stream
+			name := names[0]
+			require.NoError(t, services.WriteSidecar(filepath.Join(repoDir, "backups", name+".meta"), services.MetadataSidecar{
+				MimeType:    "text/plain",
+				Compression: &services.CompressionInfo{Codec: "gzip", Level: 1},
+			}))
+			out := new(bytes.Buffer)
+			require.NoError(t, runner.RestoreWithOptions(repoDir, name, services.RestoreOptions{Native: true, Recompress: true}, out))
+			zr, err := gzip.NewReader(out)
+			require.NoError(t, err)
+			restored := new(bytes.Buffer)
+			_, err = restored.ReadFrom(zr)
+			require.NoError(t, err)
+			info, err := repo.BackupInfo(name)
+			require.NoError(t, err)
+			digest := sha256.Sum256(restored.Bytes())
+			assert.Equal(t, info.SHA256, digest[:])
+		})
+	}
+}
+
+func TestE2E_Estimate(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "chunked")
+	copyFixtureRepo(t, "chunked", repoDir)
+	input, err := os.ReadFile(filepath.Join("..", "internal", "zbackup", "testdata", "chunked.input"))
+	require.NoError(t, err)
+
+	registry := registries.NewLocalRegistry()
+	registry.ZBackupPath = filepath.Join(tempDir, "missing-zbackup")
+	runner := services.NewBackupRunner(registry)
+
+	before, err := os.ReadDir(filepath.Join(repoDir, "backups"))
+	require.NoError(t, err)
+
+	// Half of the stored stream followed by new data
+	fresh := make([]byte, 180)
+	rand.New(rand.NewSource(1)).Read(fresh)
+	stream := append(append([]byte{}, input[:512]...), fresh...)
+	report, err := runner.Estimate(repoDir, services.BackupOptions{}, bytes.NewReader(stream))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(stream)), report.InputBytes)
+	assert.Equal(t, uint64(512), report.DuplicateBytes)
+	assert.Equal(t, uint64(180), report.NewBytes)
+	assert.InDelta(t, 692.0/180, report.DedupRatio, 0.001)
+	assert.Nil(t, report.Compression)
+
+	// Compressed input is decompressed first, as a backup with --decompress would be
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(input)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	report, err = runner.Estimate(repoDir, services.BackupOptions{CompressedInput: services.CompressedInputDecompress}, &compressed)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), report.DuplicateBytes)
+	assert.Zero(t, report.NewBytes)
+	assert.Equal(t, 1.0, report.DuplicateShare)
+	require.NotNil(t, report.Compression)
+	assert.Equal(t, "gzip", report.Compression.Codec)
+
+	after, err := os.ReadDir(filepath.Join(repoDir, "backups"))
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}